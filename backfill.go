@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// BlockRange is a range of block numbers, both ends included.
+type BlockRange struct {
+	From BlockNumber
+	To   BlockNumber
+}
+
+// Len returns the number of blocks in the range.
+func (blockRange BlockRange) Len() uint64 {
+	return blockRange.To - blockRange.From + 1
+}
+
+// BackfillState is the progress of a BackfillRange.
+type BackfillState string
+
+const (
+	BackfillStatePending   BackfillState = "pending"
+	BackfillStateClaimed   BackfillState = "claimed"
+	BackfillStateCompleted BackfillState = "completed"
+	BackfillStateFailed    BackfillState = "failed"
+)
+
+// BackfillRange is a range of historical blocks to be stored by one of the backfilling workers.
+type BackfillRange struct {
+	BlockRange
+	State BackfillState
+	// ClaimedBy names the worker which claimed the range last, it is empty if the range was never claimed.
+	ClaimedBy string
+	ClaimedAt *time.Time
+	Attempts  uint64
+	// Error is the error the range failed with last.
+	Error string
+}
+
+// BackfillTracker coordinates workers storing historical blocks through ranges persisted by the backend.
+// A worker claims a range, stores and commits its blocks, then completes the range, or fails it with the error it got.
+//
+// Unlike the DataStore methods, all methods commit right away, so every worker sees the claims of the others at once.
+// Methods changing a claimed range fail with ErrConflict if the range is claimed by another worker,
+// e.g. as its lease expired, and with ErrNotFound if the range does not exist.
+type BackfillTracker interface {
+	// PlanBackfill registers the blocks between from and to which are neither stored nor registered already,
+	// as pending ranges of at most size blocks. It returns the registered ranges.
+	PlanBackfill(_ context.Context, from, to BlockNumber, size uint64) ([]*BackfillRange, error)
+	// ClaimBackfillRange claims the lowest pending range for the worker, or the lowest range claimed longer than lease ago,
+	// as its worker is considered dead. It returns nil if there is no range to claim.
+	ClaimBackfillRange(_ context.Context, worker string, lease time.Duration) (*BackfillRange, error)
+	CompleteBackfillRange(_ context.Context, from BlockNumber, worker string) error
+	FailBackfillRange(_ context.Context, from BlockNumber, worker string, cause error) error
+	// RetryFailedBackfillRanges makes all failed ranges pending again, and returns their number.
+	RetryFailedBackfillRanges(context.Context) (uint64, error)
+	// ListBackfillRanges lists the ranges in the given state, or all ranges if state is nil, in block order.
+	ListBackfillRanges(_ context.Context, state *BackfillState) ([]*BackfillRange, error)
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CallFrame is a node of a transaction's call tree, see BuildCallTree.
+type CallFrame struct {
+	*TraceAction
+	Calls []*CallFrame
+}
+
+// BuildCallTree assembles the nested call structure of a single transaction's traces using their TraceAddress.
+// Traces not having a stored parent (e.g. traces stored without trace addresses) are attached to the top level call.
+// Returns nil if no traces are given.
+func BuildCallTree(traces []*TraceAction) (*CallFrame, error) {
+	if len(traces) == 0 {
+		return nil, nil
+	}
+	sorted := make([]*TraceAction, len(traces))
+	copy(sorted, traces)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return CompareTraceAddresses(sorted[i].TraceAddress, sorted[j].TraceAddress) < 0
+	})
+	if len(sorted[0].TraceAddress) != 0 {
+		return nil, fmt.Errorf("top level call is missing from the traces of transaction %v", sorted[0].TransactionId)
+	}
+	root := &CallFrame{TraceAction: sorted[0]}
+	framesByAddress := map[string]*CallFrame{traceAddressKey(nil): root}
+	for _, trace := range sorted[1:] {
+		frame := &CallFrame{TraceAction: trace}
+		parent := root
+		if len(trace.TraceAddress) != 0 {
+			if found, ok := framesByAddress[traceAddressKey(trace.TraceAddress[:len(trace.TraceAddress)-1])]; ok {
+				parent = found
+			}
+		}
+		parent.Calls = append(parent.Calls, frame)
+		if key := traceAddressKey(trace.TraceAddress); framesByAddress[key] == nil {
+			framesByAddress[key] = frame
+		}
+	}
+	return root, nil
+}
+
+// CompareTraceAddresses orders trace addresses depth-first, the way calls are executed.
+// The result is negative when a precedes b, positive when b precedes a, and 0 when they are equal.
+func CompareTraceAddresses(a, b []uint64) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+func traceAddressKey(traceAddress []uint64) string {
+	return fmt.Sprint(traceAddress)
+}
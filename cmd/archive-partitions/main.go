@@ -0,0 +1,34 @@
+// Command archive-partitions detaches the partitions of a partitioned postgres database holding only blocks before the given block,
+// and moves them to a separate schema, from where they can be dumped with pg_dump and dropped.
+// Connection and partitioning settings are read from the same POSTGRES_* environment variables as the repository.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/librescan-org/backend-db/database/postgres"
+)
+
+func main() {
+	before := flag.Uint64("before", 0, "archive the partitions holding only blocks before this block number")
+	schema := flag.String("schema", "archive", "schema the detached partitions are moved to")
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	repo := &postgres.PostgresRepository{}
+	if err := repo.Load(); err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	archived, err := repo.ArchivePartitions(ctx, *before, *schema)
+	if err != nil {
+		log.Fatalf("archiving failed: %v", err)
+	}
+	for _, partition := range archived {
+		log.Printf("archived %s to schema %s", partition, *schema)
+	}
+	log.Printf("archived %d partitions", len(archived))
+}
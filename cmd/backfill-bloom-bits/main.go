@@ -0,0 +1,47 @@
+// Command backfill-bloom-bits indexes the logs blooms of the blocks stored in postgres before the bloom bits index existed,
+// so log filters find their logs. Indexing a block twice is harmless, an interrupted run can be resumed with -from.
+// Connection settings are read from the same POSTGRES_* environment variables as the repository.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/postgres"
+)
+
+func main() {
+	from := flag.Uint64("from", 0, "first block to index")
+	to := flag.Int64("to", -1, "last block to index, the latest stored block by default")
+	batchSize := flag.Uint64("batch-size", 10000, "number of blocks to index per committed batch")
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	repo := &postgres.PostgresRepository{}
+	if err := repo.Load(); err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	last := uint64(*to)
+	if *to < 0 {
+		latestBlockNumber, err := repo.GetLatestBlockNumber()
+		if err != nil {
+			log.Fatalf("failed to get latest block number: %v", err)
+		}
+		if latestBlockNumber == nil {
+			log.Print("no blocks are stored")
+			return
+		}
+		last = *latestBlockNumber
+	}
+	err := repo.BackfillBloomBits(ctx, *from, last, *batchSize, func(lastBlockNumber storage.BlockNumber) {
+		log.Printf("indexed blocks up to %d", lastBlockNumber)
+	})
+	if err != nil {
+		log.Fatalf("backfill failed: %v", err)
+	}
+	log.Print("backfill finished")
+}
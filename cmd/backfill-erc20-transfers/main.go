@@ -0,0 +1,32 @@
+// Command backfill-erc20-transfers derives ERC-20 token transfers from the logs already stored in postgres.
+// It can be interrupted at any time, the next run resumes from the last committed checkpoint.
+// Connection settings are read from the same POSTGRES_* environment variables as the repository.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/librescan-org/backend-db/database/postgres"
+)
+
+func main() {
+	batchSize := flag.Uint64("batch-size", 10000, "number of transfer logs to derive per committed batch")
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	repo := &postgres.PostgresRepository{}
+	if err := repo.Load(); err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	err := repo.BackfillErc20TokenTransfers(ctx, *batchSize, func(lastTransactionId int64, logsProcessed int) {
+		log.Printf("processed %d logs up to transaction %d", logsProcessed, lastTransactionId)
+	})
+	if err != nil {
+		log.Fatalf("backfill failed: %v", err)
+	}
+	log.Print("backfill finished")
+}
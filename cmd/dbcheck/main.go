@@ -0,0 +1,64 @@
+// Command dbcheck reports inconsistencies of the stored chain data between two blocks, as JSON lines on the standard output:
+// missing blocks, broken parent hash links, gaps in transaction indexes, missing receipts, decreasing cumulative gas used,
+// logs without receipts, contracts not matching their receipts, and ether balances contradicting the state changes.
+// It exits with status 2 if any inconsistency is found, and with status 1 if the check could not be run.
+// Connection settings are read from the same POSTGRES_* environment variables as the repository.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/librescan-org/backend-db/database/postgres"
+)
+
+// exitInconsistent is the exit status when inconsistencies are found, telling them apart from failures to run the check.
+const exitInconsistent = 2
+
+type inconsistency struct {
+	Check       string `json:"check"`
+	BlockNumber uint64 `json:"blockNumber"`
+	Detail      string `json:"detail"`
+}
+
+func main() {
+	from := flag.Uint64("from", 0, "first block to check")
+	to := flag.Int64("to", -1, "last block to check, the latest stored block by default")
+	flag.Parse()
+	repo := &postgres.PostgresRepository{}
+	if err := repo.Load(); err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	last := uint64(*to)
+	if *to < 0 {
+		latestBlockNumber, err := repo.GetLatestBlockNumber()
+		if err != nil {
+			log.Fatalf("failed to get latest block number: %v", err)
+		}
+		if latestBlockNumber == nil {
+			log.Print("no blocks are stored")
+			return
+		}
+		last = *latestBlockNumber
+	}
+	inconsistencies, err := repo.CheckIntegrity(*from, last)
+	if err != nil {
+		log.Fatalf("integrity check failed: %v", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, found := range inconsistencies {
+		err = encoder.Encode(inconsistency{
+			Check:       found.Check,
+			BlockNumber: found.BlockNumber,
+			Detail:      found.Detail,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(inconsistencies) != 0 {
+		os.Exit(exitInconsistent)
+	}
+}
@@ -0,0 +1,30 @@
+// Command migrate applies the pending schema migrations to a postgres database created by an earlier version of the repository.
+// Migrations run online, so the earlier version can keep serving the database until migrate finishes.
+// It can be interrupted at any time, the next run resumes where the previous one stopped.
+// Connection settings are read from the same POSTGRES_* environment variables as the repository.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/librescan-org/backend-db/database/postgres"
+)
+
+func main() {
+	batchSize := flag.Int64("batch-size", 10000, "width of the key range of rows converted per batch")
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	repo := &postgres.PostgresRepository{}
+	err := repo.Migrate(ctx, *batchSize, func(migrationName, tableName string, done, total int64) {
+		log.Printf("%s: %s at %d of %d", migrationName, tableName, done, total)
+	})
+	if err != nil {
+		log.Fatalf("migration failed: %v", err)
+	}
+	log.Print("migrations finished")
+}
@@ -0,0 +1,74 @@
+// Command reconcile-erc20-balances reports ERC-20 token holders whose latest stored balance
+// disagrees with the sum of their stored transfers, as JSON lines on the standard output.
+// It exits with status 1 if any discrepancy is found.
+// Connection settings are read from the same POSTGRES_* environment variables as the repository.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/postgres"
+)
+
+type discrepancy struct {
+	Holder             common.Address `json:"holder"`
+	Token              common.Address `json:"token"`
+	StoredBalance      *big.Int       `json:"storedBalance"`
+	TransferredBalance *big.Int       `json:"transferredBalance"`
+}
+
+func main() {
+	token := flag.String("token", "", "reconcile only the token having this address")
+	flag.Parse()
+	repo := &postgres.PostgresRepository{}
+	if err := repo.Load(); err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	var tokenAddressId *storage.AddressId
+	if *token != "" {
+		if !common.IsHexAddress(*token) {
+			log.Fatalf("invalid token address: %s", *token)
+		}
+		addressId, err := repo.GetAddressIdByHash(common.HexToAddress(*token))
+		if err != nil {
+			log.Fatalf("failed to look up token: %v", err)
+		}
+		if addressId.IsZero() {
+			log.Fatalf("token %s is not stored", *token)
+		}
+		tokenAddressId = &addressId
+	}
+	discrepancies, err := repo.ReconcileErc20TokenBalances(tokenAddressId)
+	if err != nil {
+		log.Fatalf("reconciliation failed: %v", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, found := range discrepancies {
+		holder, err := repo.GetAddressById(found.HolderAddressId)
+		if err != nil {
+			log.Fatalf("failed to look up holder: %v", err)
+		}
+		tokenAddress, err := repo.GetAddressById(found.TokenAddressId)
+		if err != nil {
+			log.Fatalf("failed to look up token: %v", err)
+		}
+		err = encoder.Encode(discrepancy{
+			Holder:             *holder,
+			Token:              *tokenAddress,
+			StoredBalance:      found.StoredBalance,
+			TransferredBalance: found.TransferredBalance,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(discrepancies) != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,92 @@
+// Command verify-roots rebuilds the transaction and receipt tries of the blocks stored between two blocks,
+// and reports the blocks whose stored roots differ from the rebuilt ones, as JSON lines on the standard output.
+// Roots which can not be rebuilt, e.g. as transactions were stored without their raw encoding, are reported as unverifiable.
+// It exits with status 2 if any mismatch is found, with status 3 if roots are only unverifiable,
+// and with status 1 if the verification could not be run.
+// Connection settings are read from the same POSTGRES_* environment variables as the repository.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/librescan-org/backend-db/convert"
+	"github.com/librescan-org/backend-db/database/postgres"
+)
+
+// Exit statuses telling the roots found apart from failures to run the verification.
+const (
+	exitMismatch     = 2
+	exitUnverifiable = 3
+)
+
+type mismatch struct {
+	BlockNumber  uint64       `json:"blockNumber"`
+	Root         string       `json:"root"`
+	Stored       common.Hash  `json:"stored"`
+	Computed     *common.Hash `json:"computed,omitempty"`
+	Unverifiable string       `json:"unverifiable,omitempty"`
+}
+
+func main() {
+	from := flag.Uint64("from", 0, "first block to verify")
+	to := flag.Int64("to", -1, "last block to verify, the latest stored block by default")
+	flag.Parse()
+	repo := &postgres.PostgresRepository{}
+	if err := repo.Load(); err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	last := uint64(*to)
+	if *to < 0 {
+		latestBlockNumber, err := repo.GetLatestBlockNumber()
+		if err != nil {
+			log.Fatalf("failed to get latest block number: %v", err)
+		}
+		if latestBlockNumber == nil {
+			log.Print("no blocks are stored")
+			return
+		}
+		last = *latestBlockNumber
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	foundMismatch, foundUnverifiable := false, false
+	for number := *from; number <= last; number++ {
+		block, err := repo.GetBlockByNumber(number)
+		if err != nil {
+			log.Fatalf("failed to get block %d: %v", number, err)
+		}
+		if block == nil {
+			continue
+		}
+		mismatches, err := convert.VerifyBlockRoots(repo, block)
+		if err != nil {
+			log.Fatalf("failed to verify block %d: %v", number, err)
+		}
+		for _, rootMismatch := range mismatches {
+			found := mismatch{
+				BlockNumber:  rootMismatch.BlockNumber,
+				Root:         rootMismatch.Root,
+				Stored:       rootMismatch.Stored,
+				Unverifiable: rootMismatch.Unverifiable,
+			}
+			if rootMismatch.Unverifiable == "" {
+				foundMismatch = true
+				found.Computed = &rootMismatch.Computed
+			} else {
+				foundUnverifiable = true
+			}
+			if err = encoder.Encode(found); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if foundMismatch {
+		os.Exit(exitMismatch)
+	}
+	if foundUnverifiable {
+		os.Exit(exitUnverifiable)
+	}
+}
@@ -0,0 +1,239 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	storage "github.com/librescan-org/backend-db"
+)
+
+// BatchIngestOptions customizes a BatchIngestor.
+//   - Window bounds the number of blocks submitted ahead of the next block to commit, Submit blocks beyond it.
+//     When 0, it is twice the number of stores.
+//   - Committed is called after each block was committed, in block order. It can be nil.
+type BatchIngestOptions struct {
+	IngestOptions
+	Window    uint64
+	Committed func(*BlockBundle, *IngestResult)
+}
+
+// BatchIngestor ingests consecutive blocks through several stores in parallel, committing them in block order,
+// so the stored blocks never have gaps, e.g. when the initial sync is interrupted.
+// Each store has its own transaction, which stages the blocks it ingests until it is their turn to be committed.
+//
+// The entities shared between blocks, like addresses and topic0s, are committed by the store right after they are resolved,
+// so the stores do not wait for each other's staged blocks. Backends deriving state shared between blocks,
+// like the running ERC-20 token balances of the postgres backend, should have it disabled while ingesting in parallel.
+//
+// The methods of a BatchIngestor are safe for concurrent use, bundles can be submitted from several goroutines in any order.
+// After the first error, the ingestor stops, and the blocks from NextBlock on have to be ingested again.
+type BatchIngestor struct {
+	stores  []storage.Storage
+	options BatchIngestOptions
+	ctx     context.Context
+	workers sync.WaitGroup
+
+	mutex sync.Mutex
+	// changed is closed and replaced whenever the fields below change.
+	changed chan struct{}
+	// pending are the submitted bundles not yet taken by a store.
+	pending map[storage.BlockNumber]*BlockBundle
+	// staging are the blocks being ingested by the stores.
+	staging   map[storage.BlockNumber]bool
+	nextBlock storage.BlockNumber
+	closed    bool
+	err       error
+}
+
+// NewBatchIngestor starts ingesting blocks from firstBlock on, through the given stores.
+// The stores must not be used by anything else until Close returns. Canceling ctx stops the ingestor.
+func NewBatchIngestor(ctx context.Context, stores []storage.Storage, firstBlock storage.BlockNumber, options BatchIngestOptions) *BatchIngestor {
+	if len(stores) == 0 {
+		panic("at least one store is needed")
+	}
+	if options.Window == 0 {
+		options.Window = 2 * uint64(len(stores))
+	}
+	if options.Window < uint64(len(stores)) {
+		options.Window = uint64(len(stores))
+	}
+	ingestor := &BatchIngestor{
+		stores:    stores,
+		options:   options,
+		ctx:       ctx,
+		changed:   make(chan struct{}),
+		pending:   make(map[storage.BlockNumber]*BlockBundle),
+		staging:   make(map[storage.BlockNumber]bool),
+		nextBlock: firstBlock,
+	}
+	for _, store := range stores {
+		ingestor.workers.Add(1)
+		go ingestor.work(store)
+	}
+	return ingestor
+}
+
+// notify wakes up everyone waiting for a change, the mutex must be held.
+func (ingestor *BatchIngestor) notify() {
+	close(ingestor.changed)
+	ingestor.changed = make(chan struct{})
+}
+
+// wait waits until ready returns true or the ingestor failed, with the mutex held when called and when returning.
+func (ingestor *BatchIngestor) wait(ctx context.Context, ready func() bool) error {
+	for !ready() {
+		if ingestor.err != nil {
+			return ingestor.err
+		}
+		changed := ingestor.changed
+		ingestor.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			ingestor.mutex.Lock()
+			return ctx.Err()
+		case <-changed:
+		}
+		ingestor.mutex.Lock()
+	}
+	return ingestor.err
+}
+
+// fail stops the ingestor with the first error, the mutex must be held.
+func (ingestor *BatchIngestor) fail(err error) {
+	if ingestor.err == nil {
+		ingestor.err = err
+		ingestor.notify()
+	}
+}
+
+// Submit hands a block over to be ingested, waiting while it is too far ahead of the next block to commit.
+// It returns the ingestor's error if it failed.
+func (ingestor *BatchIngestor) Submit(ctx context.Context, bundle *BlockBundle) error {
+	number := bundle.Block.NumberU64()
+	ingestor.mutex.Lock()
+	defer ingestor.mutex.Unlock()
+	err := ingestor.wait(ctx, func() bool { return number < ingestor.nextBlock+ingestor.options.Window })
+	if err != nil {
+		return err
+	}
+	if ingestor.closed {
+		return fmt.Errorf("block %d submitted after closing the ingestor", number)
+	}
+	if _, found := ingestor.pending[number]; found || ingestor.staging[number] || number < ingestor.nextBlock {
+		return fmt.Errorf("block %d submitted twice", number)
+	}
+	ingestor.pending[number] = bundle
+	ingestor.notify()
+	return nil
+}
+
+// NextBlock returns the next block to commit, all blocks before it are committed.
+func (ingestor *BatchIngestor) NextBlock() storage.BlockNumber {
+	ingestor.mutex.Lock()
+	defer ingestor.mutex.Unlock()
+	return ingestor.nextBlock
+}
+
+// Close waits until all submitted blocks are committed, and returns the first error of the ingestor.
+// It fails if a block was skipped, as the blocks after it can not be committed.
+func (ingestor *BatchIngestor) Close(ctx context.Context) error {
+	ingestor.mutex.Lock()
+	ingestor.closed = true
+	ingestor.notify()
+	ingestor.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		ingestor.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+	}
+	ingestor.mutex.Lock()
+	defer ingestor.mutex.Unlock()
+	return ingestor.err
+}
+
+// take returns the lowest pending block among the blocks which are next to commit, one for each store,
+// so the next block to commit is always taken by a store which is not staging a later block.
+// It returns nil when the ingestor is closed and all blocks were taken, or when it failed.
+func (ingestor *BatchIngestor) take() *BlockBundle {
+	ingestor.mutex.Lock()
+	defer ingestor.mutex.Unlock()
+	var bundle *BlockBundle
+	err := ingestor.wait(ingestor.ctx, func() bool {
+		for number := ingestor.nextBlock; number < ingestor.nextBlock+uint64(len(ingestor.stores)); number++ {
+			if bundle = ingestor.pending[number]; bundle != nil {
+				delete(ingestor.pending, number)
+				ingestor.staging[number] = true
+				return true
+			}
+		}
+		if !ingestor.closed || ingestor.staging[ingestor.nextBlock] {
+			return false
+		}
+		// the next block is neither pending nor staged, so the later blocks, staged ones included, can never be committed
+		if len(ingestor.pending) != 0 || len(ingestor.staging) != 0 {
+			ingestor.fail(fmt.Errorf("block %d was not submitted", ingestor.nextBlock))
+		}
+		return true
+	})
+	if err != nil {
+		ingestor.fail(err)
+		return nil
+	}
+	return bundle
+}
+func (ingestor *BatchIngestor) work(store storage.Storage) {
+	defer ingestor.workers.Done()
+	for {
+		bundle := ingestor.take()
+		if bundle == nil {
+			return
+		}
+		if err := ingestor.ingest(store, bundle); err != nil {
+			ingestor.mutex.Lock()
+			ingestor.fail(fmt.Errorf("failed to ingest block %d: %w", bundle.Block.NumberU64(), err))
+			ingestor.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// ingest stages the block in the store's transaction, and commits it once all blocks before it are committed.
+func (ingestor *BatchIngestor) ingest(store storage.Storage, bundle *BlockBundle) error {
+	number := bundle.Block.NumberU64()
+	ids, err := resolveIds(store, bundle, ingestor.options.IngestOptions)
+	if err != nil {
+		return err
+	}
+	if err = store.Commit(ingestor.ctx); err != nil {
+		return err
+	}
+	result, err := write(store, bundle, ids)
+	if err != nil {
+		return err
+	}
+	ingestor.mutex.Lock()
+	err = ingestor.wait(ingestor.ctx, func() bool { return ingestor.nextBlock == number })
+	ingestor.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	// no other store commits until nextBlock is advanced
+	if err = store.Commit(ingestor.ctx); err != nil {
+		return err
+	}
+	if ingestor.options.Committed != nil {
+		ingestor.options.Committed(bundle, result)
+	}
+	ingestor.mutex.Lock()
+	defer ingestor.mutex.Unlock()
+	delete(ingestor.staging, number)
+	ingestor.nextBlock++
+	ingestor.notify()
+	return nil
+}
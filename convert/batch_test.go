@@ -0,0 +1,228 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// newEmptyBundle returns a block without transactions.
+func newEmptyBundle(number storage.BlockNumber) *BlockBundle {
+	header := &types.Header{
+		ParentHash: common.BigToHash(new(big.Int).SetUint64(number - 1)),
+		Coinbase:   common.HexToAddress("0x00000000000000000000000000000000000000aa"),
+		Difficulty: big.NewInt(0),
+		Number:     new(big.Int).SetUint64(number),
+		GasLimit:   30_000_000,
+		Time:       1700000000 + number*12,
+	}
+	return &BlockBundle{
+		Block:           types.NewBlock(header, nil, nil, nil, trie.NewStackTrie(nil)),
+		TotalDifficulty: big.NewInt(0),
+	}
+}
+
+// failingStore fails to store the block of the given number.
+type failingStore struct {
+	storage.Storage
+	failingBlock storage.BlockNumber
+}
+
+var errTestStoreFailed = errors.New("test store failed")
+
+func (store *failingStore) StoreBlock(blocks ...*storage.Block) error {
+	for _, block := range blocks {
+		if block.Number == store.failingBlock {
+			return errTestStoreFailed
+		}
+	}
+	return store.Storage.StoreBlock(blocks...)
+}
+
+func newTestStores(t *testing.T, count int) []storage.Storage {
+	t.Helper()
+	stores := make([]storage.Storage, 0, count)
+	for i := 0; i < count; i++ {
+		stores = append(stores, newTestStore(t))
+	}
+	return stores
+}
+
+// committedBlocks records the numbers of the committed blocks, in the order they were committed.
+type committedBlocks struct {
+	mutex   sync.Mutex
+	numbers []storage.BlockNumber
+}
+
+func (committed *committedBlocks) add(bundle *BlockBundle, _ *IngestResult) {
+	committed.mutex.Lock()
+	defer committed.mutex.Unlock()
+	committed.numbers = append(committed.numbers, bundle.Block.NumberU64())
+}
+func (committed *committedBlocks) get() []storage.BlockNumber {
+	committed.mutex.Lock()
+	defer committed.mutex.Unlock()
+	return append([]storage.BlockNumber(nil), committed.numbers...)
+}
+
+func blockNumbers(from, to storage.BlockNumber) []storage.BlockNumber {
+	var numbers []storage.BlockNumber
+	for number := from; number <= to; number++ {
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+func TestBatchIngestorCommitsInBlockOrder(t *testing.T) {
+	stores := newTestStores(t, 4)
+	var committed committedBlocks
+	ingestor := NewBatchIngestor(context.Background(), stores, 1, BatchIngestOptions{Committed: committed.add})
+	// each goroutine submits every 4th block, swapping them in pairs, so the blocks arrive out of order
+	var submitters sync.WaitGroup
+	for first := storage.BlockNumber(1); first <= 4; first++ {
+		submitters.Add(1)
+		go func(first storage.BlockNumber) {
+			defer submitters.Done()
+			for _, number := range []storage.BlockNumber{first + 4, first, first + 12, first + 8} {
+				if err := ingestor.Submit(context.Background(), newEmptyBundle(number)); err != nil {
+					t.Errorf("submitting block %d: %v", number, err)
+					return
+				}
+			}
+		}(first)
+	}
+	submitters.Wait()
+	if err := ingestor.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := blockNumbers(1, 16); !reflect.DeepEqual(committed.get(), want) {
+		t.Errorf("committed blocks: got %v, want %v", committed.get(), want)
+	}
+	if next := ingestor.NextBlock(); next != 17 {
+		t.Errorf("next block: got %d, want 17", next)
+	}
+	// every block is committed by one of the stores
+	for _, number := range blockNumbers(1, 16) {
+		found := 0
+		for _, store := range stores {
+			block, err := store.GetBlockByNumber(number)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block != nil {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("block %d is stored by %d stores, want 1", number, found)
+		}
+	}
+}
+
+func TestBatchIngestorSubmitWaitsWithinWindow(t *testing.T) {
+	ingestor := NewBatchIngestor(context.Background(), newTestStores(t, 1), 1, BatchIngestOptions{Window: 2})
+	if err := ingestor.Submit(context.Background(), newEmptyBundle(2)); err != nil {
+		t.Fatal(err)
+	}
+	// block 3 is out of the window until block 1 is committed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ingestor.Submit(ctx, newEmptyBundle(3)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("block 3 submitted beyond the window: got %v, want to wait", err)
+	}
+	submitted := make(chan error, 1)
+	go func() { submitted <- ingestor.Submit(context.Background(), newEmptyBundle(3)) }()
+	select {
+	case err := <-submitted:
+		t.Fatalf("block 3 submitted beyond the window: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := ingestor.Submit(context.Background(), newEmptyBundle(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-submitted; err != nil {
+		t.Fatalf("block 3 submitted once block 1 is committed: %v", err)
+	}
+	if err := ingestor.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if next := ingestor.NextBlock(); next != 4 {
+		t.Errorf("next block: got %d, want 4", next)
+	}
+}
+
+func TestBatchIngestorRejectsDuplicates(t *testing.T) {
+	ingestor := NewBatchIngestor(context.Background(), newTestStores(t, 2), 1, BatchIngestOptions{})
+	for _, number := range []storage.BlockNumber{1, 2} {
+		if err := ingestor.Submit(context.Background(), newEmptyBundle(number)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the block is rejected whether it is still pending, being ingested or already committed
+	if err := ingestor.Submit(context.Background(), newEmptyBundle(2)); err == nil {
+		t.Error("block 2 submitted twice")
+	}
+	if err := ingestor.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingestor.Submit(context.Background(), newEmptyBundle(1)); err == nil {
+		t.Error("committed block 1 submitted again")
+	}
+}
+
+func TestBatchIngestorCloseReportsMissingBlock(t *testing.T) {
+	var committed committedBlocks
+	ingestor := NewBatchIngestor(context.Background(), newTestStores(t, 2), 1, BatchIngestOptions{Committed: committed.add})
+	for _, number := range []storage.BlockNumber{1, 3, 4} {
+		if err := ingestor.Submit(context.Background(), newEmptyBundle(number)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ingestor.Close(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "block 2 was not submitted") {
+		t.Errorf("closing without block 2: got %v, want it reported", err)
+	}
+	if next := ingestor.NextBlock(); next != 2 {
+		t.Errorf("next block: got %d, want 2", next)
+	}
+	if want := []storage.BlockNumber{1}; !reflect.DeepEqual(committed.get(), want) {
+		t.Errorf("committed blocks: got %v, want %v", committed.get(), want)
+	}
+}
+
+func TestBatchIngestorStopsAtFirstError(t *testing.T) {
+	// with a single store, the blocks before the failing one are all committed before it is ingested,
+	// while several stores might fail before committing them, leaving NextBlock before the failing block
+	stores := []storage.Storage{&failingStore{Storage: newTestStore(t), failingBlock: 3}}
+	var committed committedBlocks
+	ingestor := NewBatchIngestor(context.Background(), stores, 1, BatchIngestOptions{Committed: committed.add, Window: 4})
+	for _, number := range blockNumbers(1, 8) {
+		// submitting fails once the ingestor stopped
+		if err := ingestor.Submit(context.Background(), newEmptyBundle(number)); err != nil {
+			if !errors.Is(err, errTestStoreFailed) {
+				t.Errorf("submitting block %d: got %v, want the error of block 3", number, err)
+			}
+			break
+		}
+	}
+	err := ingestor.Close(context.Background())
+	if !errors.Is(err, errTestStoreFailed) || !strings.Contains(err.Error(), "block 3") {
+		t.Errorf("closing: got %v, want the error of block 3", err)
+	}
+	if next := ingestor.NextBlock(); next != 3 {
+		t.Errorf("next block: got %d, want 3", next)
+	}
+	if want := []storage.BlockNumber{1, 2}; !reflect.DeepEqual(committed.get(), want) {
+		t.Errorf("committed blocks: got %v, want %v", committed.get(), want)
+	}
+}
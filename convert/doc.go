@@ -0,0 +1,2 @@
+// Package convert maps raw node outputs to storage entities, so consumers don't have to.
+package convert
@@ -0,0 +1,203 @@
+package convert
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// addressLookup resolves address ids through a Reader, caching the results.
+type addressLookup struct {
+	reader    storage.Reader
+	addresses map[storage.AddressId]common.Address
+}
+
+func newAddressLookup(reader storage.Reader) *addressLookup {
+	return &addressLookup{
+		reader:    reader,
+		addresses: make(map[storage.AddressId]common.Address),
+	}
+}
+func (lookup *addressLookup) address(addressId storage.AddressId) (common.Address, error) {
+	if address, ok := lookup.addresses[addressId]; ok {
+		return address, nil
+	}
+	address, err := lookup.reader.GetAddressById(addressId)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if address == nil {
+		return common.Address{}, fmt.Errorf("address %v: %w", addressId, storage.ErrNotFound)
+	}
+	lookup.addresses[addressId] = *address
+	return *address, nil
+}
+
+// ToGethHeader maps a storage block back to a go-ethereum header.
+// Fields not persisted by storage (e.g. the withdrawals root) are left empty.
+func ToGethHeader(reader storage.Reader, block *storage.Block) (*types.Header, error) {
+	coinbase, err := newAddressLookup(reader).address(block.MinerAddressId)
+	if err != nil {
+		return nil, err
+	}
+	header := &types.Header{
+		ParentHash:  block.ParentHash,
+		UncleHash:   block.Sha3Uncles,
+		Coinbase:    coinbase,
+		Root:        block.StateRoot,
+		TxHash:      block.TransactionsRoot,
+		ReceiptHash: block.ReceiptsRoot,
+		Bloom:       block.LogsBloom,
+		Difficulty:  block.Difficulty,
+		Number:      new(big.Int).SetUint64(block.Number),
+		GasLimit:    block.GasLimit,
+		GasUsed:     block.GasUsed,
+		Time:        block.Timestamp,
+		Extra:       block.ExtraData,
+		MixDigest:   block.MixHash,
+		Nonce:       types.EncodeNonce(block.Nonce),
+	}
+	if block.BaseFeePerGas != nil && block.BaseFeePerGas.Sign() != 0 {
+		header.BaseFee = block.BaseFeePerGas
+	}
+	return header, nil
+}
+
+// ToGethTransaction maps a storage transaction back to a go-ethereum transaction, decoding its raw encoding.
+// Transactions stored without it are mapped to unsigned transactions, as signatures and access lists are not persisted
+// by storage otherwise, hence the hash of the result differs from the stored one.
+func ToGethTransaction(reader storage.Reader, transaction *storage.Transaction) (*types.Transaction, error) {
+	if transaction.Raw != nil {
+		gethTransaction := new(types.Transaction)
+		if err := gethTransaction.UnmarshalBinary(transaction.Raw); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction %s: %w", transaction.Hash, err)
+		}
+		return gethTransaction, nil
+	}
+	var to *common.Address
+	if transaction.ToAddressId != nil {
+		address, err := newAddressLookup(reader).address(*transaction.ToAddressId)
+		if err != nil {
+			return nil, err
+		}
+		to = &address
+	}
+	switch transaction.Type {
+	case types.LegacyTxType:
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    transaction.Nonce,
+			GasPrice: transaction.GasPrice,
+			Gas:      transaction.Gas,
+			To:       to,
+			Value:    transaction.Value,
+			Data:     transaction.Input,
+		}), nil
+	case types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			Nonce:    transaction.Nonce,
+			GasPrice: transaction.GasPrice,
+			Gas:      transaction.Gas,
+			To:       to,
+			Value:    transaction.Value,
+			Data:     transaction.Input,
+		}), nil
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			Nonce:     transaction.Nonce,
+			GasTipCap: transaction.GasTipCap,
+			GasFeeCap: transaction.GasFeeCap,
+			Gas:       transaction.Gas,
+			To:        to,
+			Value:     transaction.Value,
+			Data:      transaction.Input,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d of transaction %s", transaction.Type, transaction.Hash)
+	}
+}
+
+// ToGethLog maps a storage log back to a go-ethereum log.
+// Inclusion fields are taken from the transaction the log belongs to.
+func ToGethLog(reader storage.Reader, log *storage.Log, transaction *storage.Transaction) (*types.Log, error) {
+	return toGethLog(reader, newAddressLookup(reader), log, transaction)
+}
+func toGethLog(reader storage.Reader, lookup *addressLookup, log *storage.Log, transaction *storage.Transaction) (*types.Log, error) {
+	address, err := lookup.address(log.AddressId)
+	if err != nil {
+		return nil, err
+	}
+	gethLog := &types.Log{
+		Address:     address,
+		Data:        log.Data,
+		BlockNumber: transaction.BlockNumber,
+		TxHash:      transaction.Hash,
+		TxIndex:     uint(transaction.Index),
+		Index:       uint(log.LogIndex),
+	}
+	if log.Topic0Id != nil {
+		eventType, err := reader.GetEventTypeById(*log.Topic0Id)
+		if err != nil {
+			return nil, err
+		}
+		if eventType == nil {
+			return nil, fmt.Errorf("topic0 %v: %w", *log.Topic0Id, storage.ErrNotFound)
+		}
+		gethLog.Topics = append(gethLog.Topics, eventType.Hash)
+		for _, topic := range []*[32]byte{log.Topic1, log.Topic2, log.Topic3} {
+			if topic == nil {
+				break
+			}
+			gethLog.Topics = append(gethLog.Topics, *topic)
+		}
+	}
+	return gethLog, nil
+}
+
+// ToGethReceipt maps a storage receipt and its logs back to a go-ethereum receipt.
+// Logs are ordered by their index, and the logs bloom is recalculated from them.
+func ToGethReceipt(reader storage.Reader, receipt *storage.Receipt, transaction *storage.Transaction, logs []*storage.Log, blockHash common.Hash) (*types.Receipt, error) {
+	lookup := newAddressLookup(reader)
+	gethReceipt := &types.Receipt{
+		Type:              transaction.Type,
+		CumulativeGasUsed: receipt.CumulativeGasUsed,
+		TxHash:            transaction.Hash,
+		GasUsed:           receipt.GasUsed,
+		EffectiveGasPrice: receipt.EffectiveGasPrice,
+		BlockHash:         blockHash,
+		BlockNumber:       new(big.Int).SetUint64(transaction.BlockNumber),
+		TransactionIndex:  uint(transaction.Index),
+		Logs:              []*types.Log{},
+	}
+	if receipt.PostState != (common.Hash{}) {
+		gethReceipt.PostState = receipt.PostState.Bytes()
+	}
+	if receipt.Status == storage.ReceiptStatusSuccess {
+		gethReceipt.Status = types.ReceiptStatusSuccessful
+	}
+	if receipt.ContractAddressId != nil {
+		address, err := lookup.address(*receipt.ContractAddressId)
+		if err != nil {
+			return nil, err
+		}
+		gethReceipt.ContractAddress = address
+	}
+	sortedLogs := make([]*storage.Log, len(logs))
+	copy(sortedLogs, logs)
+	sort.Slice(sortedLogs, func(i, j int) bool {
+		return sortedLogs[i].LogIndex < sortedLogs[j].LogIndex
+	})
+	for _, log := range sortedLogs {
+		gethLog, err := toGethLog(reader, lookup, log, transaction)
+		if err != nil {
+			return nil, err
+		}
+		gethLog.BlockHash = blockHash
+		gethReceipt.Logs = append(gethReceipt.Logs, gethLog)
+	}
+	gethReceipt.Bloom = types.CreateBloom(types.Receipts{gethReceipt})
+	return gethReceipt, nil
+}
@@ -0,0 +1,372 @@
+package convert
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// Erc20TransferTopic is the topic0 of the standard Transfer(address,address,uint256) event.
+// ERC-20 transfers have 3 topics, while ERC-721 transfers have 4, as their token id is indexed too.
+var Erc20TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// BlockBundle is a go-ethereum block together with everything needed to store it.
+//   - Receipts MUST be in the same order as the block's transactions.
+//   - StaticReward and UncleRewards are optional, rewards are stored as 0 when omitted.
+type BlockBundle struct {
+	Block           *types.Block
+	Receipts        types.Receipts
+	TotalDifficulty *big.Int
+	StaticReward    *big.Int
+	UncleRewards    []*big.Int
+}
+
+// IngestOptions customizes Ingest.
+//   - ChainId is used for recovering transaction senders. When nil, the chain id of each transaction is used.
+//   - Code returns the deployed bytecode of contracts created in the block.
+//     When nil, contract creations are only recorded on receipts, and no Contract entities are stored.
+type IngestOptions struct {
+	ChainId *big.Int
+	Code    func(common.Address) ([]byte, error)
+}
+
+// IngestResult holds the storage ids assigned during Ingest.
+type IngestResult struct {
+	TransactionIds []storage.TransactionId
+}
+
+// resolvedIds maps hashes of the referenced entities to their storage ids.
+type resolvedIds struct {
+	addresses   map[common.Address]storage.AddressId
+	topic0s     map[common.Hash]storage.Topic0Id
+	bytecodeIds map[common.Address]storage.BytecodeId
+	senders     []common.Address
+}
+
+// Ingest stores a full go-ethereum block with its uncles, transactions, receipts, logs,
+// ERC-20 token transfers and created contracts, resolving all address and topic0 ids on the way.
+// Like all inserter operations, nothing is committed.
+func Ingest(store storage.Inserter, bundle *BlockBundle, options IngestOptions) (*IngestResult, error) {
+	ids, err := resolveIds(store, bundle, options)
+	if err != nil {
+		return nil, err
+	}
+	return write(store, bundle, ids)
+}
+
+// resolveIds stores all entities shared between blocks, and returns their ids.
+func resolveIds(store storage.Inserter, bundle *BlockBundle, options IngestOptions) (*resolvedIds, error) {
+	block := bundle.Block
+	transactions := block.Transactions()
+	if len(bundle.Receipts) != len(transactions) {
+		return nil, fmt.Errorf("block %d has %d transactions, but %d receipts were given", block.NumberU64(), len(transactions), len(bundle.Receipts))
+	}
+	ids := &resolvedIds{
+		addresses:   make(map[common.Address]storage.AddressId),
+		topic0s:     make(map[common.Hash]storage.Topic0Id),
+		bytecodeIds: make(map[common.Address]storage.BytecodeId),
+	}
+	var addresses []common.Address
+	collectAddress := func(address common.Address) {
+		if _, ok := ids.addresses[address]; !ok {
+			ids.addresses[address] = storage.AddressId{}
+			addresses = append(addresses, address)
+		}
+	}
+	var eventTypes []*storage.EventType
+	collectAddress(block.Coinbase())
+	for _, uncle := range block.Uncles() {
+		collectAddress(uncle.Coinbase)
+	}
+	for i, transaction := range transactions {
+		chainId := options.ChainId
+		if chainId == nil {
+			chainId = transaction.ChainId()
+		}
+		sender, err := types.Sender(types.LatestSignerForChainID(chainId), transaction)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recover sender of transaction %s: %w", transaction.Hash(), err)
+		}
+		ids.senders = append(ids.senders, sender)
+		collectAddress(sender)
+		if to := transaction.To(); to != nil {
+			collectAddress(*to)
+		}
+		receipt := bundle.Receipts[i]
+		if receipt.ContractAddress != (common.Address{}) {
+			collectAddress(receipt.ContractAddress)
+		}
+		for _, log := range receipt.Logs {
+			collectAddress(log.Address)
+			if len(log.Topics) != 0 {
+				if _, ok := ids.topic0s[log.Topics[0]]; !ok {
+					ids.topic0s[log.Topics[0]] = storage.Topic0Id{}
+					eventTypes = append(eventTypes, &storage.EventType{Hash: log.Topics[0]})
+				}
+			}
+			if transfer := parseErc20Transfer(log); transfer != nil {
+				collectAddress(transfer.from)
+				collectAddress(transfer.to)
+			}
+		}
+	}
+	addressIds, err := store.StoreAddress(addresses...)
+	if err != nil {
+		return nil, err
+	}
+	for i, address := range addresses {
+		ids.addresses[address] = addressIds[i]
+	}
+	if len(eventTypes) != 0 {
+		topic0Ids, err := store.StoreTopic0(eventTypes...)
+		if err != nil {
+			return nil, err
+		}
+		for i, eventType := range eventTypes {
+			ids.topic0s[eventType.Hash] = topic0Ids[i]
+		}
+	}
+	if options.Code != nil {
+		for _, receipt := range bundle.Receipts {
+			if receipt.ContractAddress == (common.Address{}) || receipt.Status != types.ReceiptStatusSuccessful {
+				continue
+			}
+			code, err := options.Code(receipt.ContractAddress)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get code of contract %s: %w", receipt.ContractAddress, err)
+			}
+			bytecodeIds, err := store.StoreBytecode(code)
+			if err != nil {
+				return nil, err
+			}
+			ids.bytecodeIds[receipt.ContractAddress] = bytecodeIds[0]
+		}
+	}
+	return ids, nil
+}
+
+// write stores all entities belonging to the block only.
+func write(store storage.Inserter, bundle *BlockBundle, ids *resolvedIds) (*IngestResult, error) {
+	block := bundle.Block
+	if err := store.StoreBlock(ToBlock(block, ids.addresses[block.Coinbase()], bundle.TotalDifficulty, bundle.StaticReward)); err != nil {
+		return nil, err
+	}
+	if uncleHeaders := block.Uncles(); len(uncleHeaders) != 0 {
+		uncles := make([]*storage.Uncle, 0, len(uncleHeaders))
+		for i, uncleHeader := range uncleHeaders {
+			var reward *big.Int
+			if i < len(bundle.UncleRewards) {
+				reward = bundle.UncleRewards[i]
+			}
+			uncles = append(uncles, ToUncle(uncleHeader, uint8(i), block.NumberU64(), ids.addresses[uncleHeader.Coinbase], reward))
+		}
+		if err := store.StoreUncle(uncles...); err != nil {
+			return nil, err
+		}
+	}
+	gethTransactions := block.Transactions()
+	if len(gethTransactions) == 0 {
+		return &IngestResult{}, nil
+	}
+	transactions := make([]*storage.Transaction, 0, len(gethTransactions))
+	for i, gethTransaction := range gethTransactions {
+		var toAddressId *storage.AddressId
+		if to := gethTransaction.To(); to != nil {
+			id := ids.addresses[*to]
+			toAddressId = &id
+		}
+		transactions = append(transactions, ToTransaction(gethTransaction, block.NumberU64(), uint64(i), ids.addresses[ids.senders[i]], toAddressId))
+	}
+	transactionIds, err := store.StoreTransaction(transactions...)
+	if err != nil {
+		return nil, err
+	}
+	var receipts []*storage.Receipt
+	var logs []*storage.Log
+	var transfers []*storage.Erc20TokenTransfer
+	var contracts []*storage.Contract
+	for i, gethReceipt := range bundle.Receipts {
+		transactionId := transactionIds[i]
+		var contractAddressId *storage.AddressId
+		if gethReceipt.ContractAddress != (common.Address{}) {
+			id := ids.addresses[gethReceipt.ContractAddress]
+			contractAddressId = &id
+			if bytecodeId, ok := ids.bytecodeIds[gethReceipt.ContractAddress]; ok {
+				contracts = append(contracts, &storage.Contract{
+					AddressId:     id,
+					TransactionId: transactionId,
+					BytecodeId:    bytecodeId,
+				})
+			}
+		}
+		receipts = append(receipts, ToReceipt(gethReceipt, gethTransactions[i], block.BaseFee(), transactionId, contractAddressId))
+		for _, gethLog := range gethReceipt.Logs {
+			var topic0Id *storage.Topic0Id
+			if len(gethLog.Topics) != 0 {
+				id := ids.topic0s[gethLog.Topics[0]]
+				topic0Id = &id
+			}
+			log := ToLog(gethLog, transactionId, ids.addresses[gethLog.Address], topic0Id)
+			logs = append(logs, log)
+			if transfer := parseErc20Transfer(gethLog); transfer != nil {
+				transfers = append(transfers, &storage.Erc20TokenTransfer{
+					LogId:          log.LogId,
+					TokenAddressId: log.AddressId,
+					FromAddressId:  ids.addresses[transfer.from],
+					ToAddressId:    ids.addresses[transfer.to],
+					Value:          transfer.value,
+				})
+			}
+		}
+	}
+	if err = store.StoreReceipt(receipts...); err != nil {
+		return nil, err
+	}
+	if len(logs) != 0 {
+		if err = store.StoreLog(logs...); err != nil {
+			return nil, err
+		}
+	}
+	if len(transfers) != 0 {
+		if err = store.StoreErc20TokenTransfer(transfers...); err != nil {
+			return nil, err
+		}
+	}
+	if len(contracts) != 0 {
+		if err = store.StoreContract(contracts...); err != nil {
+			return nil, err
+		}
+	}
+	return &IngestResult{TransactionIds: transactionIds}, nil
+}
+
+// ToBlock maps a go-ethereum block to a storage block.
+func ToBlock(block *types.Block, minerAddressId storage.AddressId, totalDifficulty, staticReward *big.Int) *storage.Block {
+	return &storage.Block{
+		Hash:             block.Hash(),
+		ParentHash:       block.ParentHash(),
+		Number:           block.NumberU64(),
+		Nonce:            block.Nonce(),
+		Sha3Uncles:       block.UncleHash(),
+		LogsBloom:        block.Bloom(),
+		StateRoot:        block.Root(),
+		TransactionsRoot: block.TxHash(),
+		ReceiptsRoot:     block.ReceiptHash(),
+		MinerAddressId:   minerAddressId,
+		Difficulty:       block.Difficulty(),
+		TotalDifficulty:  totalDifficulty,
+		Size:             block.Size(),
+		ExtraData:        block.Extra(),
+		GasLimit:         block.GasLimit(),
+		GasUsed:          block.GasUsed(),
+		Timestamp:        block.Time(),
+		BaseFeePerGas:    block.BaseFee(),
+		MixHash:          block.MixDigest(),
+		StaticReward:     staticReward,
+	}
+}
+
+// ToUncle maps a go-ethereum uncle header to a storage uncle.
+func ToUncle(header *types.Header, position uint8, blockHeight storage.BlockNumber, minerAddressId storage.AddressId, reward *big.Int) *storage.Uncle {
+	return &storage.Uncle{
+		Position:       position,
+		Hash:           header.Hash(),
+		UncleHeight:    header.Number.Uint64(),
+		BlockHeight:    blockHeight,
+		ParentHash:     header.ParentHash,
+		MinerAddressId: minerAddressId,
+		Difficulty:     header.Difficulty,
+		GasLimit:       header.GasLimit,
+		GasUsed:        header.GasUsed,
+		Timestamp:      header.Time,
+		Reward:         reward,
+	}
+}
+
+// ToTransaction maps a go-ethereum transaction to a storage transaction.
+// The raw encoding is left nil if the transaction can not be encoded.
+func ToTransaction(transaction *types.Transaction, blockNumber storage.BlockNumber, index uint64, fromAddressId storage.AddressId, toAddressId *storage.AddressId) *storage.Transaction {
+	storageTransaction := &storage.Transaction{
+		BlockNumber:   blockNumber,
+		Hash:          transaction.Hash(),
+		Nonce:         transaction.Nonce(),
+		Index:         index,
+		FromAddressId: fromAddressId,
+		ToAddressId:   toAddressId,
+		Value:         transaction.Value(),
+		Gas:           transaction.Gas(),
+		GasPrice:      transaction.GasPrice(),
+		Input:         transaction.Data(),
+		Type:          transaction.Type(),
+	}
+	if transaction.Type() >= types.DynamicFeeTxType {
+		storageTransaction.GasTipCap = transaction.GasTipCap()
+		storageTransaction.GasFeeCap = transaction.GasFeeCap()
+	}
+	if raw, err := transaction.MarshalBinary(); err == nil {
+		storageTransaction.Raw = raw
+	}
+	return storageTransaction
+}
+
+// ToReceipt maps a go-ethereum receipt to a storage receipt.
+// If the receipt lacks the effective gas price, it is calculated from the transaction and the block's base fee.
+func ToReceipt(receipt *types.Receipt, transaction *types.Transaction, baseFee *big.Int, transactionId storage.TransactionId, contractAddressId *storage.AddressId) *storage.Receipt {
+	effectiveGasPrice := receipt.EffectiveGasPrice
+	if effectiveGasPrice == nil {
+		if baseFee == nil {
+			effectiveGasPrice = transaction.GasPrice()
+		} else {
+			effectiveGasPrice = new(big.Int).Add(baseFee, transaction.EffectiveGasTipValue(baseFee))
+		}
+	}
+	return &storage.Receipt{
+		TransactionId:     transactionId,
+		CumulativeGasUsed: receipt.CumulativeGasUsed,
+		GasUsed:           receipt.GasUsed,
+		ContractAddressId: contractAddressId,
+		PostState:         common.BytesToHash(receipt.PostState),
+		Status:            receipt.Status == types.ReceiptStatusSuccessful,
+		EffectiveGasPrice: effectiveGasPrice,
+	}
+}
+
+// ToLog maps a go-ethereum log to a storage log.
+func ToLog(log *types.Log, transactionId storage.TransactionId, addressId storage.AddressId, topic0Id *storage.Topic0Id) *storage.Log {
+	storageLog := &storage.Log{
+		LogId: storage.LogId{
+			TransactionId: transactionId,
+			LogIndex:      uint64(log.Index),
+		},
+		AddressId: addressId,
+		Topic0Id:  topic0Id,
+		Data:      log.Data,
+	}
+	topics := []**[32]byte{&storageLog.Topic1, &storageLog.Topic2, &storageLog.Topic3}
+	for i := 1; i < len(log.Topics) && i <= len(topics); i++ {
+		topic := [32]byte(log.Topics[i])
+		*topics[i-1] = &topic
+	}
+	return storageLog
+}
+
+type erc20Transfer struct {
+	from, to common.Address
+	value    *big.Int
+}
+
+// parseErc20Transfer returns nil if the log is not an ERC-20 Transfer event.
+func parseErc20Transfer(log *types.Log) *erc20Transfer {
+	if len(log.Topics) != 3 || log.Topics[0] != Erc20TransferTopic || len(log.Data) != common.HashLength {
+		return nil
+	}
+	return &erc20Transfer{
+		from:  common.BytesToAddress(log.Topics[1].Bytes()),
+		to:    common.BytesToAddress(log.Topics[2].Bytes()),
+		value: new(big.Int).SetBytes(log.Data),
+	}
+}
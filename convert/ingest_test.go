@@ -0,0 +1,250 @@
+package convert
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/sqlite"
+)
+
+// testChainId is the chain id the transactions of testBundle are signed for.
+var testChainId = big.NewInt(1337)
+
+// testBundle holds a block built by go-ethereum, so its transaction and receipt roots are the real ones:
+//   - a signed dynamic fee transaction creating the token contract,
+//   - a signed legacy transaction calling it, logging an ERC-20 transfer and an ERC-721 transfer.
+type testBundle struct {
+	*BlockBundle
+	sender, recipient, token common.Address
+	code                     []byte
+	transferred              *big.Int
+}
+
+func newTestBundle(t *testing.T) *testBundle {
+	t.Helper()
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := &testBundle{
+		sender:      crypto.PubkeyToAddress(key.PublicKey),
+		recipient:   common.HexToAddress("0x00000000000000000000000000000000000000cc"),
+		code:        []byte{0x60, 0x80, 0x60, 0x40, 0x52},
+		transferred: big.NewInt(1000),
+	}
+	bundle.token = crypto.CreateAddress(bundle.sender, 0)
+	signer := types.LatestSignerForChainID(testChainId)
+	creation := signTransaction(t, signer, key, &types.DynamicFeeTx{
+		ChainID:   testChainId,
+		Nonce:     0,
+		GasTipCap: big.NewInt(2_000_000_000),
+		GasFeeCap: big.NewInt(30_000_000_000),
+		Gas:       200_000,
+		Data:      append([]byte{0x60, 0x05}, bundle.code...),
+	})
+	call := signTransaction(t, signer, key, &types.LegacyTx{
+		Nonce:    1,
+		GasPrice: big.NewInt(20_000_000_000),
+		Gas:      100_000,
+		To:       &bundle.token,
+		Value:    big.NewInt(0),
+		Data:     common.FromHex("0xa9059cbb"),
+	})
+	receipts := types.Receipts{{
+		Type:              creation.Type(),
+		Status:            types.ReceiptStatusSuccessful,
+		CumulativeGasUsed: 150_000,
+		GasUsed:           150_000,
+		ContractAddress:   bundle.token,
+		Logs:              []*types.Log{},
+	}, {
+		Type:              call.Type(),
+		Status:            types.ReceiptStatusSuccessful,
+		CumulativeGasUsed: 210_000,
+		GasUsed:           60_000,
+		Logs: []*types.Log{{
+			Address: bundle.token,
+			Topics:  []common.Hash{Erc20TransferTopic, common.BytesToHash(bundle.sender.Bytes()), common.BytesToHash(bundle.recipient.Bytes())},
+			Data:    common.BigToHash(bundle.transferred).Bytes(),
+			Index:   0,
+		}, {
+			// an ERC-721 transfer of token 7, having the same topic0 but indexing the token id
+			Address: bundle.token,
+			Topics:  []common.Hash{Erc20TransferTopic, common.BytesToHash(bundle.sender.Bytes()), common.BytesToHash(bundle.recipient.Bytes()), common.BigToHash(big.NewInt(7))},
+			Data:    []byte{},
+			Index:   1,
+		}},
+	}}
+	for _, receipt := range receipts {
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+	}
+	header := &types.Header{
+		ParentHash: common.HexToHash("0xb6"),
+		Coinbase:   common.HexToAddress("0x00000000000000000000000000000000000000aa"),
+		Root:       common.HexToHash("0x5e7"),
+		Difficulty: big.NewInt(0),
+		Number:     big.NewInt(7),
+		GasLimit:   30_000_000,
+		GasUsed:    210_000,
+		Time:       1700000084,
+		Extra:      []byte("librescan"),
+		BaseFee:    big.NewInt(1_000_000_000),
+	}
+	bundle.BlockBundle = &BlockBundle{
+		Block:           types.NewBlock(header, types.Transactions{creation, call}, nil, receipts, trie.NewStackTrie(nil)),
+		Receipts:        receipts,
+		TotalDifficulty: big.NewInt(0),
+		StaticReward:    big.NewInt(0),
+	}
+	return bundle
+}
+
+func signTransaction(t *testing.T, signer types.Signer, key *ecdsa.PrivateKey, data types.TxData) *types.Transaction {
+	t.Helper()
+	transaction, err := types.SignNewTx(key, signer, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transaction
+}
+
+// newTestStore returns a loaded repository of an in-memory SQLite database.
+func newTestStore(t *testing.T) storage.Storage {
+	t.Helper()
+	repo := sqlite.NewSqliteRepository(":memory:")
+	if err := repo.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// ingestTestBundle stores the bundle, along with the code of the contract it creates.
+func ingestTestBundle(t *testing.T, store storage.Storage, bundle *testBundle) *IngestResult {
+	t.Helper()
+	result, err := Ingest(store, bundle.BlockBundle, IngestOptions{
+		ChainId: testChainId,
+		Code: func(address common.Address) ([]byte, error) {
+			if address != bundle.token {
+				t.Errorf("code of %s was requested, which is not a created contract", address)
+			}
+			return bundle.code, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestIngestRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	bundle := newTestBundle(t)
+	result := ingestTestBundle(t, store, bundle)
+	gethBlock := bundle.Block
+
+	block, err := store.GetBlockByNumber(gethBlock.NumberU64())
+	if err != nil || block == nil {
+		t.Fatalf("block %d: %v, %v", gethBlock.NumberU64(), block, err)
+	}
+	header, err := ToGethHeader(store, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Hash() != gethBlock.Hash() {
+		t.Errorf("hash of the mapped header: got %s, want %s", header.Hash(), gethBlock.Hash())
+	}
+
+	transactions, transactionIds, _, err := store.ListTransactionsByBlockNumber(gethBlock.NumberU64(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != len(gethBlock.Transactions()) {
+		t.Fatalf("got %d transactions, want %d", len(transactions), len(gethBlock.Transactions()))
+	}
+	gethReceipts := make(types.Receipts, len(transactions))
+	for i, transaction := range transactions {
+		index := transaction.Index
+		if transactionIds[i] != result.TransactionIds[index] {
+			t.Errorf("id of transaction %d: got %v, want %v", index, transactionIds[i], result.TransactionIds[index])
+		}
+		gethTransaction, err := ToGethTransaction(store, transaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := gethBlock.Transactions()[index].Hash(); gethTransaction.Hash() != want {
+			t.Errorf("hash of mapped transaction %d: got %s, want %s", index, gethTransaction.Hash(), want)
+		}
+		receipt, err := store.GetReceiptByTransactionId(transactionIds[i])
+		if err != nil || receipt == nil {
+			t.Fatalf("receipt of transaction %d: %v, %v", index, receipt, err)
+		}
+		logs, err := store.ListLogsByTransactionId(transactionIds[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		gethReceipt, err := ToGethReceipt(store, receipt, transaction, logs, gethBlock.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := bundle.Receipts[index]
+		if gethReceipt.ContractAddress != want.ContractAddress {
+			t.Errorf("contract created by transaction %d: got %s, want %s", index, gethReceipt.ContractAddress, want.ContractAddress)
+		}
+		if gethReceipt.Bloom != want.Bloom {
+			t.Errorf("logs bloom of the receipt of transaction %d differs", index)
+		}
+		for j, log := range gethReceipt.Logs {
+			if !reflect.DeepEqual(log.Topics, want.Logs[j].Topics) || log.Address != want.Logs[j].Address {
+				t.Errorf("log %d of transaction %d: got %s %v, want %s %v", j, index, log.Address, log.Topics, want.Logs[j].Address, want.Logs[j].Topics)
+			}
+		}
+		gethReceipts[index] = gethReceipt
+	}
+	if root := types.DeriveSha(gethReceipts, trie.NewStackTrie(nil)); root != gethBlock.ReceiptHash() {
+		t.Errorf("receipts root of the mapped receipts: got %s, want %s", root, gethBlock.ReceiptHash())
+	}
+
+	tokenId, err := store.GetAddressIdByHash(bundle.token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract, err := store.GetContractByAddressId(tokenId)
+	if err != nil || contract == nil {
+		t.Fatalf("contract %s: %v, %v", bundle.token, contract, err)
+	}
+	if contract.TransactionId != result.TransactionIds[0] {
+		t.Errorf("contract created by transaction %v, want %v", contract.TransactionId, result.TransactionIds[0])
+	}
+	bytecode, err := store.GetByteCode(contract.BytecodeId)
+	if err != nil || bytecode == nil || !reflect.DeepEqual([]byte(*bytecode), bundle.code) {
+		t.Errorf("bytecode of contract %s: got %v, %v, want %x", bundle.token, bytecode, err, bundle.code)
+	}
+
+	// only the ERC-20 transfer is derived from the logs, the ERC-721 one has 4 topics
+	transfers, _, err := store.ListErc20TokenTransfers(&bundle.token, nil, &storage.OffsetPagination{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 {
+		t.Fatalf("got %d transfers, want 1", len(transfers))
+	}
+	senderId, _ := store.GetAddressIdByHash(bundle.sender)
+	recipientId, _ := store.GetAddressIdByHash(bundle.recipient)
+	want := &storage.Erc20TokenTransfer{
+		LogId:          storage.LogId{TransactionId: result.TransactionIds[1], LogIndex: 0},
+		TokenAddressId: tokenId,
+		FromAddressId:  senderId,
+		ToAddressId:    recipientId,
+		Value:          bundle.transferred,
+	}
+	if !reflect.DeepEqual(transfers[0], want) {
+		t.Errorf("transfer: got %+v, want %+v", transfers[0], want)
+	}
+}
@@ -0,0 +1,326 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	storage "github.com/librescan-org/backend-db"
+)
+
+const (
+	traceTypeCreate       = "CREATE"
+	traceTypeCreate2      = "CREATE2"
+	traceTypeSelfdestruct = "SELFDESTRUCT"
+)
+
+// AddressResolver returns the storage ids of the given addresses, in the same order.
+// storage.Inserter's StoreAddress method satisfies it.
+type AddressResolver func(...common.Address) ([]storage.AddressId, error)
+
+// CallFrame mirrors a single frame of go-ethereum's callTracer output.
+type CallFrame struct {
+	Type         string          `json:"type"`
+	From         common.Address  `json:"from"`
+	To           *common.Address `json:"to,omitempty"`
+	Value        *hexutil.Big    `json:"value,omitempty"`
+	Gas          hexutil.Uint64  `json:"gas"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	Input        hexutil.Bytes   `json:"input"`
+	Output       hexutil.Bytes   `json:"output,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	RevertReason string          `json:"revertReason,omitempty"`
+	Calls        []CallFrame     `json:"calls,omitempty"`
+}
+
+// ParityTrace mirrors a single element of parity style trace lists,
+// as returned by trace_block, trace_transaction or go-ethereum's flatCallTracer.
+type ParityTrace struct {
+	Action struct {
+		CallType       string          `json:"callType,omitempty"`
+		CreationMethod string          `json:"creationMethod,omitempty"`
+		From           *common.Address `json:"from,omitempty"`
+		To             *common.Address `json:"to,omitempty"`
+		Gas            *hexutil.Uint64 `json:"gas,omitempty"`
+		Input          *hexutil.Bytes  `json:"input,omitempty"`
+		Init           *hexutil.Bytes  `json:"init,omitempty"`
+		Value          *hexutil.Big    `json:"value,omitempty"`
+		SelfDestructed *common.Address `json:"address,omitempty"`
+		RefundAddress  *common.Address `json:"refundAddress,omitempty"`
+		Balance        *hexutil.Big    `json:"balance,omitempty"`
+	} `json:"action"`
+	Result *struct {
+		Address *common.Address `json:"address,omitempty"`
+		Code    *hexutil.Bytes  `json:"code,omitempty"`
+		GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+		Output  *hexutil.Bytes  `json:"output,omitempty"`
+	} `json:"result,omitempty"`
+	Error               string       `json:"error,omitempty"`
+	TraceAddress        []uint64     `json:"traceAddress"`
+	TransactionHash     *common.Hash `json:"transactionHash"`
+	TransactionPosition uint64       `json:"transactionPosition"`
+	Type                string       `json:"type"`
+}
+
+// tracedCall is the backend agnostic form of a single trace, before address resolution.
+type tracedCall struct {
+	traceAddress []uint64
+	typ          string
+	from         common.Address
+	to           common.Address
+	created      *common.Address
+	refund       *common.Address
+	value        *big.Int
+	gas          uint64
+	gasUsed      uint64
+	input        []byte
+	initCode     []byte
+	output       []byte
+	err          *string
+}
+
+// CallTracerToTraceActions converts the raw JSON output of debug_traceTransaction's callTracer
+// to trace actions of the given transaction, ready for StoreTrace.
+// Traces are indexed in depth-first order, starting from 0 for the top level call.
+func CallTracerToTraceActions(transactionId storage.TransactionId, callTracerJSON []byte, resolve AddressResolver) ([]*storage.TraceAction, error) {
+	var root CallFrame
+	if err := json.Unmarshal(callTracerJSON, &root); err != nil {
+		return nil, fmt.Errorf("invalid callTracer output: %w", err)
+	}
+	return CallFrameToTraceActions(transactionId, &root, resolve)
+}
+
+// CallFrameToTraceActions is the same as CallTracerToTraceActions, but operates on an already decoded frame.
+func CallFrameToTraceActions(transactionId storage.TransactionId, root *CallFrame, resolve AddressResolver) ([]*storage.TraceAction, error) {
+	var calls []*tracedCall
+	var walk func(frame *CallFrame, traceAddress []uint64)
+	walk = func(frame *CallFrame, traceAddress []uint64) {
+		calls = append(calls, callFrameToTracedCall(frame, traceAddress))
+		for i := range frame.Calls {
+			walk(&frame.Calls[i], append(append([]uint64(nil), traceAddress...), uint64(i)))
+		}
+	}
+	walk(root, nil)
+	return toTraceActions(transactionId, calls, resolve)
+}
+
+// ParityToTraceActions converts the raw JSON output of trace_block, trace_transaction or go-ethereum's flatCallTracer
+// to trace actions, ready for StoreTrace.
+// transactionIds maps the traced transactions' hashes to their storage ids, a trace of any other transaction is an error.
+// Block reward traces are skipped. Traces are indexed per transaction, in the order of their trace addresses.
+func ParityToTraceActions(parityJSON []byte, transactionIds map[common.Hash]storage.TransactionId, resolve AddressResolver) ([]*storage.TraceAction, error) {
+	var parityTraces []ParityTrace
+	if err := json.Unmarshal(parityJSON, &parityTraces); err != nil {
+		return nil, fmt.Errorf("invalid parity trace output: %w", err)
+	}
+	var transactionHashes []common.Hash
+	callsByTransaction := make(map[common.Hash][]*tracedCall)
+	for i := range parityTraces {
+		parityTrace := &parityTraces[i]
+		if parityTrace.Type == "reward" || parityTrace.TransactionHash == nil {
+			continue
+		}
+		call, err := parityTraceToTracedCall(parityTrace)
+		if err != nil {
+			return nil, err
+		}
+		hash := *parityTrace.TransactionHash
+		if _, ok := callsByTransaction[hash]; !ok {
+			transactionHashes = append(transactionHashes, hash)
+		}
+		callsByTransaction[hash] = append(callsByTransaction[hash], call)
+	}
+	var traceActions []*storage.TraceAction
+	for _, hash := range transactionHashes {
+		transactionId, ok := transactionIds[hash]
+		if !ok {
+			return nil, fmt.Errorf("no transaction id given for traced transaction %s", hash)
+		}
+		calls := callsByTransaction[hash]
+		sort.SliceStable(calls, func(i, j int) bool {
+			return storage.CompareTraceAddresses(calls[i].traceAddress, calls[j].traceAddress) < 0
+		})
+		converted, err := toTraceActions(transactionId, calls, resolve)
+		if err != nil {
+			return nil, err
+		}
+		traceActions = append(traceActions, converted...)
+	}
+	return traceActions, nil
+}
+func callFrameToTracedCall(frame *CallFrame, traceAddress []uint64) *tracedCall {
+	call := &tracedCall{
+		traceAddress: traceAddress,
+		typ:          strings.ToUpper(frame.Type),
+		from:         frame.From,
+		value:        (*big.Int)(frame.Value),
+		gas:          uint64(frame.Gas),
+		gasUsed:      uint64(frame.GasUsed),
+		input:        frame.Input,
+		output:       frame.Output,
+	}
+	if frame.To != nil {
+		call.to = *frame.To
+	}
+	if frame.Error != "" {
+		errorMessage := frame.Error
+		call.err = &errorMessage
+	}
+	switch call.typ {
+	case traceTypeCreate, traceTypeCreate2:
+		call.initCode = frame.Input
+		if frame.To != nil && call.err == nil {
+			createdAddress := *frame.To
+			call.created = &createdAddress
+		}
+	case traceTypeSelfdestruct:
+		refundAddress := call.to
+		call.refund = &refundAddress
+	}
+	return call
+}
+func parityTraceToTracedCall(parityTrace *ParityTrace) (*tracedCall, error) {
+	action := &parityTrace.Action
+	call := &tracedCall{
+		traceAddress: parityTrace.TraceAddress,
+		value:        (*big.Int)(action.Value),
+	}
+	if parityTrace.Error != "" {
+		errorMessage := parityTrace.Error
+		call.err = &errorMessage
+	}
+	if action.Gas != nil {
+		call.gas = uint64(*action.Gas)
+	}
+	if result := parityTrace.Result; result != nil {
+		if result.GasUsed != nil {
+			call.gasUsed = uint64(*result.GasUsed)
+		}
+		if result.Output != nil {
+			call.output = *result.Output
+		}
+	}
+	switch parityTrace.Type {
+	case "call":
+		if action.From == nil || action.To == nil {
+			return nil, fmt.Errorf("call trace %v of transaction %s misses its from or to address", parityTrace.TraceAddress, parityTrace.TransactionHash)
+		}
+		call.typ = strings.ToUpper(action.CallType)
+		call.from = *action.From
+		call.to = *action.To
+		if action.Input != nil {
+			call.input = *action.Input
+		}
+	case "create":
+		if action.From == nil {
+			return nil, fmt.Errorf("create trace %v of transaction %s misses its from address", parityTrace.TraceAddress, parityTrace.TransactionHash)
+		}
+		call.typ = traceTypeCreate
+		if action.CreationMethod != "" {
+			call.typ = strings.ToUpper(action.CreationMethod)
+		}
+		call.from = *action.From
+		if action.Init != nil {
+			call.input = *action.Init
+			call.initCode = *action.Init
+		}
+		if result := parityTrace.Result; result != nil {
+			if result.Address != nil {
+				call.to = *result.Address
+				if call.err == nil {
+					createdAddress := *result.Address
+					call.created = &createdAddress
+				}
+			}
+			if result.Code != nil {
+				call.output = *result.Code
+			}
+		}
+	case "suicide":
+		if action.SelfDestructed == nil || action.RefundAddress == nil {
+			return nil, fmt.Errorf("suicide trace %v of transaction %s misses its address or refund address", parityTrace.TraceAddress, parityTrace.TransactionHash)
+		}
+		call.typ = traceTypeSelfdestruct
+		call.from = *action.SelfDestructed
+		call.to = *action.RefundAddress
+		refundAddress := *action.RefundAddress
+		call.refund = &refundAddress
+		call.value = (*big.Int)(action.Balance)
+	default:
+		return nil, fmt.Errorf("unsupported trace type %q in transaction %s", parityTrace.Type, parityTrace.TransactionHash)
+	}
+	return call, nil
+}
+
+// toTraceActions resolves all addresses of the calls in a single round, and converts them to trace actions.
+func toTraceActions(transactionId storage.TransactionId, calls []*tracedCall, resolve AddressResolver) ([]*storage.TraceAction, error) {
+	if len(calls) > math.MaxUint16+1 {
+		return nil, fmt.Errorf("transaction %v has %d traces, exceeding the maximum of %d", transactionId, len(calls), math.MaxUint16+1)
+	}
+	var addresses []common.Address
+	addressIndexes := make(map[common.Address]int)
+	collect := func(address common.Address) {
+		if _, ok := addressIndexes[address]; !ok {
+			addressIndexes[address] = len(addresses)
+			addresses = append(addresses, address)
+		}
+	}
+	for _, call := range calls {
+		collect(call.from)
+		collect(call.to)
+		if call.created != nil {
+			collect(*call.created)
+		}
+		if call.refund != nil {
+			collect(*call.refund)
+		}
+	}
+	addressIds, err := resolve(addresses...)
+	if err != nil {
+		return nil, err
+	}
+	if len(addressIds) != len(addresses) {
+		return nil, fmt.Errorf("address resolver returned %d ids for %d addresses", len(addressIds), len(addresses))
+	}
+	addressIdOf := func(address common.Address) storage.AddressId {
+		return addressIds[addressIndexes[address]]
+	}
+	traceActions := make([]*storage.TraceAction, 0, len(calls))
+	for i, call := range calls {
+		value := call.value
+		if value == nil {
+			value = new(big.Int)
+		}
+		traceAction := &storage.TraceAction{
+			TransactionId: transactionId,
+			Index:         uint16(i),
+			TraceAddress:  call.traceAddress,
+			Depth:         uint16(len(call.traceAddress)),
+			Type:          call.typ,
+			Input:         call.input,
+			Output:        call.output,
+			From:          addressIdOf(call.from),
+			To:            addressIdOf(call.to),
+			Value:         value,
+			Gas:           call.gas,
+			GasUsed:       call.gasUsed,
+			Error:         call.err,
+			InitCode:      call.initCode,
+		}
+		if call.created != nil {
+			createdAddressId := addressIdOf(*call.created)
+			traceAction.CreatedAddressId = &createdAddressId
+		}
+		if call.refund != nil {
+			refundAddressId := addressIdOf(*call.refund)
+			traceAction.RefundAddressId = &refundAddressId
+		}
+		traceActions = append(traceActions, traceAction)
+	}
+	return traceActions, nil
+}
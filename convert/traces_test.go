@@ -0,0 +1,205 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	storage "github.com/librescan-org/backend-db"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// fakeAddressResolver assigns ids to addresses in the order they are first resolved, like a fresh database.
+type fakeAddressResolver struct {
+	ids       map[common.Address]storage.AddressId
+	addresses map[storage.AddressId]common.Address
+}
+
+func newFakeAddressResolver() *fakeAddressResolver {
+	return &fakeAddressResolver{ids: map[common.Address]storage.AddressId{}, addresses: map[storage.AddressId]common.Address{}}
+}
+func (resolver *fakeAddressResolver) resolve(addresses ...common.Address) ([]storage.AddressId, error) {
+	ids := make([]storage.AddressId, 0, len(addresses))
+	for _, address := range addresses {
+		id, ok := resolver.ids[address]
+		if !ok {
+			id = storage.NewAddressId(int64(len(resolver.ids) + 1))
+			resolver.ids[address] = id
+			resolver.addresses[id] = address
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// goldenTraceAction is the form of a storage.TraceAction in the golden files, naming addresses instead of their ids.
+type goldenTraceAction struct {
+	TransactionId  storage.TransactionId `json:"transactionId"`
+	Index          uint16                `json:"index"`
+	TraceAddress   []uint64              `json:"traceAddress"`
+	Depth          uint16                `json:"depth"`
+	Type           string                `json:"type"`
+	From           common.Address        `json:"from"`
+	To             common.Address        `json:"to"`
+	Value          *big.Int              `json:"value"`
+	Gas            uint64                `json:"gas"`
+	GasUsed        uint64                `json:"gasUsed"`
+	Input          hexutil.Bytes         `json:"input"`
+	Output         hexutil.Bytes         `json:"output"`
+	InitCode       hexutil.Bytes         `json:"initCode"`
+	Error          *string               `json:"error"`
+	CreatedAddress *common.Address       `json:"createdAddress"`
+	RefundAddress  *common.Address       `json:"refundAddress"`
+}
+
+func (resolver *fakeAddressResolver) golden(traceActions []*storage.TraceAction) []goldenTraceAction {
+	addressOf := func(id *storage.AddressId) *common.Address {
+		if id == nil {
+			return nil
+		}
+		address := resolver.addresses[*id]
+		return &address
+	}
+	golden := make([]goldenTraceAction, 0, len(traceActions))
+	for _, traceAction := range traceActions {
+		traceAddress := traceAction.TraceAddress
+		if traceAddress == nil {
+			traceAddress = []uint64{}
+		}
+		golden = append(golden, goldenTraceAction{
+			TransactionId:  traceAction.TransactionId,
+			Index:          traceAction.Index,
+			TraceAddress:   traceAddress,
+			Depth:          traceAction.Depth,
+			Type:           traceAction.Type,
+			From:           resolver.addresses[traceAction.From],
+			To:             resolver.addresses[traceAction.To],
+			Value:          traceAction.Value,
+			Gas:            traceAction.Gas,
+			GasUsed:        traceAction.GasUsed,
+			Input:          traceAction.Input,
+			Output:         traceAction.Output,
+			InitCode:       traceAction.InitCode,
+			Error:          traceAction.Error,
+			CreatedAddress: addressOf(traceAction.CreatedAddressId),
+			RefundAddress:  addressOf(traceAction.RefundAddressId),
+		})
+	}
+	return golden
+}
+
+// checkGolden compares the trace actions to the golden file, or rewrites it with -update.
+func checkGolden(t *testing.T, goldenPath string, golden []goldenTraceAction) {
+	t.Helper()
+	actual, err := json.MarshalIndent(golden, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+	if *update {
+		if err = os.WriteFile(goldenPath, actual, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("trace actions differ from %s, run the test with -update to rewrite it:\n%s", goldenPath, actual)
+	}
+}
+
+func TestTraceActionsGolden(t *testing.T) {
+	transactionA := common.HexToHash("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	transactionB := common.HexToHash("0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	tests := []struct {
+		name    string
+		fixture string
+		convert func(fixture []byte, resolve AddressResolver) ([]*storage.TraceAction, error)
+		// traceAddresses and values are checked besides the golden file, as they are the easiest to break
+		traceAddresses [][]uint64
+		values         []string
+	}{
+		{
+			name:    "callTracer nested calls",
+			fixture: "calltracer_nested.json",
+			convert: func(fixture []byte, resolve AddressResolver) ([]*storage.TraceAction, error) {
+				return CallTracerToTraceActions(storage.NewTransactionId(1), fixture, resolve)
+			},
+			traceAddresses: [][]uint64{nil, {0}, {0, 0}, {0, 1}, {0, 2}, {0, 2, 0}, {0, 3}, {0, 4}},
+			values:         []string{"1000000000000000000", "0", "0", "0", "10000000000000000", "10000000000000000", "0", "0"},
+		},
+		{
+			name:    "callTracer plain transfer",
+			fixture: "calltracer_transfer.json",
+			convert: func(fixture []byte, resolve AddressResolver) ([]*storage.TraceAction, error) {
+				return CallTracerToTraceActions(storage.NewTransactionId(2), fixture, resolve)
+			},
+			traceAddresses: [][]uint64{nil},
+			values:         []string{"2000000000000000000"},
+		},
+		{
+			name:    "parity block",
+			fixture: "parity_block.json",
+			convert: func(fixture []byte, resolve AddressResolver) ([]*storage.TraceAction, error) {
+				transactionIds := map[common.Hash]storage.TransactionId{
+					transactionA: storage.NewTransactionId(1),
+					transactionB: storage.NewTransactionId(2),
+				}
+				return ParityToTraceActions(fixture, transactionIds, resolve)
+			},
+			traceAddresses: [][]uint64{{}, {0}, {0, 0}, {0, 1}, {0, 2}, {0, 2, 0}, {0, 3}, {0, 4}, {}},
+			values:         []string{"1000000000000000000", "1000000000000000000", "0", "0", "10000000000000000", "10000000000000000", "0", "0", "2000000000000000000"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixturePath := filepath.Join("testdata", test.fixture)
+			fixture, err := os.ReadFile(fixturePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resolver := newFakeAddressResolver()
+			traceActions, err := test.convert(fixture, resolver.resolve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(traceActions) != len(test.traceAddresses) {
+				t.Fatalf("got %d trace actions, expected %d", len(traceActions), len(test.traceAddresses))
+			}
+			for i, traceAction := range traceActions {
+				if storage.CompareTraceAddresses(traceAction.TraceAddress, test.traceAddresses[i]) != 0 {
+					t.Errorf("trace %d has trace address %v, expected %v", i, traceAction.TraceAddress, test.traceAddresses[i])
+				}
+				if int(traceAction.Depth) != len(test.traceAddresses[i]) {
+					t.Errorf("trace %d has depth %d, expected %d", i, traceAction.Depth, len(test.traceAddresses[i]))
+				}
+				if traceAction.Value.String() != test.values[i] {
+					t.Errorf("trace %d has value %s, expected %s", i, traceAction.Value, test.values[i])
+				}
+			}
+			goldenPath := fixturePath[:len(fixturePath)-len(filepath.Ext(fixturePath))] + ".golden.json"
+			checkGolden(t, goldenPath, resolver.golden(traceActions))
+		})
+	}
+}
+
+func TestParityToTraceActionsRejectsUnknownTransactions(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "parity_block.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParityToTraceActions(fixture, map[common.Hash]storage.TransactionId{}, newFakeAddressResolver().resolve)
+	if err == nil {
+		t.Fatal("traces of a transaction without id were converted")
+	}
+}
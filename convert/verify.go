@@ -0,0 +1,111 @@
+package convert
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// Roots of a block checked by VerifyBlockRoots.
+const (
+	RootTransactions = "transactions"
+	RootReceipts     = "receipts"
+)
+
+// RootMismatch is a root of a stored block differing from the root of the trie rebuilt from the stored data,
+// or a root which can not be rebuilt from the stored data.
+//   - Unverifiable is the reason the trie can not be rebuilt, in which case Computed is the zero hash.
+type RootMismatch struct {
+	BlockNumber  storage.BlockNumber
+	Root         string
+	Stored       common.Hash
+	Computed     common.Hash
+	Unverifiable string
+}
+
+// VerifyBlockRoots rebuilds the transaction and receipt tries of the block from the stored transactions and receipts,
+// and returns the roots differing from the ones stored with the block.
+// Roots not stored with the block, as it was stored by an earlier version, are not verified.
+// The transactions root can not be rebuilt if a transaction is stored without its raw encoding,
+// nor the receipts root if a receipt is missing, those roots are returned as unverifiable instead.
+func VerifyBlockRoots(reader storage.Reader, block *storage.Block) ([]*RootMismatch, error) {
+	if block.TransactionsRoot == (common.Hash{}) && block.ReceiptsRoot == (common.Hash{}) {
+		return nil, nil
+	}
+	transactions, transactionIds, _, err := reader.ListTransactionsByBlockNumber(block.Number, nil)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]int, len(transactions))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return transactions[indexes[i]].Index < transactions[indexes[j]].Index
+	})
+	var gethTransactions types.Transactions
+	var gethReceipts types.Receipts
+	// the reasons the tries can not be rebuilt, naming the first transaction missing data
+	var transactionsUnverifiable, receiptsUnverifiable string
+	for _, i := range indexes {
+		if transactions[i].Raw == nil {
+			if transactionsUnverifiable == "" {
+				transactionsUnverifiable = fmt.Sprintf("transaction %s is stored without its raw encoding", transactions[i].Hash)
+			}
+		} else {
+			gethTransaction, err := ToGethTransaction(reader, transactions[i])
+			if err != nil {
+				return nil, err
+			}
+			gethTransactions = append(gethTransactions, gethTransaction)
+		}
+		if block.ReceiptsRoot == (common.Hash{}) {
+			continue
+		}
+		receipt, err := reader.GetReceiptByTransactionId(transactionIds[i])
+		if err != nil {
+			return nil, err
+		}
+		if receipt == nil {
+			if receiptsUnverifiable == "" {
+				receiptsUnverifiable = fmt.Sprintf("receipt of transaction %s is missing", transactions[i].Hash)
+			}
+			continue
+		}
+		logs, err := reader.ListLogsByTransactionId(transactionIds[i])
+		if err != nil {
+			return nil, err
+		}
+		gethReceipt, err := ToGethReceipt(reader, receipt, transactions[i], logs, block.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map receipt of transaction %s: %w", transactions[i].Hash, err)
+		}
+		gethReceipts = append(gethReceipts, gethReceipt)
+	}
+	var mismatches []*RootMismatch
+	if mismatch := verifyRoot(block, RootTransactions, block.TransactionsRoot, gethTransactions, transactionsUnverifiable); mismatch != nil {
+		mismatches = append(mismatches, mismatch)
+	}
+	if mismatch := verifyRoot(block, RootReceipts, block.ReceiptsRoot, gethReceipts, receiptsUnverifiable); mismatch != nil {
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches, nil
+}
+
+// verifyRoot compares the stored root with the root of the trie of the list, unless it is not stored or the trie can not be rebuilt.
+func verifyRoot(block *storage.Block, name string, stored common.Hash, list types.DerivableList, unverifiable string) *RootMismatch {
+	if stored == (common.Hash{}) {
+		return nil
+	}
+	if unverifiable != "" {
+		return &RootMismatch{BlockNumber: block.Number, Root: name, Stored: stored, Unverifiable: unverifiable}
+	}
+	if root := types.DeriveSha(list, trie.NewStackTrie(nil)); root != stored {
+		return &RootMismatch{BlockNumber: block.Number, Root: name, Stored: stored, Computed: root}
+	}
+	return nil
+}
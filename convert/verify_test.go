@@ -0,0 +1,78 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// rawDroppingStore stores transactions without their raw encoding, like earlier versions did.
+type rawDroppingStore struct {
+	storage.Storage
+}
+
+func (store rawDroppingStore) StoreTransaction(transactions ...*storage.Transaction) ([]storage.TransactionId, error) {
+	withoutRaw := make([]*storage.Transaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		copied := *transaction
+		copied.Raw = nil
+		withoutRaw = append(withoutRaw, &copied)
+	}
+	return store.Storage.StoreTransaction(withoutRaw...)
+}
+
+// verifyTestBundle ingests the bundle into the store, and verifies the roots of its stored block.
+func verifyTestBundle(t *testing.T, store storage.Storage, bundle *testBundle) []*RootMismatch {
+	t.Helper()
+	ingestTestBundle(t, store, bundle)
+	block, err := store.GetBlockByNumber(bundle.Block.NumberU64())
+	if err != nil || block == nil {
+		t.Fatalf("block %d: %v, %v", bundle.Block.NumberU64(), block, err)
+	}
+	if block.TransactionsRoot != bundle.Block.TxHash() || block.ReceiptsRoot != bundle.Block.ReceiptHash() {
+		t.Fatalf("roots of the stored block: got %s and %s, want %s and %s",
+			block.TransactionsRoot, block.ReceiptsRoot, bundle.Block.TxHash(), bundle.Block.ReceiptHash())
+	}
+	mismatches, err := VerifyBlockRoots(store, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mismatches
+}
+
+func TestVerifyBlockRootsOfIngestedBlock(t *testing.T) {
+	bundle := newTestBundle(t)
+	for _, mismatch := range verifyTestBundle(t, newTestStore(t), bundle) {
+		t.Errorf("%s root of the ingested block: %+v", mismatch.Root, mismatch)
+	}
+}
+
+func TestVerifyBlockRootsOfTamperedReceipt(t *testing.T) {
+	bundle := newTestBundle(t)
+	// the receipt is changed after the receipts root of the header was computed
+	bundle.Receipts[1].CumulativeGasUsed++
+	mismatches := verifyTestBundle(t, newTestStore(t), bundle)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want the receipts root only", len(mismatches))
+	}
+	mismatch := mismatches[0]
+	if mismatch.Root != RootReceipts || mismatch.Stored != bundle.Block.ReceiptHash() ||
+		mismatch.Computed == (common.Hash{}) || mismatch.Computed == mismatch.Stored || mismatch.Unverifiable != "" {
+		t.Errorf("mismatch of the tampered receipt: got %+v", mismatch)
+	}
+}
+
+func TestVerifyBlockRootsOfTransactionsWithoutRaw(t *testing.T) {
+	bundle := newTestBundle(t)
+	mismatches := verifyTestBundle(t, rawDroppingStore{newTestStore(t)}, bundle)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want the transactions root only", len(mismatches))
+	}
+	mismatch := mismatches[0]
+	if mismatch.Root != RootTransactions || mismatch.Computed != (common.Hash{}) ||
+		!strings.Contains(mismatch.Unverifiable, bundle.Block.Transactions()[0].Hash().String()) {
+		t.Errorf("transactions root stored without raw transactions: got %+v, want it unverifiable", mismatch)
+	}
+}
@@ -1,23 +1,69 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
 	"time"
 
 	storage "github.com/librescan-org/backend-db"
 	"github.com/librescan-org/backend-db/database/postgres"
 )
 
+// loadRetryPolicy retries transient errors forever, as the database might still be starting up or waiting for migrations.
+var loadRetryPolicy = storage.RetryPolicy{
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+	Retryable:    isTransientLoadError,
+}
+
+// isTransientLoadError reports whether loading might succeed later, like once the database accepts connections,
+// or once the pending migrations are applied by cmd/migrate.
+// Configuration errors, e.g. an invalid environment variable, fail at once, as retrying them would never succeed.
+func isTransientLoadError(err error) bool {
+	return errors.Is(err, storage.ErrRetryable) || errors.Is(err, postgres.ErrMigrationsPending)
+}
+
+// env_DATABASE_DSN selects the backend of LoadRepository by the scheme of the DSN, see Open.
+// If it is not set, the postgres backend is configured by the POSTGRES_* variables.
+// Either way, postgres reads go to the read replicas of POSTGRES_READ_REPLICAS if it is set, see postgres.WithReadReplicasFromEnv.
+const env_DATABASE_DSN = "DATABASE_DSN"
+
+// LoadRepository loads the repository, retrying until it succeeds.
+// It exits the process if the repository can not be loaded, see LoadRepositoryWithRetries for handling the error instead.
 func LoadRepository() storage.Storage {
-	repo := &postgres.PostgresRepository{}
-	for {
+	repo, err := LoadRepositoryWithRetries(context.Background(), loadRetryPolicy)
+	if err != nil {
+		log.Fatalf("failed to load the repository: %v", err)
+	}
+	return repo
+}
+
+// LoadRepositoryWithRetries loads the repository of DATABASE_DSN, retrying according to the policy.
+// The last error is returned if the policy runs out of attempts or the context is done.
+func LoadRepositoryWithRetries(ctx context.Context, policy storage.RetryPolicy) (storage.Storage, error) {
+	if dsn := os.Getenv(env_DATABASE_DSN); dsn != "" {
+		return OpenWithRetries(ctx, dsn, policy)
+	}
+	repo, err := postgres.WithReadReplicasFromEnv(&postgres.PostgresRepository{})
+	if err != nil {
+		return nil, err
+	}
+	return load(ctx, repo, policy)
+}
+
+// load loads the repository, retrying according to the policy.
+func load(ctx context.Context, repo storage.Storage, policy storage.RetryPolicy) (storage.Storage, error) {
+	err := policy.Do(ctx, func() error {
 		err := repo.Load()
-		if err == nil {
-			break
-		} else {
+		if err != nil {
 			log.Printf("failed to initialize database: %v", err)
-			time.Sleep(time.Second * 1)
 		}
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return repo
+	return repo, nil
 }
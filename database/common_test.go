@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	storage "github.com/librescan-org/backend-db"
+)
+
+func TestOpenFailsAtOnceOnConfigurationErrors(t *testing.T) {
+	t.Setenv("POSTGRES_PARTITION_SIZE", "many")
+	for _, dsn := range []string{
+		"librescan",
+		"unknown://librescan",
+		"sqlite://",
+		"postgres://librescan@localhost:1/",
+		// the partition size is invalid, which fails before connecting
+		"postgres://librescan@localhost:1/librescan",
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := Open(ctx, dsn)
+		cancel()
+		if err == nil {
+			t.Errorf("%s: opened despite the invalid configuration", dsn)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: configuration error is retried: %v", dsn, err)
+		}
+	}
+}
+
+func TestOpenRetriesConnectionErrors(t *testing.T) {
+	// nothing listens on port 1, so connecting is refused
+	policy := loadRetryPolicy
+	policy.MaxAttempts, policy.InitialDelay = 2, time.Millisecond
+	_, err := OpenWithRetries(context.Background(), "postgres://librescan@localhost:1/librescan", policy)
+	if !errors.Is(err, storage.ErrRetryable) || !isTransientLoadError(err) {
+		t.Errorf("refused connection is not retried: %v", err)
+	}
+}
+
+func TestLoadRepositoryFailsAtOnceOnInvalidReadReplicas(t *testing.T) {
+	t.Setenv("POSTGRES_READ_REPLICAS", "postgres://librescan@localhost:1/librescan")
+	t.Setenv("POSTGRES_REPLICA_MAX_LAG", "a few blocks")
+	for _, dsn := range []string{"", "postgres://librescan@localhost:1/librescan"} {
+		t.Setenv(env_DATABASE_DSN, dsn)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		repo, err := LoadRepositoryWithRetries(ctx, loadRetryPolicy)
+		cancel()
+		if err == nil || repo != nil {
+			t.Errorf("DSN %q: loaded despite the invalid replica configuration", dsn)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("DSN %q: replica configuration error is retried: %v", dsn, err)
+		}
+	}
+}
@@ -0,0 +1,681 @@
+package sqlcommon
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// Dialect is how the SQL backends differ in the queries of the Listers, besides the placeholders of their statement builders.
+type Dialect struct {
+	// Numeric converts a big.Int to a parameter compared against numeric columns, nil becoming NULL.
+	Numeric func(number *big.Int) any
+	// TraceAddress returns a scanner of the trace_address column into the path of a trace in the call tree.
+	TraceAddress func(traceAddress *[]uint64) sql.Scanner
+}
+
+// Listers implement the listers of storage.Reader on the tables defined here, for the SQL backends.
+// The backends annotate the errors, as they map them differently.
+type Listers struct {
+	Dialect Dialect
+	// StatementBuilder runs the queries in the transaction of the backend.
+	StatementBuilder sq.StatementBuilderType
+	// Reader is the backend, which the listers look up the addresses, transactions and tokens they filter by with.
+	Reader storage.Reader
+}
+
+func (listers Listers) ListBlocks(pagination storage.OffsetPagination) (blocks []*storage.Block, totalRecordsFound uint64, err error) {
+	if pagination.Limit != 0 {
+		var rows *sql.Rows
+		rows, err = listers.StatementBuilder.
+			Select(QuoteIdentifiers(TableColumnsBlocks)...).
+			From(TableNameBlocks).
+			OrderBy("number DESC").
+			Limit(uint64(pagination.Limit)).
+			Offset(pagination.Offset).Query()
+		if err != nil {
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var block *storage.Block
+			block, err = ScanBlock(rows)
+			if err != nil {
+				return
+			}
+			blocks = append(blocks, block)
+		}
+	}
+	err = listers.StatementBuilder.Select("COUNT(*)").From(TableNameBlocks).Scan(&totalRecordsFound)
+	return
+}
+func (listers Listers) ListUnclesByBlockNumber(blockNumber storage.BlockNumber) (uncles []*storage.Uncle, err error) {
+	rows, err := listers.StatementBuilder.
+		Select(QuoteIdentifiers(TableColumnsUncles)...).
+		From(TableNameUncles).
+		Where("block_height = ?", blockNumber).
+		OrderBy("position").Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var minerAddressId storage.AddressId
+	var difficulty, reward Numeric
+	for rows.Next() {
+		var uncle storage.Uncle
+		err = rows.Scan(
+			&uncle.Hash,
+			&uncle.Position,
+			&uncle.UncleHeight,
+			&uncle.BlockHeight,
+			&uncle.ParentHash,
+			&minerAddressId,
+			&difficulty,
+			&uncle.GasLimit,
+			&uncle.GasUsed,
+			&reward,
+			&uncle.Timestamp,
+		)
+		if err != nil {
+			return
+		}
+		uncle.MinerAddressId = minerAddressId
+		uncle.Difficulty = difficulty.Value
+		uncle.Reward = reward.Value
+		uncles = append(uncles, &uncle)
+	}
+	return
+}
+func (listers Listers) ListTracesByTransactionHash(transactionHash *common.Hash) (traceActions []*storage.TraceAction, blockNumber, timestamp uint64, err error) {
+	_, transactionId, err := listers.Reader.GetTransactionByHash(transactionHash)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	traces, blockNumbers, timestamps, _, err := listers.listTraces([]any{`transaction_id = ?`, transactionId}, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(traces) != 0 {
+		blockNumber = blockNumbers[0]
+		timestamp = timestamps[0]
+	}
+	return traces, blockNumber, timestamp, nil
+}
+func (listers Listers) ListTransactionsByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	if pagination == nil || pagination.Limit != 0 {
+		selectBuilder := listers.StatementBuilder.Select(QuoteIdentifiers(TableColumnsTransactions)...).
+			From(TableNameTransactions).
+			Where("block_id = ?", blockNumber).
+			OrderBy(`"index" DESC`)
+		if pagination != nil {
+			selectBuilder = selectBuilder.
+				Limit(uint64(pagination.Limit)).
+				Offset(pagination.Offset)
+		}
+		rows, err := selectBuilder.Query()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			tx, txId, err := ScanTransaction(rows)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			transactions = append(transactions, tx)
+			transactionIds = append(transactionIds, txId)
+		}
+	}
+	err = listers.StatementBuilder.
+		Select("COUNT(*)").
+		From(TableNameTransactions).
+		Where("block_id = ?", blockNumber).
+		Scan(&totalRecordsFound)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return
+}
+func (listers Listers) ListTransactionsByFilter(filter storage.TransactionFilter, pagination storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	filterQuery := func(selectBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+		selectBuilder = selectBuilder.From(TableNameTransactions)
+		if filter.Address != nil {
+			addressId, err := listers.Reader.GetAddressIdByHash(*filter.Address)
+			if err != nil {
+				return selectBuilder, err
+			}
+			switch filter.Direction {
+			case storage.DirectionOutgoing:
+				selectBuilder = selectBuilder.Where(TableNameTransactions+".from_address_id = ?", addressId)
+			case storage.DirectionIncoming:
+				selectBuilder = selectBuilder.Where(TableNameTransactions+".to_address_id = ?", addressId)
+			default:
+				selectBuilder = selectBuilder.Where(fmt.Sprintf("? IN (%[1]s.from_address_id, %[1]s.to_address_id)", TableNameTransactions), addressId)
+			}
+		}
+		if filter.OnlyContractCreations {
+			selectBuilder = selectBuilder.Where(TableNameTransactions + ".to_address_id IS NULL")
+		}
+		if filter.FromBlock != nil {
+			selectBuilder = selectBuilder.Where(TableNameTransactions+".block_id >= ?", *filter.FromBlock)
+		}
+		if filter.ToBlock != nil {
+			selectBuilder = selectBuilder.Where(TableNameTransactions+".block_id <= ?", *filter.ToBlock)
+		}
+		if filter.FromTimestamp != nil || filter.ToTimestamp != nil {
+			selectBuilder = selectBuilder.Join(fmt.Sprintf("%s AS b ON b.number = %s.block_id", TableNameBlocks, TableNameTransactions))
+			if filter.FromTimestamp != nil {
+				selectBuilder = selectBuilder.Where("b.timestamp >= ?", *filter.FromTimestamp)
+			}
+			if filter.ToTimestamp != nil {
+				selectBuilder = selectBuilder.Where("b.timestamp <= ?", *filter.ToTimestamp)
+			}
+		}
+		if filter.Status != nil {
+			selectBuilder = selectBuilder.
+				Join(fmt.Sprintf("%s AS r ON r.transaction_id = %s.id", TableNameReceipts, TableNameTransactions)).
+				Where("r.success = ?", *filter.Status)
+		}
+		if filter.MethodSelector != nil {
+			selectBuilder = selectBuilder.Where(fmt.Sprintf("substr(%s.input, 1, 4) = ?", TableNameTransactions), filter.MethodSelector[:])
+		}
+		if filter.MinValue != nil {
+			selectBuilder = selectBuilder.Where(fmt.Sprintf("%s.value >= ?", TableNameTransactions), listers.Dialect.Numeric(filter.MinValue))
+		}
+		return selectBuilder, nil
+	}
+	if pagination.Limit != 0 {
+		selectBuilder, err := filterQuery(listers.StatementBuilder.Select(QualifiedColumns(TableNameTransactions, TableColumnsTransactions)...))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		rows, err := selectBuilder.
+			OrderBy(TableNameTransactions + ".id DESC").
+			Limit(uint64(pagination.Limit)).
+			Offset(pagination.Offset).
+			Query()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			tx, txId, err := ScanTransaction(rows)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			transactions = append(transactions, tx)
+			transactionIds = append(transactionIds, txId)
+		}
+	}
+	countBuilder, err := filterQuery(listers.StatementBuilder.Select("COUNT(*)"))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if err = countBuilder.Scan(&totalRecordsFound); err != nil {
+		return nil, nil, 0, err
+	}
+	return
+}
+func (listers Listers) ListTransactions(pagination storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	rows, err := listers.StatementBuilder.
+		Select(QuoteIdentifiers(TableColumnsTransactions)...).
+		From(TableNameTransactions).
+		OrderBy("id DESC").
+		Limit(uint64(pagination.Limit)).
+		Offset(pagination.Offset).
+		Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tx, txId, err := ScanTransaction(rows)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		transactions = append(transactions, tx)
+		transactionIds = append(transactionIds, txId)
+	}
+	err = listers.StatementBuilder.
+		Select("COUNT(*)").
+		From(TableNameTransactions).
+		Scan(&totalRecordsFound)
+	return
+}
+func (listers Listers) ListStorageKeysByTransactionId(transactionId storage.TransactionId) (storageKeys []*storage.StorageKey, err error) {
+	rows, err := listers.StatementBuilder.
+		Select(QuoteIdentifiers(TableColumnsStorageKeys[1:])...).
+		From(TableNameStorageKeys).
+		Where("transaction_id = ?", transactionId).
+		Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addressId storage.AddressId
+		var storageKeyAsBytes []byte
+		if err = rows.Scan(&addressId, &storageKeyAsBytes); err != nil {
+			return
+		}
+		storageKeys = append(storageKeys, &storage.StorageKey{
+			TransactionId: transactionId,
+			AddressId:     addressId,
+			StorageKey:    new(big.Int).SetBytes(storageKeyAsBytes)},
+		)
+	}
+	return
+}
+func (listers Listers) ListLogsByTransactionId(transactionId storage.TransactionId) (_ []*storage.Log, err error) {
+	rows, err := listers.StatementBuilder.
+		Select(QuoteIdentifiers(TableColumnsLogs)...).
+		From(TableNameLogs).
+		Where("transaction_id = ?", transactionId).
+		OrderBy(`"index" DESC`).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return ScanLogs(rows)
+}
+func (listers Listers) ListErc20TokenTransfers(token, fromOrToFilter *common.Address, pagination *storage.OffsetPagination) (_ []*storage.Erc20TokenTransfer, _ uint64, err error) {
+	filterQuery := func(selectBuilder sq.SelectBuilder) (*sq.SelectBuilder, error) {
+		if token != nil {
+			addressId, err := listers.Reader.GetAddressIdByHash(*token)
+			if err != nil {
+				return nil, err
+			}
+			selectBuilder = selectBuilder.Where("token_address_id = ?", addressId)
+		}
+		if fromOrToFilter != nil {
+			addressId, err := listers.Reader.GetAddressIdByHash(*fromOrToFilter)
+			if err != nil {
+				return nil, err
+			}
+			selectBuilder = selectBuilder.Where("? IN (from_address_id, to_address_id)", addressId)
+		}
+		return &selectBuilder, nil
+	}
+	var totalTransferCount uint64
+	selectBuilder, err := filterQuery(listers.StatementBuilder.
+		Select("COUNT(*)").
+		From(TableNameErc20TokenTransfers))
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = selectBuilder.Scan(&totalTransferCount); err != nil || pagination == nil {
+		return nil, totalTransferCount, err
+	}
+	statement, err := filterQuery(listers.StatementBuilder.
+		Select(QuoteIdentifiers(TableColumnsErc20TokenTransfers)...).
+		From(TableNameErc20TokenTransfers).
+		OrderBy("transaction_id DESC", "log_index DESC").
+		Limit(uint64(pagination.Limit)).
+		Offset(pagination.Offset))
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := statement.Query()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var transactionId storage.TransactionId
+	var logIndex uint64
+	var value Numeric
+	var tokenAddressId, fromAddressId, toAddressId storage.AddressId
+	var transfers []*storage.Erc20TokenTransfer
+	for rows.Next() {
+		var transfer storage.Erc20TokenTransfer
+		err = rows.Scan(
+			&transactionId,
+			&logIndex,
+			&tokenAddressId,
+			&fromAddressId,
+			&toAddressId,
+			&value,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		transfer.LogId = storage.LogId{
+			TransactionId: transactionId,
+			LogIndex:      logIndex,
+		}
+		transfer.TokenAddressId = tokenAddressId
+		transfer.FromAddressId = fromAddressId
+		transfer.ToAddressId = toAddressId
+		transfer.Value = value.Value
+		transfers = append(transfers, &transfer)
+	}
+	return transfers, totalTransferCount, err
+}
+func (listers Listers) ListTracesByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ uint64, _ uint64, err error) {
+	traces, _, timestamps, totalRecordsFound, err := listers.listTraces([]any{`block_id = ?`, blockNumber}, pagination)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var timestamp uint64
+	if len(timestamps) != 0 {
+		timestamp = timestamps[0]
+	}
+	return traces, timestamp, totalRecordsFound, nil
+}
+func (listers Listers) ListTraces(pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ []uint64, _ []uint64, _ uint64, err error) {
+	return listers.listTraces(nil, pagination)
+}
+func (listers Listers) ListTracesByAddress(address common.Address, direction storage.Direction, onlyWithValue bool, pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ []uint64, _ []uint64, _ uint64, err error) {
+	addressId, err := listers.Reader.GetAddressIdByHash(address)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	var conditions sq.And
+	switch direction {
+	case storage.DirectionOutgoing:
+		conditions = append(conditions, sq.Expr(TableNameTraces+".from_address_id = ?", addressId))
+	case storage.DirectionIncoming:
+		conditions = append(conditions, sq.Expr(TableNameTraces+".to_address_id = ?", addressId))
+	default:
+		conditions = append(conditions, sq.Expr(fmt.Sprintf("? IN (%[1]s.from_address_id, %[1]s.to_address_id)", TableNameTraces), addressId))
+	}
+	if onlyWithValue {
+		conditions = append(conditions, sq.Expr(fmt.Sprintf("%s.value > ?", TableNameTraces), listers.zero()))
+	}
+	return listers.listTraces([]any{conditions}, pagination)
+}
+func (listers Listers) listTraces(whereClause []any, pagination *storage.OffsetPagination) ([]*storage.TraceAction, []uint64, []uint64, uint64, error) {
+	addFilterLogic := func(selectBuilder sq.SelectBuilder) sq.SelectBuilder {
+		mainSelectBuilder := selectBuilder.
+			From(TableNameTraces).
+			Join(fmt.Sprintf(`%s AS t ON t.id = "transaction_id"`, TableNameTransactions)).
+			Join(fmt.Sprintf(`%s AS b ON b.number = t.block_id`, TableNameBlocks))
+		if whereClause != nil {
+			mainSelectBuilder = mainSelectBuilder.Where(whereClause[0], whereClause[1:]...)
+		}
+		return mainSelectBuilder
+	}
+	var totalRecordsFound uint64
+	err := addFilterLogic(listers.StatementBuilder.Select("COUNT(*)")).Scan(&totalRecordsFound)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	selectBuilder := addFilterLogic(
+		listers.StatementBuilder.Select(
+			"b.number",
+			"b.timestamp",
+			"transaction_id",
+			TableNameTraces+`."index"`,
+			"type",
+			TableNameTraces+".input",
+			TableNameTraces+".from_address_id",
+			TableNameTraces+".to_address_id",
+			TableNameTraces+".value",
+			TableNameTraces+".gas",
+			TableNameTraces+".error",
+			TableNameTraces+".trace_address",
+			TableNameTraces+".depth",
+			TableNameTraces+".output",
+			TableNameTraces+".gas_used",
+			TableNameTraces+".created_address_id",
+			TableNameTraces+".init",
+			TableNameTraces+".refund_address_id")).
+		OrderBy("transaction_id DESC", TableNameTraces+`."index" DESC`)
+	if pagination != nil {
+		selectBuilder = selectBuilder.
+			Limit(uint64(pagination.Limit)).
+			Offset(pagination.Offset)
+	}
+	rows, err := selectBuilder.Query()
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	defer rows.Close()
+	var traces []*storage.TraceAction
+	var blockNumbers, timestamps []uint64
+	for rows.Next() {
+		var trace storage.TraceAction
+		var value Numeric
+		var blockNumber, timestamp uint64
+		var gasUsed sql.NullInt64
+		var createdAddressId, refundAddressId storage.AddressId
+		err = rows.Scan(&blockNumber, &timestamp, &trace.TransactionId, &trace.Index, &trace.Type, &trace.Input, &trace.From, &trace.To, &value, &trace.Gas, &trace.Error,
+			listers.Dialect.TraceAddress(&trace.TraceAddress), &trace.Depth, &trace.Output, &gasUsed, &createdAddressId, &trace.InitCode, &refundAddressId)
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+		trace.Value = value.Value
+		trace.GasUsed = uint64(gasUsed.Int64)
+		if !createdAddressId.IsZero() {
+			trace.CreatedAddressId = &createdAddressId
+		}
+		if !refundAddressId.IsZero() {
+			trace.RefundAddressId = &refundAddressId
+		}
+		traces = append(traces, &trace)
+		blockNumbers = append(blockNumbers, blockNumber)
+		timestamps = append(timestamps, timestamp)
+	}
+	return traces, blockNumbers, timestamps, totalRecordsFound, nil
+}
+func (listers Listers) ListErc20TokenBalancesAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (erc20TokenBalances []*storage.Erc20TokenBalance, err error) {
+	rows, err := listers.StatementBuilder.
+		Select("block_id", "token_address_id", "balance").
+		FromSelect(listers.StatementBuilder.
+			Select("block_id", "token_address_id", "balance", "ROW_NUMBER() OVER (PARTITION BY token_address_id ORDER BY block_id DESC) AS recency").
+			From(TableNameErc20TokenBalances).
+			Where("address_id = ?", addressId).
+			Where("block_id <= ?", blockNumber), "balances").
+		Where("recency = 1").
+		OrderBy("token_address_id").
+		Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var erc20TokenBalance storage.Erc20TokenBalance
+		var balance Numeric
+		var tokenAddressId storage.AddressId
+		err = rows.Scan(&erc20TokenBalance.BlockNumber, &tokenAddressId, &balance)
+		if err != nil {
+			return
+		}
+		erc20TokenBalance.AddressId = addressId
+		erc20TokenBalance.TokenAddressId = tokenAddressId
+		erc20TokenBalance.Balance = balance.Value
+		erc20TokenBalances = append(erc20TokenBalances, &erc20TokenBalance)
+	}
+	return
+}
+
+// LatestErc20TokenBalances selects the latest balance of each holder of the token, optionally at the given block.
+func (listers Listers) LatestErc20TokenBalances(tokenAddressId storage.AddressId, atBlock *storage.BlockNumber) sq.SelectBuilder {
+	balances := listers.StatementBuilder.
+		Select("address_id", "block_id", "balance", "ROW_NUMBER() OVER (PARTITION BY address_id ORDER BY block_id DESC) AS recency").
+		From(TableNameErc20TokenBalances).
+		Where("token_address_id = ?", tokenAddressId)
+	if atBlock != nil {
+		balances = balances.Where("block_id <= ?", *atBlock)
+	}
+	return listers.StatementBuilder.
+		Select("address_id", "block_id", "balance").
+		FromSelect(balances, "balances").
+		Where("recency = 1")
+}
+
+// CountErc20TokenHolders counts the holders of a positive balance of the token, optionally at the given block.
+func (listers Listers) CountErc20TokenHolders(tokenAddressId storage.AddressId, atBlock *storage.BlockNumber) (holders uint64, err error) {
+	err = listers.StatementBuilder.
+		Select("COUNT(*)").
+		FromSelect(listers.LatestErc20TokenBalances(tokenAddressId, atBlock), "latest").
+		Where("balance > ?", listers.zero()).
+		Scan(&holders)
+	return
+}
+func (listers Listers) ListErc20TokenHolders(tokenAddressId storage.Erc20TokenId, atBlock storage.BlockNumber, pagination storage.OffsetPagination) (holders []*storage.Erc20TokenHolder, totalRecordsFound uint64, err error) {
+	totalRecordsFound, err = listers.CountErc20TokenHolders(tokenAddressId, &atBlock)
+	if err != nil || pagination.Limit == 0 {
+		return
+	}
+	token, err := listers.Reader.GetErc20TokenByAddressId(tokenAddressId)
+	if err != nil {
+		return nil, 0, err
+	}
+	var totalSupply *big.Float
+	if token != nil && token.TotalSupply != nil && token.TotalSupply.Sign() != 0 {
+		totalSupply = new(big.Float).SetInt(token.TotalSupply)
+	}
+	rows, err := listers.StatementBuilder.
+		Select("address_id", "block_id", "balance").
+		FromSelect(listers.LatestErc20TokenBalances(tokenAddressId, &atBlock), "latest").
+		Where("balance > ?", listers.zero()).
+		OrderBy("balance DESC", "address_id").
+		Limit(uint64(pagination.Limit)).
+		Offset(pagination.Offset).
+		Query()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var holder storage.Erc20TokenHolder
+		var holderAddressId storage.AddressId
+		var balance Numeric
+		if err = rows.Scan(&holderAddressId, &holder.BlockNumber, &balance); err != nil {
+			return nil, 0, err
+		}
+		holder.AddressId = holderAddressId
+		holder.TokenAddressId = tokenAddressId
+		holder.Balance = balance.Value
+		if totalSupply != nil {
+			percentage, _ := new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Mul(holder.Balance, big.NewInt(100))), totalSupply).Float64()
+			holder.PercentageOfSupply = &percentage
+		}
+		holders = append(holders, &holder)
+	}
+	return holders, totalRecordsFound, rows.Err()
+}
+func (listers Listers) ListStateChangesByTransactionHash(transactionHash *common.Hash) (_ []*storage.StateChange, err error) {
+	transaction, transactionId, err := listers.Reader.GetTransactionByHash(transactionHash)
+	if err != nil {
+		return nil, err
+	}
+	if transaction == nil {
+		return nil, nil
+	}
+	stateChangesRows, err := listers.StatementBuilder.
+		Select(QuoteIdentifiers(TableColumnsStateChanges[1:])...).
+		From(TableNameStateChanges).
+		Where("transaction_id = ?", transactionId).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	var stateChanges []*storage.StateChange
+	for stateChangesRows.Next() {
+		var addressId storage.AddressId
+		var balanceBefore, balanceAfter Numeric
+		var nullableNonceBefore, nullableNonceAfter sql.NullInt64
+		if err = stateChangesRows.Scan(&addressId, &balanceBefore, &balanceAfter, &nullableNonceBefore, &nullableNonceAfter); err != nil {
+			stateChangesRows.Close()
+			return nil, err
+		}
+		var nonceBefore *uint64
+		if nullableNonceBefore.Valid {
+			n := uint64(nullableNonceBefore.Int64)
+			nonceBefore = &n
+		}
+		var nonceAfter *uint64
+		if nullableNonceAfter.Valid {
+			n := uint64(nullableNonceAfter.Int64)
+			nonceAfter = &n
+		}
+		stateChanges = append(stateChanges, &storage.StateChange{
+			TransactionId: transactionId,
+			AddressId:     addressId,
+			BalanceBefore: balanceBefore.Value,
+			BalanceAfter:  balanceAfter.Value,
+			NonceBefore:   nonceBefore,
+			NonceAfter:    nonceAfter,
+		})
+	}
+	if err = stateChangesRows.Close(); err != nil {
+		return nil, err
+	}
+	for _, stateChange := range stateChanges {
+		storageChangesRows, err := listers.StatementBuilder.
+			Select(QuoteIdentifiers(TableColumnsStorageChanges[1:])...).
+			From(TableNameStorageChanges).
+			Where("transaction_id = ?", stateChange.TransactionId).
+			Where("address_id = ?", stateChange.AddressId).
+			Query()
+		if err != nil {
+			return nil, err
+		}
+		var storageChanges []*storage.StorageChange
+		for storageChangesRows.Next() {
+			var addressId storage.AddressId
+			var storageAddress, valueBefore, valueAfter []byte
+			if err = storageChangesRows.Scan(&addressId, &storageAddress, &valueBefore, &valueAfter); err != nil {
+				storageChangesRows.Close()
+				return nil, err
+			}
+			storageChanges = append(storageChanges, &storage.StorageChange{
+				TransactionId:  transactionId,
+				AddressId:      addressId,
+				StorageAddress: new(big.Int).SetBytes(storageAddress),
+				ValueBefore:    new(big.Int).SetBytes(valueBefore),
+				ValueAfter:     new(big.Int).SetBytes(valueAfter),
+			})
+		}
+		storageChangesRows.Close()
+		stateChange.StorageChanges = storageChanges
+	}
+
+	return stateChanges, nil
+}
+func (listers Listers) ListMissingBlockRanges(from, to storage.BlockNumber) (missingRanges []*storage.BlockRange, err error) {
+	if from > to {
+		return nil, nil
+	}
+	if to >= math.MaxInt64 {
+		return nil, fmt.Errorf("%w: block %d is out of range", storage.ErrConstraint, to)
+	}
+	// the blocks before and after the range are added, so the gaps at its ends are found too
+	numbers := listers.StatementBuilder.
+		Select("number").
+		From(TableNameBlocks).
+		Where("number BETWEEN ? AND ?", from, to).
+		Suffix("UNION ALL SELECT CAST(? AS BIGINT) - 1 UNION ALL SELECT CAST(? AS BIGINT) + 1", from, to)
+	rows, err := listers.StatementBuilder.
+		Select("number + 1", "next_number - 1").
+		FromSelect(listers.StatementBuilder.
+			Select("number", "LEAD(number) OVER (ORDER BY number) AS next_number").
+			FromSelect(numbers, "numbers"), "successive").
+		Where("next_number > number + 1").
+		OrderBy("number").
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var missingRange storage.BlockRange
+		if err = rows.Scan(&missingRange.From, &missingRange.To); err != nil {
+			return nil, err
+		}
+		missingRanges = append(missingRanges, &missingRange)
+	}
+	return missingRanges, rows.Err()
+}
+
+// zero is the parameter compared against numeric columns to filter out empty balances and values.
+func (listers Listers) zero() any {
+	return listers.Dialect.Numeric(new(big.Int))
+}
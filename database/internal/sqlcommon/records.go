@@ -0,0 +1,178 @@
+package sqlcommon
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	storage "github.com/librescan-org/backend-db"
+)
+
+func DescribeBlock(block *storage.Block) string {
+	return fmt.Sprintf("block %d", block.Number)
+}
+func DescribeUncle(uncle *storage.Uncle) string {
+	return fmt.Sprintf("uncle %v", uncle.Hash)
+}
+func DescribeTransaction(transaction *storage.Transaction) string {
+	return fmt.Sprintf("transaction %v", transaction.Hash)
+}
+func DescribeStorageKey(storageKey *storage.StorageKey) string {
+	return fmt.Sprintf("storage key %x of address %v in transaction %v", storageKey.StorageKey, storageKey.AddressId, storageKey.TransactionId)
+}
+func DescribeReceipt(receipt *storage.Receipt) string {
+	return fmt.Sprintf("receipt of transaction %v", receipt.TransactionId)
+}
+func DescribeLogId(logId storage.LogId) string {
+	return fmt.Sprintf("log %d of transaction %v", logId.LogIndex, logId.TransactionId)
+}
+func DescribeLog(log *storage.Log) string {
+	return DescribeLogId(log.LogId)
+}
+func DescribeEventType(eventType *storage.EventType) string {
+	return fmt.Sprintf("event type %v", eventType.Hash)
+}
+func DescribeErc20TokenTransfer(erc20TokenTransfer *storage.Erc20TokenTransfer) string {
+	return "transfer of " + DescribeLogId(erc20TokenTransfer.LogId)
+}
+func DescribeErc20Token(erc20Token *storage.Erc20Token) string {
+	return fmt.Sprintf("token %v", erc20Token.AddressId)
+}
+func DescribeContract(contract *storage.Contract) string {
+	return fmt.Sprintf("contract %v", contract.AddressId)
+}
+func DescribeTrace(traceAction *storage.TraceAction) string {
+	return fmt.Sprintf("trace %d of transaction %v", traceAction.Index, traceAction.TransactionId)
+}
+func DescribeEtherBalance(etherBalance *storage.EtherBalance) string {
+	return fmt.Sprintf("balance of address %v at block %d", etherBalance.AddressId, etherBalance.BlockNumber)
+}
+func DescribeErc20TokenBalance(tokenBalance *storage.Erc20TokenBalance) string {
+	return fmt.Sprintf("balance of address %v of token %v at block %d", tokenBalance.AddressId, tokenBalance.TokenAddressId, tokenBalance.BlockNumber)
+}
+func DescribeStateChange(stateChange *storage.StateChange) string {
+	return fmt.Sprintf("state change of address %v in transaction %v", stateChange.AddressId, stateChange.TransactionId)
+}
+func DescribeStorageChange(storageChange *storage.StorageChange) string {
+	return fmt.Sprintf("change of storage %x of address %v in transaction %v", storageChange.StorageAddress, storageChange.AddressId, storageChange.TransactionId)
+}
+func BigIntMustNotBeNil(number *big.Int) *big.Int {
+	if number == nil {
+		return new(big.Int)
+	}
+	return number
+}
+func BytecodeSha256(bytecode storage.Bytecode) []byte {
+	sha256Digest := sha256.Sum256(bytecode)
+	return sha256Digest[:]
+}
+func DescribeBytecode(bytecode storage.Bytecode) string {
+	return fmt.Sprintf("bytecode with SHA-256 %x", BytecodeSha256(bytecode))
+}
+
+// ValidateRecords checks the records before they are inserted or buffered, so a buffered record missing a mandatory field
+// is reported by its Store method instead of failing the whole Commit. validate can be nil to only check for nil records.
+func ValidateRecords[Record any](records []Record, validate func(Record) error) error {
+	for i, record := range records {
+		if value := reflect.ValueOf(record); value.Kind() == reflect.Pointer && value.IsNil() {
+			return fmt.Errorf("record %d: %w: the record is nil", i, storage.ErrConstraint)
+		}
+		if validate == nil {
+			continue
+		}
+		if err := validate(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequiredField is a mandatory field of a record, missing when its id is zero or its pointer is nil.
+type RequiredField struct {
+	name    string
+	missing bool
+}
+
+// RequireFields fails with storage.ErrConstraint naming the record and its missing fields.
+func RequireFields(entity string, fields ...RequiredField) error {
+	var missing []string
+	for _, field := range fields {
+		if field.missing {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %w: missing %s", entity, storage.ErrConstraint, strings.Join(missing, ", "))
+}
+func ValidateBlock(block *storage.Block) error {
+	return RequireFields(DescribeBlock(block),
+		RequiredField{"miner address id", block.MinerAddressId.IsZero()})
+}
+func ValidateTransaction(transaction *storage.Transaction) error {
+	return RequireFields(DescribeTransaction(transaction),
+		RequiredField{"from address id", transaction.FromAddressId.IsZero()})
+}
+func ValidateStorageKey(storageKey *storage.StorageKey) error {
+	return RequireFields(DescribeStorageKey(storageKey),
+		RequiredField{"transaction id", storageKey.TransactionId.IsZero()},
+		RequiredField{"address id", storageKey.AddressId.IsZero()},
+		RequiredField{"storage key", storageKey.StorageKey == nil})
+}
+func ValidateReceipt(receipt *storage.Receipt) error {
+	return RequireFields(DescribeReceipt(receipt),
+		RequiredField{"transaction id", receipt.TransactionId.IsZero()})
+}
+func ValidateLog(log *storage.Log) error {
+	return RequireFields(DescribeLog(log),
+		RequiredField{"transaction id", log.TransactionId.IsZero()},
+		RequiredField{"address id", log.AddressId.IsZero()})
+}
+func ValidateErc20TokenTransfer(erc20TokenTransfer *storage.Erc20TokenTransfer) error {
+	return RequireFields(DescribeErc20TokenTransfer(erc20TokenTransfer),
+		RequiredField{"transaction id", erc20TokenTransfer.TransactionId.IsZero()},
+		RequiredField{"token address id", erc20TokenTransfer.TokenAddressId.IsZero()},
+		RequiredField{"from address id", erc20TokenTransfer.FromAddressId.IsZero()},
+		RequiredField{"to address id", erc20TokenTransfer.ToAddressId.IsZero()})
+}
+func ValidateErc20Token(erc20Token *storage.Erc20Token) error {
+	return RequireFields(DescribeErc20Token(erc20Token),
+		RequiredField{"address id", erc20Token.AddressId.IsZero()})
+}
+func ValidateContract(contract *storage.Contract) error {
+	return RequireFields(DescribeContract(contract),
+		RequiredField{"address id", contract.AddressId.IsZero()},
+		RequiredField{"transaction id", contract.TransactionId.IsZero()},
+		RequiredField{"bytecode id", contract.BytecodeId.IsZero()})
+}
+func ValidateTrace(traceAction *storage.TraceAction) error {
+	return RequireFields(DescribeTrace(traceAction),
+		RequiredField{"transaction id", traceAction.TransactionId.IsZero()},
+		RequiredField{"from address id", traceAction.From.IsZero()},
+		RequiredField{"to address id", traceAction.To.IsZero()})
+}
+func ValidateEtherBalance(etherBalance *storage.EtherBalance) error {
+	return RequireFields(DescribeEtherBalance(etherBalance),
+		RequiredField{"address id", etherBalance.AddressId.IsZero()})
+}
+func ValidateErc20TokenBalance(tokenBalance *storage.Erc20TokenBalance) error {
+	return RequireFields(DescribeErc20TokenBalance(tokenBalance),
+		RequiredField{"address id", tokenBalance.AddressId.IsZero()},
+		RequiredField{"token address id", tokenBalance.TokenAddressId.IsZero()})
+}
+func ValidateStateChange(stateChange *storage.StateChange) error {
+	return RequireFields(DescribeStateChange(stateChange),
+		RequiredField{"transaction id", stateChange.TransactionId.IsZero()},
+		RequiredField{"address id", stateChange.AddressId.IsZero()})
+}
+func ValidateStorageChange(storageChange *storage.StorageChange) error {
+	return RequireFields(DescribeStorageChange(storageChange),
+		RequiredField{"transaction id", storageChange.TransactionId.IsZero()},
+		RequiredField{"address id", storageChange.AddressId.IsZero()},
+		RequiredField{"storage address", storageChange.StorageAddress == nil},
+		RequiredField{"value before", storageChange.ValueBefore == nil},
+		RequiredField{"value after", storageChange.ValueAfter == nil})
+}
@@ -0,0 +1,172 @@
+package sqlcommon
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// Numeric scans a numeric column into a big.Int, NULL becoming nil.
+// The backends store numbers as decimal text or numerics, which are both scanned from their decimal representation.
+type Numeric struct {
+	Value *big.Int
+}
+
+func (number *Numeric) Scan(src any) error {
+	var text string
+	switch typedSrc := src.(type) {
+	case nil:
+		number.Value = nil
+		return nil
+	case int64:
+		number.Value = big.NewInt(typedSrc)
+		return nil
+	case []byte:
+		text = string(typedSrc)
+	case string:
+		text = typedSrc
+	default:
+		return fmt.Errorf("cannot scan %T into numeric", src)
+	}
+	value, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return fmt.Errorf("invalid integer numeric: %q", text)
+	}
+	number.Value = value
+	return nil
+}
+
+type ScannerWithErrHandling interface {
+	Scan(...any) error
+	Err() error
+}
+
+func ScanBlock(scanner ScannerWithErrHandling) (*storage.Block, error) {
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var block = storage.Block{}
+	var logsBlooms, parentHash, transactionsRoot, receiptsRoot []byte
+	var nonce, difficulty, totalDifficulty, staticReward, baseFeePerGas Numeric
+	var size, gasLimit, gasUsed sql.NullInt64
+
+	var minerAddressId storage.AddressId
+	err := scanner.Scan(
+		&block.Hash,
+		&block.Number,
+		&nonce,
+		&block.Sha3Uncles,
+		&logsBlooms,
+		&block.StateRoot,
+		&minerAddressId,
+		&difficulty,
+		&totalDifficulty,
+		&size,
+		&block.ExtraData,
+		&gasLimit,
+		&gasUsed,
+		&baseFeePerGas,
+		&block.MixHash,
+		&staticReward,
+		&block.Timestamp,
+		&parentHash,
+		&transactionsRoot,
+		&receiptsRoot)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err == nil {
+		block.ParentHash = common.BytesToHash(parentHash)
+		block.TransactionsRoot = common.BytesToHash(transactionsRoot)
+		block.ReceiptsRoot = common.BytesToHash(receiptsRoot)
+		block.Nonce = nonce.Value.Uint64()
+		block.LogsBloom = types.BytesToBloom(logsBlooms)
+		block.MinerAddressId = minerAddressId
+		block.StaticReward = staticReward.Value
+		block.Difficulty = difficulty.Value
+		block.TotalDifficulty = totalDifficulty.Value
+		block.Size = uint64(size.Int64)
+		block.GasLimit = uint64(gasLimit.Int64)
+		block.GasUsed = uint64(gasUsed.Int64)
+		block.BaseFeePerGas = baseFeePerGas.Value
+	}
+	return &block, err
+}
+func ScanTransaction(scanner ScannerWithErrHandling) (*storage.Transaction, storage.TransactionId, error) {
+	if err := scanner.Err(); err != nil {
+		return nil, storage.TransactionId{}, err
+	}
+	transaction := &storage.Transaction{}
+	var value, gasPrice, gasTipCap, gasFeeCap Numeric
+	var transactionId storage.TransactionId
+	var toAddressId storage.AddressId
+	err := scanner.Scan(
+		&transactionId,
+		&transaction.BlockNumber,
+		&transaction.Hash,
+		&transaction.Nonce,
+		&transaction.Index,
+		&transaction.FromAddressId,
+		&toAddressId,
+		&value,
+		&transaction.Gas,
+		&gasPrice,
+		&gasTipCap,
+		&gasFeeCap,
+		&transaction.Input,
+		&transaction.Type,
+		&transaction.Raw,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, storage.TransactionId{}, nil
+		}
+		return nil, storage.TransactionId{}, err
+	}
+	if !toAddressId.IsZero() {
+		transaction.ToAddressId = &toAddressId
+	}
+	transaction.Value = value.Value
+	transaction.GasPrice = gasPrice.Value
+	transaction.GasTipCap = gasTipCap.Value
+	transaction.GasFeeCap = gasFeeCap.Value
+	return transaction, transactionId, nil
+}
+func ScanLogs(rows *sql.Rows) (logs []*storage.Log, err error) {
+	var topic0Id storage.Topic0Id
+	var topic1, topic2, topic3 []byte
+	for rows.Next() {
+		var log storage.Log
+		err = rows.Scan(
+			&log.TransactionId,
+			&log.LogIndex,
+			&log.AddressId,
+			&topic0Id,
+			&topic1,
+			&topic2,
+			&topic3,
+			&log.Data)
+		if err != nil {
+			return
+		}
+		if !topic0Id.IsZero() {
+			id := topic0Id
+			log.Topic0Id = &id
+		}
+		if len(topic1) != 0 {
+			log.Topic1 = (*[32]byte)(topic1)
+		}
+		if len(topic2) != 0 {
+			log.Topic2 = (*[32]byte)(topic2)
+		}
+		if len(topic3) != 0 {
+			log.Topic3 = (*[32]byte)(topic3)
+		}
+		logs = append(logs, &log)
+	}
+	return
+}
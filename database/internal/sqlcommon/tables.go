@@ -0,0 +1,196 @@
+// Package sqlcommon holds what the SQL backends share: the tables and columns of their schemas, the listers reading them,
+// and the validation and description of the records they store, which the other backends use as well.
+package sqlcommon
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	TableNameAddresses           = `"Addresses"`
+	TableNameBlocks              = `"Blocks"`
+	TableNameUncles              = `"Uncles"`
+	TableNameBytecodes           = `"Bytecodes"`
+	TableNameTransactions        = `"Transactions"`
+	TableNameStorageKeys         = `"StorageKeys"`
+	TableNameContracts           = `"Contracts"`
+	TableNameReceipts            = `"Receipts"`
+	TableNameEventTypes          = `"FirstTopics"`
+	TableNameLogs                = `"Logs"`
+	TableNameErc20Tokens         = `"Erc20Tokens"`
+	TableNameErc20TokenTransfers = `"Erc20TokenTransfers"`
+	TableNameTraces              = `"Traces"`
+	TableNameEtherBalances       = `"EtherBalances"`
+	TableNameErc20TokenBalances  = `"Erc20TokenBalances"`
+	TableNameStateChanges        = `"StateChanges"`
+	TableNameStorageChanges      = `"StorageChanges"`
+)
+
+var TableColumnsBlocks = []string{
+	"hash",
+	"number",
+	"nonce",
+	"sha3_uncles",
+	"logs_bloom",
+	"state_root",
+	"miner",
+	"difficulty",
+	"total_difficulty",
+	"size",
+	"extra_data",
+	"gas_limit",
+	"gas_used",
+	"base_fee",
+	"mix_hash",
+	"static_reward",
+	"timestamp",
+	"parent_hash",
+	"transactions_root",
+	"receipts_root"}
+
+var TableColumnsUncles = []string{
+	"hash",
+	"position",
+	"uncle_height",
+	"block_height",
+	"parent_hash",
+	// "sha3_uncles",
+	"miner",
+	"difficulty",
+	"gas_limit",
+	"gas_used",
+	"reward",
+	"timestamp"}
+
+var TableColumnsContracts = []string{
+	"address_id", // query depends on this being at index 0 in this slice
+	"transaction_id",
+	"bytecode_id"}
+
+var TableColumnsTransactions = []string{
+	"id", // query depends on this being at index 0 in this slice
+	"block_id",
+	"hash",
+	"nonce",
+	"index",
+	"from_address_id",
+	"to_address_id",
+	"value",
+	"gas",
+	"gas_price",
+	"gas_tip_cap",
+	"gas_fee_cap",
+	"input",
+	"transaction_type",
+	"raw"}
+
+var TableColumnsStorageKeys = []string{
+	"transaction_id", // query depends on this being at index 0 in this slice
+	"address_id",
+	"storage_key"}
+
+var TableColumnsErc20Tokens = []string{
+	"address_id", // query depends on this being at index 0 in this slice
+	"symbol",
+	"name",
+	"decimals",
+	"total_supply"}
+
+var TableColumnsBytecodes = []string{
+	"bytecode",
+	"sha256"}
+
+var TableColumnsLogs = []string{
+	"transaction_id",
+	"index",
+	"address_id",
+	"topic_0_id",
+	"topic_1",
+	"topic_2",
+	"topic_3",
+	"data"}
+
+var TableColumnsReceipts = []string{
+	"transaction_id", // query depends on this being at index 0 in this slice
+	"cumulative_gas_used",
+	"gas_used",
+	"contract_address_id",
+	"post_state",
+	"success",
+	"effective_gas_price"}
+
+var TableColumnsEventTypes = []string{
+	"id", // query depends on this being at index 0 in this slice
+	"hash",
+	"signature"}
+
+var TableColumnsErc20TokenTransfers = []string{
+	"transaction_id",
+	"log_index",
+	"token_address_id",
+	"from_address_id",
+	"to_address_id",
+	"value"}
+
+var TableColumnsTraces = []string{
+	"transaction_id",
+	"index",
+	"type",
+	"input",
+	"from_address_id",
+	"to_address_id",
+	"value",
+	"gas",
+	"error",
+	"trace_address",
+	"depth",
+	"output",
+	"gas_used",
+	"created_address_id",
+	"init",
+	"refund_address_id"}
+
+var TableColumnsEtherBalances = []string{
+	"address_id",
+	"block_id",
+	"balance"}
+
+var TableColumnsErc20TokenBalances = []string{
+	"address_id",
+	"block_id",
+	"token_address_id",
+	"balance"}
+
+var TableColumnsStateChanges = []string{
+	"transaction_id", // query depends on this being at index 0 in this slice
+	"address_id",
+	"balance_before",
+	"balance_after",
+	"nonce_before",
+	"nonce_after"}
+
+var TableColumnsStorageChanges = []string{
+	"transaction_id", // query depends on this being at index 0 in this slice
+	"address_id",
+	"storage_address",
+	"value_before",
+	"value_after"}
+
+// QualifiedColumns prefixes all columns with the table name, so they stay unambiguous in joins.
+func QualifiedColumns(tableName string, columns []string) []string {
+	qualified := make([]string, 0, len(columns))
+	for _, column := range columns {
+		qualified = append(qualified, fmt.Sprintf(`%s."%s"`, tableName, column))
+	}
+	return qualified
+}
+
+// QuoteIdentifiers quotes the columns, as some of them, e.g. "index", are keywords of SQL.
+func QuoteIdentifiers(identifiers []string) []string {
+	quoted := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		quoted = append(quoted, strconv.Quote(identifier))
+	}
+	return quoted
+}
@@ -0,0 +1,441 @@
+// Package storagetest seeds the storage backends with a small data set referencing every kind of entity,
+// shared by the tests of the backends.
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// Fixture is the data set stored by Seed, along with the ids the backend assigned to it.
+//   - Blocks 1 and 2 are stored, block 2 having an uncle.
+//   - Transactions[0] is a plain transfer in block 1.
+//   - Transactions[1] is an ERC-20 transfer in block 2, with a log, a nested trace and state changes.
+//   - Transactions[2] is a contract creation in block 2.
+//
+// Numbers exceed 64 bits wherever the entity allows it, so that numeric conversions are exercised.
+type Fixture struct {
+	Miner, Sender, Recipient, Token, Contract           common.Address
+	MinerId, SenderId, RecipientId, TokenId, ContractId storage.AddressId
+
+	Blocks              []*storage.Block
+	Uncles              []*storage.Uncle
+	Transactions        []*storage.Transaction
+	TransactionIds      []storage.TransactionId
+	Receipts            []*storage.Receipt
+	EventType           *storage.EventType
+	Topic0Id            storage.Topic0Id
+	Logs                []*storage.Log
+	Erc20Token          *storage.Erc20Token
+	Erc20TokenTransfers []*storage.Erc20TokenTransfer
+	Bytecode            storage.Bytecode
+	BytecodeId          storage.BytecodeId
+	ContractRecord      *storage.Contract
+	Traces              []*storage.TraceAction
+	EtherBalances       []*storage.EtherBalance
+	Erc20TokenBalances  []*storage.Erc20TokenBalance
+	StateChanges        []*storage.StateChange
+	StorageKeys         []*storage.StorageKey
+}
+
+// BigInt parses a decimal number, panicking on malformed input.
+func BigInt(decimal string) *big.Int {
+	number, ok := new(big.Int).SetString(decimal, 10)
+	if !ok {
+		panic("malformed decimal number " + decimal)
+	}
+	return number
+}
+
+func pointer[T any](value T) *T {
+	return &value
+}
+
+// logsBloom returns the logs bloom of a block holding logs of the addresses and topics.
+func logsBloom(values ...[]byte) (bloom types.Bloom) {
+	for _, value := range values {
+		bloom.Add(value)
+	}
+	return bloom
+}
+
+// transferEventHash is the hash of the ERC-20 Transfer(address,address,uint256) event.
+var transferEventHash = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+// Seed stores the Fixture and commits it.
+func Seed(t testing.TB, repo storage.Storage) *Fixture {
+	t.Helper()
+	fixture := &Fixture{
+		Miner:     common.HexToAddress("0x00000000000000000000000000000000000000aa"),
+		Sender:    common.HexToAddress("0x00000000000000000000000000000000000000bb"),
+		Recipient: common.HexToAddress("0x00000000000000000000000000000000000000cc"),
+		Token:     common.HexToAddress("0x00000000000000000000000000000000000000dd"),
+		Contract:  common.HexToAddress("0x00000000000000000000000000000000000000ee"),
+	}
+	addressIds, err := repo.StoreAddress(fixture.Miner, fixture.Sender, fixture.Recipient, fixture.Token, fixture.Contract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.MinerId, fixture.SenderId, fixture.RecipientId, fixture.TokenId, fixture.ContractId = addressIds[0], addressIds[1], addressIds[2], addressIds[3], addressIds[4]
+
+	fixture.Blocks = []*storage.Block{{
+		Hash:             common.HexToHash("0xb1"),
+		ParentHash:       common.HexToHash("0xb0"),
+		Number:           1,
+		Nonce:            42,
+		Sha3Uncles:       common.HexToHash("0x51"),
+		StateRoot:        common.HexToHash("0x5e1"),
+		TransactionsRoot: common.HexToHash("0x7e1"),
+		ReceiptsRoot:     common.HexToHash("0x9e1"),
+		MinerAddressId:   fixture.MinerId,
+		Difficulty:       BigInt("17179869184"),
+		TotalDifficulty:  BigInt("58750003716598352816469"),
+		Size:             540,
+		ExtraData:        []byte("librescan"),
+		GasLimit:         30000000,
+		GasUsed:          21000,
+		Timestamp:        1700000000,
+		BaseFeePerGas:    BigInt("7000000000"),
+		MixHash:          common.HexToHash("0x3e1"),
+		StaticReward:     BigInt("2000000000000000000"),
+	}, {
+		Hash:             common.HexToHash("0xb2"),
+		ParentHash:       common.HexToHash("0xb1"),
+		Number:           2,
+		Nonce:            43,
+		Sha3Uncles:       common.HexToHash("0x52"),
+		LogsBloom:        logsBloom(fixture.Token.Bytes(), transferEventHash.Bytes(), common.BytesToHash(fixture.Sender.Bytes()).Bytes(), common.BytesToHash(fixture.Recipient.Bytes()).Bytes()),
+		StateRoot:        common.HexToHash("0x5e2"),
+		TransactionsRoot: common.HexToHash("0x7e2"),
+		ReceiptsRoot:     common.HexToHash("0x9e2"),
+		MinerAddressId:   fixture.MinerId,
+		Difficulty:       BigInt("17171480576"),
+		TotalDifficulty:  BigInt("58750003716615524297045"),
+		Size:             1210,
+		ExtraData:        []byte{},
+		GasLimit:         30000000,
+		GasUsed:          150000,
+		Timestamp:        1700000012,
+		BaseFeePerGas:    BigInt("6500000000"),
+		MixHash:          common.HexToHash("0x3e2"),
+		StaticReward:     BigInt("2000000000000000000"),
+	}}
+	if err = repo.StoreBlock(fixture.Blocks...); err != nil {
+		t.Fatal(err)
+	}
+	fixture.Uncles = []*storage.Uncle{{
+		Position:       0,
+		Hash:           common.HexToHash("0xc1"),
+		UncleHeight:    1,
+		BlockHeight:    2,
+		ParentHash:     common.HexToHash("0xb0"),
+		MinerAddressId: fixture.RecipientId,
+		Difficulty:     BigInt("17179869184"),
+		GasLimit:       30000000,
+		GasUsed:        0,
+		Timestamp:      1700000005,
+		Reward:         BigInt("1750000000000000000"),
+	}}
+	if err = repo.StoreUncle(fixture.Uncles...); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture.Transactions = []*storage.Transaction{{
+		BlockNumber:   1,
+		Hash:          common.HexToHash("0x7a1"),
+		Nonce:         0,
+		Index:         0,
+		FromAddressId: fixture.SenderId,
+		ToAddressId:   &fixture.RecipientId,
+		Value:         BigInt("1267650600228229401496703205383"),
+		Gas:           21000,
+		GasPrice:      BigInt("7500000000"),
+		Input:         []byte{},
+		Type:          0,
+		Raw:           []byte{0xf8, 0x6c},
+	}, {
+		BlockNumber:   2,
+		Hash:          common.HexToHash("0x7a2"),
+		Nonce:         1,
+		Index:         0,
+		FromAddressId: fixture.SenderId,
+		ToAddressId:   &fixture.TokenId,
+		Value:         big.NewInt(0),
+		Gas:           60000,
+		GasPrice:      BigInt("7000000000"),
+		GasTipCap:     BigInt("500000000"),
+		GasFeeCap:     BigInt("20000000000"),
+		Input:         common.FromHex("0xa9059cbb00000000000000000000000000000000000000000000000000000000000000cc0000000000000000000000000000000000000000000000056bc75e2d63100000"),
+		Type:          2,
+		Raw:           []byte{0x02, 0xf8},
+	}, {
+		BlockNumber:   2,
+		Hash:          common.HexToHash("0x7a3"),
+		Nonce:         0,
+		Index:         1,
+		FromAddressId: fixture.RecipientId,
+		Value:         big.NewInt(0),
+		Gas:           100000,
+		GasPrice:      BigInt("6500000000"),
+		Input:         common.FromHex("0x6080604052"),
+		Type:          0,
+		Raw:           []byte{0xf8, 0x6d},
+	}}
+	if fixture.TransactionIds, err = repo.StoreTransaction(fixture.Transactions...); err != nil {
+		t.Fatal(err)
+	}
+	fixture.Receipts = []*storage.Receipt{{
+		TransactionId:     fixture.TransactionIds[0],
+		CumulativeGasUsed: 21000,
+		GasUsed:           21000,
+		Status:            storage.ReceiptStatusSuccess,
+		EffectiveGasPrice: BigInt("7500000000"),
+	}, {
+		TransactionId:     fixture.TransactionIds[1],
+		CumulativeGasUsed: 51000,
+		GasUsed:           51000,
+		Status:            storage.ReceiptStatusSuccess,
+		EffectiveGasPrice: BigInt("7000000000"),
+	}, {
+		TransactionId:     fixture.TransactionIds[2],
+		CumulativeGasUsed: 150000,
+		GasUsed:           99000,
+		ContractAddressId: &fixture.ContractId,
+		Status:            storage.ReceiptStatusSuccess,
+		EffectiveGasPrice: BigInt("6500000000"),
+	}}
+	if err = repo.StoreReceipt(fixture.Receipts...); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture.EventType = &storage.EventType{
+		Hash:      transferEventHash,
+		Signature: pointer("Transfer(address,address,uint256)"),
+	}
+	topic0Ids, err := repo.StoreTopic0(fixture.EventType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.Topic0Id = topic0Ids[0]
+	transferredTokens := BigInt("100000000000000000000")
+	logId := storage.LogId{TransactionId: fixture.TransactionIds[1], LogIndex: 0}
+	fixture.Logs = []*storage.Log{{
+		LogId:     logId,
+		AddressId: fixture.TokenId,
+		Topic0Id:  &fixture.Topic0Id,
+		Topic1:    pointer([32]byte(common.BytesToHash(fixture.Sender.Bytes()))),
+		Topic2:    pointer([32]byte(common.BytesToHash(fixture.Recipient.Bytes()))),
+		Data:      common.BigToHash(transferredTokens).Bytes(),
+	}}
+	if err = repo.StoreLog(fixture.Logs...); err != nil {
+		t.Fatal(err)
+	}
+	fixture.Erc20Token = &storage.Erc20Token{
+		AddressId:   fixture.TokenId,
+		Symbol:      "LST",
+		Name:        "Librescan Test Token",
+		Decimals:    18,
+		TotalSupply: BigInt("1000000000000000000000000000"),
+	}
+	if err = repo.StoreErc20Token(fixture.Erc20Token); err != nil {
+		t.Fatal(err)
+	}
+	fixture.Erc20TokenTransfers = []*storage.Erc20TokenTransfer{{
+		LogId:          logId,
+		TokenAddressId: fixture.TokenId,
+		FromAddressId:  fixture.SenderId,
+		ToAddressId:    fixture.RecipientId,
+		Value:          transferredTokens,
+	}}
+	if err = repo.StoreErc20TokenTransfer(fixture.Erc20TokenTransfers...); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture.Bytecode = common.FromHex("0x6080604052348015600f57600080fd5b50")
+	bytecodeIds, err := repo.StoreBytecode(fixture.Bytecode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.BytecodeId = bytecodeIds[0]
+	fixture.ContractRecord = &storage.Contract{
+		AddressId:     fixture.ContractId,
+		TransactionId: fixture.TransactionIds[2],
+		BytecodeId:    fixture.BytecodeId,
+	}
+	if err = repo.StoreContract(fixture.ContractRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture.Traces = []*storage.TraceAction{{
+		TransactionId: fixture.TransactionIds[0],
+		Index:         0,
+		TraceAddress:  []uint64{},
+		Type:          "CALL",
+		Input:         []byte{},
+		Output:        []byte{},
+		From:          fixture.SenderId,
+		To:            fixture.RecipientId,
+		Value:         fixture.Transactions[0].Value,
+		Gas:           21000,
+	}, {
+		TransactionId: fixture.TransactionIds[1],
+		Index:         0,
+		TraceAddress:  []uint64{},
+		Type:          "CALL",
+		Input:         fixture.Transactions[1].Input,
+		Output:        common.BigToHash(big.NewInt(1)).Bytes(),
+		From:          fixture.SenderId,
+		To:            fixture.TokenId,
+		Value:         big.NewInt(0),
+		Gas:           60000,
+		GasUsed:       29000,
+	}, {
+		TransactionId: fixture.TransactionIds[1],
+		Index:         1,
+		TraceAddress:  []uint64{0},
+		Depth:         1,
+		Type:          "STATICCALL",
+		Input:         common.FromHex("0x70a08231"),
+		Output:        common.BigToHash(transferredTokens).Bytes(),
+		From:          fixture.TokenId,
+		To:            fixture.ContractId,
+		Value:         big.NewInt(0),
+		Gas:           30000,
+		GasUsed:       2600,
+		Error:         pointer("execution reverted"),
+	}, {
+		TransactionId:    fixture.TransactionIds[2],
+		Index:            0,
+		TraceAddress:     []uint64{},
+		Type:             "CREATE",
+		Input:            []byte{},
+		Output:           fixture.Bytecode,
+		From:             fixture.RecipientId,
+		To:               fixture.ContractId,
+		Value:            big.NewInt(0),
+		Gas:              100000,
+		GasUsed:          78000,
+		CreatedAddressId: &fixture.ContractId,
+		InitCode:         fixture.Transactions[2].Input,
+	}}
+	if err = repo.StoreTrace(fixture.Traces...); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture.EtherBalances = []*storage.EtherBalance{
+		{BlockNumber: 1, AddressId: fixture.SenderId, Balance: BigInt("2535301200456458802993406410752")},
+		{BlockNumber: 1, AddressId: fixture.RecipientId, Balance: fixture.Transactions[0].Value},
+		{BlockNumber: 2, AddressId: fixture.SenderId, Balance: BigInt("2535301200456458446993406410752")},
+	}
+	if err = repo.StoreEtherBalance(fixture.EtherBalances...); err != nil {
+		t.Fatal(err)
+	}
+	fixture.Erc20TokenBalances = []*storage.Erc20TokenBalance{
+		{BlockNumber: 2, AddressId: fixture.SenderId, TokenAddressId: fixture.TokenId, Balance: BigInt("999999900000000000000000000")},
+		{BlockNumber: 2, AddressId: fixture.RecipientId, TokenAddressId: fixture.TokenId, Balance: transferredTokens},
+	}
+	if err = repo.StoreErc20TokenBalance(fixture.Erc20TokenBalances...); err != nil {
+		t.Fatal(err)
+	}
+
+	storageSlot := big.NewInt(3)
+	fixture.StateChanges = []*storage.StateChange{{
+		TransactionId: fixture.TransactionIds[1],
+		AddressId:     fixture.SenderId,
+		BalanceBefore: fixture.EtherBalances[0].Balance,
+		BalanceAfter:  fixture.EtherBalances[2].Balance,
+		NonceBefore:   pointer[uint64](1),
+		NonceAfter:    pointer[uint64](2),
+	}, {
+		TransactionId: fixture.TransactionIds[1],
+		AddressId:     fixture.TokenId,
+		BalanceBefore: big.NewInt(0),
+		BalanceAfter:  big.NewInt(0),
+		StorageChanges: []*storage.StorageChange{{
+			TransactionId:  fixture.TransactionIds[1],
+			AddressId:      fixture.TokenId,
+			StorageAddress: storageSlot,
+			ValueBefore:    BigInt("1000000000000000000000000000"),
+			ValueAfter:     BigInt("999999900000000000000000000"),
+		}},
+	}}
+	if err = repo.StoreStateChange(fixture.StateChanges...); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.StoreStorageChange(fixture.StateChanges[1].StorageChanges...); err != nil {
+		t.Fatal(err)
+	}
+	fixture.StorageKeys = []*storage.StorageKey{{
+		TransactionId: fixture.TransactionIds[1],
+		AddressId:     fixture.TokenId,
+		StorageKey:    storageSlot,
+	}}
+	if err = repo.StoreStorageKey(fixture.StorageKeys...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+// CheckEqual fails the test if the entities read back differ from the expected ones.
+// Entities are compared by their JSON encoding, so that numbers and byte slices compare by value,
+// and empty byte slices and lists compare equal to nil, as backends are free to return either.
+func CheckEqual(t testing.TB, what string, actual, expected any) {
+	t.Helper()
+	if failure, ok := actual.(readError); ok {
+		t.Errorf("failed to read %s: %v", what, failure.err)
+		return
+	}
+	actualJson, err := normalizedJson(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedJson, err := normalizedJson(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actualJson != expectedJson {
+		t.Errorf("%s differ:\n got: %s\nwant: %s", what, actualJson, expectedJson)
+	}
+}
+func normalizedJson(value any) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	var decoded any
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		return "", err
+	}
+	encoded, err = json.Marshal(withoutEmpty(decoded))
+	return string(encoded), err
+}
+func withoutEmpty(value any) any {
+	switch typedValue := value.(type) {
+	case string:
+		if typedValue == "" {
+			return nil
+		}
+	case []any:
+		if len(typedValue) == 0 {
+			return nil
+		}
+		for i, element := range typedValue {
+			typedValue[i] = withoutEmpty(element)
+		}
+	case map[string]any:
+		for key, element := range typedValue {
+			typedValue[key] = withoutEmpty(element)
+		}
+	}
+	return value
+}
@@ -0,0 +1,211 @@
+package storagetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// TestStorage tests a backend by storing the Fixture, reading it back, and deleting its last block.
+// newStorage returns an empty, loaded repository of the backend, once per subtest.
+// Listers are expected to return the newest entities first, like the SQL backends.
+func TestStorage(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	t.Run("Getters", func(t *testing.T) {
+		repo := newStorage(t)
+		testGetters(t, repo, Seed(t, repo))
+	})
+	t.Run("Listers", func(t *testing.T) {
+		repo := newStorage(t)
+		testListers(t, repo, Seed(t, repo))
+	})
+	t.Run("DeleteBlockAndAllReferences", func(t *testing.T) {
+		repo := newStorage(t)
+		testDeleteBlockAndAllReferences(t, repo, Seed(t, repo))
+	})
+}
+
+// must fails the test on errors, returning the value read otherwise.
+func must[T any](t *testing.T, value T, err error) T {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// readError is the failure of a read compared by CheckEqual.
+type readError struct {
+	err error
+}
+
+// read passes the result of a read to CheckEqual, which fails the test on errors.
+func read[T any](value T, err error) any {
+	if err != nil {
+		return readError{err}
+	}
+	return value
+}
+
+func testGetters(t *testing.T, repo storage.Storage, fixture *Fixture) {
+	CheckEqual(t, "address", read(repo.GetAddressById(fixture.SenderId)), fixture.Sender)
+	CheckEqual(t, "address id", read(repo.GetAddressIdByHash(fixture.Sender)), fixture.SenderId)
+	if addressId, err := repo.GetAddressIdByHash(common.HexToAddress("0xff")); must(t, addressId, err) != (storage.AddressId{}) {
+		t.Errorf("unknown address has id %v", addressId)
+	}
+	for _, block := range fixture.Blocks {
+		CheckEqual(t, "block by number", read(repo.GetBlockByNumber(block.Number)), block)
+		CheckEqual(t, "block by hash", read(repo.GetBlockByHash(&block.Hash)), block)
+	}
+	CheckEqual(t, "unknown block", read(repo.GetBlockByNumber(3)), nil)
+	CheckEqual(t, "latest block number", read(repo.GetLatestBlockNumber()), 2)
+	CheckEqual(t, "uncle", read(repo.GetUncleByUncleHash(&fixture.Uncles[0].Hash)), fixture.Uncles[0])
+	for i, transactionId := range fixture.TransactionIds {
+		CheckEqual(t, "transaction by id", read(repo.GetTransactionById(transactionId)), fixture.Transactions[i])
+		transaction, storedTransactionId, err := repo.GetTransactionByHash(&fixture.Transactions[i].Hash)
+		CheckEqual(t, "transaction by hash", must(t, transaction, err), fixture.Transactions[i])
+		CheckEqual(t, "transaction id", storedTransactionId, transactionId)
+		CheckEqual(t, "receipt", read(repo.GetReceiptByTransactionId(transactionId)), fixture.Receipts[i])
+	}
+	unknownHash := common.HexToHash("0xff")
+	transaction, _, err := repo.GetTransactionByHash(&unknownHash)
+	CheckEqual(t, "unknown transaction", read(transaction, err), nil)
+	CheckEqual(t, "call tree", read(repo.GetCallTree(&fixture.Transactions[1].Hash)),
+		&storage.CallFrame{TraceAction: fixture.Traces[1], Calls: []*storage.CallFrame{{TraceAction: fixture.Traces[2]}}})
+	CheckEqual(t, "bytecode", read(repo.GetByteCode(fixture.BytecodeId)), fixture.Bytecode)
+	CheckEqual(t, "log", read(repo.GetLogById(fixture.Logs[0].LogId)), fixture.Logs[0])
+	CheckEqual(t, "token", read(repo.GetErc20TokenByAddressId(fixture.TokenId)), fixture.Erc20Token)
+	CheckEqual(t, "contract", read(repo.GetContractByAddressId(fixture.ContractId)), fixture.ContractRecord)
+	CheckEqual(t, "event type", read(repo.GetEventTypeById(fixture.Topic0Id)), fixture.EventType)
+	CheckEqual(t, "wei balance at block 1", read(repo.GetWeiBalanceAtBlock(fixture.SenderId, 1)), fixture.EtherBalances[0].Balance)
+	CheckEqual(t, "wei balance at block 2", read(repo.GetWeiBalanceAtBlock(fixture.SenderId, 2)), fixture.EtherBalances[2].Balance)
+	CheckEqual(t, "unchanged wei balance", read(repo.GetWeiBalanceAtBlock(fixture.RecipientId, 2)), fixture.EtherBalances[1].Balance)
+	CheckEqual(t, "last ether balance", read(repo.GetLastStoredEtherBalance(fixture.SenderId)), fixture.EtherBalances[2])
+	CheckEqual(t, "last token balance", read(repo.GetLastStoredErc20TokenBalance(fixture.SenderId, fixture.TokenId)), fixture.Erc20TokenBalances[0])
+	CheckEqual(t, "first transaction sent", read(repo.GetFirstTxSent(fixture.SenderId)), fixture.Transactions[0].Hash)
+	CheckEqual(t, "last transaction sent", read(repo.GetLastTxSent(fixture.SenderId)), fixture.Transactions[1].Hash)
+	CheckEqual(t, "token holders", read(repo.GetErc20TokenHolders(fixture.TokenId)), 2)
+}
+
+func testListers(t *testing.T, repo storage.Storage, fixture *Fixture) {
+	blocks, total, err := repo.ListBlocks(storage.OffsetPagination{Limit: 10})
+	CheckEqual(t, "blocks", must(t, blocks, err), []*storage.Block{fixture.Blocks[1], fixture.Blocks[0]})
+	CheckEqual(t, "block count", total, 2)
+	blocks, total, err = repo.ListBlocks(storage.OffsetPagination{})
+	CheckEqual(t, "blocks without limit", must(t, blocks, err), nil)
+	CheckEqual(t, "block count without limit", total, 2)
+	CheckEqual(t, "uncles", read(repo.ListUnclesByBlockNumber(2)), fixture.Uncles)
+
+	transactions, transactionIds, total, err := repo.ListTransactions(storage.OffsetPagination{Limit: 2, Offset: 1})
+	CheckEqual(t, "transactions", must(t, transactions, err), []*storage.Transaction{fixture.Transactions[1], fixture.Transactions[0]})
+	CheckEqual(t, "transaction ids", transactionIds, []storage.TransactionId{fixture.TransactionIds[1], fixture.TransactionIds[0]})
+	CheckEqual(t, "transaction count", total, 3)
+	transactions, _, total, err = repo.ListTransactionsByBlockNumber(2, nil)
+	CheckEqual(t, "transactions of block", must(t, transactions, err), []*storage.Transaction{fixture.Transactions[2], fixture.Transactions[1]})
+	CheckEqual(t, "transaction count of block", total, 2)
+	transactions, _, total, err = repo.ListTransactionsByAddress(fixture.Sender, storage.OffsetPagination{Limit: 10})
+	CheckEqual(t, "transactions of address", must(t, transactions, err), []*storage.Transaction{fixture.Transactions[1], fixture.Transactions[0]})
+	CheckEqual(t, "transaction count of address", total, 2)
+	transactions, _, total, err = repo.ListTransactionsByFilter(storage.TransactionFilter{Address: &fixture.Sender, MinValue: common.Big1}, storage.OffsetPagination{Limit: 10})
+	CheckEqual(t, "transactions with value", must(t, transactions, err), []*storage.Transaction{fixture.Transactions[0]})
+	CheckEqual(t, "transaction count with value", total, 1)
+	transactions, _, _, err = repo.ListTransactionsByFilter(storage.TransactionFilter{OnlyContractCreations: true}, storage.OffsetPagination{Limit: 10})
+	CheckEqual(t, "contract creations", must(t, transactions, err), []*storage.Transaction{fixture.Transactions[2]})
+
+	CheckEqual(t, "storage keys", read(repo.ListStorageKeysByTransactionId(fixture.TransactionIds[1])), fixture.StorageKeys)
+	CheckEqual(t, "logs", read(repo.ListLogsByTransactionId(fixture.TransactionIds[1])), fixture.Logs)
+	CheckEqual(t, "logs by filter", read(repo.ListLogsByFilter(storage.LogFilter{
+		ToBlock:   2,
+		Addresses: []common.Address{fixture.Token},
+		Topics:    [][]common.Hash{{fixture.EventType.Hash}},
+	}, nil)), fixture.Logs)
+	CheckEqual(t, "logs by other topic", read(repo.ListLogsByFilter(storage.LogFilter{
+		ToBlock: 2,
+		Topics:  [][]common.Hash{{common.HexToHash("0xff")}},
+	}, nil)), nil)
+
+	transfers, total, err := repo.ListErc20TokenTransfers(&fixture.Token, &fixture.Recipient, &storage.OffsetPagination{Limit: 10})
+	CheckEqual(t, "token transfers", must(t, transfers, err), fixture.Erc20TokenTransfers)
+	CheckEqual(t, "token transfer count", total, 1)
+	transfers, total, err = repo.ListErc20TokenTransfers(nil, nil, nil)
+	CheckEqual(t, "token transfers without pagination", must(t, transfers, err), nil)
+	CheckEqual(t, "token transfer count without pagination", total, 1)
+
+	traces, blockNumbers, timestamps, total, err := repo.ListTraces(nil)
+	CheckEqual(t, "traces", must(t, traces, err), []*storage.TraceAction{fixture.Traces[3], fixture.Traces[2], fixture.Traces[1], fixture.Traces[0]})
+	CheckEqual(t, "block numbers of traces", blockNumbers, []uint64{2, 2, 2, 1})
+	CheckEqual(t, "timestamps of traces", timestamps, []uint64{fixture.Blocks[1].Timestamp, fixture.Blocks[1].Timestamp, fixture.Blocks[1].Timestamp, fixture.Blocks[0].Timestamp})
+	CheckEqual(t, "trace count", total, 4)
+	traces, blockNumber, timestamp, err := repo.ListTracesByTransactionHash(&fixture.Transactions[1].Hash)
+	CheckEqual(t, "traces of transaction", must(t, traces, err), []*storage.TraceAction{fixture.Traces[2], fixture.Traces[1]})
+	CheckEqual(t, "block number of transaction", blockNumber, 2)
+	CheckEqual(t, "timestamp of transaction", timestamp, fixture.Blocks[1].Timestamp)
+	traces, timestamp, total, err = repo.ListTracesByBlockNumber(1, nil)
+	CheckEqual(t, "traces of block", must(t, traces, err), []*storage.TraceAction{fixture.Traces[0]})
+	CheckEqual(t, "timestamp of block", timestamp, fixture.Blocks[0].Timestamp)
+	CheckEqual(t, "trace count of block", total, 1)
+	traces, blockNumbers, _, total, err = repo.ListTracesByAddress(fixture.Sender, storage.DirectionOutgoing, true, nil)
+	CheckEqual(t, "traces with value of address", must(t, traces, err), []*storage.TraceAction{fixture.Traces[0]})
+	CheckEqual(t, "block numbers of traces with value", blockNumbers, []uint64{1})
+	CheckEqual(t, "trace count with value", total, 1)
+
+	CheckEqual(t, "token balances", read(repo.ListErc20TokenBalancesAtBlock(fixture.SenderId, 2)), []*storage.Erc20TokenBalance{fixture.Erc20TokenBalances[0]})
+	CheckEqual(t, "token balances before transfer", read(repo.ListErc20TokenBalancesAtBlock(fixture.SenderId, 1)), nil)
+	holders, total, err := repo.ListErc20TokenHolders(fixture.TokenId, 2, storage.OffsetPagination{Limit: 10})
+	if must(t, holders, err); len(holders) != 2 {
+		t.Fatalf("listed %d token holders, expected 2", len(holders))
+	}
+	for i, balance := range fixture.Erc20TokenBalances {
+		CheckEqual(t, "token holder", holders[i].Erc20TokenBalance, balance)
+		if holders[i].PercentageOfSupply == nil {
+			t.Error("token holder has no percentage of supply")
+		}
+	}
+	CheckEqual(t, "token holder count", total, 2)
+	CheckEqual(t, "state changes", read(repo.ListStateChangesByTransactionHash(&fixture.Transactions[1].Hash)), fixture.StateChanges)
+	CheckEqual(t, "missing block ranges", read(repo.ListMissingBlockRanges(0, 4)), []*storage.BlockRange{{From: 0, To: 0}, {From: 3, To: 4}})
+}
+
+func testDeleteBlockAndAllReferences(t *testing.T, repo storage.Storage, fixture *Fixture) {
+	if err := repo.DeleteBlockAndAllReferences(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	CheckEqual(t, "deleted block", read(repo.GetBlockByNumber(2)), nil)
+	CheckEqual(t, "latest block number", read(repo.GetLatestBlockNumber()), 1)
+	CheckEqual(t, "missing block ranges", read(repo.ListMissingBlockRanges(1, 2)), []*storage.BlockRange{{From: 2, To: 2}})
+	CheckEqual(t, "uncles of deleted block", read(repo.ListUnclesByBlockNumber(2)), nil)
+	CheckEqual(t, "uncle of deleted block", read(repo.GetUncleByUncleHash(&fixture.Uncles[0].Hash)), nil)
+	for _, i := range []int{1, 2} {
+		CheckEqual(t, "deleted transaction", read(repo.GetTransactionById(fixture.TransactionIds[i])), nil)
+		transaction, _, err := repo.GetTransactionByHash(&fixture.Transactions[i].Hash)
+		CheckEqual(t, "deleted transaction by hash", must(t, transaction, err), nil)
+		CheckEqual(t, "receipt of deleted transaction", read(repo.GetReceiptByTransactionId(fixture.TransactionIds[i])), nil)
+	}
+	CheckEqual(t, "deleted log", read(repo.GetLogById(fixture.Logs[0].LogId)), nil)
+	CheckEqual(t, "logs of deleted transaction", read(repo.ListLogsByTransactionId(fixture.TransactionIds[1])), nil)
+	transfers, total, err := repo.ListErc20TokenTransfers(nil, nil, &storage.OffsetPagination{Limit: 10})
+	CheckEqual(t, "deleted token transfers", must(t, transfers, err), nil)
+	CheckEqual(t, "token transfer count", total, 0)
+	CheckEqual(t, "storage keys of deleted transaction", read(repo.ListStorageKeysByTransactionId(fixture.TransactionIds[1])), nil)
+	CheckEqual(t, "state changes of deleted transaction", read(repo.ListStateChangesByTransactionHash(&fixture.Transactions[1].Hash)), nil)
+	CheckEqual(t, "contract created in deleted block", read(repo.GetContractByAddressId(fixture.ContractId)), nil)
+	CheckEqual(t, "last ether balance", read(repo.GetLastStoredEtherBalance(fixture.SenderId)), fixture.EtherBalances[0])
+	CheckEqual(t, "token balance of deleted block", read(repo.GetLastStoredErc20TokenBalance(fixture.SenderId, fixture.TokenId)), nil)
+	CheckEqual(t, "token holders", read(repo.GetErc20TokenHolders(fixture.TokenId)), 0)
+
+	// the earlier block and the entities not belonging to blocks are kept
+	CheckEqual(t, "kept block", read(repo.GetBlockByNumber(1)), fixture.Blocks[0])
+	CheckEqual(t, "kept transaction", read(repo.GetTransactionById(fixture.TransactionIds[0])), fixture.Transactions[0])
+	CheckEqual(t, "kept receipt", read(repo.GetReceiptByTransactionId(fixture.TransactionIds[0])), fixture.Receipts[0])
+	traces, _, _, total, err := repo.ListTraces(nil)
+	CheckEqual(t, "kept traces", must(t, traces, err), []*storage.TraceAction{fixture.Traces[0]})
+	CheckEqual(t, "trace count", total, 1)
+	CheckEqual(t, "kept address", read(repo.GetAddressIdByHash(fixture.Token)), fixture.TokenId)
+	CheckEqual(t, "kept token", read(repo.GetErc20TokenByAddressId(fixture.TokenId)), fixture.Erc20Token)
+	CheckEqual(t, "kept bytecode", read(repo.GetByteCode(fixture.BytecodeId)), fixture.Bytecode)
+	CheckEqual(t, "kept event type", read(repo.GetEventTypeById(fixture.Topic0Id)), fixture.EventType)
+}
@@ -0,0 +1,120 @@
+package pebble
+
+import (
+	"fmt"
+
+	storage "github.com/librescan-org/backend-db"
+)
+
+func (repo *PebbleRepository) DeleteBlockAndAllReferences(blockNumbers ...storage.BlockNumber) (err error) {
+	defer annotate(&err, "failed to delete blocks %v", blockNumbers)
+	for _, blockNumber := range blockNumbers {
+		if err = repo.deleteBlock(blockNumber); err != nil {
+			return fmt.Errorf("block %d: %w", blockNumber, err)
+		}
+	}
+	return nil
+}
+
+// deleteBlock deletes the block along with the records referencing it, and their index entries,
+// like the cascading foreign keys of the SQL backends.
+func (repo *PebbleRepository) deleteBlock(blockNumber storage.BlockNumber) error {
+	block, err := repo.getBlock(blockNumber)
+	if block == nil || err != nil {
+		return err
+	}
+	if err = repo.delete(key(prefixBlockByHash, block.Hash.Bytes())); err != nil {
+		return err
+	}
+	if err = repo.delete(key(prefixBlock, uint64Bytes(blockNumber))); err != nil {
+		return err
+	}
+	if err = repo.count(counterBlocks, -1); err != nil {
+		return err
+	}
+	_, err = repo.deletePrefix(key(prefixUncleByBlock, uint64Bytes(blockNumber)), func(_, uncleHash []byte) ([][]byte, error) {
+		return [][]byte{key(prefixUncle, uncleHash)}, nil
+	})
+	if err != nil {
+		return err
+	}
+	var transactionIds [][]byte
+	lower, upper := transactionIdsOfBlocks(blockNumber, blockNumber)
+	deleted, err := repo.deleteRange(key(prefixTransaction, lower), key(prefixTransaction, upper), func(transactionKey, value []byte) ([][]byte, error) {
+		var transaction storage.Transaction
+		if err := decodeRecord(transactionKey, value, &transaction); err != nil {
+			return nil, err
+		}
+		transactionId := append([]byte(nil), transactionKey[1:]...)
+		transactionIds = append(transactionIds, transactionId)
+		var toAddressId storage.AddressId
+		if transaction.ToAddressId != nil {
+			toAddressId = *transaction.ToAddressId
+		}
+		return append(addressIndexKeys(prefixTransactionByAddress, transaction.FromAddressId, toAddressId, transactionId),
+			key(prefixTransactionByHash, transaction.Hash.Bytes())), nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = repo.count(counterTransactions, -int64(deleted)); err != nil {
+		return err
+	}
+	for _, transactionId := range transactionIds {
+		if err = repo.deleteTransactionReferences(transactionId); err != nil {
+			return err
+		}
+	}
+	_, err = repo.deletePrefix(key(prefixEtherBalanceByBlock, uint64Bytes(blockNumber)), func(indexKey, _ []byte) ([][]byte, error) {
+		return [][]byte{key(prefixEtherBalance, indexKey[9:17], uint64Bytes(blockNumber))}, nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = repo.deletePrefix(key(prefixErc20TokenBalanceByBlock, uint64Bytes(blockNumber)), func(indexKey, _ []byte) ([][]byte, error) {
+		holderAddressId, tokenAddressId := indexKey[9:17], indexKey[17:25]
+		return [][]byte{
+			key(prefixErc20TokenBalance, holderAddressId, tokenAddressId, uint64Bytes(blockNumber)),
+			key(prefixErc20TokenBalanceByToken, tokenAddressId, holderAddressId, uint64Bytes(blockNumber)),
+		}, nil
+	})
+	return err
+}
+
+// deleteTransactionReferences deletes the records referencing the transaction, and their index entries.
+func (repo *PebbleRepository) deleteTransactionReferences(transactionId []byte) error {
+	for _, prefix := range []byte{prefixStorageKey, prefixReceipt, prefixLog, prefixStateChange, prefixStorageChange} {
+		if _, err := repo.deletePrefix(key(prefix, transactionId), nil); err != nil {
+			return err
+		}
+	}
+	_, err := repo.deletePrefix(key(prefixErc20TokenTransfer, transactionId), func(transferKey, value []byte) ([][]byte, error) {
+		var transfer storage.Erc20TokenTransfer
+		if err := decodeRecord(transferKey, value, &transfer); err != nil {
+			return nil, err
+		}
+		logIndex := transferKey[9:]
+		return append(addressIndexKeys(prefixErc20TokenTransferByAddress, transfer.FromAddressId, transfer.ToAddressId, transactionId, logIndex),
+			key(prefixErc20TokenTransferByToken, idBytes(transfer.TokenAddressId), transactionId, logIndex)), nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = repo.deletePrefix(key(prefixContractByTransaction, transactionId), func(indexKey, _ []byte) ([][]byte, error) {
+		return [][]byte{key(prefixContract, indexKey[9:17])}, nil
+	})
+	if err != nil {
+		return err
+	}
+	deleted, err := repo.deletePrefix(key(prefixTrace, transactionId), func(traceKey, value []byte) ([][]byte, error) {
+		var trace storage.TraceAction
+		if err := decodeRecord(traceKey, value, &trace); err != nil {
+			return nil, err
+		}
+		return addressIndexKeys(prefixTraceByAddress, trace.From, trace.To, transactionId, traceKey[9:]), nil
+	})
+	if err != nil {
+		return err
+	}
+	return repo.count(counterTraces, -int64(deleted))
+}
@@ -0,0 +1,109 @@
+package pebble
+
+import (
+	"context"
+	"testing"
+
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/storagetest"
+)
+
+// blockOfKey returns the number of the block the key is bound to, false if the key is not bound to a block.
+// Keys of the records and index entries of a block must all be deleted along with it.
+func blockOfKey(t *testing.T, key, value []byte) (storage.BlockNumber, bool) {
+	t.Helper()
+	switch key[0] {
+	case prefixBlock, prefixUncleByBlock, prefixEtherBalanceByBlock, prefixErc20TokenBalanceByBlock:
+		return keyUint64(key, 1), true
+	case prefixTransaction, prefixStorageKey, prefixReceipt, prefixLog, prefixErc20TokenTransfer,
+		prefixContractByTransaction, prefixTrace, prefixStateChange, prefixStorageChange:
+		return blockNumberOfTransaction(keyTransactionId(key, 1)), true
+	case prefixTransactionByAddress, prefixTraceByAddress, prefixErc20TokenTransferByAddress, prefixErc20TokenTransferByToken:
+		return blockNumberOfTransaction(keyTransactionId(key, 9)), true
+	case prefixEtherBalance:
+		return keyUint64(key, 9), true
+	case prefixErc20TokenBalance, prefixErc20TokenBalanceByToken:
+		return keyUint64(key, 17), true
+	case prefixBlockByHash:
+		return keyUint64(value, 0), true
+	case prefixTransactionByHash:
+		return blockNumberOfTransaction(keyTransactionId(value, 0)), true
+	case prefixUncle:
+		var uncle storage.Uncle
+		if err := decodeRecord(key, value, &uncle); err != nil {
+			t.Fatal(err)
+		}
+		return uncle.BlockHeight, true
+	case prefixContract:
+		var contract storage.Contract
+		if err := decodeRecord(key, value, &contract); err != nil {
+			t.Fatal(err)
+		}
+		return blockNumberOfTransaction(contract.TransactionId), true
+	default:
+		return 0, false
+	}
+}
+
+// prefixesOfBlock returns the prefixes of the keys bound to the block.
+func prefixesOfBlock(t *testing.T, repo *PebbleRepository, blockNumber storage.BlockNumber) map[byte]bool {
+	t.Helper()
+	prefixes := map[byte]bool{}
+	err := repo.scan(nil, nil, false, func(key, value []byte) (bool, error) {
+		if number, bound := blockOfKey(t, key, value); bound && number == blockNumber {
+			prefixes[key[0]] = true
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prefixes
+}
+
+func TestDeleteBlockDeletesIndexEntries(t *testing.T) {
+	repo := newTestRepository(t)
+	fixture := storagetest.Seed(t, repo)
+
+	// every kind of key bound to a block is seeded, so the test covers all of them
+	prefixes := prefixesOfBlock(t, repo, 2)
+	for _, prefix := range []byte{
+		prefixBlock, prefixBlockByHash, prefixUncle, prefixUncleByBlock,
+		prefixTransaction, prefixTransactionByHash, prefixTransactionByAddress, prefixStorageKey, prefixReceipt, prefixLog,
+		prefixErc20TokenTransfer, prefixErc20TokenTransferByToken, prefixErc20TokenTransferByAddress,
+		prefixContract, prefixContractByTransaction, prefixTrace, prefixTraceByAddress,
+		prefixEtherBalance, prefixEtherBalanceByBlock, prefixErc20TokenBalance, prefixErc20TokenBalanceByToken, prefixErc20TokenBalanceByBlock,
+		prefixStateChange, prefixStorageChange,
+	} {
+		if !prefixes[prefix] {
+			t.Errorf("no key of prefix %#x references block 2 before its deletion", prefix)
+		}
+	}
+
+	if err := repo.DeleteBlockAndAllReferences(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for prefix := range prefixesOfBlock(t, repo, 2) {
+		t.Errorf("keys of prefix %#x still reference block 2 after its deletion", prefix)
+	}
+	if len(prefixesOfBlock(t, repo, 1)) == 0 {
+		t.Error("keys of block 1 are deleted along with block 2")
+	}
+
+	traces := 0
+	for _, trace := range fixture.Traces {
+		if blockNumberOfTransaction(trace.TransactionId) == 1 {
+			traces++
+		}
+	}
+	for name, want := range map[string]uint64{counterBlocks: 1, counterTransactions: 1, counterTraces: uint64(traces)} {
+		if count, err := repo.counter(name); err != nil {
+			t.Fatal(err)
+		} else if count != want {
+			t.Errorf("counter of %s: got %d, want %d", name, count, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package pebble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pebbledb "github.com/cockroachdb/pebble"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// storageError attaches the storage errors matching an error of the store, keeping the original error in the chain.
+type storageError struct {
+	kinds []error
+	err   error
+}
+
+func (err *storageError) Error() string {
+	return err.err.Error()
+}
+func (err *storageError) Unwrap() []error {
+	return append(append([]error(nil), err.kinds...), err.err)
+}
+
+// errorKinds returns the storage errors matching err, or nil if there are none.
+// The constraints of the records are checked by the inserters, which return the storage errors themselves.
+func errorKinds(err error) []error {
+	switch {
+	case errors.Is(err, pebbledb.ErrNotFound):
+		return []error{storage.ErrNotFound}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return []error{storage.ErrTimeout}
+	}
+	return nil
+}
+
+// mapError wraps err into the storage errors matching it, unless that was done already.
+func mapError(err error) error {
+	var mapped *storageError
+	if err == nil || errors.As(err, &mapped) {
+		return err
+	}
+	kinds := errorKinds(err)
+	if kinds == nil {
+		return err
+	}
+	return &storageError{kinds: kinds, err: err}
+}
+
+// annotate maps *err to the storage errors, and prefixes it with the failed operation naming the entity and the key.
+// Every exported method defers it on its named error result.
+func annotate(err *error, format string, args ...any) {
+	if *err == nil {
+		return
+	}
+	*err = fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), mapError(*err))
+}
+
+// errMissingReference is returned when a record references a block or a transaction which is not stored,
+// like the foreign keys of the SQL backends.
+func errMissingReference(format string, args ...any) error {
+	return fmt.Errorf("%w: %w: %s is not stored", storage.ErrConstraint, storage.ErrNotFound, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,231 @@
+package pebble
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+func (repo *PebbleRepository) GetEventTypeById(eventTypeId storage.Topic0Id) (_ *storage.EventType, err error) {
+	defer annotate(&err, "failed to get event type %v", eventTypeId)
+	var eventType eventTypeRecord
+	found, err := repo.getRecord(key(prefixEventType, idBytes(eventTypeId)), &eventType)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &storage.EventType{Hash: eventType.Hash, Signature: eventType.Signature}, nil
+}
+func (repo *PebbleRepository) getBlock(blockNumber storage.BlockNumber) (*storage.Block, error) {
+	var block storage.Block
+	found, err := repo.getRecord(key(prefixBlock, uint64Bytes(blockNumber)), &block)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+func (repo *PebbleRepository) GetBlockByHash(hash *common.Hash) (_ *storage.Block, err error) {
+	defer annotate(&err, "failed to get block %v", hash)
+	blockNumber, err := repo.get(key(prefixBlockByHash, hash.Bytes()))
+	if blockNumber == nil || err != nil {
+		return nil, err
+	}
+	return repo.getBlock(keyUint64(blockNumber, 0))
+}
+func (repo *PebbleRepository) GetBlockByNumber(number uint64) (_ *storage.Block, err error) {
+	defer annotate(&err, "failed to get block %d", number)
+	return repo.getBlock(number)
+}
+func (repo *PebbleRepository) getTransaction(transactionId storage.TransactionId) (*storage.Transaction, error) {
+	var transaction storage.Transaction
+	found, err := repo.getRecord(key(prefixTransaction, idBytes(transactionId)), &transaction)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+func (repo *PebbleRepository) GetTransactionById(transactionId storage.TransactionId) (_ *storage.Transaction, err error) {
+	defer annotate(&err, "failed to get transaction %v", transactionId)
+	return repo.getTransaction(transactionId)
+}
+func (repo *PebbleRepository) GetTransactionByHash(transactionHash *common.Hash) (_ *storage.Transaction, _ storage.TransactionId, err error) {
+	defer annotate(&err, "failed to get transaction %v", transactionHash)
+	id, err := repo.get(key(prefixTransactionByHash, transactionHash.Bytes()))
+	if id == nil || err != nil {
+		return nil, storage.TransactionId{}, err
+	}
+	transactionId := keyTransactionId(id, 0)
+	transaction, err := repo.getTransaction(transactionId)
+	if transaction == nil || err != nil {
+		return nil, storage.TransactionId{}, err
+	}
+	return transaction, transactionId, nil
+}
+func (repo *PebbleRepository) GetAddressById(addressId storage.AddressId) (_ *common.Address, err error) {
+	defer annotate(&err, "failed to get address %v", addressId)
+	hash, err := repo.get(key(prefixAddress, idBytes(addressId)))
+	if hash == nil || err != nil {
+		return nil, err
+	}
+	address := common.BytesToAddress(hash)
+	return &address, nil
+}
+func (repo *PebbleRepository) GetAddressIdByHash(addressHash common.Address) (_ storage.AddressId, err error) {
+	defer annotate(&err, "failed to get id of address %v", addressHash)
+	return repo.addressId(addressHash)
+}
+
+// addressId returns the id of the address, the zero id if it is not stored.
+func (repo *PebbleRepository) addressId(address common.Address) (storage.AddressId, error) {
+	id, err := repo.getUint64(key(prefixAddressByHash, address.Bytes()))
+	if id == 0 || err != nil {
+		return storage.AddressId{}, err
+	}
+	return storage.NewAddressId(int64(id)), nil
+}
+func (repo *PebbleRepository) GetReceiptByTransactionId(transactionId storage.TransactionId) (_ *storage.Receipt, err error) {
+	defer annotate(&err, "failed to get receipt of transaction %v", transactionId)
+	var receipt storage.Receipt
+	found, err := repo.getRecord(key(prefixReceipt, idBytes(transactionId)), &receipt)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+func (repo *PebbleRepository) GetByteCode(bytecodeId storage.BytecodeId) (_ *storage.Bytecode, err error) {
+	defer annotate(&err, "failed to get bytecode %v", bytecodeId)
+	value, err := repo.get(key(prefixBytecode, idBytes(bytecodeId)))
+	if value == nil || err != nil {
+		return nil, err
+	}
+	bytecode := storage.Bytecode(value)
+	return &bytecode, nil
+}
+func (repo *PebbleRepository) GetLogById(logId storage.LogId) (_ *storage.Log, err error) {
+	defer annotate(&err, "failed to get log %d of transaction %v", logId.LogIndex, logId.TransactionId)
+	var log storage.Log
+	found, err := repo.getRecord(key(prefixLog, idBytes(logId.TransactionId), uint64Bytes(logId.LogIndex)), &log)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+func (repo *PebbleRepository) GetErc20TokenByAddressId(addressId storage.AddressId) (_ *storage.Erc20Token, err error) {
+	defer annotate(&err, "failed to get ERC-20 token %v", addressId)
+	var erc20Token storage.Erc20Token
+	found, err := repo.getRecord(key(prefixErc20Token, idBytes(addressId)), &erc20Token)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &erc20Token, nil
+}
+func (repo *PebbleRepository) GetContractByAddressId(addressId storage.AddressId) (_ *storage.Contract, err error) {
+	defer annotate(&err, "failed to get contract %v", addressId)
+	var contract storage.Contract
+	found, err := repo.getRecord(key(prefixContract, idBytes(addressId)), &contract)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &contract, nil
+}
+
+// lastBalance returns the block number and the balance of the last key of the range, or nil if there are none.
+func (repo *PebbleRepository) lastBalance(lower, upper []byte) (blockNumber storage.BlockNumber, balance *big.Int, err error) {
+	err = repo.scan(lower, upper, true, func(key, value []byte) (bool, error) {
+		blockNumber = keyUint64(key, len(key)-8)
+		balance = new(big.Int).SetBytes(value)
+		return false, nil
+	})
+	return
+}
+func (repo *PebbleRepository) GetLastStoredEtherBalance(addressId storage.AddressId) (_ *storage.EtherBalance, err error) {
+	defer annotate(&err, "failed to get last ether balance of address %v", addressId)
+	prefix := key(prefixEtherBalance, idBytes(addressId))
+	blockNumber, balance, err := repo.lastBalance(prefix, upperBound(prefix))
+	if balance == nil || err != nil {
+		return nil, err
+	}
+	return &storage.EtherBalance{BlockNumber: blockNumber, AddressId: addressId, Balance: balance}, nil
+}
+func (repo *PebbleRepository) GetLastStoredErc20TokenBalance(addressId, tokenAddressId storage.AddressId) (_ *storage.Erc20TokenBalance, err error) {
+	defer annotate(&err, "failed to get last balance of address %v of token %v", addressId, tokenAddressId)
+	prefix := key(prefixErc20TokenBalance, idBytes(addressId), idBytes(tokenAddressId))
+	blockNumber, balance, err := repo.lastBalance(prefix, upperBound(prefix))
+	if balance == nil || err != nil {
+		return nil, err
+	}
+	return &storage.Erc20TokenBalance{BlockNumber: blockNumber, AddressId: addressId, TokenAddressId: tokenAddressId, Balance: balance}, nil
+}
+func (repo *PebbleRepository) GetLatestBlockNumber() (_ *storage.BlockNumber, err error) {
+	defer annotate(&err, "failed to get latest block number")
+	var blockNumber *storage.BlockNumber
+	err = repo.scanPrefix([]byte{prefixBlock}, true, func(key, _ []byte) (bool, error) {
+		number := keyUint64(key, 1)
+		blockNumber = &number
+		return false, nil
+	})
+	return blockNumber, err
+}
+func (repo *PebbleRepository) GetWeiBalanceAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (_ *big.Int, err error) {
+	defer annotate(&err, "failed to get balance of address %v at block %d", addressId, blockNumber)
+	_, balance, err := repo.lastBalance(key(prefixEtherBalance, idBytes(addressId)), upperBound(key(prefixEtherBalance, idBytes(addressId), uint64Bytes(blockNumber))))
+	if err != nil {
+		return nil, err
+	}
+	if balance == nil {
+		return new(big.Int), nil
+	}
+	return balance, nil
+}
+
+// sentTransactionHash returns the hash of the first transaction sent by the address, or of the last one if last is set,
+// or nil if it sent none.
+func (repo *PebbleRepository) sentTransactionHash(addressId storage.AddressId, last bool) (*common.Hash, error) {
+	var transactionId *storage.TransactionId
+	err := repo.scanPrefix(key(prefixTransactionByAddress, idBytes(addressId)), last, func(key, directions []byte) (bool, error) {
+		if !matchesDirection(directions[0], storage.DirectionOutgoing) {
+			return true, nil
+		}
+		id := keyTransactionId(key, 9)
+		transactionId = &id
+		return false, nil
+	})
+	if transactionId == nil || err != nil {
+		return nil, err
+	}
+	transaction, err := repo.getTransaction(*transactionId)
+	if transaction == nil || err != nil {
+		return nil, err
+	}
+	return &transaction.Hash, nil
+}
+func (repo *PebbleRepository) GetFirstTxSent(addressId storage.AddressId) (_ *common.Hash, err error) {
+	defer annotate(&err, "failed to get first transaction sent by address %v", addressId)
+	return repo.sentTransactionHash(addressId, false)
+}
+func (repo *PebbleRepository) GetLastTxSent(addressId storage.AddressId) (_ *common.Hash, err error) {
+	defer annotate(&err, "failed to get last transaction sent by address %v", addressId)
+	return repo.sentTransactionHash(addressId, true)
+}
+func (repo *PebbleRepository) GetErc20TokenHolders(erc20TokenId storage.Erc20TokenId) (holders uint64, err error) {
+	defer annotate(&err, "failed to count holders of token %v", erc20TokenId)
+	balances, err := repo.latestErc20TokenBalances(erc20TokenId, nil)
+	return uint64(len(balances)), err
+}
+func (repo *PebbleRepository) GetUncleByUncleHash(uncleHash *common.Hash) (_ *storage.Uncle, err error) {
+	defer annotate(&err, "failed to get uncle %v", uncleHash)
+	var uncle storage.Uncle
+	found, err := repo.getRecord(key(prefixUncle, uncleHash.Bytes()), &uncle)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &uncle, nil
+}
+func (repo *PebbleRepository) GetCallTree(transactionHash *common.Hash) (_ *storage.CallFrame, err error) {
+	defer annotate(&err, "failed to get call tree of transaction %v", transactionHash)
+	traces, _, _, err := repo.ListTracesByTransactionHash(transactionHash)
+	if err != nil {
+		return nil, err
+	}
+	return storage.BuildCallTree(traces)
+}
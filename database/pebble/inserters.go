@@ -0,0 +1,415 @@
+package pebble
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+)
+
+// storeRecords stores the records which are not stored yet, keeping the stored ones like the SQL backends do on conflicts.
+// recordKey returns the key of a record, failing if it references records which are not stored,
+// and index adds the index entries of a stored record, it can be nil.
+// Errors name the failing record, as described by describeRecord.
+func storeRecords[Record any](repo *PebbleRepository, records []Record, describeRecord func(Record) string, recordKey func(Record) ([]byte, error), index func(Record) error) error {
+	for _, record := range records {
+		err := func() error {
+			keyOfRecord, err := recordKey(record)
+			if err != nil {
+				return err
+			}
+			stored, err := repo.has(keyOfRecord)
+			if err != nil || stored {
+				return err
+			}
+			if err = repo.setRecord(keyOfRecord, record); err != nil {
+				return err
+			}
+			if index == nil {
+				return nil
+			}
+			return index(record)
+		}()
+		if err != nil {
+			return fmt.Errorf("%s: %w", describeRecord(record), err)
+		}
+	}
+	return nil
+}
+
+// requireBlock fails if the block is not stored.
+func (repo *PebbleRepository) requireBlock(blockNumber storage.BlockNumber) error {
+	stored, err := repo.has(key(prefixBlock, uint64Bytes(blockNumber)))
+	if err == nil && !stored {
+		err = errMissingReference("block %d", blockNumber)
+	}
+	return err
+}
+
+// requireTransaction fails if the transaction is not stored.
+func (repo *PebbleRepository) requireTransaction(transactionId storage.TransactionId) error {
+	stored, err := repo.has(key(prefixTransaction, idBytes(transactionId)))
+	if err == nil && !stored {
+		err = errMissingReference("transaction %v", transactionId)
+	}
+	return err
+}
+
+// indexAddresses adds the address index entries of a record sent by from to to, see addressIndexKeys.
+func (repo *PebbleRepository) indexAddresses(prefix byte, from, to storage.AddressId, recordKey ...[]byte) error {
+	for addressId, directions := range addressDirections(from, to) {
+		if err := repo.set(addressIndexKey(prefix, addressId, recordKey...), []byte{directions}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addressIndexKeys returns the keys of the address index entries of a record sent by from to to,
+// the key of the record following the address id.
+func addressIndexKeys(prefix byte, from, to storage.AddressId, recordKey ...[]byte) [][]byte {
+	var keys [][]byte
+	for addressId := range addressDirections(from, to) {
+		keys = append(keys, addressIndexKey(prefix, addressId, recordKey...))
+	}
+	return keys
+}
+func addressIndexKey(prefix byte, addressId storage.AddressId, recordKey ...[]byte) []byte {
+	return key(prefix, append([][]byte{idBytes(addressId)}, recordKey...)...)
+}
+
+// storeId returns the id stored with the key in the index, or generates the next id of the sequence,
+// which is stored with the key by store.
+func (repo *PebbleRepository) storeId(indexKey []byte, sequenceName string, store func(id int64) error) (int64, error) {
+	storedId, err := repo.getUint64(indexKey)
+	if err != nil || storedId != 0 {
+		return int64(storedId), err
+	}
+	id, err := repo.sequence(sequenceName)
+	if err != nil {
+		return 0, err
+	}
+	if err = store(id); err != nil {
+		return 0, err
+	}
+	return id, repo.set(indexKey, uint64Bytes(uint64(id)))
+}
+
+func (repo *PebbleRepository) StoreAddress(addresses ...common.Address) (_ []storage.AddressId, err error) {
+	defer annotate(&err, "failed to store addresses")
+	addressIds := make([]storage.AddressId, 0, len(addresses))
+	for _, address := range addresses {
+		id, err := repo.storeId(key(prefixAddressByHash, address.Bytes()), sequenceAddresses, func(id int64) error {
+			return repo.set(key(prefixAddress, uint64Bytes(uint64(id))), address.Bytes())
+		})
+		if err != nil {
+			return nil, fmt.Errorf("address %v: %w", address, err)
+		}
+		addressIds = append(addressIds, storage.NewAddressId(id))
+	}
+	return addressIds, nil
+}
+func (repo *PebbleRepository) StoreBlock(blocks ...*storage.Block) (err error) {
+	defer annotate(&err, "failed to store blocks")
+	if err = sqlcommon.ValidateRecords(blocks, sqlcommon.ValidateBlock); err != nil {
+		return err
+	}
+	return storeRecords(repo, blocks, sqlcommon.DescribeBlock,
+		func(block *storage.Block) ([]byte, error) {
+			block.Difficulty = sqlcommon.BigIntMustNotBeNil(block.Difficulty)
+			block.TotalDifficulty = sqlcommon.BigIntMustNotBeNil(block.TotalDifficulty)
+			block.BaseFeePerGas = sqlcommon.BigIntMustNotBeNil(block.BaseFeePerGas)
+			block.StaticReward = sqlcommon.BigIntMustNotBeNil(block.StaticReward)
+			return key(prefixBlock, uint64Bytes(block.Number)), nil
+		},
+		func(block *storage.Block) error {
+			if err := repo.set(key(prefixBlockByHash, block.Hash.Bytes()), uint64Bytes(block.Number)); err != nil {
+				return err
+			}
+			return repo.count(counterBlocks, 1)
+		})
+}
+func (repo *PebbleRepository) StoreUncle(uncles ...*storage.Uncle) (err error) {
+	defer annotate(&err, "failed to store uncles")
+	if err = sqlcommon.ValidateRecords(uncles, nil); err != nil {
+		return err
+	}
+	return storeRecords(repo, uncles, sqlcommon.DescribeUncle,
+		func(uncle *storage.Uncle) ([]byte, error) {
+			uncle.Difficulty = sqlcommon.BigIntMustNotBeNil(uncle.Difficulty)
+			uncle.Reward = sqlcommon.BigIntMustNotBeNil(uncle.Reward)
+			return key(prefixUncle, uncle.Hash.Bytes()), repo.requireBlock(uncle.BlockHeight)
+		},
+		func(uncle *storage.Uncle) error {
+			return repo.set(key(prefixUncleByBlock, uint64Bytes(uncle.BlockHeight), []byte{uncle.Position}), uncle.Hash.Bytes())
+		})
+}
+func (repo *PebbleRepository) StoreTransaction(transactions ...*storage.Transaction) (_ []storage.TransactionId, err error) {
+	defer annotate(&err, "failed to store transactions")
+	if err = sqlcommon.ValidateRecords(transactions, sqlcommon.ValidateTransaction); err != nil {
+		return nil, err
+	}
+	transactionIds := make([]storage.TransactionId, 0, len(transactions))
+	for _, transaction := range transactions {
+		id, err := repo.storeTransaction(transaction)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sqlcommon.DescribeTransaction(transaction), err)
+		}
+		transactionIds = append(transactionIds, id)
+	}
+	return transactionIds, nil
+}
+
+// storeTransaction stores the transaction unless its hash is stored already, and returns its id.
+func (repo *PebbleRepository) storeTransaction(transaction *storage.Transaction) (storage.TransactionId, error) {
+	// the id of the first transaction of the genesis block is 0, so stored ids are told apart by their presence
+	storedId, err := repo.get(key(prefixTransactionByHash, transaction.Hash.Bytes()))
+	if err != nil {
+		return storage.TransactionId{}, err
+	}
+	if storedId != nil {
+		return keyTransactionId(storedId, 0), nil
+	}
+	id, err := transactionId(transaction.BlockNumber, transaction.Index)
+	if err != nil {
+		return storage.TransactionId{}, err
+	}
+	if err = repo.requireBlock(transaction.BlockNumber); err != nil {
+		return storage.TransactionId{}, err
+	}
+	if stored, err := repo.has(key(prefixTransaction, idBytes(id))); err != nil || stored {
+		if stored {
+			err = fmt.Errorf("%w: transaction %d of block %d is stored with another hash", storage.ErrConflict, transaction.Index, transaction.BlockNumber)
+		}
+		return storage.TransactionId{}, err
+	}
+	transaction.Value = sqlcommon.BigIntMustNotBeNil(transaction.Value)
+	transaction.GasPrice = sqlcommon.BigIntMustNotBeNil(transaction.GasPrice)
+	if err = repo.setRecord(key(prefixTransaction, idBytes(id)), transaction); err != nil {
+		return storage.TransactionId{}, err
+	}
+	if err = repo.set(key(prefixTransactionByHash, transaction.Hash.Bytes()), idBytes(id)); err != nil {
+		return storage.TransactionId{}, err
+	}
+	var toAddressId storage.AddressId
+	if transaction.ToAddressId != nil {
+		toAddressId = *transaction.ToAddressId
+	}
+	if err = repo.indexAddresses(prefixTransactionByAddress, transaction.FromAddressId, toAddressId, idBytes(id)); err != nil {
+		return storage.TransactionId{}, err
+	}
+	return id, repo.count(counterTransactions, 1)
+}
+func (repo *PebbleRepository) StoreStorageKey(storageKeys ...*storage.StorageKey) (err error) {
+	defer annotate(&err, "failed to store storage keys")
+	if err = sqlcommon.ValidateRecords(storageKeys, sqlcommon.ValidateStorageKey); err != nil {
+		return err
+	}
+	for _, storageKey := range storageKeys {
+		if err = repo.requireTransaction(storageKey.TransactionId); err != nil {
+			return fmt.Errorf("%s: %w", sqlcommon.DescribeStorageKey(storageKey), err)
+		}
+		err = repo.set(key(prefixStorageKey, idBytes(storageKey.TransactionId), idBytes(storageKey.AddressId), wordBytes(storageKey.StorageKey.Bytes())), nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sqlcommon.DescribeStorageKey(storageKey), err)
+		}
+	}
+	return nil
+}
+func (repo *PebbleRepository) StoreReceipt(receipts ...*storage.Receipt) (err error) {
+	defer annotate(&err, "failed to store receipts")
+	if err = sqlcommon.ValidateRecords(receipts, sqlcommon.ValidateReceipt); err != nil {
+		return err
+	}
+	return storeRecords(repo, receipts, sqlcommon.DescribeReceipt,
+		func(receipt *storage.Receipt) ([]byte, error) {
+			receipt.EffectiveGasPrice = sqlcommon.BigIntMustNotBeNil(receipt.EffectiveGasPrice)
+			return key(prefixReceipt, idBytes(receipt.TransactionId)), repo.requireTransaction(receipt.TransactionId)
+		}, nil)
+}
+func (repo *PebbleRepository) StoreLog(logs ...*storage.Log) (err error) {
+	defer annotate(&err, "failed to store logs")
+	if err = sqlcommon.ValidateRecords(logs, sqlcommon.ValidateLog); err != nil {
+		return err
+	}
+	return storeRecords(repo, logs, sqlcommon.DescribeLog,
+		func(log *storage.Log) ([]byte, error) {
+			return key(prefixLog, idBytes(log.TransactionId), uint64Bytes(log.LogIndex)), repo.requireTransaction(log.TransactionId)
+		}, nil)
+}
+
+// eventTypeRecord is the stored event type, as storage.EventType would be encoded as its embedded hash alone.
+type eventTypeRecord struct {
+	Hash      common.Hash
+	Signature *string
+}
+
+func (repo *PebbleRepository) StoreTopic0(eventTypes ...*storage.EventType) (_ []storage.Topic0Id, err error) {
+	defer annotate(&err, "failed to store event types")
+	if err = sqlcommon.ValidateRecords(eventTypes, nil); err != nil {
+		return nil, err
+	}
+	eventTypeIds := make([]storage.Topic0Id, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		id, err := repo.storeId(key(prefixEventTypeByHash, eventType.Hash.Bytes()), sequenceEventTypes, func(id int64) error {
+			return repo.setRecord(key(prefixEventType, uint64Bytes(uint64(id))), eventTypeRecord{Hash: eventType.Hash, Signature: eventType.Signature})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sqlcommon.DescribeEventType(eventType), err)
+		}
+		eventTypeIds = append(eventTypeIds, storage.NewTopic0Id(id))
+	}
+	return eventTypeIds, nil
+}
+func (repo *PebbleRepository) StoreErc20TokenTransfer(erc20TokenTransfers ...*storage.Erc20TokenTransfer) (err error) {
+	defer annotate(&err, "failed to store ERC-20 token transfers")
+	if err = sqlcommon.ValidateRecords(erc20TokenTransfers, sqlcommon.ValidateErc20TokenTransfer); err != nil {
+		return err
+	}
+	return storeRecords(repo, erc20TokenTransfers, sqlcommon.DescribeErc20TokenTransfer,
+		func(transfer *storage.Erc20TokenTransfer) ([]byte, error) {
+			transfer.Value = sqlcommon.BigIntMustNotBeNil(transfer.Value)
+			return key(prefixErc20TokenTransfer, idBytes(transfer.TransactionId), uint64Bytes(transfer.LogIndex)), repo.requireTransaction(transfer.TransactionId)
+		},
+		func(transfer *storage.Erc20TokenTransfer) error {
+			err := repo.set(key(prefixErc20TokenTransferByToken, idBytes(transfer.TokenAddressId), idBytes(transfer.TransactionId), uint64Bytes(transfer.LogIndex)), nil)
+			if err != nil {
+				return err
+			}
+			return repo.indexAddresses(prefixErc20TokenTransferByAddress, transfer.FromAddressId, transfer.ToAddressId, idBytes(transfer.TransactionId), uint64Bytes(transfer.LogIndex))
+		})
+}
+func (repo *PebbleRepository) StoreErc20Token(erc20Tokens ...*storage.Erc20Token) (err error) {
+	defer annotate(&err, "failed to store ERC-20 tokens")
+	if err = sqlcommon.ValidateRecords(erc20Tokens, sqlcommon.ValidateErc20Token); err != nil {
+		return err
+	}
+	return storeRecords(repo, erc20Tokens, sqlcommon.DescribeErc20Token,
+		func(erc20Token *storage.Erc20Token) ([]byte, error) {
+			return key(prefixErc20Token, idBytes(erc20Token.AddressId)), nil
+		}, nil)
+}
+func (repo *PebbleRepository) StoreContract(contracts ...*storage.Contract) (err error) {
+	defer annotate(&err, "failed to store contracts")
+	if err = sqlcommon.ValidateRecords(contracts, sqlcommon.ValidateContract); err != nil {
+		return err
+	}
+	return storeRecords(repo, contracts, sqlcommon.DescribeContract,
+		func(contract *storage.Contract) ([]byte, error) {
+			return key(prefixContract, idBytes(contract.AddressId)), repo.requireTransaction(contract.TransactionId)
+		},
+		func(contract *storage.Contract) error {
+			return repo.set(key(prefixContractByTransaction, idBytes(contract.TransactionId), idBytes(contract.AddressId)), nil)
+		})
+}
+func (repo *PebbleRepository) StoreBytecode(bytecodes ...storage.Bytecode) (_ []storage.BytecodeId, err error) {
+	defer annotate(&err, "failed to store bytecodes")
+	bytecodeIds := make([]storage.BytecodeId, 0, len(bytecodes))
+	for _, bytecode := range bytecodes {
+		id, err := repo.storeId(key(prefixBytecodeBySha256, sqlcommon.BytecodeSha256(bytecode)), sequenceBytecodes, func(id int64) error {
+			return repo.set(key(prefixBytecode, uint64Bytes(uint64(id))), bytecode)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sqlcommon.DescribeBytecode(bytecode), err)
+		}
+		bytecodeIds = append(bytecodeIds, storage.NewBytecodeId(id))
+	}
+	return bytecodeIds, nil
+}
+func (repo *PebbleRepository) StoreTrace(traceActions ...*storage.TraceAction) (err error) {
+	defer annotate(&err, "failed to store traces")
+	if err = sqlcommon.ValidateRecords(traceActions, sqlcommon.ValidateTrace); err != nil {
+		return err
+	}
+	return storeRecords(repo, traceActions, sqlcommon.DescribeTrace,
+		func(traceAction *storage.TraceAction) ([]byte, error) {
+			traceAction.Value = sqlcommon.BigIntMustNotBeNil(traceAction.Value)
+			return key(prefixTrace, idBytes(traceAction.TransactionId), uint16Bytes(traceAction.Index)), repo.requireTransaction(traceAction.TransactionId)
+		},
+		func(traceAction *storage.TraceAction) error {
+			err := repo.indexAddresses(prefixTraceByAddress, traceAction.From, traceAction.To, idBytes(traceAction.TransactionId), uint16Bytes(traceAction.Index))
+			if err != nil {
+				return err
+			}
+			return repo.count(counterTraces, 1)
+		})
+}
+func (repo *PebbleRepository) StoreEtherBalance(etherBalances ...*storage.EtherBalance) (err error) {
+	defer annotate(&err, "failed to store ether balances")
+	if err = sqlcommon.ValidateRecords(etherBalances, sqlcommon.ValidateEtherBalance); err != nil {
+		return err
+	}
+	for _, etherBalance := range etherBalances {
+		if err = repo.storeBalance(etherBalance.BlockNumber, sqlcommon.BigIntMustNotBeNil(etherBalance.Balance),
+			key(prefixEtherBalance, idBytes(etherBalance.AddressId), uint64Bytes(etherBalance.BlockNumber)),
+			key(prefixEtherBalanceByBlock, uint64Bytes(etherBalance.BlockNumber), idBytes(etherBalance.AddressId))); err != nil {
+			return fmt.Errorf("%s: %w", sqlcommon.DescribeEtherBalance(etherBalance), err)
+		}
+	}
+	return nil
+}
+func (repo *PebbleRepository) StoreErc20TokenBalance(tokenBalances ...*storage.Erc20TokenBalance) (err error) {
+	defer annotate(&err, "failed to store ERC-20 token balances")
+	if err = sqlcommon.ValidateRecords(tokenBalances, sqlcommon.ValidateErc20TokenBalance); err != nil {
+		return err
+	}
+	for _, tokenBalance := range tokenBalances {
+		if err = repo.storeBalance(tokenBalance.BlockNumber, sqlcommon.BigIntMustNotBeNil(tokenBalance.Balance),
+			key(prefixErc20TokenBalance, idBytes(tokenBalance.AddressId), idBytes(tokenBalance.TokenAddressId), uint64Bytes(tokenBalance.BlockNumber)),
+			key(prefixErc20TokenBalanceByBlock, uint64Bytes(tokenBalance.BlockNumber), idBytes(tokenBalance.AddressId), idBytes(tokenBalance.TokenAddressId)),
+			key(prefixErc20TokenBalanceByToken, idBytes(tokenBalance.TokenAddressId), idBytes(tokenBalance.AddressId), uint64Bytes(tokenBalance.BlockNumber))); err != nil {
+			return fmt.Errorf("%s: %w", sqlcommon.DescribeErc20TokenBalance(tokenBalance), err)
+		}
+	}
+	return nil
+}
+
+// storeBalance stores the balance at the block with the key, unless a balance is stored with it already.
+// The balance is stored with the index keys too, the ones in block order holding no value.
+func (repo *PebbleRepository) storeBalance(blockNumber storage.BlockNumber, balance *big.Int, balanceKey, blockIndexKey []byte, indexKeys ...[]byte) error {
+	if err := repo.requireBlock(blockNumber); err != nil {
+		return err
+	}
+	stored, err := repo.has(balanceKey)
+	if err != nil || stored {
+		return err
+	}
+	for _, balanceKey := range append([][]byte{balanceKey}, indexKeys...) {
+		if err = repo.set(balanceKey, balance.Bytes()); err != nil {
+			return err
+		}
+	}
+	return repo.set(blockIndexKey, nil)
+}
+func (repo *PebbleRepository) StoreStateChange(stateChanges ...*storage.StateChange) (err error) {
+	defer annotate(&err, "failed to store state changes")
+	if err = sqlcommon.ValidateRecords(stateChanges, sqlcommon.ValidateStateChange); err != nil {
+		return err
+	}
+	// the storage changes are stored by StoreStorageChange
+	withoutStorageChanges := make([]*storage.StateChange, 0, len(stateChanges))
+	for _, stateChange := range stateChanges {
+		stateChange.BalanceBefore = sqlcommon.BigIntMustNotBeNil(stateChange.BalanceBefore)
+		stateChange.BalanceAfter = sqlcommon.BigIntMustNotBeNil(stateChange.BalanceAfter)
+		withoutStorageChange := *stateChange
+		withoutStorageChange.StorageChanges = nil
+		withoutStorageChanges = append(withoutStorageChanges, &withoutStorageChange)
+	}
+	return storeRecords(repo, withoutStorageChanges, sqlcommon.DescribeStateChange,
+		func(stateChange *storage.StateChange) ([]byte, error) {
+			return key(prefixStateChange, idBytes(stateChange.TransactionId), idBytes(stateChange.AddressId)), repo.requireTransaction(stateChange.TransactionId)
+		}, nil)
+}
+func (repo *PebbleRepository) StoreStorageChange(storageChanges ...*storage.StorageChange) (err error) {
+	defer annotate(&err, "failed to store storage changes")
+	if err = sqlcommon.ValidateRecords(storageChanges, sqlcommon.ValidateStorageChange); err != nil {
+		return err
+	}
+	return storeRecords(repo, storageChanges, sqlcommon.DescribeStorageChange,
+		func(storageChange *storage.StorageChange) ([]byte, error) {
+			return key(prefixStorageChange, idBytes(storageChange.TransactionId), idBytes(storageChange.AddressId), wordBytes(storageChange.StorageAddress.Bytes())),
+				repo.requireTransaction(storageChange.TransactionId)
+		}, nil)
+}
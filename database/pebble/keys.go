@@ -0,0 +1,196 @@
+package pebble
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	storage "github.com/librescan-org/backend-db"
+)
+
+// Every key starts with the prefix of its record kind, followed by the parts of the key encoded in big-endian,
+// so the keys of a kind sort by their parts, and a range of them is read by a single iterator.
+// The values of records are JSON, the values of index entries are described along with their prefixes.
+// The prefixes are persisted, so they must never change.
+const (
+	// name: the last id generated, or the number of stored records, see sequence and counter
+	prefixSequence byte = 0x01
+	// address id: address
+	prefixAddress byte = 0x02
+	// address: address id
+	prefixAddressByHash byte = 0x03
+	// block number: block
+	prefixBlock byte = 0x04
+	// block hash: block number
+	prefixBlockByHash byte = 0x05
+	// uncle hash: uncle
+	prefixUncle byte = 0x06
+	// block number, position: uncle hash
+	prefixUncleByBlock byte = 0x07
+	// transaction id: transaction
+	prefixTransaction byte = 0x08
+	// transaction hash: transaction id
+	prefixTransactionByHash byte = 0x09
+	// address id, transaction id: directions
+	prefixTransactionByAddress byte = 0x0a
+	// transaction id, address id, storage key: nothing
+	prefixStorageKey byte = 0x0b
+	// transaction id: receipt
+	prefixReceipt byte = 0x0c
+	// transaction id, log index: log
+	prefixLog byte = 0x0d
+	// event type id: event type
+	prefixEventType byte = 0x0e
+	// event type hash: event type id
+	prefixEventTypeByHash byte = 0x0f
+	// token address id: token
+	prefixErc20Token byte = 0x10
+	// transaction id, log index: transfer
+	prefixErc20TokenTransfer byte = 0x11
+	// token address id, transaction id, log index: nothing
+	prefixErc20TokenTransferByToken byte = 0x12
+	// address id, transaction id, log index: directions
+	prefixErc20TokenTransferByAddress byte = 0x13
+	// bytecode id: bytecode
+	prefixBytecode byte = 0x14
+	// SHA-256 of the bytecode: bytecode id
+	prefixBytecodeBySha256 byte = 0x15
+	// address id: contract
+	prefixContract byte = 0x16
+	// transaction id, address id: nothing
+	prefixContractByTransaction byte = 0x17
+	// transaction id, trace index: trace
+	prefixTrace byte = 0x18
+	// address id, transaction id, trace index: directions
+	prefixTraceByAddress byte = 0x19
+	// address id, block number: balance
+	prefixEtherBalance byte = 0x1a
+	// block number, address id: nothing
+	prefixEtherBalanceByBlock byte = 0x1b
+	// holder address id, token address id, block number: balance
+	prefixErc20TokenBalance byte = 0x1c
+	// token address id, holder address id, block number: balance
+	prefixErc20TokenBalanceByToken byte = 0x1d
+	// block number, holder address id, token address id: nothing
+	prefixErc20TokenBalanceByBlock byte = 0x1e
+	// transaction id, address id: state change
+	prefixStateChange byte = 0x1f
+	// transaction id, address id, storage address: storage change
+	prefixStorageChange byte = 0x20
+)
+
+// Directions are the values of the address index entries, telling whether the address sent or received the record.
+const (
+	directionFrom byte = 1 << iota
+	directionTo
+)
+
+// matchesDirection reports whether the directions of an address index entry match the direction filter.
+func matchesDirection(directions byte, direction storage.Direction) bool {
+	switch direction {
+	case storage.DirectionOutgoing:
+		return directions&directionFrom != 0
+	case storage.DirectionIncoming:
+		return directions&directionTo != 0
+	default:
+		return true
+	}
+}
+
+// addressDirections returns the directions of the address index entries of a record sent by from to to.
+func addressDirections(from, to storage.AddressId) map[storage.AddressId]byte {
+	directions := map[storage.AddressId]byte{from: directionFrom}
+	if !to.IsZero() {
+		directions[to] |= directionTo
+	}
+	return directions
+}
+
+// Transaction ids are derived from the block numbers and the indexes of the transactions, like in a partitioned postgres database,
+// so the transactions of a block are a range of ids sorted in chain order, and they are deleted along with their block.
+const (
+	transactionIndexBits = 16
+	maxTransactionIndex  = 1<<transactionIndexBits - 1
+	// maxBlockNumber is the number of the last block whose transactions have ids, which are positive int64s.
+	maxBlockNumber = math.MaxInt64 >> transactionIndexBits
+)
+
+func transactionId(blockNumber storage.BlockNumber, transactionIndex uint64) (storage.TransactionId, error) {
+	if blockNumber > maxBlockNumber {
+		return storage.TransactionId{}, fmt.Errorf("%w: block %d exceeds the maximum of %d", storage.ErrConstraint, blockNumber, maxBlockNumber)
+	}
+	if transactionIndex > maxTransactionIndex {
+		return storage.TransactionId{}, fmt.Errorf("%w: transaction index %d of block %d exceeds the maximum of %d", storage.ErrConstraint, transactionIndex, blockNumber, maxTransactionIndex)
+	}
+	return storage.NewTransactionId(int64(blockNumber<<transactionIndexBits | transactionIndex)), nil
+}
+
+// blockNumberOfTransaction returns the number of the block holding the transaction.
+func blockNumberOfTransaction(transactionId storage.TransactionId) storage.BlockNumber {
+	return uint64(transactionId.Int64()) >> transactionIndexBits
+}
+
+// transactionIdsOfBlocks returns the key part bounding the transaction ids of the blocks between from and to, the upper bound excluded.
+func transactionIdsOfBlocks(from, to storage.BlockNumber) (lower, upper []byte) {
+	if to > maxBlockNumber {
+		to = maxBlockNumber
+	}
+	if from > to {
+		return uint64Bytes(0), uint64Bytes(0)
+	}
+	return uint64Bytes(from << transactionIndexBits), uint64Bytes((to + 1) << transactionIndexBits)
+}
+
+func uint64Bytes(value uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, value)
+}
+func uint16Bytes(value uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, value)
+}
+func idBytes[Kind any](id storage.Id[Kind]) []byte {
+	return uint64Bytes(uint64(id.Int64()))
+}
+
+// key joins the prefix and the parts of a key.
+func key(prefix byte, parts ...[]byte) []byte {
+	length := 1
+	for _, part := range parts {
+		length += len(part)
+	}
+	joined := make([]byte, 0, length)
+	joined = append(joined, prefix)
+	for _, part := range parts {
+		joined = append(joined, part...)
+	}
+	return joined
+}
+
+// upperBound returns the smallest key greater than all keys starting with prefix.
+func upperBound(prefix []byte) []byte {
+	bound := append([]byte(nil), prefix...)
+	for i := len(bound) - 1; i >= 0; i-- {
+		bound[i]++
+		if bound[i] != 0 {
+			return bound[:i+1]
+		}
+	}
+	return nil
+}
+
+// keyUint64 decodes the part of the key at the offset.
+func keyUint64(key []byte, offset int) uint64 {
+	return binary.BigEndian.Uint64(key[offset : offset+8])
+}
+func keyAddressId(key []byte, offset int) storage.AddressId {
+	return storage.NewAddressId(int64(keyUint64(key, offset)))
+}
+func keyTransactionId(key []byte, offset int) storage.TransactionId {
+	return storage.NewTransactionId(int64(keyUint64(key, offset)))
+}
+
+// wordBytes pads the bytes of a storage word to 32 bytes, so words of all values sort like them.
+func wordBytes(word []byte) []byte {
+	padded := make([]byte, 32)
+	copy(padded[32-len(word):], word)
+	return padded
+}
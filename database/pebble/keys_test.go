@@ -0,0 +1,169 @@
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+func TestBlockNumberKeysSortLikeNumbers(t *testing.T) {
+	numbers := []storage.BlockNumber{0, 1, 255, 256, 65535, 65536, 1 << 32, maxBlockNumber, math.MaxUint64}
+	for i, number := range numbers {
+		numberKey := key(prefixBlock, uint64Bytes(number))
+		if decoded := keyUint64(numberKey, 1); decoded != number {
+			t.Errorf("key of block %d decodes to %d", number, decoded)
+		}
+		if i == 0 {
+			continue
+		}
+		if previousKey := key(prefixBlock, uint64Bytes(numbers[i-1])); bytes.Compare(previousKey, numberKey) >= 0 {
+			t.Errorf("key of block %d %x does not sort before the key of block %d %x", numbers[i-1], previousKey, number, numberKey)
+		}
+	}
+}
+
+func TestTransactionIds(t *testing.T) {
+	for _, blockNumber := range []storage.BlockNumber{0, 1, 256, maxBlockNumber} {
+		for _, index := range []uint64{0, 1, maxTransactionIndex} {
+			id, err := transactionId(blockNumber, index)
+			if err != nil {
+				t.Fatalf("transaction %d of block %d: %v", index, blockNumber, err)
+			}
+			if id.Int64() < 0 {
+				t.Errorf("id of transaction %d of block %d is negative: %d", index, blockNumber, id.Int64())
+			}
+			if decoded := blockNumberOfTransaction(id); decoded != blockNumber {
+				t.Errorf("id of transaction %d of block %d decodes to block %d", index, blockNumber, decoded)
+			}
+			if decoded := keyTransactionId(key(prefixTransaction, idBytes(id)), 1); decoded != id {
+				t.Errorf("key of transaction %v decodes to %v", id, decoded)
+			}
+		}
+	}
+	if _, err := transactionId(maxBlockNumber+1, 0); !errors.Is(err, storage.ErrConstraint) {
+		t.Errorf("transaction of block %d: got %v, want %v", uint64(maxBlockNumber+1), err, storage.ErrConstraint)
+	}
+	if _, err := transactionId(1, maxTransactionIndex+1); !errors.Is(err, storage.ErrConstraint) {
+		t.Errorf("transaction %d of block 1: got %v, want %v", maxTransactionIndex+1, err, storage.ErrConstraint)
+	}
+}
+
+func TestTransactionIdsOfBlocks(t *testing.T) {
+	lastOfBlock1, _ := transactionId(1, maxTransactionIndex)
+	firstOfBlock2, _ := transactionId(2, 0)
+	lastOfBlock3, _ := transactionId(3, maxTransactionIndex)
+	firstOfBlock4, _ := transactionId(4, 0)
+	lower, upper := transactionIdsOfBlocks(2, 3)
+	for _, id := range []storage.TransactionId{firstOfBlock2, lastOfBlock3} {
+		if bytes.Compare(idBytes(id), lower) < 0 || bytes.Compare(idBytes(id), upper) >= 0 {
+			t.Errorf("transaction %v of blocks 2 to 3 is out of [%x, %x)", id, lower, upper)
+		}
+	}
+	for _, id := range []storage.TransactionId{lastOfBlock1, firstOfBlock4} {
+		if bytes.Compare(idBytes(id), lower) >= 0 && bytes.Compare(idBytes(id), upper) < 0 {
+			t.Errorf("transaction %v of another block is in [%x, %x)", id, lower, upper)
+		}
+	}
+	if lower, upper := transactionIdsOfBlocks(3, 2); bytes.Compare(lower, upper) < 0 {
+		t.Errorf("empty range of blocks 3 to 2 is [%x, %x)", lower, upper)
+	}
+	lastId, _ := transactionId(maxBlockNumber, maxTransactionIndex)
+	if _, upper := transactionIdsOfBlocks(0, math.MaxUint64); bytes.Compare(idBytes(lastId), upper) >= 0 {
+		t.Errorf("last transaction %v is out of the range of all blocks, bounded by %x", lastId, upper)
+	}
+}
+
+func TestUpperBound(t *testing.T) {
+	for _, test := range []struct {
+		prefix, bound []byte
+	}{
+		{[]byte{1, 2}, []byte{1, 3}},
+		{[]byte{1, 0xff}, []byte{2}},
+		{[]byte{1, 0xff, 0xff}, []byte{2}},
+		{[]byte{0xff, 0xff}, nil},
+	} {
+		if bound := upperBound(test.prefix); !bytes.Equal(bound, test.bound) {
+			t.Errorf("upper bound of %x: got %x, want %x", test.prefix, bound, test.bound)
+		}
+	}
+}
+
+func TestAddressIndexKeys(t *testing.T) {
+	addressId := storage.NewAddressId(300)
+	id, _ := transactionId(257, 3)
+	indexKey := key(prefixTransactionByAddress, idBytes(addressId), idBytes(id))
+	if decoded := keyAddressId(indexKey, 1); decoded != addressId {
+		t.Errorf("address id of %x: got %v, want %v", indexKey, decoded, addressId)
+	}
+	if decoded := keyTransactionId(indexKey, 9); decoded != id {
+		t.Errorf("transaction id of %x: got %v, want %v", indexKey, decoded, id)
+	}
+}
+
+func TestTransactionsByAddressAreScannedInReverse(t *testing.T) {
+	repo := newTestRepository(t)
+	addressIds, err := repo.StoreAddress(common.HexToAddress("0xaa"), common.HexToAddress("0xbb"), common.HexToAddress("0xcc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner, sender, recipient := addressIds[0], addressIds[1], addressIds[2]
+	// the numbers span several bytes, so the transactions are only sorted if the ids are compared as big-endian bytes
+	blockNumbers := []storage.BlockNumber{1, 255, 256, 257, 65536}
+	for _, blockNumber := range blockNumbers {
+		if err = repo.StoreBlock(&storage.Block{Number: blockNumber, Hash: common.BigToHash(new(big.Int).SetUint64(blockNumber)), MinerAddressId: miner}); err != nil {
+			t.Fatal(err)
+		}
+		transactions := []*storage.Transaction{
+			{BlockNumber: blockNumber, Index: 0, Hash: common.BigToHash(new(big.Int).SetUint64(blockNumber<<8 | 0)), FromAddressId: sender, ToAddressId: &recipient},
+			{BlockNumber: blockNumber, Index: 1, Hash: common.BigToHash(new(big.Int).SetUint64(blockNumber<<8 | 1)), FromAddressId: recipient, ToAddressId: &sender},
+		}
+		if _, err = repo.StoreTransaction(transactions...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = repo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned []storage.TransactionId
+	err = repo.scanPrefix(key(prefixTransactionByAddress, idBytes(sender)), true, func(indexKey, _ []byte) (bool, error) {
+		scanned = append(scanned, keyTransactionId(indexKey, 9))
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(scanned); i++ {
+		if scanned[i-1].Int64() <= scanned[i].Int64() {
+			t.Fatalf("reverse scan is not descending: %v", scanned)
+		}
+	}
+	if len(scanned) != 2*len(blockNumbers) {
+		t.Fatalf("reverse scan found %d transactions, want %d", len(scanned), 2*len(blockNumbers))
+	}
+
+	var listed []storage.TransactionId
+	for page := uint64(0); ; page++ {
+		_, ids, found, err := repo.ListTransactionsByAddress(common.HexToAddress("0xbb"), storage.NewOffsetPagination(3, page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != uint64(len(scanned)) {
+			t.Errorf("page %d: found %d transactions, want %d", page, found, len(scanned))
+		}
+		if len(ids) == 0 {
+			break
+		}
+		listed = append(listed, ids...)
+	}
+	if !reflect.DeepEqual(listed, scanned) {
+		t.Errorf("listed transactions %v differ from the scanned ones %v", listed, scanned)
+	}
+}
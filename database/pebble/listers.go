@@ -0,0 +1,724 @@
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// pager counts the records found by a lister, and tells which of them are in the page of the pagination,
+// all of them if it is nil.
+type pager struct {
+	pagination *storage.OffsetPagination
+	found      uint64
+}
+
+// next counts a found record, and reports whether it is in the page.
+func (page *pager) next() bool {
+	page.found++
+	return page.pagination == nil ||
+		page.found > page.pagination.Offset && page.found <= page.pagination.Offset+uint64(page.pagination.Limit)
+}
+
+// done reports whether the records after the page were reached, so listers not counting all records can stop.
+func (page *pager) done() bool {
+	return page.pagination != nil && page.found >= page.pagination.Offset+uint64(page.pagination.Limit)
+}
+
+// listRecords decodes the records of the range which are in the page, and calls visit with each of them and its key.
+// The scan stops at the end of the page, unless countAll is set.
+func listRecords[Record any](repo *PebbleRepository, lower, upper []byte, reverse bool, page *pager, countAll bool, visit func(key []byte, record *Record)) error {
+	if page.done() && !countAll {
+		return nil
+	}
+	return repo.scan(lower, upper, reverse, func(key, value []byte) (bool, error) {
+		if page.next() {
+			record := new(Record)
+			if err := decodeRecord(key, value, record); err != nil {
+				return false, err
+			}
+			visit(key, record)
+		}
+		return countAll || !page.done(), nil
+	})
+}
+
+// blockTimestamps caches the timestamps of the blocks read by a lister.
+type blockTimestamps map[storage.BlockNumber]uint64
+
+func (repo *PebbleRepository) timestamp(timestamps blockTimestamps, blockNumber storage.BlockNumber) (uint64, error) {
+	if timestamp, ok := timestamps[blockNumber]; ok {
+		return timestamp, nil
+	}
+	block, err := repo.getBlock(blockNumber)
+	if err != nil {
+		return 0, err
+	}
+	if block == nil {
+		return 0, errMissingReference("block %d", blockNumber)
+	}
+	timestamps[blockNumber] = block.Timestamp
+	return block.Timestamp, nil
+}
+
+func (repo *PebbleRepository) ListBlocks(pagination storage.OffsetPagination) (blocks []*storage.Block, totalRecordsFound uint64, err error) {
+	defer annotate(&err, "failed to list blocks")
+	page := pager{pagination: &pagination}
+	err = listRecords(repo, []byte{prefixBlock}, upperBound([]byte{prefixBlock}), true, &page, false, func(_ []byte, block *storage.Block) {
+		blocks = append(blocks, block)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	totalRecordsFound, err = repo.counter(counterBlocks)
+	return
+}
+func (repo *PebbleRepository) ListUnclesByBlockNumber(blockNumber storage.BlockNumber) (uncles []*storage.Uncle, err error) {
+	defer annotate(&err, "failed to list uncles of block %d", blockNumber)
+	err = repo.scanPrefix(key(prefixUncleByBlock, uint64Bytes(blockNumber)), false, func(_, uncleHash []byte) (bool, error) {
+		var uncle storage.Uncle
+		found, err := repo.getRecord(key(prefixUncle, uncleHash), &uncle)
+		if err == nil && !found {
+			err = errMissingReference("uncle %x", uncleHash)
+		}
+		if err != nil {
+			return false, err
+		}
+		uncles = append(uncles, &uncle)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uncles, nil
+}
+func (repo *PebbleRepository) ListTransactions(pagination storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	defer annotate(&err, "failed to list transactions")
+	page := pager{pagination: &pagination}
+	err = listRecords(repo, []byte{prefixTransaction}, upperBound([]byte{prefixTransaction}), true, &page, false, func(key []byte, transaction *storage.Transaction) {
+		transactions = append(transactions, transaction)
+		transactionIds = append(transactionIds, keyTransactionId(key, 1))
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	totalRecordsFound, err = repo.counter(counterTransactions)
+	return
+}
+func (repo *PebbleRepository) ListTransactionsByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	defer annotate(&err, "failed to list transactions of block %d", blockNumber)
+	lower, upper := transactionIdsOfBlocks(blockNumber, blockNumber)
+	page := pager{pagination: pagination}
+	err = listRecords(repo, key(prefixTransaction, lower), key(prefixTransaction, upper), true, &page, true, func(key []byte, transaction *storage.Transaction) {
+		transactions = append(transactions, transaction)
+		transactionIds = append(transactionIds, keyTransactionId(key, 1))
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return transactions, transactionIds, page.found, nil
+}
+func (repo *PebbleRepository) ListTransactionsByAddress(address common.Address, pagination storage.OffsetPagination) (_ []*storage.Transaction, _ []storage.TransactionId, _ uint64, err error) {
+	defer annotate(&err, "failed to list transactions of address %v", address)
+	return repo.ListTransactionsByFilter(storage.TransactionFilter{Address: &address}, pagination)
+}
+func (repo *PebbleRepository) ListTransactionsByFilter(filter storage.TransactionFilter, pagination storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	defer annotate(&err, "failed to list transactions by filter")
+	fromBlock, toBlock := storage.BlockNumber(0), storage.BlockNumber(maxBlockNumber)
+	if filter.FromBlock != nil {
+		fromBlock = *filter.FromBlock
+	}
+	if filter.ToBlock != nil {
+		toBlock = *filter.ToBlock
+	}
+	lower, upper := transactionIdsOfBlocks(fromBlock, toBlock)
+	// the transactions of an address are read from its index entries, the transactions of the block range otherwise
+	lowerKey, upperKey := key(prefixTransaction, lower), key(prefixTransaction, upper)
+	if filter.Address != nil {
+		addressId, err := repo.addressId(*filter.Address)
+		if err != nil || addressId.IsZero() {
+			return nil, nil, 0, err
+		}
+		lowerKey, upperKey = key(prefixTransactionByAddress, idBytes(addressId), lower), key(prefixTransactionByAddress, idBytes(addressId), upper)
+	}
+	page := pager{pagination: &pagination}
+	timestamps := blockTimestamps{}
+	err = repo.scan(lowerKey, upperKey, true, func(scannedKey, value []byte) (bool, error) {
+		transactionId := keyTransactionId(scannedKey, len(scannedKey)-8)
+		transaction := new(storage.Transaction)
+		if filter.Address == nil {
+			if err := decodeRecord(scannedKey, value, transaction); err != nil {
+				return false, err
+			}
+		} else {
+			if !matchesDirection(value[0], filter.Direction) {
+				return true, nil
+			}
+			found, err := repo.getRecord(key(prefixTransaction, idBytes(transactionId)), transaction)
+			if err == nil && !found {
+				err = errMissingReference("transaction %v", transactionId)
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		matches, err := repo.matchesTransactionFilter(&filter, transactionId, transaction, timestamps)
+		if err != nil {
+			return false, err
+		}
+		if matches && page.next() {
+			transactions = append(transactions, transaction)
+			transactionIds = append(transactionIds, transactionId)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return transactions, transactionIds, page.found, nil
+}
+
+// matchesTransactionFilter reports whether the transaction matches the filter, except for its address and block range,
+// which are matched by the range of keys read.
+func (repo *PebbleRepository) matchesTransactionFilter(filter *storage.TransactionFilter, transactionId storage.TransactionId, transaction *storage.Transaction, timestamps blockTimestamps) (bool, error) {
+	if filter.OnlyContractCreations && transaction.ToAddressId != nil {
+		return false, nil
+	}
+	if filter.MethodSelector != nil && (len(transaction.Input) < len(filter.MethodSelector) || !bytes.Equal(transaction.Input[:len(filter.MethodSelector)], filter.MethodSelector[:])) {
+		return false, nil
+	}
+	if filter.MinValue != nil && transaction.Value.Cmp(filter.MinValue) < 0 {
+		return false, nil
+	}
+	if filter.FromTimestamp != nil || filter.ToTimestamp != nil {
+		timestamp, err := repo.timestamp(timestamps, transaction.BlockNumber)
+		if err != nil {
+			return false, err
+		}
+		if filter.FromTimestamp != nil && timestamp < *filter.FromTimestamp || filter.ToTimestamp != nil && timestamp > *filter.ToTimestamp {
+			return false, nil
+		}
+	}
+	if filter.Status != nil {
+		var receipt storage.Receipt
+		found, err := repo.getRecord(key(prefixReceipt, idBytes(transactionId)), &receipt)
+		if !found || err != nil {
+			return false, err
+		}
+		return receipt.Status == *filter.Status, nil
+	}
+	return true, nil
+}
+func (repo *PebbleRepository) ListStorageKeysByTransactionId(transactionId storage.TransactionId) (storageKeys []*storage.StorageKey, err error) {
+	defer annotate(&err, "failed to list storage keys of transaction %v", transactionId)
+	err = repo.scanPrefix(key(prefixStorageKey, idBytes(transactionId)), false, func(key, _ []byte) (bool, error) {
+		storageKeys = append(storageKeys, &storage.StorageKey{
+			TransactionId: transactionId,
+			AddressId:     keyAddressId(key, 9),
+			StorageKey:    new(big.Int).SetBytes(key[17:]),
+		})
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storageKeys, nil
+}
+func (repo *PebbleRepository) ListLogsByTransactionId(transactionId storage.TransactionId) (logs []*storage.Log, err error) {
+	defer annotate(&err, "failed to list logs of transaction %v", transactionId)
+	prefix := key(prefixLog, idBytes(transactionId))
+	err = listRecords(repo, prefix, upperBound(prefix), true, &pager{}, true, func(_ []byte, log *storage.Log) {
+		logs = append(logs, log)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+func (repo *PebbleRepository) ListLogsByFilter(filter storage.LogFilter, pagination *storage.OffsetPagination) (logs []*storage.Log, err error) {
+	defer annotate(&err, "failed to list logs of blocks between %d and %d by filter", filter.FromBlock, filter.ToBlock)
+	if filter.FromBlock > filter.ToBlock {
+		return nil, fmt.Errorf("%w: from block is after to block", storage.ErrConstraint)
+	}
+	if len(filter.Topics) > 4 {
+		return nil, fmt.Errorf("%w: logs have at most 4 topics", storage.ErrConstraint)
+	}
+	page := pager{pagination: pagination}
+	appendLog := func(_ []byte, log *storage.Log) {
+		logs = append(logs, log)
+	}
+	matcher, err := repo.newLogMatcher(&filter)
+	if matcher == nil || err != nil {
+		return nil, err
+	}
+	if matcher.matchesAll() {
+		lower, upper := transactionIdsOfBlocks(filter.FromBlock, filter.ToBlock)
+		if err = listRecords(repo, key(prefixLog, lower), key(prefixLog, upper), false, &page, false, appendLog); err != nil {
+			return nil, err
+		}
+		return logs, nil
+	}
+	if page.done() {
+		return nil, nil
+	}
+	// the logs of the blocks whose bloom filters do not match are not read
+	err = repo.scan(key(prefixBlock, uint64Bytes(filter.FromBlock)), upperBound(key(prefixBlock, uint64Bytes(filter.ToBlock))), false, func(blockKey, value []byte) (bool, error) {
+		var block storage.Block
+		if err := decodeRecord(blockKey, value, &block); err != nil {
+			return false, err
+		}
+		if !matcher.matchesBloom(block.LogsBloom) {
+			return true, nil
+		}
+		lower, upper := transactionIdsOfBlocks(block.Number, block.Number)
+		err := repo.scan(key(prefixLog, lower), key(prefixLog, upper), false, func(logKey, value []byte) (bool, error) {
+			var log storage.Log
+			if err := decodeRecord(logKey, value, &log); err != nil {
+				return false, err
+			}
+			if matcher.matches(&log) && page.next() {
+				logs = append(logs, &log)
+			}
+			return !page.done(), nil
+		})
+		return err == nil && !page.done(), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// logMatcher matches the logs of a filter, by the bloom filters of their blocks first.
+type logMatcher struct {
+	filter     *storage.LogFilter
+	addressIds map[storage.AddressId]bool
+	topic0Ids  map[storage.Topic0Id]bool
+}
+
+// newLogMatcher returns the matcher of the filter, or nil if no logs can match it as its addresses or first topics are not stored.
+func (repo *PebbleRepository) newLogMatcher(filter *storage.LogFilter) (*logMatcher, error) {
+	matcher := &logMatcher{filter: filter}
+	if len(filter.Addresses) != 0 {
+		matcher.addressIds = map[storage.AddressId]bool{}
+		for _, address := range filter.Addresses {
+			addressId, err := repo.addressId(address)
+			if err != nil {
+				return nil, err
+			}
+			if !addressId.IsZero() {
+				matcher.addressIds[addressId] = true
+			}
+		}
+		if len(matcher.addressIds) == 0 {
+			return nil, nil
+		}
+	}
+	if len(filter.Topics) != 0 && len(filter.Topics[0]) != 0 {
+		matcher.topic0Ids = map[storage.Topic0Id]bool{}
+		for _, topic := range filter.Topics[0] {
+			eventTypeId, err := repo.getUint64(key(prefixEventTypeByHash, topic.Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			if eventTypeId != 0 {
+				matcher.topic0Ids[storage.NewTopic0Id(int64(eventTypeId))] = true
+			}
+		}
+		if len(matcher.topic0Ids) == 0 {
+			return nil, nil
+		}
+	}
+	return matcher, nil
+}
+
+// matchesAll reports whether the filter matches all logs of its blocks.
+func (matcher *logMatcher) matchesAll() bool {
+	if len(matcher.filter.Addresses) != 0 {
+		return false
+	}
+	for _, topics := range matcher.filter.Topics {
+		if len(topics) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesBloom reports whether the bloom filter of a block may hold logs matching the filter.
+func (matcher *logMatcher) matchesBloom(bloom types.Bloom) bool {
+	if len(matcher.filter.Addresses) != 0 && !bloomContainsAny(bloom, matcher.filter.Addresses) {
+		return false
+	}
+	for _, topics := range matcher.filter.Topics {
+		if len(topics) != 0 && !bloomContainsAny(bloom, topics) {
+			return false
+		}
+	}
+	return true
+}
+func bloomContainsAny[Value interface{ Bytes() []byte }](bloom types.Bloom, values []Value) bool {
+	for _, value := range values {
+		if types.BloomLookup(bloom, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the log matches the filter, except for its block range.
+func (matcher *logMatcher) matches(log *storage.Log) bool {
+	if matcher.addressIds != nil && !matcher.addressIds[log.AddressId] {
+		return false
+	}
+	if matcher.topic0Ids != nil && (log.Topic0Id == nil || !matcher.topic0Ids[*log.Topic0Id]) {
+		return false
+	}
+	for position, topic := range []*[32]byte{log.Topic1, log.Topic2, log.Topic3} {
+		if position+1 >= len(matcher.filter.Topics) {
+			break
+		}
+		topics := matcher.filter.Topics[position+1]
+		if len(topics) == 0 {
+			continue
+		}
+		if topic == nil {
+			return false
+		}
+		matched := false
+		for _, hash := range topics {
+			if hash == *topic {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+func (repo *PebbleRepository) ListErc20TokenTransfers(token, fromOrToFilter *common.Address, pagination *storage.OffsetPagination) (transfers []*storage.Erc20TokenTransfer, totalRecordsFound uint64, err error) {
+	defer annotate(&err, "failed to list ERC-20 token transfers of token %v and address %v", token, fromOrToFilter)
+	var tokenAddressId, addressId storage.AddressId
+	if token != nil {
+		if tokenAddressId, err = repo.addressId(*token); err != nil || tokenAddressId.IsZero() {
+			return nil, 0, err
+		}
+	}
+	if fromOrToFilter != nil {
+		if addressId, err = repo.addressId(*fromOrToFilter); err != nil || addressId.IsZero() {
+			return nil, 0, err
+		}
+	}
+	// the transfers are read from the index entries of the address or of the token, all transfers are read otherwise
+	prefix := []byte{prefixErc20TokenTransfer}
+	switch {
+	case fromOrToFilter != nil:
+		prefix = key(prefixErc20TokenTransferByAddress, idBytes(addressId))
+	case token != nil:
+		prefix = key(prefixErc20TokenTransferByToken, idBytes(tokenAddressId))
+	}
+	// without pagination, only the transfers are counted
+	page := pager{pagination: pagination}
+	if pagination == nil {
+		page.pagination = &storage.OffsetPagination{}
+	}
+	err = repo.scanPrefix(prefix, true, func(scannedKey, value []byte) (bool, error) {
+		var transfer *storage.Erc20TokenTransfer
+		load := func() error {
+			transfer = new(storage.Erc20TokenTransfer)
+			if len(prefix) == 1 {
+				return decodeRecord(scannedKey, value, transfer)
+			}
+			transferKey := key(prefixErc20TokenTransfer, scannedKey[len(prefix):])
+			found, err := repo.getRecord(transferKey, transfer)
+			if err == nil && !found {
+				err = errMissingReference("ERC-20 token transfer %x", transferKey)
+			}
+			return err
+		}
+		if token != nil && fromOrToFilter != nil {
+			if err := load(); err != nil {
+				return false, err
+			}
+			if transfer.TokenAddressId != tokenAddressId {
+				return true, nil
+			}
+		}
+		if page.next() {
+			if transfer == nil {
+				if err := load(); err != nil {
+					return false, err
+				}
+			}
+			transfers = append(transfers, transfer)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return transfers, page.found, nil
+}
+
+// listTraces lists the traces of the range in reverse order, along with the numbers and the timestamps of their blocks.
+// The range is of traces, or of address index entries if byAddress is set, the ones not matching direction being skipped.
+// The scan stops at the end of the page, unless countAll is set.
+func (repo *PebbleRepository) listTraces(lower, upper []byte, byAddress bool, direction storage.Direction, onlyWithValue bool, page *pager, countAll bool) (traces []*storage.TraceAction, blockNumbers, timestamps []uint64, err error) {
+	if page.done() && !countAll {
+		return nil, nil, nil, nil
+	}
+	blocks := blockTimestamps{}
+	err = repo.scan(lower, upper, true, func(scannedKey, value []byte) (bool, error) {
+		if byAddress && !matchesDirection(value[0], direction) {
+			return true, nil
+		}
+		var trace *storage.TraceAction
+		load := func() error {
+			trace = new(storage.TraceAction)
+			if !byAddress {
+				return decodeRecord(scannedKey, value, trace)
+			}
+			traceKey := key(prefixTrace, scannedKey[9:])
+			found, err := repo.getRecord(traceKey, trace)
+			if err == nil && !found {
+				err = errMissingReference("trace %x", traceKey)
+			}
+			return err
+		}
+		if onlyWithValue {
+			if err := load(); err != nil {
+				return false, err
+			}
+			if trace.Value.Sign() <= 0 {
+				return true, nil
+			}
+		}
+		if page.next() {
+			if trace == nil {
+				if err := load(); err != nil {
+					return false, err
+				}
+			}
+			blockNumber := blockNumberOfTransaction(trace.TransactionId)
+			timestamp, err := repo.timestamp(blocks, blockNumber)
+			if err != nil {
+				return false, err
+			}
+			traces = append(traces, trace)
+			blockNumbers = append(blockNumbers, blockNumber)
+			timestamps = append(timestamps, timestamp)
+		}
+		return countAll || !page.done(), nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return traces, blockNumbers, timestamps, nil
+}
+func (repo *PebbleRepository) ListTraces(pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ []uint64, _ []uint64, _ uint64, err error) {
+	defer annotate(&err, "failed to list traces")
+	traces, blockNumbers, timestamps, err := repo.listTraces([]byte{prefixTrace}, upperBound([]byte{prefixTrace}), false, storage.DirectionAny, false, &pager{pagination: pagination}, false)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	totalRecordsFound, err := repo.counter(counterTraces)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	return traces, blockNumbers, timestamps, totalRecordsFound, nil
+}
+func (repo *PebbleRepository) ListTracesByTransactionHash(transactionHash *common.Hash) (traces []*storage.TraceAction, blockNumber, timestamp uint64, err error) {
+	defer annotate(&err, "failed to list traces of transaction %v", transactionHash)
+	transactionId, err := repo.get(key(prefixTransactionByHash, transactionHash.Bytes()))
+	if transactionId == nil || err != nil {
+		return nil, 0, 0, err
+	}
+	prefix := key(prefixTrace, transactionId)
+	traces, blockNumbers, timestamps, err := repo.listTraces(prefix, upperBound(prefix), false, storage.DirectionAny, false, &pager{}, true)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(traces) != 0 {
+		blockNumber = blockNumbers[0]
+		timestamp = timestamps[0]
+	}
+	return traces, blockNumber, timestamp, nil
+}
+func (repo *PebbleRepository) ListTracesByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (_ []*storage.TraceAction, timestamp uint64, _ uint64, err error) {
+	defer annotate(&err, "failed to list traces of block %d", blockNumber)
+	lower, upper := transactionIdsOfBlocks(blockNumber, blockNumber)
+	page := pager{pagination: pagination}
+	traces, _, timestamps, err := repo.listTraces(key(prefixTrace, lower), key(prefixTrace, upper), false, storage.DirectionAny, false, &page, true)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(timestamps) != 0 {
+		timestamp = timestamps[0]
+	}
+	return traces, timestamp, page.found, nil
+}
+func (repo *PebbleRepository) ListTracesByAddress(address common.Address, direction storage.Direction, onlyWithValue bool, pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ []uint64, _ []uint64, _ uint64, err error) {
+	defer annotate(&err, "failed to list traces of address %v", address)
+	addressId, err := repo.addressId(address)
+	if err != nil || addressId.IsZero() {
+		return nil, nil, nil, 0, err
+	}
+	prefix := key(prefixTraceByAddress, idBytes(addressId))
+	page := pager{pagination: pagination}
+	traces, blockNumbers, timestamps, err := repo.listTraces(prefix, upperBound(prefix), true, direction, onlyWithValue, &page, true)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	return traces, blockNumbers, timestamps, page.found, nil
+}
+func (repo *PebbleRepository) ListErc20TokenBalancesAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (erc20TokenBalances []*storage.Erc20TokenBalance, err error) {
+	defer annotate(&err, "failed to list ERC-20 token balances of address %v at block %d", addressId, blockNumber)
+	// the balances of a token follow each other in block order, the last one up to the block being kept
+	err = repo.scanPrefix(key(prefixErc20TokenBalance, idBytes(addressId)), false, func(key, balance []byte) (bool, error) {
+		tokenAddressId, balanceBlockNumber := keyAddressId(key, 9), keyUint64(key, 17)
+		if balanceBlockNumber > blockNumber {
+			return true, nil
+		}
+		erc20TokenBalance := &storage.Erc20TokenBalance{
+			BlockNumber:    balanceBlockNumber,
+			AddressId:      addressId,
+			TokenAddressId: tokenAddressId,
+			Balance:        new(big.Int).SetBytes(balance),
+		}
+		if last := len(erc20TokenBalances) - 1; last >= 0 && erc20TokenBalances[last].TokenAddressId == tokenAddressId {
+			erc20TokenBalances[last] = erc20TokenBalance
+		} else {
+			erc20TokenBalances = append(erc20TokenBalances, erc20TokenBalance)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return erc20TokenBalances, nil
+}
+
+// latestErc20TokenBalances returns the latest balance of each holder of the token, optionally at the given block,
+// except for the holders whose latest balance is zero.
+func (repo *PebbleRepository) latestErc20TokenBalances(tokenAddressId storage.AddressId, atBlock *storage.BlockNumber) ([]*storage.Erc20TokenBalance, error) {
+	var balances []*storage.Erc20TokenBalance
+	var latest *storage.Erc20TokenBalance
+	keepLatest := func() {
+		if latest != nil && latest.Balance.Sign() != 0 {
+			balances = append(balances, latest)
+		}
+	}
+	err := repo.scanPrefix(key(prefixErc20TokenBalanceByToken, idBytes(tokenAddressId)), false, func(key, balance []byte) (bool, error) {
+		holderAddressId, blockNumber := keyAddressId(key, 9), keyUint64(key, 17)
+		if atBlock != nil && blockNumber > *atBlock {
+			return true, nil
+		}
+		if latest != nil && latest.AddressId != holderAddressId {
+			keepLatest()
+		}
+		latest = &storage.Erc20TokenBalance{
+			BlockNumber:    blockNumber,
+			AddressId:      holderAddressId,
+			TokenAddressId: tokenAddressId,
+			Balance:        new(big.Int).SetBytes(balance),
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keepLatest()
+	return balances, nil
+}
+func (repo *PebbleRepository) ListErc20TokenHolders(tokenAddressId storage.Erc20TokenId, atBlock storage.BlockNumber, pagination storage.OffsetPagination) (holders []*storage.Erc20TokenHolder, totalRecordsFound uint64, err error) {
+	defer annotate(&err, "failed to list holders of token %v at block %d", tokenAddressId, atBlock)
+	balances, err := repo.latestErc20TokenBalances(tokenAddressId, &atBlock)
+	if err != nil || pagination.Limit == 0 {
+		return nil, uint64(len(balances)), err
+	}
+	token, err := repo.GetErc20TokenByAddressId(tokenAddressId)
+	if err != nil {
+		return nil, 0, err
+	}
+	var totalSupply *big.Float
+	if token != nil && token.TotalSupply != nil && token.TotalSupply.Sign() != 0 {
+		totalSupply = new(big.Float).SetInt(token.TotalSupply)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		if order := balances[i].Balance.Cmp(balances[j].Balance); order != 0 {
+			return order > 0
+		}
+		return balances[i].AddressId.Int64() < balances[j].AddressId.Int64()
+	})
+	page := pager{pagination: &pagination}
+	for _, balance := range balances {
+		if !page.next() {
+			continue
+		}
+		holder := &storage.Erc20TokenHolder{Erc20TokenBalance: *balance}
+		if totalSupply != nil {
+			percentage, _ := new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Mul(holder.Balance, big.NewInt(100))), totalSupply).Float64()
+			holder.PercentageOfSupply = &percentage
+		}
+		holders = append(holders, holder)
+	}
+	return holders, page.found, nil
+}
+func (repo *PebbleRepository) ListStateChangesByTransactionHash(transactionHash *common.Hash) (stateChanges []*storage.StateChange, err error) {
+	defer annotate(&err, "failed to list state changes of transaction %v", transactionHash)
+	transactionId, err := repo.get(key(prefixTransactionByHash, transactionHash.Bytes()))
+	if transactionId == nil || err != nil {
+		return nil, err
+	}
+	prefix := key(prefixStateChange, transactionId)
+	err = listRecords(repo, prefix, upperBound(prefix), false, &pager{}, true, func(_ []byte, stateChange *storage.StateChange) {
+		stateChanges = append(stateChanges, stateChange)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, stateChange := range stateChanges {
+		prefix := key(prefixStorageChange, transactionId, idBytes(stateChange.AddressId))
+		err = listRecords(repo, prefix, upperBound(prefix), false, &pager{}, true, func(_ []byte, storageChange *storage.StorageChange) {
+			stateChange.StorageChanges = append(stateChange.StorageChanges, storageChange)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stateChanges, nil
+}
+func (repo *PebbleRepository) ListMissingBlockRanges(from, to storage.BlockNumber) (missingRanges []*storage.BlockRange, err error) {
+	defer annotate(&err, "failed to list missing blocks between %d and %d", from, to)
+	if from > to {
+		return nil, nil
+	}
+	// next is the number following the last stored block, reaching to ends the scan before next overflows
+	next, reachedTo := from, false
+	err = repo.scan(key(prefixBlock, uint64Bytes(from)), upperBound(key(prefixBlock, uint64Bytes(to))), false, func(key, _ []byte) (bool, error) {
+		number := keyUint64(key, 1)
+		if number > next {
+			missingRanges = append(missingRanges, &storage.BlockRange{From: next, To: number - 1})
+		}
+		if number == to {
+			reachedTo = true
+			return false, nil
+		}
+		next = number + 1
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !reachedTo {
+		missingRanges = append(missingRanges, &storage.BlockRange{From: next, To: to})
+	}
+	return missingRanges, nil
+}
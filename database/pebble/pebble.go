@@ -0,0 +1,220 @@
+// Package pebble implements storage.Storage on an embedded Pebble key-value store, for read-heavy explorer nodes
+// serving immutable historical data, which is read through keys laid out for the Reader methods, see keys.go.
+// The store is a directory opened by a single process.
+package pebble
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	pebbledb "github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// env_PEBBLE_PATH is the directory of the store, ":memory:" for a store living in memory.
+const env_PEBBLE_PATH = "PEBBLE_PATH"
+
+// Names of the sequences generating ids, and of the counters of the records listed with their total number.
+const (
+	sequenceAddresses  = "addresses"
+	sequenceEventTypes = "eventTypes"
+	sequenceBytecodes  = "bytecodes"
+
+	counterBlocks       = "blocks"
+	counterTransactions = "transactions"
+	counterTraces       = "traces"
+)
+
+type PebbleRepository struct {
+	db *pebbledb.DB
+	// batch holds the writes since the last commit, reads see them as it is indexed.
+	batch *pebbledb.Batch
+
+	// path is empty if the store is configured by the environment, see NewPebbleRepository.
+	path string
+}
+
+// NewPebbleRepository returns a repository of the store in the directory, ":memory:" for a store living in memory,
+// instead of the one of PEBBLE_PATH.
+func NewPebbleRepository(path string) *PebbleRepository {
+	return &PebbleRepository{path: path}
+}
+
+func (repo *PebbleRepository) Load() (err error) {
+	path := repo.path
+	if path == "" {
+		path = os.Getenv(env_PEBBLE_PATH)
+	}
+	defer annotate(&err, "failed to load store %s", path)
+	if strings.TrimSpace(path) == "" {
+		return fmt.Errorf("%s is not set", env_PEBBLE_PATH)
+	}
+	options := &pebbledb.Options{}
+	if path == ":memory:" {
+		options.FS = vfs.NewMem()
+		path = ""
+	}
+	if repo.db, err = pebbledb.Open(path, options); err != nil {
+		return err
+	}
+	repo.batch = repo.db.NewIndexedBatch()
+	return nil
+}
+func (repo *PebbleRepository) Commit(ctx context.Context) (err error) {
+	defer annotate(&err, "failed to commit")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	// the next batch is used even if committing failed, so the repository stays usable
+	batch := repo.batch
+	repo.batch = repo.db.NewIndexedBatch()
+	return errors.Join(batch.Commit(pebbledb.Sync), batch.Close())
+}
+
+// get returns a copy of the value stored with the key, or nil if there is none.
+func (repo *PebbleRepository) get(key []byte) ([]byte, error) {
+	value, closer, err := repo.batch.Get(key)
+	if errors.Is(err, pebbledb.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte{}, value...), nil
+}
+
+// getRecord decodes the record stored with the key into record, and reports whether it was found.
+func (repo *PebbleRepository) getRecord(key []byte, record any) (bool, error) {
+	value, err := repo.get(key)
+	if value == nil || err != nil {
+		return false, err
+	}
+	return true, decodeRecord(key, value, record)
+}
+
+// decodeRecord decodes the value of the record stored with the key into record.
+func decodeRecord(key, value []byte, record any) error {
+	if err := json.Unmarshal(value, record); err != nil {
+		return fmt.Errorf("invalid record %x: %w", key, err)
+	}
+	return nil
+}
+
+// has reports whether a value is stored with the key.
+func (repo *PebbleRepository) has(key []byte) (bool, error) {
+	value, err := repo.get(key)
+	return value != nil, err
+}
+
+func (repo *PebbleRepository) set(key, value []byte) error {
+	return repo.batch.Set(key, value, nil)
+}
+func (repo *PebbleRepository) setRecord(key []byte, record any) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return repo.set(key, value)
+}
+func (repo *PebbleRepository) delete(key []byte) error {
+	return repo.batch.Delete(key, nil)
+}
+
+// scan calls visit with the keys and values between lower and upper, the upper bound excluded,
+// in ascending order, or in descending order if reverse is set, until visit returns false.
+// The key and the value are only valid until visit returns.
+func (repo *PebbleRepository) scan(lower, upper []byte, reverse bool, visit func(key, value []byte) (bool, error)) (err error) {
+	iterator := repo.batch.NewIter(&pebbledb.IterOptions{LowerBound: lower, UpperBound: upper})
+	defer func() {
+		err = errors.Join(err, iterator.Close())
+	}()
+	valid, step := iterator.First, iterator.Next
+	if reverse {
+		valid, step = iterator.Last, iterator.Prev
+	}
+	for ok := valid(); ok; ok = step() {
+		more, err := visit(iterator.Key(), iterator.Value())
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanPrefix scans the keys starting with the prefix, see scan.
+func (repo *PebbleRepository) scanPrefix(prefix []byte, reverse bool, visit func(key, value []byte) (bool, error)) error {
+	return repo.scan(prefix, upperBound(prefix), reverse, visit)
+}
+
+// deleteRange deletes the keys between lower and upper, the upper bound excluded, and returns their number.
+// The keys returned by visit for each deleted key are deleted too, like the index entries of the deleted records,
+// visit can be nil.
+func (repo *PebbleRepository) deleteRange(lower, upper []byte, visit func(key, value []byte) ([][]byte, error)) (int, error) {
+	var keys [][]byte
+	deleted := 0
+	err := repo.scan(lower, upper, false, func(key, value []byte) (bool, error) {
+		if visit != nil {
+			referencingKeys, err := visit(key, value)
+			if err != nil {
+				return false, err
+			}
+			keys = append(keys, referencingKeys...)
+		}
+		keys = append(keys, append([]byte(nil), key...))
+		deleted++
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// the keys are deleted after the scan, as the iterator must not see the batch changing
+	for _, key := range keys {
+		if err = repo.delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return deleted, nil
+}
+
+// deletePrefix deletes the keys starting with the prefix, see deleteRange.
+func (repo *PebbleRepository) deletePrefix(prefix []byte, visit func(key, value []byte) ([][]byte, error)) (int, error) {
+	return repo.deleteRange(prefix, upperBound(prefix), visit)
+}
+
+// getUint64 returns the number stored with the key, 0 if there is none.
+func (repo *PebbleRepository) getUint64(key []byte) (uint64, error) {
+	value, err := repo.get(key)
+	if value == nil || err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// sequence generates the next id of the sequence, starting with 1 as the zero id is not valid.
+func (repo *PebbleRepository) sequence(name string) (int64, error) {
+	last, err := repo.getUint64(key(prefixSequence, []byte(name)))
+	if err != nil {
+		return 0, err
+	}
+	return int64(last + 1), repo.set(key(prefixSequence, []byte(name)), uint64Bytes(last+1))
+}
+
+// counter returns the number of stored records counted by the counter.
+func (repo *PebbleRepository) counter(name string) (uint64, error) {
+	return repo.getUint64(key(prefixSequence, []byte("count:"+name)))
+}
+
+// count adds delta to the counter.
+func (repo *PebbleRepository) count(name string, delta int64) error {
+	count, err := repo.counter(name)
+	if err != nil {
+		return err
+	}
+	return repo.set(key(prefixSequence, []byte("count:"+name)), uint64Bytes(uint64(int64(count)+delta)))
+}
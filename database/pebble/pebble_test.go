@@ -0,0 +1,28 @@
+package pebble
+
+import (
+	"testing"
+
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/storagetest"
+)
+
+// newTestRepository returns a loaded repository of an in-memory store, closed at the end of the test.
+func newTestRepository(t *testing.T) *PebbleRepository {
+	t.Helper()
+	repo := NewPebbleRepository(":memory:")
+	if err := repo.Load(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = repo.batch.Close()
+		_ = repo.db.Close()
+	})
+	return repo
+}
+
+func TestPebbleRepository(t *testing.T) {
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		return newTestRepository(t)
+	})
+}
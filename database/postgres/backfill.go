@@ -0,0 +1,226 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// backfillStatementBuilder runs the statements of the BackfillTracker methods outside of the repository's transaction,
+// so they are committed right away.
+func (repo *PostgresRepository) backfillStatementBuilder() sq.StatementBuilderType {
+	return sq.StatementBuilder.RunWith(repo.conn).PlaceholderFormat(sq.Dollar)
+}
+func scanBackfillRange(rows sq.RowScanner) (*storage.BackfillRange, error) {
+	var backfillRange storage.BackfillRange
+	var claimedBy, backfillError sql.NullString
+	var claimedAt sql.NullTime
+	err := rows.Scan(&backfillRange.From,
+		&backfillRange.To,
+		&backfillRange.State,
+		&claimedBy,
+		&claimedAt,
+		&backfillRange.Attempts,
+		&backfillError)
+	if err != nil {
+		return nil, err
+	}
+	backfillRange.ClaimedBy = claimedBy.String
+	backfillRange.Error = backfillError.String
+	if claimedAt.Valid {
+		backfillRange.ClaimedAt = &claimedAt.Time
+	}
+	return &backfillRange, nil
+}
+func (repo *PostgresRepository) PlanBackfill(ctx context.Context, from, to storage.BlockNumber, size uint64) (_ []*storage.BackfillRange, err error) {
+	defer annotate(&err, "failed to plan backfill of blocks between %d and %d", from, to)
+	if size == 0 {
+		return nil, fmt.Errorf("range size must be positive")
+	}
+	missingRanges, err := repo.ListMissingBlockRanges(from, to)
+	if err != nil || len(missingRanges) == 0 {
+		return nil, err
+	}
+	tx, err := repo.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// concurrent planners would register overlapping ranges
+	if _, err = tx.ExecContext(ctx, "LOCK TABLE "+tableNameBackfillRanges+" IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return nil, err
+	}
+	statementBuilder := sq.StatementBuilder.RunWith(tx).PlaceholderFormat(sq.Dollar)
+	rows, err := statementBuilder.
+		Select("from_block", "to_block").
+		From(tableNameBackfillRanges).
+		Where("to_block >= ? AND from_block <= ?", from, to).
+		OrderBy("from_block").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var registeredRanges []storage.BlockRange
+	for rows.Next() {
+		var registeredRange storage.BlockRange
+		if err = rows.Scan(&registeredRange.From, &registeredRange.To); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		registeredRanges = append(registeredRanges, registeredRange)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	var plannedRanges []*storage.BackfillRange
+	for _, missingRange := range missingRanges {
+		for _, unregisteredRange := range subtractBlockRanges(*missingRange, registeredRanges) {
+			for start := unregisteredRange.From; start <= unregisteredRange.To; start += size {
+				end := unregisteredRange.To
+				if unregisteredRange.To-start >= size {
+					end = start + size - 1
+				}
+				plannedRanges = append(plannedRanges, &storage.BackfillRange{
+					BlockRange: storage.BlockRange{From: start, To: end},
+					State:      storage.BackfillStatePending,
+				})
+				if end == unregisteredRange.To {
+					break
+				}
+			}
+		}
+	}
+	for _, plannedRange := range plannedRanges {
+		_, err = statementBuilder.
+			Insert(tableNameBackfillRanges).
+			Columns("from_block", "to_block", "state").
+			Values(plannedRange.From, plannedRange.To, plannedRange.State).
+			ExecContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("range of blocks %d to %d: %w", plannedRange.From, plannedRange.To, mapError(err))
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return plannedRanges, nil
+}
+
+// subtractBlockRanges returns the parts of blockRange not covered by the subtracted ranges, which are sorted.
+func subtractBlockRanges(blockRange storage.BlockRange, subtracted []storage.BlockRange) []storage.BlockRange {
+	var remaining []storage.BlockRange
+	next := blockRange.From
+	for _, subtractedRange := range subtracted {
+		if subtractedRange.To < next || subtractedRange.From > blockRange.To {
+			continue
+		}
+		if subtractedRange.From > next {
+			remaining = append(remaining, storage.BlockRange{From: next, To: subtractedRange.From - 1})
+		}
+		if subtractedRange.To >= blockRange.To {
+			return remaining
+		}
+		next = subtractedRange.To + 1
+	}
+	return append(remaining, storage.BlockRange{From: next, To: blockRange.To})
+}
+func (repo *PostgresRepository) ClaimBackfillRange(ctx context.Context, worker string, lease time.Duration) (_ *storage.BackfillRange, err error) {
+	defer annotate(&err, "failed to claim a backfill range for worker %s", worker)
+	// ranges being claimed by other workers are skipped instead of waited for
+	row := repo.conn.QueryRowContext(ctx, fmt.Sprintf(`UPDATE %[1]s
+		SET "state" = $1, "claimed_by" = $2, "claimed_at" = now(), "attempts" = "attempts" + 1
+		WHERE "from_block" = (
+			SELECT "from_block" FROM %[1]s
+			WHERE "state" = $3 OR ("state" = $1 AND "claimed_at" < now() - make_interval(secs => $4))
+			ORDER BY "from_block"
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING %[2]s`, tableNameBackfillRanges, strings.Join(tableColumnsBackfillRanges, ", ")),
+		storage.BackfillStateClaimed, worker, storage.BackfillStatePending, lease.Seconds())
+	backfillRange, err := scanBackfillRange(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return backfillRange, err
+}
+func (repo *PostgresRepository) CompleteBackfillRange(ctx context.Context, from storage.BlockNumber, worker string) (err error) {
+	defer annotate(&err, "failed to complete backfill range starting at block %d", from)
+	return repo.finishBackfillRange(ctx, from, worker, sq.Eq{"state": storage.BackfillStateCompleted, "error": nil})
+}
+func (repo *PostgresRepository) FailBackfillRange(ctx context.Context, from storage.BlockNumber, worker string, cause error) (err error) {
+	defer annotate(&err, "failed to fail backfill range starting at block %d", from)
+	return repo.finishBackfillRange(ctx, from, worker, sq.Eq{"state": storage.BackfillStateFailed, "error": cause.Error()})
+}
+
+// finishBackfillRange sets the columns of the range, if it is claimed by the worker.
+func (repo *PostgresRepository) finishBackfillRange(ctx context.Context, from storage.BlockNumber, worker string, columns sq.Eq) error {
+	result, err := repo.backfillStatementBuilder().
+		Update(tableNameBackfillRanges).
+		SetMap(columns).
+		Where("from_block = ?", from).
+		Where("state = ?", storage.BackfillStateClaimed).
+		Where("claimed_by = ?", worker).
+		ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected != 0 {
+		return err
+	}
+	var state storage.BackfillState
+	var claimedBy sql.NullString
+	err = repo.backfillStatementBuilder().
+		Select("state", "claimed_by").
+		From(tableNameBackfillRanges).
+		Where("from_block = ?", from).
+		ScanContext(ctx, &state, &claimedBy)
+	if err == sql.ErrNoRows {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: the range is %s by worker %q instead of being claimed by worker %q", storage.ErrConflict, state, claimedBy.String, worker)
+}
+func (repo *PostgresRepository) RetryFailedBackfillRanges(ctx context.Context) (_ uint64, err error) {
+	defer annotate(&err, "failed to retry failed backfill ranges")
+	result, err := repo.backfillStatementBuilder().
+		Update(tableNameBackfillRanges).
+		Set("state", storage.BackfillStatePending).
+		Where("state = ?", storage.BackfillStateFailed).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return uint64(rowsAffected), err
+}
+func (repo *PostgresRepository) ListBackfillRanges(ctx context.Context, state *storage.BackfillState) (backfillRanges []*storage.BackfillRange, err error) {
+	defer annotate(&err, "failed to list backfill ranges")
+	selectBuilder := repo.backfillStatementBuilder().
+		Select(tableColumnsBackfillRanges...).
+		From(tableNameBackfillRanges).
+		OrderBy("from_block")
+	if state != nil {
+		selectBuilder = selectBuilder.Where("state = ?", *state)
+	}
+	rows, err := selectBuilder.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var backfillRange *storage.BackfillRange
+		if backfillRange, err = scanBackfillRange(rows); err != nil {
+			return nil, err
+		}
+		backfillRanges = append(backfillRanges, backfillRange)
+	}
+	return backfillRanges, rows.Err()
+}
@@ -0,0 +1,154 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	storage "github.com/librescan-org/backend-db"
+)
+
+// blockRangesOf returns the block ranges of the backfill ranges.
+func blockRangesOf(backfillRanges []*storage.BackfillRange) []storage.BlockRange {
+	blockRanges := make([]storage.BlockRange, 0, len(backfillRanges))
+	for _, backfillRange := range backfillRanges {
+		blockRanges = append(blockRanges, backfillRange.BlockRange)
+	}
+	return blockRanges
+}
+
+func TestPlanBackfillSkipsStoredAndPlannedBlocks(t *testing.T) {
+	repo := newTestRepository(t, newTestDatabase(t))
+	chain := newTestChain(t, repo)
+	chain.storeBlocks(3, 4, 5)
+	chain.commit()
+	ctx := context.Background()
+
+	planned, err := repo.PlanBackfill(ctx, 1, 20, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []storage.BlockRange{{From: 1, To: 2}, {From: 6, To: 10}, {From: 11, To: 15}, {From: 16, To: 20}}
+	if got := blockRangesOf(planned); !reflect.DeepEqual(got, want) {
+		t.Errorf("planned ranges: got %v, want %v", got, want)
+	}
+	// planning an overlapping range only registers the blocks beyond the planned ones
+	if planned, err = repo.PlanBackfill(ctx, 10, 27, 5); err != nil {
+		t.Fatal(err)
+	}
+	want = []storage.BlockRange{{From: 21, To: 25}, {From: 26, To: 27}}
+	if got := blockRangesOf(planned); !reflect.DeepEqual(got, want) {
+		t.Errorf("ranges planned again: got %v, want %v", got, want)
+	}
+	if planned, err = repo.PlanBackfill(ctx, 1, 27, 5); err != nil || len(planned) != 0 {
+		t.Errorf("planning the planned blocks: got %v, %v, want nothing planned", blockRangesOf(planned), err)
+	}
+	registered, err := repo.ListBackfillRanges(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) != 6 {
+		t.Errorf("registered ranges: got %v, want 6 ranges", blockRangesOf(registered))
+	}
+}
+
+func TestClaimBackfillRangeConcurrently(t *testing.T) {
+	repo := newTestRepository(t, newTestDatabase(t))
+	ctx := context.Background()
+	if _, err := repo.PlanBackfill(ctx, 1, 100, 5); err != nil {
+		t.Fatal(err)
+	}
+	// the workers skip the rows being claimed by the others, so every range is claimed once
+	var mutex sync.Mutex
+	claimedBy := make(map[storage.BlockNumber]string)
+	var workers sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		workers.Add(1)
+		go func(worker string) {
+			defer workers.Done()
+			for {
+				claimed, err := repo.ClaimBackfillRange(ctx, worker, time.Hour)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if claimed == nil {
+					return
+				}
+				mutex.Lock()
+				if other, found := claimedBy[claimed.From]; found {
+					t.Errorf("range starting at block %d is claimed by workers %s and %s", claimed.From, other, worker)
+				}
+				claimedBy[claimed.From] = worker
+				mutex.Unlock()
+				if claimed.ClaimedBy != worker || claimed.State != storage.BackfillStateClaimed || claimed.Attempts != 1 {
+					t.Errorf("range claimed by worker %s: %+v", worker, claimed)
+				}
+			}
+		}(fmt.Sprintf("worker-%d", i))
+	}
+	workers.Wait()
+	if len(claimedBy) != 20 {
+		t.Errorf("claimed %d ranges, want 20", len(claimedBy))
+	}
+	for from, worker := range claimedBy {
+		if err := repo.CompleteBackfillRange(ctx, from, worker); err != nil {
+			t.Error(err)
+		}
+	}
+	completed := storage.BackfillStateCompleted
+	if ranges, err := repo.ListBackfillRanges(ctx, &completed); err != nil {
+		t.Fatal(err)
+	} else if len(ranges) != 20 {
+		t.Errorf("completed %d ranges, want 20", len(ranges))
+	}
+}
+
+func TestClaimBackfillRangeAfterLeaseExpiry(t *testing.T) {
+	repo := newTestRepository(t, newTestDatabase(t))
+	ctx := context.Background()
+	if _, err := repo.PlanBackfill(ctx, 1, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := repo.ClaimBackfillRange(ctx, "dead", time.Hour)
+	if err != nil || claimed == nil {
+		t.Fatalf("claiming the pending range: got %v, %v", claimed, err)
+	}
+	if claimed, err = repo.ClaimBackfillRange(ctx, "alive", time.Hour); err != nil || claimed != nil {
+		t.Fatalf("claiming the range within its lease: got %+v, %v, want nothing claimed", claimed, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if claimed, err = repo.ClaimBackfillRange(ctx, "alive", time.Millisecond); err != nil || claimed == nil {
+		t.Fatalf("claiming the range after its lease expired: got %v, %v", claimed, err)
+	}
+	if claimed.ClaimedBy != "alive" || claimed.Attempts != 2 {
+		t.Errorf("range claimed again: got %+v, want it claimed by the alive worker at the second attempt", claimed)
+	}
+	// the worker whose lease expired can not finish the range any more
+	for name, finish := range map[string]func() error{
+		"complete": func() error { return repo.CompleteBackfillRange(ctx, 1, "dead") },
+		"fail":     func() error { return repo.FailBackfillRange(ctx, 1, "dead", errors.New("too late")) },
+	} {
+		if err = finish(); !errors.Is(err, storage.ErrConflict) {
+			t.Errorf("%s the range claimed by another worker: got %v, want %v", name, err, storage.ErrConflict)
+		}
+	}
+	if err = repo.CompleteBackfillRange(ctx, 11, "alive"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("completing a range which does not exist: got %v, want %v", err, storage.ErrNotFound)
+	}
+	if err = repo.CompleteBackfillRange(ctx, 1, "alive"); err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := repo.ListBackfillRanges(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0].State != storage.BackfillStateCompleted || ranges[0].ClaimedBy != "alive" {
+		t.Errorf("ranges after completion: got %+v", ranges)
+	}
+}
@@ -0,0 +1,265 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// bloomBitsSectionSize is the number of blocks whose logs bloom bits are held by a row of the bloom bits index,
+// which is transposed like go-ethereum's bloombits: a row holds the same bit of the logs blooms of all blocks of a section,
+// so the blocks possibly matching a filter are found by reading a few rows per section.
+const bloomBitsSectionSize = 4096
+
+// bloomBitIndexes returns the indexes of the logs bloom bits set by the address or topic.
+func bloomBitIndexes(data []byte) [3]uint {
+	hash := crypto.Keccak256(data)
+	var indexes [3]uint
+	for i := range indexes {
+		indexes[i] = (uint(hash[2*i])<<8 | uint(hash[2*i+1])) & (types.BloomBitLength - 1)
+	}
+	return indexes
+}
+
+// setBloomBits returns the indexes of the bits set in the logs bloom.
+func setBloomBits(bloom types.Bloom) []int64 {
+	var bits []int64
+	for bit := 0; bit < types.BloomBitLength; bit++ {
+		if bloom[types.BloomByteLength-1-bit/8]&(1<<(bit%8)) != 0 {
+			bits = append(bits, int64(bit))
+		}
+	}
+	return bits
+}
+
+// indexBloomBits sets the bits of the blocks' logs blooms in the bloom bits index.
+func (repo *PostgresRepository) indexBloomBits(blocks []*storage.Block) error {
+	for _, block := range blocks {
+		bits := setBloomBits(block.LogsBloom)
+		if len(bits) == 0 {
+			continue
+		}
+		section, position := block.Number/bloomBitsSectionSize, block.Number%bloomBitsSectionSize
+		_, err := repo.runner.Exec(fmt.Sprintf(`INSERT INTO %[1]s ("section", "bit", "bits")
+			SELECT $1, "bit", set_bit(decode(repeat('00', %[2]d), 'hex'), $2, 1) FROM unnest($3::smallint[]) AS "bit"
+			ON CONFLICT ("section", "bit") DO UPDATE SET "bits" = set_bit(%[1]s."bits", $2, 1)`, tableNameBloomBits, bloomBitsSectionSize/8),
+			section, position, pq.Array(bits))
+		if err != nil {
+			return fmt.Errorf("bloom bits of block %d: %w", block.Number, err)
+		}
+	}
+	return nil
+}
+
+// clearBloomBits clears the bits of the blocks in the bloom bits index, so deleted blocks are not found by log filters.
+func (repo *PostgresRepository) clearBloomBits(blockNumbers []storage.BlockNumber) error {
+	for _, blockNumber := range blockNumbers {
+		section, position := blockNumber/bloomBitsSectionSize, blockNumber%bloomBitsSectionSize
+		_, err := repo.statementBuilder.
+			Update(tableNameBloomBits).
+			Set("bits", sq.Expr("set_bit(bits, ?, 0)", position)).
+			Where("section = ?", section).
+			Where("get_bit(bits, ?) = 1", position).
+			Exec()
+		if err != nil {
+			return fmt.Errorf("bloom bits of block %d: %w", blockNumber, err)
+		}
+	}
+	return nil
+}
+
+// BackfillBloomBits indexes the logs blooms of the blocks stored between from and to, in batches of batchSize blocks.
+// Blocks are indexed as they are stored, so only the blocks stored by earlier versions need to be backfilled,
+// they are skipped by ListLogsByFilter until then. Each batch is committed, progress is called after each, and it can be nil.
+func (repo *PostgresRepository) BackfillBloomBits(ctx context.Context, from, to storage.BlockNumber, batchSize uint64, progress func(lastBlockNumber storage.BlockNumber)) (err error) {
+	defer annotate(&err, "failed to backfill bloom bits of blocks between %d and %d", from, to)
+	if batchSize == 0 {
+		return fmt.Errorf("batch size must be positive")
+	}
+	for start := from; start <= to; start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := to
+		if to-start >= batchSize {
+			end = start + batchSize - 1
+		}
+		rows, err := repo.statementBuilder.
+			Select("number", "logs_bloom").
+			From(tableNameBlocks).
+			Where("number BETWEEN ? AND ?", start, end).
+			Query()
+		if err != nil {
+			return err
+		}
+		var blocks []*storage.Block
+		for rows.Next() {
+			var block storage.Block
+			var logsBloom []byte
+			if err = rows.Scan(&block.Number, &logsBloom); err != nil {
+				rows.Close()
+				return err
+			}
+			block.LogsBloom = types.BytesToBloom(logsBloom)
+			blocks = append(blocks, &block)
+		}
+		if err = rows.Close(); err != nil {
+			return err
+		}
+		if err = repo.indexBloomBits(blocks); err != nil {
+			return err
+		}
+		if err = repo.Commit(ctx); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(end)
+		}
+		if end == to {
+			break
+		}
+	}
+	return nil
+}
+
+// bloomBitsCandidates returns the blocks between from and to whose logs blooms match all groups,
+// a group matching if the bloom has all bits of any of its alternatives.
+func (repo *PostgresRepository) bloomBitsCandidates(from, to storage.BlockNumber, groups [][][3]uint) ([]int64, error) {
+	var bits []int64
+	for _, group := range groups {
+		for _, alternative := range group {
+			for _, bit := range alternative {
+				bits = append(bits, int64(bit))
+			}
+		}
+	}
+	rows, err := repo.statementBuilder.
+		Select(tableColumnsBloomBits...).
+		From(tableNameBloomBits).
+		Where("section BETWEEN ? AND ?", from/bloomBitsSectionSize, to/bloomBitsSectionSize).
+		Where("bit = ANY(?)", pq.Array(bits)).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vectors := make(map[uint64]map[uint][]byte)
+	for rows.Next() {
+		var section uint64
+		var bit uint
+		var vector []byte
+		if err = rows.Scan(&section, &bit, &vector); err != nil {
+			return nil, err
+		}
+		if vectors[section] == nil {
+			vectors[section] = make(map[uint][]byte)
+		}
+		vectors[section][bit] = vector
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var candidates []int64
+	for section, sectionVectors := range vectors {
+		matches := make([]byte, bloomBitsSectionSize/8)
+		for i := range matches {
+			matches[i] = 0xff
+		}
+		for _, group := range groups {
+			groupMatches := make([]byte, len(matches))
+			for _, alternative := range group {
+				for i := range groupMatches {
+					alternativeMatches := byte(0xff)
+					for _, bit := range alternative {
+						if vector := sectionVectors[bit]; len(vector) > i {
+							alternativeMatches &= vector[i]
+						} else {
+							alternativeMatches = 0
+						}
+					}
+					groupMatches[i] |= alternativeMatches
+				}
+			}
+			for i := range matches {
+				matches[i] &= groupMatches[i]
+			}
+		}
+		for position := uint64(0); position < bloomBitsSectionSize; position++ {
+			number := section*bloomBitsSectionSize + position
+			if number >= from && number <= to && matches[position/8]&(1<<(position%8)) != 0 {
+				candidates = append(candidates, int64(number))
+			}
+		}
+	}
+	return candidates, nil
+}
+func (repo *PostgresRepository) ListLogsByFilter(filter storage.LogFilter, pagination *storage.OffsetPagination) (_ []*storage.Log, err error) {
+	defer annotate(&err, "failed to list logs of blocks between %d and %d by filter", filter.FromBlock, filter.ToBlock)
+	if filter.FromBlock > filter.ToBlock {
+		return nil, fmt.Errorf("%w: from block is after to block", storage.ErrConstraint)
+	}
+	if len(filter.Topics) > 4 {
+		return nil, fmt.Errorf("%w: logs have at most 4 topics", storage.ErrConstraint)
+	}
+	selectBuilder := repo.statementBuilder.
+		Select(qualifiedColumns(tableNameLogs, tableColumnsLogs)...).
+		From(tableNameLogs).
+		Join(fmt.Sprintf("%[1]s ON %[1]s.id = %[2]s.transaction_id", tableNameTransactions, tableNameLogs)).
+		Where(tableNameTransactions+".block_id BETWEEN ? AND ?", filter.FromBlock, filter.ToBlock).
+		OrderBy(tableNameLogs+".transaction_id", tableNameLogs+`."index"`)
+	var groups [][][3]uint
+	if len(filter.Addresses) != 0 {
+		var group [][3]uint
+		addresses := make(pq.ByteaArray, 0, len(filter.Addresses))
+		for _, address := range filter.Addresses {
+			group = append(group, bloomBitIndexes(address.Bytes()))
+			addresses = append(addresses, address.Bytes())
+		}
+		groups = append(groups, group)
+		selectBuilder = selectBuilder.Where(fmt.Sprintf("%s.address_id IN (SELECT id FROM %s WHERE hash = ANY(?))", tableNameLogs, tableNameAddresses), addresses)
+	}
+	for position, topics := range filter.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		var group [][3]uint
+		hashes := make(pq.ByteaArray, 0, len(topics))
+		for _, topic := range topics {
+			group = append(group, bloomBitIndexes(topic.Bytes()))
+			hashes = append(hashes, topic.Bytes())
+		}
+		groups = append(groups, group)
+		if position == 0 {
+			selectBuilder = selectBuilder.Where(fmt.Sprintf("%s.topic_0_id IN (SELECT id FROM %s WHERE hash = ANY(?))", tableNameLogs, tableNameEventTypes), hashes)
+		} else {
+			selectBuilder = selectBuilder.Where(fmt.Sprintf("%s.topic_%d = ANY(?)", tableNameLogs, position), hashes)
+		}
+	}
+	// without addresses nor topics, every block having logs matches
+	if len(groups) != 0 {
+		candidates, err := repo.bloomBitsCandidates(filter.FromBlock, filter.ToBlock, groups)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+		selectBuilder = selectBuilder.Where(tableNameTransactions+".block_id = ANY(?)", pq.Array(candidates))
+	}
+	if pagination != nil {
+		selectBuilder = selectBuilder.
+			Limit(uint64(pagination.Limit)).
+			Offset(pagination.Offset)
+	}
+	rows, err := selectBuilder.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanLogs(rows)
+}
@@ -0,0 +1,189 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// bloomTestLog is a log of the bloom bits tests, the first topic being its topic0.
+type bloomTestLog struct {
+	blockNumber storage.BlockNumber
+	address     common.Address
+	topics      []common.Hash
+}
+
+// matches tells whether the log matches the filter, like a full scan of the logs would.
+func (log bloomTestLog) matches(filter storage.LogFilter) bool {
+	if log.blockNumber < filter.FromBlock || log.blockNumber > filter.ToBlock {
+		return false
+	}
+	if len(filter.Addresses) != 0 && !containsHash(addressHashes(filter.Addresses), common.BytesToHash(log.address.Bytes())) {
+		return false
+	}
+	for position, topics := range filter.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		if position >= len(log.topics) || !containsHash(topics, log.topics[position]) {
+			return false
+		}
+	}
+	return true
+}
+func addressHashes(addresses []common.Address) []common.Hash {
+	hashes := make([]common.Hash, 0, len(addresses))
+	for _, address := range addresses {
+		hashes = append(hashes, common.BytesToHash(address.Bytes()))
+	}
+	return hashes
+}
+func containsHash(hashes []common.Hash, hash common.Hash) bool {
+	for _, candidate := range hashes {
+		if candidate == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// storeBloomTestBlock stores the block with a transaction emitting the logs, and the logs bloom of the logs.
+// It returns the ids of the stored logs, in the order of the logs.
+func (chain *testChain) storeBloomTestBlock(number storage.BlockNumber, logs []bloomTestLog) []storage.LogId {
+	chain.t.Helper()
+	block := chain.block(number)
+	for _, log := range logs {
+		block.LogsBloom.Add(log.address.Bytes())
+		for _, topic := range log.topics {
+			block.LogsBloom.Add(topic.Bytes())
+		}
+	}
+	if err := chain.repo.StoreBlock(block); err != nil {
+		chain.t.Fatal(err)
+	}
+	transactionId := chain.storeTransaction(number, 0, chain.miner, chain.miner)
+	logIds := make([]storage.LogId, 0, len(logs))
+	for i, log := range logs {
+		topic0Ids, err := chain.repo.StoreTopic0(&storage.EventType{Hash: log.topics[0]})
+		if err != nil {
+			chain.t.Fatal(err)
+		}
+		stored := &storage.Log{
+			LogId:     storage.LogId{TransactionId: transactionId, LogIndex: storage.LogIndex(i)},
+			AddressId: chain.address(log.address),
+			Topic0Id:  &topic0Ids[0],
+		}
+		for position, topic := range []**[32]byte{&stored.Topic1, &stored.Topic2, &stored.Topic3} {
+			if position+1 < len(log.topics) {
+				value := [32]byte(log.topics[position+1])
+				*topic = &value
+			}
+		}
+		if err = chain.repo.StoreLog(stored); err != nil {
+			chain.t.Fatal(err)
+		}
+		logIds = append(logIds, stored.LogId)
+	}
+	return logIds
+}
+
+func listLogIdsByFilter(t *testing.T, repo *PostgresRepository, filter storage.LogFilter) []storage.LogId {
+	t.Helper()
+	logs, err := repo.ListLogsByFilter(filter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logIds []storage.LogId
+	for _, log := range logs {
+		logIds = append(logIds, log.LogId)
+	}
+	return logIds
+}
+
+func TestListLogsByFilterMatchesFullScan(t *testing.T) {
+	repo := newTestRepository(t, newTestDatabase(t))
+	chain := newTestChain(t, repo)
+	tokenA, tokenB, unknown := common.HexToAddress("0xa0"), common.HexToAddress("0xb0"), common.HexToAddress("0xee")
+	eventX, eventY := common.HexToHash("0xe1"), common.HexToHash("0xe2")
+	holder1, holder2 := common.HexToHash("0x01"), common.HexToHash("0x02")
+	// the blocks span two sections of the bloom bits index
+	logsByBlock := map[storage.BlockNumber][]bloomTestLog{
+		10:   {{address: tokenA, topics: []common.Hash{eventY, holder1}}},
+		4095: {{address: tokenA, topics: []common.Hash{eventX, holder1}}},
+		4096: {{address: tokenB, topics: []common.Hash{eventY, holder2}}, {address: tokenA, topics: []common.Hash{eventX, holder2, holder1}}},
+		4097: {{address: tokenB, topics: []common.Hash{eventX, holder1}}},
+	}
+	var logs []bloomTestLog
+	var logIds []storage.LogId
+	for _, blockNumber := range []storage.BlockNumber{10, 4095, 4096, 4097} {
+		for i := range logsByBlock[blockNumber] {
+			logsByBlock[blockNumber][i].blockNumber = blockNumber
+		}
+		logs = append(logs, logsByBlock[blockNumber]...)
+		logIds = append(logIds, chain.storeBloomTestBlock(blockNumber, logsByBlock[blockNumber])...)
+	}
+	chain.commit()
+
+	filters := []storage.LogFilter{
+		{FromBlock: 0, ToBlock: 5000, Addresses: []common.Address{tokenA}},
+		{FromBlock: 0, ToBlock: 5000, Addresses: []common.Address{tokenB}, Topics: [][]common.Hash{{eventX}}},
+		{FromBlock: 0, ToBlock: 5000, Topics: [][]common.Hash{{eventX, eventY}, {holder2}}},
+		{FromBlock: 0, ToBlock: 5000, Topics: [][]common.Hash{nil, {holder1}}},
+		{FromBlock: 0, ToBlock: 5000, Topics: [][]common.Hash{nil, nil, {holder1}}},
+		{FromBlock: 4096, ToBlock: 4096, Addresses: []common.Address{tokenA, tokenB}, Topics: [][]common.Hash{{eventY}}},
+		{FromBlock: 4000, ToBlock: 4096, Addresses: []common.Address{tokenA}},
+		{FromBlock: 0, ToBlock: 5000, Addresses: []common.Address{unknown}},
+		{FromBlock: 0, ToBlock: 5000, Topics: [][]common.Hash{{eventX}, {holder1}, {holder2}}},
+	}
+	for _, filter := range filters {
+		var want []storage.LogId
+		for i, log := range logs {
+			if log.matches(filter) {
+				want = append(want, logIds[i])
+			}
+		}
+		if got := listLogIdsByFilter(t, repo, filter); !reflect.DeepEqual(got, want) {
+			t.Errorf("logs of filter %+v: got %v, want %v", filter, got, want)
+		}
+	}
+
+	// the reorged block has other logs, its former bits must not match any more
+	if err := repo.DeleteBlockAndAllReferences(4096); err != nil {
+		t.Fatal(err)
+	}
+	chain.commit()
+	candidates, err := repo.bloomBitsCandidates(4096, 4096, [][][3]uint{{bloomBitIndexes(tokenB.Bytes())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 0 {
+		t.Errorf("deleted block 4096 is still a candidate of its former logs' address: %v", candidates)
+	}
+	var bitsOfDeletedBlock int
+	err = repo.statementBuilder.
+		Select("COUNT(*)").
+		From(tableNameBloomBits).
+		Where("section = ?", 4096/bloomBitsSectionSize).
+		Where("get_bit(bits, ?) = 1", 4096%bloomBitsSectionSize).
+		Scan(&bitsOfDeletedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bitsOfDeletedBlock != 0 {
+		t.Errorf("%d bits of deleted block 4096 are still set", bitsOfDeletedBlock)
+	}
+	reorged := chain.storeBloomTestBlock(4096, []bloomTestLog{{address: tokenA, topics: []common.Hash{eventX, holder1}}})
+	chain.commit()
+	if got := listLogIdsByFilter(t, repo, storage.LogFilter{FromBlock: 4096, ToBlock: 4096, Addresses: []common.Address{tokenB}}); len(got) != 0 {
+		t.Errorf("logs of the reorged block by the address of its former logs: got %v, want none", got)
+	}
+	if got := listLogIdsByFilter(t, repo, storage.LogFilter{FromBlock: 4096, ToBlock: 4096, Addresses: []common.Address{tokenA}}); !reflect.DeepEqual(got, reorged) {
+		t.Errorf("logs of the reorged block: got %v, want %v", got, reorged)
+	}
+	// the blocks of the section which were not deleted keep their bits
+	if got := listLogIdsByFilter(t, repo, storage.LogFilter{FromBlock: 4097, ToBlock: 4097, Addresses: []common.Address{tokenB}}); len(got) != 1 {
+		t.Errorf("logs of block 4097 after the reorg of block 4096: got %v, want 1", got)
+	}
+}
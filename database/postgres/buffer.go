@@ -0,0 +1,371 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+)
+
+// env_POSTGRES_BUFFERED_WRITES makes the inserters buffer their records until Commit, see writeBuffer.
+const env_POSTGRES_BUFFERED_WRITES = "POSTGRES_BUFFERED_WRITES"
+
+// The entities having ids are inserted along with the ids the buffered inserters returned.
+var (
+	insertTargetAddressesWithIds = insertTarget{tableName: tableNameAddresses, columns: []string{"id", "hash"},
+		keyColumns: []string{"hash"}}
+	insertTargetBytecodesWithIds = insertTarget{tableName: tableNameBytecodes, columns: append([]string{"id"}, tableColumnsBytecodes...),
+		keyColumns: []string{"sha256"}}
+	insertTargetEventTypesWithIds = insertTarget{tableName: tableNameEventTypes, columns: tableColumnsEventTypes,
+		keyColumns: []string{"hash"}, mutableColumns: []string{"signature"}}
+	insertTargetTransactionsWithIds = insertTarget{tableName: tableNameTransactions, columns: tableColumnsTransactions,
+		keyColumns: []string{"hash"}}
+)
+
+// writeBuffer holds the records stored since the last Commit when writes are buffered.
+// Commit flushes them in the order the tables reference each other, with multi-row statements.
+// The inserters returning ids return them right away: stored entities are looked up by their keys,
+// and new entities get the next values of their table's sequence, they are inserted with.
+// Reads flush the buffered records first, so they see the records stored since the last Commit, see flushingRunner.
+// Buffered records must not be modified until they are flushed.
+type writeBuffer struct {
+	addressIds     bufferedIds
+	bytecodeIds    bufferedIds
+	eventTypeIds   bufferedIds
+	transactionIds bufferedIds
+
+	addresses           []withId[common.Address]
+	bytecodes           []withId[storage.Bytecode]
+	eventTypes          []withId[*storage.EventType]
+	blocks              []*storage.Block
+	uncles              []*storage.Uncle
+	transactions        []withId[*storage.Transaction]
+	receipts            []*storage.Receipt
+	contracts           []*storage.Contract
+	logs                []*storage.Log
+	erc20Tokens         []*storage.Erc20Token
+	erc20TokenTransfers []*storage.Erc20TokenTransfer
+	traces              []*storage.TraceAction
+	storageKeys         []*storage.StorageKey
+	stateChanges        []*storage.StateChange
+	storageChanges      []*storage.StorageChange
+	etherBalances       []*storage.EtherBalance
+	erc20TokenBalances  []*storage.Erc20TokenBalance
+}
+
+func newWriteBuffer() *writeBuffer {
+	return &writeBuffer{
+		addressIds:     bufferedIds{tableName: tableNameAddresses, keyColumn: "hash"},
+		bytecodeIds:    bufferedIds{tableName: tableNameBytecodes, keyColumn: "sha256"},
+		eventTypeIds:   bufferedIds{tableName: tableNameEventTypes, keyColumn: "hash"},
+		transactionIds: bufferedIds{tableName: tableNameTransactions, keyColumn: "hash"},
+	}
+}
+
+// empty tells whether no records are buffered.
+func (buffer *writeBuffer) empty() bool {
+	return len(buffer.addresses) == 0 && len(buffer.bytecodes) == 0 && len(buffer.eventTypes) == 0 &&
+		len(buffer.blocks) == 0 && len(buffer.uncles) == 0 && len(buffer.transactions) == 0 &&
+		len(buffer.receipts) == 0 && len(buffer.contracts) == 0 && len(buffer.logs) == 0 &&
+		len(buffer.erc20Tokens) == 0 && len(buffer.erc20TokenTransfers) == 0 && len(buffer.traces) == 0 &&
+		len(buffer.storageKeys) == 0 && len(buffer.stateChanges) == 0 && len(buffer.storageChanges) == 0 &&
+		len(buffer.etherBalances) == 0 && len(buffer.erc20TokenBalances) == 0
+}
+
+// hasUncommittedWrites tells whether records were written or buffered since the last Commit.
+func (repo *PostgresRepository) hasUncommittedWrites() bool {
+	return repo.runner.writing || (repo.buffer != nil && !repo.buffer.empty())
+}
+
+// flushForRead flushes the buffered records before a read, so the read sees them.
+// Reads run by flush itself see an empty buffer, as flush replaces it first.
+func (repo *PostgresRepository) flushForRead() error {
+	if repo.buffer == nil || repo.buffer.empty() {
+		return nil
+	}
+	return repo.flush()
+}
+
+// flushingRunner runs the statements of the statement builder when writes are buffered, flushing the buffer before every read.
+type flushingRunner struct {
+	repo *PostgresRepository
+}
+
+func (runner flushingRunner) Exec(query string, args ...any) (sql.Result, error) {
+	return runner.repo.runner.Exec(query, args...)
+}
+func (runner flushingRunner) Query(query string, args ...any) (*sql.Rows, error) {
+	return runner.repo.query(query, args...)
+}
+func (runner flushingRunner) QueryRow(query string, args ...any) sq.RowScanner {
+	return runner.repo.queryRow(query, args...)
+}
+
+// query runs a read whose rows are scanned by the caller, after flushing the buffered records.
+func (repo *PostgresRepository) query(query string, args ...any) (*sql.Rows, error) {
+	if err := repo.flushForRead(); err != nil {
+		return nil, err
+	}
+	return repo.runner.Query(query, args...)
+}
+
+// failedRow is the row of a read failing before its query is run.
+type failedRow struct {
+	err error
+}
+
+func (row failedRow) Scan(...any) error {
+	return row.err
+}
+func (row failedRow) Err() error {
+	return row.err
+}
+
+type withId[Record any] struct {
+	record Record
+	id     int64
+}
+
+// bufferedIds are the ids assigned to the keys of a table's entities since the last Commit.
+type bufferedIds struct {
+	tableName string
+	keyColumn string
+	ids       map[string]int64
+}
+
+// assignIds returns the ids of the keys, looking up the ones of stored entities, and taking the ones of new entities from the table's sequence.
+// isNew tells which keys were assigned a new id by this call, only their first occurrence being reported.
+func (repo *PostgresRepository) assignIds(bufferedIds *bufferedIds, keys [][]byte) (ids []int64, isNew []bool, err error) {
+	if bufferedIds.ids == nil {
+		bufferedIds.ids = make(map[string]int64)
+	}
+	var unknownKeys [][]byte
+	unknown := make(map[string]bool)
+	for _, key := range keys {
+		if _, found := bufferedIds.ids[string(key)]; !found && !unknown[string(key)] {
+			unknown[string(key)] = true
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+	// the lookups are part of buffering, so they do not flush the buffer like the reads of the statement builder
+	statementBuilder := sq.StatementBuilder.RunWith(repo.runner).PlaceholderFormat(sq.Dollar)
+	if len(unknownKeys) != 0 {
+		rows, err := statementBuilder.
+			Select(bufferedIds.keyColumn, "id").
+			From(bufferedIds.tableName).
+			Where(bufferedIds.keyColumn+" = ANY(?)", pq.ByteaArray(unknownKeys)).
+			Query()
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var key []byte
+			var id int64
+			if err = rows.Scan(&key, &id); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			bufferedIds.ids[string(key)] = id
+			delete(unknown, string(key))
+		}
+		if err = rows.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(unknown) != 0 {
+		rows, err := statementBuilder.
+			Select(fmt.Sprintf("nextval(pg_get_serial_sequence('%s', 'id'))", bufferedIds.tableName)).
+			From(fmt.Sprintf("generate_series(1, %d)", len(unknown))).
+			Query()
+		if err != nil {
+			return nil, nil, err
+		}
+		newKeys := unknownKeys[:0]
+		for _, key := range unknownKeys {
+			if unknown[string(key)] {
+				newKeys = append(newKeys, key)
+			}
+		}
+		i := 0
+		for ; rows.Next(); i++ {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			bufferedIds.ids[string(newKeys[i])] = id
+		}
+		if err = rows.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+	ids = make([]int64, 0, len(keys))
+	isNew = make([]bool, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, bufferedIds.ids[string(key)])
+		isNew = append(isNew, unknown[string(key)])
+		delete(unknown, string(key))
+	}
+	return ids, isNew, nil
+}
+
+// buffersStoredEntity tells whether entities which are stored already are buffered anyway,
+// so they are compared with or update the stored ones when conflicts are not ignored.
+func (repo *PostgresRepository) buffersStoredEntity() bool {
+	return repo.conflictMode != conflictModeIgnore
+}
+func (repo *PostgresRepository) bufferAddresses(addresses []common.Address) ([]storage.AddressId, error) {
+	keys := make([][]byte, 0, len(addresses))
+	for _, address := range addresses {
+		keys = append(keys, address.Bytes())
+	}
+	ids, isNew, err := repo.assignIds(&repo.buffer.addressIds, keys)
+	if err != nil {
+		return nil, err
+	}
+	addressIds := make([]storage.AddressId, 0, len(addresses))
+	for i, address := range addresses {
+		// addresses have nothing but their key, so stored ones can not conflict
+		if isNew[i] {
+			repo.buffer.addresses = append(repo.buffer.addresses, withId[common.Address]{address, ids[i]})
+		}
+		addressIds = append(addressIds, storage.NewAddressId(ids[i]))
+	}
+	return addressIds, nil
+}
+func (repo *PostgresRepository) bufferBytecodes(bytecodes []storage.Bytecode) ([]storage.BytecodeId, error) {
+	keys := make([][]byte, 0, len(bytecodes))
+	for _, bytecode := range bytecodes {
+		keys = append(keys, sqlcommon.BytecodeSha256(bytecode))
+	}
+	ids, isNew, err := repo.assignIds(&repo.buffer.bytecodeIds, keys)
+	if err != nil {
+		return nil, err
+	}
+	bytecodeIds := make([]storage.BytecodeId, 0, len(bytecodes))
+	for i, bytecode := range bytecodes {
+		if isNew[i] || repo.buffersStoredEntity() {
+			repo.buffer.bytecodes = append(repo.buffer.bytecodes, withId[storage.Bytecode]{bytecode, ids[i]})
+		}
+		bytecodeIds = append(bytecodeIds, storage.NewBytecodeId(ids[i]))
+	}
+	return bytecodeIds, nil
+}
+func (repo *PostgresRepository) bufferEventTypes(eventTypes []*storage.EventType) ([]storage.Topic0Id, error) {
+	keys := make([][]byte, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		keys = append(keys, eventType.Hash.Bytes())
+	}
+	ids, isNew, err := repo.assignIds(&repo.buffer.eventTypeIds, keys)
+	if err != nil {
+		return nil, err
+	}
+	eventTypeIds := make([]storage.Topic0Id, 0, len(eventTypes))
+	for i, eventType := range eventTypes {
+		if isNew[i] || repo.buffersStoredEntity() {
+			repo.buffer.eventTypes = append(repo.buffer.eventTypes, withId[*storage.EventType]{eventType, ids[i]})
+		}
+		eventTypeIds = append(eventTypeIds, storage.NewTopic0Id(ids[i]))
+	}
+	return eventTypeIds, nil
+}
+
+// bufferTransactions buffers all transactions, as their ids are derived in a partitioned database,
+// and they are compared with the stored ones when conflicts are not ignored.
+func (repo *PostgresRepository) bufferTransactions(transactions []*storage.Transaction) ([]storage.TransactionId, error) {
+	var transactionIds []storage.TransactionId
+	if repo.partitionSize != 0 {
+		var err error
+		if transactionIds, err = partitionedTransactionIds(transactions); err != nil {
+			return nil, err
+		}
+		for i, transaction := range transactions {
+			repo.buffer.transactions = append(repo.buffer.transactions, withId[*storage.Transaction]{transaction, transactionIds[i].Int64()})
+		}
+		return transactionIds, nil
+	}
+	keys := make([][]byte, 0, len(transactions))
+	for _, transaction := range transactions {
+		keys = append(keys, transaction.Hash.Bytes())
+	}
+	ids, isNew, err := repo.assignIds(&repo.buffer.transactionIds, keys)
+	if err != nil {
+		return nil, err
+	}
+	transactionIds = make([]storage.TransactionId, 0, len(transactions))
+	for i, transaction := range transactions {
+		if isNew[i] || repo.buffersStoredEntity() {
+			repo.buffer.transactions = append(repo.buffer.transactions, withId[*storage.Transaction]{transaction, ids[i]})
+		}
+		transactionIds = append(transactionIds, storage.NewTransactionId(ids[i]))
+	}
+	return transactionIds, nil
+}
+
+// flush inserts the buffered records, referenced tables first.
+// The buffer is emptied even if flushing fails, as the writes since the last Commit have to be redone then.
+func (repo *PostgresRepository) flush() error {
+	buffer := repo.buffer
+	repo.buffer = newWriteBuffer()
+	transactionsTarget := insertTargetTransactionsWithIds
+	if repo.partitionSize != 0 {
+		transactionsTarget = insertTargetPartitionedTransactions
+	}
+	steps := []func() error{
+		func() error {
+			return executeBulkInsert(repo, insertTargetAddressesWithIds, buffer.addresses,
+				func(address withId[common.Address]) string { return fmt.Sprintf("address %v", address.record) },
+				func(address withId[common.Address]) []any { return []any{address.id, address.record} })
+		},
+		func() error {
+			return executeBulkInsert(repo, insertTargetBytecodesWithIds, buffer.bytecodes,
+				func(bytecode withId[storage.Bytecode]) string { return sqlcommon.DescribeBytecode(bytecode.record) },
+				func(bytecode withId[storage.Bytecode]) []any {
+					return []any{bytecode.id, bytecode.record, sqlcommon.BytecodeSha256(bytecode.record)}
+				})
+		},
+		func() error {
+			return executeBulkInsert(repo, insertTargetEventTypesWithIds, buffer.eventTypes,
+				func(eventType withId[*storage.EventType]) string {
+					return sqlcommon.DescribeEventType(eventType.record)
+				},
+				func(eventType withId[*storage.EventType]) []any {
+					return []any{eventType.id, eventType.record.Hash, eventType.record.Signature}
+				})
+		},
+		func() error { return repo.storeBlocks(buffer.blocks) },
+		func() error { return repo.storeUncles(buffer.uncles) },
+		func() error {
+			return executeBulkInsert(repo, transactionsTarget, buffer.transactions,
+				func(transaction withId[*storage.Transaction]) string {
+					return sqlcommon.DescribeTransaction(transaction.record)
+				},
+				func(transaction withId[*storage.Transaction]) []any {
+					values := transactionValues(transaction.record)
+					values[0] = transaction.id
+					return values
+				})
+		},
+		func() error { return repo.storeReceipts(buffer.receipts) },
+		func() error { return repo.storeContracts(buffer.contracts) },
+		func() error { return repo.storeLogs(buffer.logs) },
+		func() error { return repo.storeErc20Tokens(buffer.erc20Tokens) },
+		func() error { return repo.storeErc20TokenTransfers(buffer.erc20TokenTransfers) },
+		func() error { return repo.storeTraces(buffer.traces) },
+		func() error { return repo.storeStorageKeys(buffer.storageKeys) },
+		func() error { return repo.storeStateChanges(buffer.stateChanges) },
+		func() error { return repo.storeStorageChanges(buffer.storageChanges) },
+		func() error { return repo.storeEtherBalances(buffer.etherBalances) },
+		func() error { return repo.storeErc20TokenBalances(buffer.erc20TokenBalances) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/storagetest"
+)
+
+func TestReadYourWritesWithBufferedRecords(t *testing.T) {
+	primary := &PostgresRepository{runner: &journalingRunner{}, buffer: newWriteBuffer()}
+	repo := &ReplicatedRepository{
+		PostgresRepository: primary,
+		replicas:           []*replica{{loaded: true, healthy: true, storedBlocks: 10}},
+		options:            ReplicaOptions{CheckInterval: time.Hour, ReadYourWrites: true},
+		checkedAt:          time.Now(),
+	}
+	if repo.nextReplica() == nil {
+		t.Fatal("reads went to the primary without uncommitted writes")
+	}
+	primary.buffer.blocks = append(primary.buffer.blocks, &storage.Block{Number: 1})
+	if repo.nextReplica() != nil {
+		t.Error("reads went to a replica while records were buffered")
+	}
+	primary.buffer = newWriteBuffer()
+	primary.runner.writing = true
+	if repo.nextReplica() != nil {
+		t.Error("reads went to a replica while records were written")
+	}
+}
+
+// newBufferedTestRepository returns a loaded repository buffering its writes, see newTestRepository.
+func newBufferedTestRepository(t *testing.T, databaseURL string) *PostgresRepository {
+	t.Helper()
+	t.Setenv(env_POSTGRES_BUFFERED_WRITES, "true")
+	repo := newTestRepository(t, databaseURL)
+	if repo.buffer == nil {
+		t.Fatal("writes are not buffered")
+	}
+	return repo
+}
+
+// TestBufferedWritesFlushOrder stores every kind of entity into the buffer, so the foreign keys only hold
+// if Commit flushes the referenced tables first.
+func TestBufferedWritesFlushOrder(t *testing.T) {
+	repo := newBufferedTestRepository(t, newTestDatabase(t))
+	fixture := storagetest.Seed(t, repo)
+	if !repo.buffer.empty() {
+		t.Fatal("records are left in the buffer after Commit")
+	}
+	for _, block := range fixture.Blocks {
+		stored, err := repo.GetBlockByNumber(block.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storagetest.CheckEqual(t, "block", stored, block)
+	}
+	for i, transactionId := range fixture.TransactionIds {
+		transaction, err := repo.GetTransactionById(transactionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storagetest.CheckEqual(t, "transaction", transaction, fixture.Transactions[i])
+		receipt, err := repo.GetReceiptByTransactionId(transactionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storagetest.CheckEqual(t, "receipt", receipt, fixture.Receipts[i])
+	}
+	log, err := repo.GetLogById(fixture.Logs[0].LogId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.CheckEqual(t, "log", log, fixture.Logs[0])
+	contract, err := repo.GetContractByAddressId(fixture.ContractId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.CheckEqual(t, "contract", contract, fixture.ContractRecord)
+	etherBalance, err := repo.GetLastStoredEtherBalance(fixture.SenderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.CheckEqual(t, "ether balance", etherBalance, fixture.EtherBalances[2])
+}
+
+func TestBufferedRecordsAreReadBeforeCommit(t *testing.T) {
+	repo := newBufferedTestRepository(t, newTestDatabase(t))
+	miner := common.HexToAddress("0xaa")
+	addressIds, err := repo.StoreAddress(miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &storage.Block{Hash: common.HexToHash("0xb1"), Number: 1, MinerAddressId: addressIds[0],
+		Difficulty: common.Big1, TotalDifficulty: common.Big1, StaticReward: common.Big2, BaseFeePerGas: common.Big3}
+	if err = repo.StoreBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if repo.buffer.empty() || repo.runner.writing {
+		t.Fatal("the records were not buffered")
+	}
+	if !repo.hasUncommittedWrites() {
+		t.Error("buffered records are not uncommitted writes")
+	}
+	stored, err := repo.GetBlockByNumber(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.CheckEqual(t, "block", stored, block)
+	if !repo.buffer.empty() || !repo.runner.writing {
+		t.Error("the buffer was not flushed by the read")
+	}
+	if err = repo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if repo.hasUncommittedWrites() {
+		t.Error("writes are left after Commit")
+	}
+}
+
+func TestBufferedIdsFromSequences(t *testing.T) {
+	databaseURL := newTestDatabase(t)
+	repo := newBufferedTestRepository(t, databaseURL)
+	a, b, c, d := common.HexToAddress("0xa"), common.HexToAddress("0xb"), common.HexToAddress("0xc"), common.HexToAddress("0xd")
+	ids, err := repo.StoreAddress(a, b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids[0] != ids[2] || ids[0] == ids[1] {
+		t.Fatalf("addresses a, b, a were assigned ids %v", ids)
+	}
+	idsAgain, err := repo.StoreAddress(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idsAgain[0] != ids[1] {
+		t.Errorf("buffered address was assigned id %v, then %v", ids[1], idsAgain[0])
+	}
+	if len(repo.buffer.addresses) != 2 {
+		t.Errorf("%d addresses were buffered, expected 2", len(repo.buffer.addresses))
+	}
+	if err = repo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ids were taken from the sequence, so the inserts of an unbuffered repository get other ids
+	t.Setenv(env_POSTGRES_BUFFERED_WRITES, "false")
+	unbufferedRepo := newTestRepository(t, databaseURL)
+	for i, address := range []common.Address{a, b} {
+		id, err := unbufferedRepo.GetAddressIdByHash(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != ids[i] {
+			t.Errorf("address %v was stored with id %v, expected %v", address, id, ids[i])
+		}
+	}
+	unbufferedIds, err := unbufferedRepo.StoreAddress(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unbufferedIds[0].Int64() <= ids[1].Int64() {
+		t.Errorf("address stored after the buffered ones got id %v, not following %v", unbufferedIds[0], ids[1])
+	}
+	if err = unbufferedRepo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// stored addresses are looked up, new ones get the next value of the sequence
+	ids, err = repo.StoreAddress(c, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids[0] != unbufferedIds[0] {
+		t.Errorf("stored address was assigned id %v, expected %v", ids[0], unbufferedIds[0])
+	}
+	if ids[1].Int64() <= ids[0].Int64() {
+		t.Errorf("new address got id %v, not following %v", ids[1], ids[0])
+	}
+	if len(repo.buffer.addresses) != 1 {
+		t.Errorf("%d addresses were buffered, expected the new one only", len(repo.buffer.addresses))
+	}
+	if err = repo.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.GetAddressIdByHash(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != ids[1] {
+		t.Errorf("address %v was stored with id %v, expected %v", d, id, ids[1])
+	}
+}
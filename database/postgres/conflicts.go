@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// env_POSTGRES_CONFLICT_MODE tells what inserters do with records conflicting with stored ones, see conflictMode.
+const env_POSTGRES_CONFLICT_MODE = "POSTGRES_CONFLICT_MODE"
+
+// conflictMode tells what inserters do with records conflicting with stored ones.
+type conflictMode uint8
+
+const (
+	// conflictModeIgnore keeps the stored records, dropping the conflicting ones silently.
+	conflictModeIgnore conflictMode = iota
+	// conflictModeStrict compares conflicting records with the stored ones, failing with a storage.ConflictError if they differ.
+	conflictModeStrict
+	// conflictModeUpsert overwrites the mutable columns of stored records, e.g. the metadata of ERC-20 tokens,
+	// and compares the immutable records like conflictModeStrict.
+	conflictModeUpsert
+)
+
+var conflictModesByName = map[string]conflictMode{
+	"":       conflictModeIgnore,
+	"ignore": conflictModeIgnore,
+	"strict": conflictModeStrict,
+	"upsert": conflictModeUpsert,
+}
+
+func getConflictModeEnv() (conflictMode, error) {
+	value := os.Getenv(env_POSTGRES_CONFLICT_MODE)
+	mode, found := conflictModesByName[strings.ToLower(value)]
+	if !found {
+		return conflictModeIgnore, fmt.Errorf("invalid value of %s: %q, expected ignore, strict or upsert", env_POSTGRES_CONFLICT_MODE, value)
+	}
+	return mode, nil
+}
+
+// insertTarget is the table inserted into by executeBulkInsert.
+type insertTarget struct {
+	tableName string
+	columns   []string
+	// keyColumns identify the stored record a record conflicts with, nil for tables without unique keys.
+	keyColumns []string
+	// mutableColumns are overwritten in conflictModeUpsert instead of being compared.
+	mutableColumns []string
+}
+
+// onConflictClause returns the conflict handling of inserts in the given mode.
+func (target insertTarget) onConflictClause(mode conflictMode) string {
+	if mode != conflictModeUpsert || len(target.mutableColumns) == 0 {
+		return on_conflict_do_nothing
+	}
+	quotedKeyColumns := quoteIdentifiers(target.keyColumns)
+	quotedMutableColumns := quoteIdentifiers(target.mutableColumns)
+	assignments := make([]string, 0, len(quotedMutableColumns))
+	excluded := make([]string, 0, len(quotedMutableColumns))
+	for _, column := range quotedMutableColumns {
+		assignments = append(assignments, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		excluded = append(excluded, "EXCLUDED."+column)
+	}
+	// unchanged records are not updated, so they are not reported as affected rows
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s WHERE (%s) IS DISTINCT FROM (%s)",
+		strings.Join(quotedKeyColumns, ", "),
+		strings.Join(assignments, ", "),
+		strings.Join(qualifiedColumns(target.tableName, target.mutableColumns), ", "),
+		strings.Join(excluded, ", "))
+}
+
+func quoteIdentifiers(identifiers []string) []string {
+	quoted := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		quoted = append(quoted, pq.QuoteIdentifier(identifier))
+	}
+	return quoted
+}
+
+// findConflict compares a record which was not inserted with the stored record having the same key.
+// values are the record's values in the order of the target's columns.
+// It returns nil if the records are the same, or if the stored record was updated in conflictModeUpsert.
+func (repo *PostgresRepository) findConflict(target insertTarget, entity string, values []any) error {
+	if target.keyColumns == nil {
+		return nil
+	}
+	columnIndexes := make(map[string]int, len(target.columns))
+	for i, column := range target.columns {
+		columnIndexes[column] = i
+	}
+	// only the referenced values are passed, as postgres can not tell the type of unreferenced parameters
+	var args []any
+	parameter := func(column string) string {
+		args = append(args, values[columnIndexes[column]])
+		return fmt.Sprintf("$%d", len(args))
+	}
+	isKeyColumn := make(map[string]bool, len(target.keyColumns))
+	var conditions []string
+	for _, column := range target.keyColumns {
+		isKeyColumn[column] = true
+		conditions = append(conditions, fmt.Sprintf("%s = %s", pq.QuoteIdentifier(column), parameter(column)))
+	}
+	if repo.conflictMode == conflictModeUpsert {
+		for _, column := range target.mutableColumns {
+			isKeyColumn[column] = true
+		}
+	}
+	// parameters take the type of the column they are first compared with, and are then formatted like the stored values
+	var comparedColumns, selections []string
+	for _, column := range target.columns {
+		if isKeyColumn[column] {
+			continue
+		}
+		quotedColumn := pq.QuoteIdentifier(column)
+		placeholder := parameter(column)
+		comparedColumns = append(comparedColumns, column)
+		selections = append(selections,
+			fmt.Sprintf("%s IS NOT DISTINCT FROM %s", quotedColumn, placeholder),
+			quotedColumn+"::text",
+			placeholder+"::text")
+	}
+	if len(comparedColumns) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		strings.Join(selections, ", "), target.tableName, strings.Join(conditions, " AND "))
+	same := make([]bool, len(comparedColumns))
+	stored := make([]sql.NullString, len(comparedColumns))
+	incoming := make([]sql.NullString, len(comparedColumns))
+	destinations := make([]any, 0, len(selections))
+	for i := range comparedColumns {
+		destinations = append(destinations, &same[i], &stored[i], &incoming[i])
+	}
+	err := repo.queryRow(query, args...).Scan(destinations...)
+	if err == sql.ErrNoRows {
+		return &storage.ConflictError{Entity: entity}
+	}
+	if err != nil {
+		return err
+	}
+	conflict := &storage.ConflictError{Entity: entity}
+	for i, column := range comparedColumns {
+		if !same[i] {
+			conflict.Fields = append(conflict.Fields, storage.ConflictingField{
+				Name:     column,
+				Stored:   nullableText(stored[i]),
+				Incoming: nullableText(incoming[i]),
+			})
+		}
+	}
+	if len(conflict.Fields) == 0 {
+		return nil
+	}
+	return conflict
+}
+func nullableText(text sql.NullString) string {
+	if !text.Valid {
+		return "NULL"
+	}
+	return text.String
+}
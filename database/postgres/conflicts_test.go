@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+func TestConflictingBlocksAreReported(t *testing.T) {
+	for _, mode := range []string{"strict", "upsert"} {
+		t.Run(mode, func(t *testing.T) {
+			t.Setenv(env_POSTGRES_CONFLICT_MODE, mode)
+			repo := newTestRepository(t, newTestDatabase(t))
+			chain := newTestChain(t, repo)
+			chain.storeBlocks(1)
+			chain.commit()
+
+			// storing the same block again is not a conflict
+			chain.storeBlocks(1)
+			reorged := chain.block(1)
+			reorged.Hash = common.HexToHash("0xbad")
+			reorged.Timestamp++
+			err := repo.StoreBlock(reorged)
+			var conflict *storage.ConflictError
+			if !errors.As(err, &conflict) || !errors.Is(err, storage.ErrConflict) {
+				t.Fatalf("block stored again with another hash: got %v, want a conflict", err)
+			}
+			var fields []string
+			for _, field := range conflict.Fields {
+				fields = append(fields, field.Name)
+				if field.Stored == field.Incoming {
+					t.Errorf("field %s differs, but its values are both %s", field.Name, field.Stored)
+				}
+			}
+			sort.Strings(fields)
+			if want := []string{"hash", "timestamp"}; !reflect.DeepEqual(fields, want) {
+				t.Errorf("differing fields: got %v, want %v", fields, want)
+			}
+
+			// another block having the hash of the stored one conflicts on the hash, without differing fields
+			other := chain.block(2)
+			other.Hash = blockHash(1)
+			err = repo.StoreBlock(other)
+			if !errors.As(err, &conflict) || len(conflict.Fields) != 0 {
+				t.Errorf("block 2 stored with the hash of block 1: got %v, want a conflict on another unique field", err)
+			}
+		})
+	}
+}
+
+func TestUpsertUpdatesErc20TokenMetadata(t *testing.T) {
+	t.Setenv(env_POSTGRES_CONFLICT_MODE, "upsert")
+	repo := newTestRepository(t, newTestDatabase(t))
+	chain := newTestChain(t, repo)
+	token := chain.address(common.HexToAddress("0xdd"))
+	for _, metadata := range []*storage.Erc20Token{
+		{AddressId: token, Symbol: "OLD", Name: "Old token", Decimals: 18, TotalSupply: big.NewInt(1000)},
+		{AddressId: token, Symbol: "NEW", Name: "New token", Decimals: 6, TotalSupply: big.NewInt(2000)},
+	} {
+		if err := repo.StoreErc20Token(metadata); err != nil {
+			t.Fatal(err)
+		}
+		chain.commit()
+		stored, err := repo.GetErc20TokenByAddressId(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored == nil || stored.Symbol != metadata.Symbol || stored.Name != metadata.Name ||
+			stored.Decimals != metadata.Decimals || stored.TotalSupply.Cmp(metadata.TotalSupply) != 0 {
+			t.Errorf("stored token: got %+v, want %+v", stored, metadata)
+		}
+	}
+}
@@ -8,12 +8,27 @@ import (
 	storage "github.com/librescan-org/backend-db"
 )
 
-func (repo *PostgresRepository) DeleteBlockAndAllReferences(blockNumbers ...storage.BlockNumber) error {
+func (repo *PostgresRepository) DeleteBlockAndAllReferences(blockNumbers ...storage.BlockNumber) (err error) {
+	defer annotate(&err, "failed to delete blocks %v", blockNumbers)
+	// deletions are not buffered, so the records buffered before them are flushed to be deleted as well
+	if repo.buffer != nil {
+		if err = repo.flush(); err != nil {
+			return err
+		}
+	}
 	blockNumbersStr := make([]string, 0, len(blockNumbers))
 	for _, blockNumber := range blockNumbers {
 		blockNumbersStr = append(blockNumbersStr, strconv.FormatUint(blockNumber, 10))
 	}
-	_, err := repo.statementBuilder.
+	if err = repo.clearBloomBits(blockNumbers); err != nil {
+		return err
+	}
+	if repo.partitionSize != 0 {
+		if err = repo.deleteTransactionsOfBlocks(blockNumbers); err != nil {
+			return err
+		}
+	}
+	_, err = repo.statementBuilder.
 		Delete(tableNameBlocks).
 		Where(fmt.Sprintf("number IN (%s)", strings.Join(blockNumbersStr, ","))).
 		Exec()
@@ -0,0 +1,428 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/convert"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+)
+
+const (
+	env_POSTGRES_DERIVE_ERC20_TRANSFERS = "POSTGRES_DERIVE_ERC20_TRANSFERS"
+	// env_POSTGRES_DERIVE_ERC20_BALANCES derives the ERC-20 token balances from the stored transfers,
+	// ignoring the balances stored through StoreErc20TokenBalance.
+	env_POSTGRES_DERIVE_ERC20_BALANCES = "POSTGRES_DERIVE_ERC20_BALANCES"
+
+	checkpointErc20TokenTransfers = "erc20_token_transfers"
+)
+
+// erc20TransferTopic0Id returns the id of the Transfer event's topic0, or the zero id if it was never stored.
+func (repo *PostgresRepository) erc20TransferTopic0Id() (storage.Topic0Id, error) {
+	if !repo.transferTopic0Id.IsZero() {
+		return repo.transferTopic0Id, nil
+	}
+	var id storage.Topic0Id
+	err := repo.statementBuilder.
+		Select("id").
+		From(tableNameEventTypes).
+		Where("hash = ?", convert.Erc20TransferTopic).
+		Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return storage.Topic0Id{}, nil
+		}
+		return storage.Topic0Id{}, err
+	}
+	repo.transferTopic0Id = id
+	return id, nil
+}
+
+// deriveErc20TokenTransfers stores the ERC-20 token transfers found among the logs.
+// ERC-721 transfers share the same topic0, but have their token id indexed as a 4th topic, so they are skipped.
+// The transfers are inserted right away even if writes are buffered, as buffered logs are derived from while being flushed.
+func (repo *PostgresRepository) deriveErc20TokenTransfers(logs []*storage.Log) error {
+	transferTopic0Id, err := repo.erc20TransferTopic0Id()
+	if err != nil || transferTopic0Id.IsZero() {
+		return err
+	}
+	var transferLogs []*storage.Log
+	var addresses []common.Address
+	for _, log := range logs {
+		if log.Topic0Id == nil || *log.Topic0Id != transferTopic0Id ||
+			log.Topic1 == nil || log.Topic2 == nil || log.Topic3 != nil || len(log.Data) != common.HashLength {
+			continue
+		}
+		transferLogs = append(transferLogs, log)
+		addresses = append(addresses, common.BytesToAddress(log.Topic1[:]), common.BytesToAddress(log.Topic2[:]))
+	}
+	if len(transferLogs) == 0 {
+		return nil
+	}
+	addressIds, err := repo.storeAddresses(addresses)
+	if err != nil {
+		return err
+	}
+	transfers := make([]*storage.Erc20TokenTransfer, 0, len(transferLogs))
+	for i, log := range transferLogs {
+		transfers = append(transfers, &storage.Erc20TokenTransfer{
+			LogId:          log.LogId,
+			TokenAddressId: log.AddressId,
+			FromAddressId:  addressIds[2*i],
+			ToAddressId:    addressIds[2*i+1],
+			Value:          new(big.Int).SetBytes(log.Data),
+		})
+	}
+	return repo.storeErc20TokenTransfers(transfers)
+}
+func (repo *PostgresRepository) getCheckpoint(name string) (transactionId int64, err error) {
+	err = repo.statementBuilder.
+		Select("transaction_id").
+		From(tableNameDerivationCheckpoints).
+		Where("name = ?", name).
+		Scan(&transactionId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return
+}
+func (repo *PostgresRepository) storeCheckpoint(name string, transactionId int64) error {
+	_, err := repo.statementBuilder.
+		Insert(tableNameDerivationCheckpoints).
+		Columns(tableColumnsDerivationCheckpoints...).
+		Values(name, transactionId).
+		Suffix(`ON CONFLICT ("name") DO UPDATE SET "transaction_id" = EXCLUDED."transaction_id"`).
+		Exec()
+	return err
+}
+
+// BackfillErc20TokenTransfers derives ERC-20 token transfers from all logs already stored, in batches of whole transactions.
+// Each batch is committed together with its checkpoint, so an interrupted backfill resumes where it stopped.
+// progress is called after each committed batch, and it can be nil.
+func (repo *PostgresRepository) BackfillErc20TokenTransfers(ctx context.Context, batchSize uint64, progress func(lastTransactionId int64, logsProcessed int)) (err error) {
+	defer annotate(&err, "failed to backfill ERC-20 token transfers")
+	if batchSize == 0 {
+		return fmt.Errorf("batch size must be positive")
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		transferTopic0Id, err := repo.erc20TransferTopic0Id()
+		if err != nil || transferTopic0Id.IsZero() {
+			return err
+		}
+		checkpoint, err := repo.getCheckpoint(checkpointErc20TokenTransfers)
+		if err != nil {
+			return err
+		}
+		// the batch ends at the transaction of the batchSize-th log, so transactions are never split
+		var upperTransactionId *int64
+		err = repo.statementBuilder.
+			Select("transaction_id").
+			From(tableNameLogs).
+			Where("topic_0_id = ?", transferTopic0Id).
+			Where("transaction_id > ?", checkpoint).
+			OrderBy("transaction_id").
+			Offset(batchSize - 1).
+			Limit(1).
+			Scan(&upperTransactionId)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		selectBuilder := repo.statementBuilder.
+			Select(tableColumnsLogs...).
+			From(tableNameLogs).
+			Where("topic_0_id = ?", transferTopic0Id).
+			Where("transaction_id > ?", checkpoint).
+			OrderBy("transaction_id")
+		if upperTransactionId != nil {
+			selectBuilder = selectBuilder.Where("transaction_id <= ?", *upperTransactionId)
+		}
+		rows, err := selectBuilder.Query()
+		if err != nil {
+			return err
+		}
+		logs, err := scanLogs(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		if err = repo.deriveErc20TokenTransfers(logs); err != nil {
+			return err
+		}
+		lastTransactionId := logs[len(logs)-1].TransactionId.Int64()
+		if err = repo.storeCheckpoint(checkpointErc20TokenTransfers, lastTransactionId); err != nil {
+			return err
+		}
+		if err = repo.Commit(ctx); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(lastTransactionId, len(logs))
+		}
+		if upperTransactionId == nil {
+			return nil
+		}
+	}
+}
+
+// zeroAddressId returns the id of the zero address, or the zero id if it was never stored.
+func (repo *PostgresRepository) zeroAddressId() (storage.AddressId, error) {
+	if repo.cachedZeroAddressId.IsZero() {
+		addressId, err := repo.GetAddressIdByHash(common.Address{})
+		if err != nil {
+			return storage.AddressId{}, err
+		}
+		repo.cachedZeroAddressId = addressId
+	}
+	return repo.cachedZeroAddressId, nil
+}
+
+// applyErc20TokenTransfersToBalances maintains the running token balances of the transfers' holders.
+// Transfers from the zero address are mints, and transfers to the zero address are burns, so the zero address never gets a balance.
+// The changes of the whole batch are summed up per holder and block first, so they never depend on reading rows written by the batch.
+// While balances are derived, they are the only source of Erc20TokenBalances, see StoreErc20TokenBalance.
+func (repo *PostgresRepository) applyErc20TokenTransfersToBalances(transfers []*storage.Erc20TokenTransfer) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+	zeroAddressId, err := repo.zeroAddressId()
+	if err != nil {
+		return err
+	}
+	blockNumbers, err := repo.transactionBlockNumbers(transfers)
+	if err != nil {
+		return err
+	}
+	var holders []holderOfToken
+	deltas := make(map[holderOfToken]map[storage.BlockNumber]*big.Int)
+	addDelta := func(holder, token storage.AddressId, blockNumber storage.BlockNumber, delta *big.Int) {
+		key := holderOfToken{holder: holder, token: token}
+		if deltas[key] == nil {
+			holders = append(holders, key)
+			deltas[key] = make(map[storage.BlockNumber]*big.Int)
+		}
+		if deltas[key][blockNumber] == nil {
+			deltas[key][blockNumber] = new(big.Int)
+		}
+		deltas[key][blockNumber].Add(deltas[key][blockNumber], delta)
+	}
+	for _, transfer := range transfers {
+		blockNumber, ok := blockNumbers[transfer.TransactionId]
+		if !ok {
+			return fmt.Errorf("failed to get block number of transaction %v: %w", transfer.TransactionId, storage.ErrNotFound)
+		}
+		if transfer.FromAddressId != zeroAddressId {
+			addDelta(transfer.FromAddressId, transfer.TokenAddressId, blockNumber, new(big.Int).Neg(transfer.Value))
+		}
+		if transfer.ToAddressId != zeroAddressId {
+			addDelta(transfer.ToAddressId, transfer.TokenAddressId, blockNumber, transfer.Value)
+		}
+	}
+	for _, holder := range holders {
+		if err = repo.adjustErc20TokenBalance(holder, deltas[holder]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transactionBlockNumbers returns the block numbers of the transfers' transactions.
+func (repo *PostgresRepository) transactionBlockNumbers(transfers []*storage.Erc20TokenTransfer) (map[storage.TransactionId]storage.BlockNumber, error) {
+	transactionIds := make([]int64, 0, len(transfers))
+	for _, transfer := range transfers {
+		transactionIds = append(transactionIds, transfer.TransactionId.Int64())
+	}
+	rows, err := repo.statementBuilder.
+		Select("id", "block_id").
+		From(tableNameTransactions).
+		Where("id = ANY(?)", pq.Int64Array(transactionIds)).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	blockNumbers := make(map[storage.TransactionId]storage.BlockNumber)
+	for rows.Next() {
+		var transactionId storage.TransactionId
+		var blockNumber storage.BlockNumber
+		if err = rows.Scan(&transactionId, &blockNumber); err != nil {
+			return nil, err
+		}
+		blockNumbers[transactionId] = blockNumber
+	}
+	return blockNumbers, rows.Err()
+}
+
+// adjustErc20TokenBalance adds the changes of a holder's balance to the balance at their blocks, and to all balances stored for later blocks.
+// A balance never becomes negative: if the transfers of a token are not all stored, e.g. as scraping started in the middle of the chain,
+// or a contract emitted a Transfer log it did not execute, the balance becomes 0 instead, and ReconcileErc20TokenBalances reports the holder.
+func (repo *PostgresRepository) adjustErc20TokenBalance(holder holderOfToken, deltas map[storage.BlockNumber]*big.Int) error {
+	blockNumbers := make([]storage.BlockNumber, 0, len(deltas))
+	for blockNumber := range deltas {
+		blockNumbers = append(blockNumbers, blockNumber)
+	}
+	firstBlockNumber := blockNumbers[0]
+	for _, blockNumber := range blockNumbers {
+		if blockNumber < firstBlockNumber {
+			firstBlockNumber = blockNumber
+		}
+	}
+	var previousBalance numeric
+	err := repo.statementBuilder.
+		Select("balance").
+		From(tableNameErc20TokenBalances).
+		Where("address_id = ?", holder.holder).
+		Where("token_address_id = ?", holder.token).
+		Where("block_id < ?", firstBlockNumber).
+		OrderBy("block_id DESC").
+		Limit(1).
+		Scan(&previousBalance)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	storedBalances := make(map[storage.BlockNumber]*big.Int)
+	rows, err := repo.statementBuilder.
+		Select("block_id", "balance").
+		From(tableNameErc20TokenBalances).
+		Where("address_id = ?", holder.holder).
+		Where("token_address_id = ?", holder.token).
+		Where("block_id >= ?", firstBlockNumber).
+		Query()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var blockNumber storage.BlockNumber
+		var balance numeric
+		if err = rows.Scan(&blockNumber, &balance); err != nil {
+			rows.Close()
+			return err
+		}
+		storedBalances[blockNumber] = balance.Value
+		if deltas[blockNumber] == nil {
+			blockNumbers = append(blockNumbers, blockNumber)
+		}
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	sort.Slice(blockNumbers, func(i, j int) bool { return blockNumbers[i] < blockNumbers[j] })
+	// the balances are the stored ones plus the changes up to their block, plus what was added to keep them from becoming negative
+	storedBalance := sqlcommon.BigIntMustNotBeNil(previousBalance.Value)
+	change := new(big.Int)
+	valuesOfBalances := make([][]any, 0, len(blockNumbers))
+	for _, blockNumber := range blockNumbers {
+		if stored := storedBalances[blockNumber]; stored != nil {
+			storedBalance = stored
+		}
+		if delta := deltas[blockNumber]; delta != nil {
+			change.Add(change, delta)
+		}
+		balance := new(big.Int).Add(storedBalance, change)
+		if balance.Sign() < 0 {
+			change.Sub(change, balance)
+			balance.SetInt64(0)
+		}
+		valuesOfBalances = append(valuesOfBalances, []any{holder.holder, blockNumber, holder.token, bigIntToNumeric(balance)})
+	}
+	recordsPerStatement := maxParameters / len(tableColumnsErc20TokenBalances)
+	for from := 0; from < len(valuesOfBalances); from += recordsPerStatement {
+		to := from + recordsPerStatement
+		if to > len(valuesOfBalances) {
+			to = len(valuesOfBalances)
+		}
+		insertBuilder := repo.statementBuilder.
+			Insert(tableNameErc20TokenBalances).
+			Columns(tableColumnsErc20TokenBalances...).
+			Suffix(`ON CONFLICT ("address_id", "block_id", "token_address_id") DO UPDATE SET "balance" = EXCLUDED."balance"`)
+		for _, values := range valuesOfBalances[from:to] {
+			insertBuilder = insertBuilder.Values(values...)
+		}
+		if _, err = insertBuilder.Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Erc20TokenBalanceDiscrepancy is a holder whose latest stored token balance disagrees with the sum of its stored transfers.
+// StoredBalance is nil if no balance is stored at all.
+type Erc20TokenBalanceDiscrepancy struct {
+	HolderAddressId    storage.AddressId
+	TokenAddressId     storage.AddressId
+	StoredBalance      *big.Int
+	TransferredBalance *big.Int
+}
+
+type holderOfToken struct {
+	holder, token storage.AddressId
+}
+
+// ReconcileErc20TokenBalances compares the latest stored balance of every holder with the sum of its transfers.
+// When tokenAddressId is nil, all tokens are reconciled, otherwise only the given one.
+// The sums are computed by the database, only the discrepancies are returned, ordered by token and holder.
+func (repo *PostgresRepository) ReconcileErc20TokenBalances(tokenAddressId *storage.AddressId) (_ []*Erc20TokenBalanceDiscrepancy, err error) {
+	defer annotate(&err, "failed to reconcile ERC-20 token balances")
+	zeroAddressId, err := repo.zeroAddressId()
+	if err != nil {
+		return nil, err
+	}
+	var args []any
+	tokenFilter := ""
+	if tokenAddressId != nil {
+		args = append(args, *tokenAddressId)
+		tokenFilter = fmt.Sprintf(`WHERE "token_address_id" = $%d`, len(args))
+	}
+	holderFilter := ""
+	if !zeroAddressId.IsZero() {
+		args = append(args, zeroAddressId)
+		holderFilter = fmt.Sprintf(`WHERE "holder" <> $%d`, len(args))
+	}
+	query := fmt.Sprintf(`WITH "Transferred" AS (
+    SELECT "holder", "token_address_id", SUM("amount") AS "balance" FROM (
+        SELECT "from_address_id" AS "holder", "token_address_id", -"value" AS "amount" FROM %[1]s %[3]s
+        UNION ALL
+        SELECT "to_address_id" AS "holder", "token_address_id", "value" AS "amount" FROM %[1]s %[3]s
+    ) AS "SignedTransfers" %[4]s
+    GROUP BY "holder", "token_address_id"
+), "Stored" AS (
+    SELECT DISTINCT ON ("address_id", "token_address_id") "address_id" AS "holder", "token_address_id", "balance" FROM %[2]s %[3]s
+    ORDER BY "address_id", "token_address_id", "block_id" DESC
+)
+SELECT COALESCE("Transferred"."holder", "Stored"."holder") AS "holder",
+    COALESCE("Transferred"."token_address_id", "Stored"."token_address_id") AS "token_address_id",
+    "Stored"."balance", COALESCE("Transferred"."balance", 0)
+FROM "Transferred" FULL OUTER JOIN "Stored"
+    ON "Transferred"."holder" = "Stored"."holder" AND "Transferred"."token_address_id" = "Stored"."token_address_id"
+WHERE COALESCE("Stored"."balance", 0) <> COALESCE("Transferred"."balance", 0)
+ORDER BY "token_address_id", "holder"`,
+		tableNameErc20TokenTransfers, tableNameErc20TokenBalances, tokenFilter, holderFilter)
+	rows, err := repo.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var discrepancies []*Erc20TokenBalanceDiscrepancy
+	for rows.Next() {
+		var discrepancy Erc20TokenBalanceDiscrepancy
+		var storedBalance, transferredBalance numeric
+		if err = rows.Scan(&discrepancy.HolderAddressId, &discrepancy.TokenAddressId, &storedBalance, &transferredBalance); err != nil {
+			return nil, err
+		}
+		discrepancy.StoredBalance = storedBalance.Value
+		discrepancy.TransferredBalance = transferredBalance.Value
+		discrepancies = append(discrepancies, &discrepancy)
+	}
+	return discrepancies, rows.Err()
+}
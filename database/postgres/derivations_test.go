@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/convert"
+)
+
+// storeTransferTopic0 stores the topic0 of the Transfer event, which the transfers are derived from, and returns its id.
+func (chain *testChain) storeTransferTopic0() storage.Topic0Id {
+	chain.t.Helper()
+	ids, err := chain.repo.StoreTopic0(&storage.EventType{Hash: convert.Erc20TransferTopic})
+	if err != nil {
+		chain.t.Fatal(err)
+	}
+	return ids[0]
+}
+
+// transferLog returns the log of an ERC-20 transfer of the value, emitted by the token.
+func transferLog(transactionId storage.TransactionId, index storage.LogIndex, topic0Id storage.Topic0Id, token storage.AddressId, from, to common.Address, value int64) *storage.Log {
+	fromTopic, toTopic := [32]byte(common.BytesToHash(from.Bytes())), [32]byte(common.BytesToHash(to.Bytes()))
+	return &storage.Log{
+		LogId:     storage.LogId{TransactionId: transactionId, LogIndex: index},
+		AddressId: token,
+		Topic0Id:  &topic0Id,
+		Topic1:    &fromTopic,
+		Topic2:    &toTopic,
+		Data:      common.BigToHash(big.NewInt(value)).Bytes(),
+	}
+}
+
+func listTestTransfers(t *testing.T, repo *PostgresRepository) []*storage.Erc20TokenTransfer {
+	t.Helper()
+	transfers, _, err := repo.ListErc20TokenTransfers(nil, nil, &storage.OffsetPagination{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transfers
+}
+
+func TestDeriveErc20TokenTransfersSkipsOtherLogs(t *testing.T) {
+	t.Setenv(env_POSTGRES_DERIVE_ERC20_TRANSFERS, "true")
+	repo := newTestRepository(t, newTestDatabase(t))
+	chain := newTestChain(t, repo)
+	sender, recipient := common.HexToAddress("0xbb"), common.HexToAddress("0xcc")
+	senderId, recipientId, token := chain.address(sender), chain.address(recipient), chain.address(common.HexToAddress("0xdd"))
+	chain.storeBlocks(1)
+	transactionId := chain.storeTransaction(1, 0, senderId, token)
+	topic0Id := chain.storeTransferTopic0()
+
+	erc20 := transferLog(transactionId, 0, topic0Id, token, sender, recipient, 1000)
+	// ERC-721 transfers index the token id as a 4th topic, and have no data
+	erc721 := transferLog(transactionId, 1, topic0Id, token, sender, recipient, 0)
+	tokenIdTopic := [32]byte(common.BigToHash(big.NewInt(7)))
+	erc721.Topic3, erc721.Data = &tokenIdTopic, nil
+	short := transferLog(transactionId, 2, topic0Id, token, sender, recipient, 1000)
+	short.Data = short.Data[1:]
+	long := transferLog(transactionId, 3, topic0Id, token, sender, recipient, 1000)
+	long.Data = append(long.Data, long.Data...)
+	if err := repo.StoreLog(erc20, erc721, short, long); err != nil {
+		t.Fatal(err)
+	}
+	chain.commit()
+
+	want := []*storage.Erc20TokenTransfer{{
+		LogId:          erc20.LogId,
+		TokenAddressId: token,
+		FromAddressId:  senderId,
+		ToAddressId:    recipientId,
+		Value:          big.NewInt(1000),
+	}}
+	if transfers := listTestTransfers(t, repo); !reflect.DeepEqual(transfers, want) {
+		t.Errorf("derived transfers: got %+v, want only the ERC-20 transfer %+v", transfers, want[0])
+	}
+}
+
+func TestBackfillErc20TokenTransfersResumesFromCheckpoint(t *testing.T) {
+	repo := newTestRepository(t, newTestDatabase(t))
+	chain := newTestChain(t, repo)
+	sender, recipient := common.HexToAddress("0xbb"), common.HexToAddress("0xcc")
+	senderId, token := chain.address(sender), chain.address(common.HexToAddress("0xdd"))
+	chain.address(recipient)
+	chain.storeBlocks(1, 2, 3)
+	topic0Id := chain.storeTransferTopic0()
+	var transactionIds []storage.TransactionId
+	for blockNumber := storage.BlockNumber(1); blockNumber <= 3; blockNumber++ {
+		transactionId := chain.storeTransaction(blockNumber, 0, senderId, token)
+		if err := repo.StoreLog(transferLog(transactionId, 0, topic0Id, token, sender, recipient, int64(blockNumber))); err != nil {
+			t.Fatal(err)
+		}
+		transactionIds = append(transactionIds, transactionId)
+	}
+	chain.commit()
+	if transfers := listTestTransfers(t, repo); len(transfers) != 0 {
+		t.Fatalf("transfers are derived while not deriving: %+v", transfers)
+	}
+
+	// an earlier backfill stopped after the first transaction
+	if err := repo.storeCheckpoint(checkpointErc20TokenTransfers, transactionIds[0].Int64()); err != nil {
+		t.Fatal(err)
+	}
+	chain.commit()
+	var progress []int64
+	err := repo.BackfillErc20TokenTransfers(context.Background(), 1, func(lastTransactionId int64, logsProcessed int) {
+		if logsProcessed != 1 {
+			t.Errorf("batch of transaction %d processed %d logs, want 1", lastTransactionId, logsProcessed)
+		}
+		progress = append(progress, lastTransactionId)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{transactionIds[1].Int64(), transactionIds[2].Int64()}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("progress of the batches: got %v, want %v", progress, want)
+	}
+	transfers := listTestTransfers(t, repo)
+	derived := make(map[storage.TransactionId]bool)
+	for _, transfer := range transfers {
+		derived[transfer.TransactionId] = true
+	}
+	if len(transfers) != 2 || derived[transactionIds[0]] || !derived[transactionIds[1]] || !derived[transactionIds[2]] {
+		t.Errorf("backfill resumed from transaction %v derived %+v, want the transfers of the later transactions only", transactionIds[0], transfers)
+	}
+	if checkpoint, err := repo.getCheckpoint(checkpointErc20TokenTransfers); err != nil {
+		t.Fatal(err)
+	} else if checkpoint != transactionIds[2].Int64() {
+		t.Errorf("checkpoint after the backfill: got %d, want %d", checkpoint, transactionIds[2].Int64())
+	}
+
+	// a completed backfill finds nothing left to derive
+	progress = nil
+	if err = repo.BackfillErc20TokenTransfers(context.Background(), 1, func(lastTransactionId int64, _ int) { progress = append(progress, lastTransactionId) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 0 || len(listTestTransfers(t, repo)) != 2 {
+		t.Errorf("backfill of a completed checkpoint processed the transactions %v", progress)
+	}
+}
+
+// balanceHistory returns the stored balances of the holder of the token, by block.
+func balanceHistory(t *testing.T, repo *PostgresRepository, holder, token storage.AddressId) map[storage.BlockNumber]int64 {
+	t.Helper()
+	rows, err := repo.statementBuilder.
+		Select("block_id", "balance").
+		From(tableNameErc20TokenBalances).
+		Where("address_id = ?", holder).
+		Where("token_address_id = ?", token).
+		Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	history := make(map[storage.BlockNumber]int64)
+	for rows.Next() {
+		var blockNumber storage.BlockNumber
+		var balance numeric
+		if err = rows.Scan(&blockNumber, &balance); err != nil {
+			t.Fatal(err)
+		}
+		history[blockNumber] = balance.Value.Int64()
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+// newBalanceTestChain returns a chain deriving the balances, of blocks 1 to 3 having a transaction each,
+// and the ids of the zero address, two holders and the token.
+func newBalanceTestChain(t *testing.T) (chain *testChain, transactionIds []storage.TransactionId, zero, alice, bob, token storage.AddressId) {
+	t.Helper()
+	t.Setenv(env_POSTGRES_DERIVE_ERC20_BALANCES, "true")
+	chain = newTestChain(t, newTestRepository(t, newTestDatabase(t)))
+	zero, alice, bob = chain.address(common.Address{}), chain.address(common.HexToAddress("0xa1")), chain.address(common.HexToAddress("0xb0"))
+	token = chain.address(common.HexToAddress("0xdd"))
+	chain.storeBlocks(1, 2, 3)
+	for blockNumber := storage.BlockNumber(1); blockNumber <= 3; blockNumber++ {
+		transactionIds = append(transactionIds, chain.storeTransaction(blockNumber, 0, alice, token))
+	}
+	chain.commit()
+	return
+}
+
+// storeTransfer stores the transfer of the value in the transaction, and commits it.
+func (chain *testChain) storeTransfer(transactionId storage.TransactionId, token, from, to storage.AddressId, value int64) {
+	chain.t.Helper()
+	err := chain.repo.StoreErc20TokenTransfer(&storage.Erc20TokenTransfer{
+		LogId:          storage.LogId{TransactionId: transactionId},
+		TokenAddressId: token,
+		FromAddressId:  from,
+		ToAddressId:    to,
+		Value:          big.NewInt(value),
+	})
+	if err != nil {
+		chain.t.Fatal(err)
+	}
+	chain.commit()
+}
+
+func checkBalanceHistory(t *testing.T, repo *PostgresRepository, name string, holder, token storage.AddressId, want map[storage.BlockNumber]int64) {
+	t.Helper()
+	if history := balanceHistory(t, repo, holder, token); !reflect.DeepEqual(history, want) {
+		t.Errorf("balances of %s by block: got %v, want %v", name, history, want)
+	}
+}
+
+func TestDeriveErc20TokenBalancesOfMintsAndBurns(t *testing.T) {
+	chain, transactionIds, zero, alice, bob, token := newBalanceTestChain(t)
+	chain.storeTransfer(transactionIds[0], token, zero, alice, 100)
+	chain.storeTransfer(transactionIds[1], token, alice, bob, 30)
+	chain.storeTransfer(transactionIds[2], token, bob, zero, 10)
+
+	checkBalanceHistory(t, chain.repo, "the minter", alice, token, map[storage.BlockNumber]int64{1: 100, 2: 70})
+	checkBalanceHistory(t, chain.repo, "the burner", bob, token, map[storage.BlockNumber]int64{2: 30, 3: 20})
+	checkBalanceHistory(t, chain.repo, "the zero address", zero, token, map[storage.BlockNumber]int64{})
+	if discrepancies, err := chain.repo.ReconcileErc20TokenBalances(&token); err != nil {
+		t.Fatal(err)
+	} else if len(discrepancies) != 0 {
+		t.Errorf("derived balances disagree with the transfers: %+v", discrepancies[0])
+	}
+
+	// the derived balances are the only ones stored
+	if err := chain.repo.StoreErc20TokenBalance(&storage.Erc20TokenBalance{BlockNumber: 3, AddressId: alice, TokenAddressId: token, Balance: big.NewInt(999)}); err != nil {
+		t.Fatal(err)
+	}
+	chain.commit()
+	checkBalanceHistory(t, chain.repo, "the holder whose balance is stored", alice, token, map[storage.BlockNumber]int64{1: 100, 2: 70})
+}
+
+func TestDeriveErc20TokenBalancesOfTransfersStoredOutOfOrder(t *testing.T) {
+	chain, transactionIds, zero, alice, bob, token := newBalanceTestChain(t)
+	chain.storeTransfer(transactionIds[0], token, zero, alice, 100)
+	chain.storeTransfer(transactionIds[2], token, alice, bob, 30)
+	// the transfer of block 2 changes the balances already derived for block 3
+	chain.storeTransfer(transactionIds[1], token, alice, bob, 20)
+
+	checkBalanceHistory(t, chain.repo, "the sender", alice, token, map[storage.BlockNumber]int64{1: 100, 2: 80, 3: 50})
+	checkBalanceHistory(t, chain.repo, "the recipient", bob, token, map[storage.BlockNumber]int64{2: 20, 3: 50})
+}
+
+func TestDeriveErc20TokenBalancesClampsToZero(t *testing.T) {
+	chain, transactionIds, _, alice, bob, token := newBalanceTestChain(t)
+	// the tokens bob sends were received before the stored transfers
+	chain.storeTransfer(transactionIds[0], token, bob, alice, 50)
+	chain.storeTransfer(transactionIds[1], token, alice, bob, 20)
+
+	checkBalanceHistory(t, chain.repo, "the sender of unknown tokens", bob, token, map[storage.BlockNumber]int64{1: 0, 2: 20})
+	checkBalanceHistory(t, chain.repo, "the recipient", alice, token, map[storage.BlockNumber]int64{1: 50, 2: 30})
+	discrepancies, err := chain.repo.ReconcileErc20TokenBalances(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Erc20TokenBalanceDiscrepancy{{HolderAddressId: bob, TokenAddressId: token, StoredBalance: big.NewInt(20), TransferredBalance: big.NewInt(-30)}}
+	if len(discrepancies) != 1 || discrepancies[0].HolderAddressId != bob || discrepancies[0].TokenAddressId != token ||
+		discrepancies[0].StoredBalance.Cmp(want[0].StoredBalance) != 0 || discrepancies[0].TransferredBalance.Cmp(want[0].TransferredBalance) != 0 {
+		t.Errorf("discrepancies: got %+v, want %+v", discrepancies, want)
+	}
+}
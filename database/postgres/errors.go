@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// storageError attaches the storage errors matching a postgres error, keeping the original error in the chain.
+type storageError struct {
+	kinds []error
+	err   error
+}
+
+func (err *storageError) Error() string {
+	return err.err.Error()
+}
+func (err *storageError) Unwrap() []error {
+	return append(append([]error(nil), err.kinds...), err.err)
+}
+
+// errorKindsByCode maps the error codes which are handled individually, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+var errorKindsByCode = map[pq.ErrorCode][]error{
+	"23505": {storage.ErrConflict},                        // unique_violation
+	"23P01": {storage.ErrConflict},                        // exclusion_violation
+	"23503": {storage.ErrConstraint, storage.ErrNotFound}, // foreign_key_violation
+	"22003": {storage.ErrConstraint},                      // numeric_value_out_of_range
+	"22001": {storage.ErrConstraint},                      // string_data_right_truncation
+	"40001": {storage.ErrRetryable},                       // serialization_failure
+	"40P01": {storage.ErrRetryable},                       // deadlock_detected
+	"53300": {storage.ErrRetryable},                       // too_many_connections
+	"57P01": {storage.ErrRetryable},                       // admin_shutdown
+	"57P02": {storage.ErrRetryable},                       // crash_shutdown
+	"57P03": {storage.ErrRetryable},                       // cannot_connect_now
+	"57014": {storage.ErrTimeout},                         // query_canceled, also raised by statement_timeout
+	"55P03": {storage.ErrTimeout, storage.ErrRetryable},   // lock_not_available, raised by lock_timeout
+	"25P03": {storage.ErrTimeout, storage.ErrRetryable},   // idle_in_transaction_session_timeout
+	"42P01": {storage.ErrSchemaMismatch},                  // undefined_table
+	"42703": {storage.ErrSchemaMismatch},                  // undefined_column
+	"42704": {storage.ErrSchemaMismatch},                  // undefined_object
+	"42883": {storage.ErrSchemaMismatch},                  // undefined_function
+	"3D000": {storage.ErrSchemaMismatch},                  // invalid_catalog_name
+	"3F000": {storage.ErrSchemaMismatch},                  // invalid_schema_name
+}
+
+// errorKindsByClass maps the error classes not handled individually by errorKindsByCode.
+var errorKindsByClass = map[pq.ErrorClass][]error{
+	"08": {storage.ErrRetryable},  // connection_exception
+	"23": {storage.ErrConstraint}, // integrity_constraint_violation
+}
+
+// errorKinds returns the storage errors matching err, or nil if there are none.
+func errorKinds(err error) []error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if kinds, found := errorKindsByCode[pqErr.Code]; found {
+			return kinds
+		}
+		return errorKindsByClass[pqErr.Code.Class()]
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return []error{storage.ErrNotFound}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return []error{storage.ErrTimeout}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return []error{storage.ErrRetryable}
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return []error{storage.ErrTimeout, storage.ErrRetryable}
+		}
+		return []error{storage.ErrRetryable}
+	}
+	return nil
+}
+
+// mapError wraps err into the storage errors matching it, unless that was done already.
+func mapError(err error) error {
+	var mapped *storageError
+	if err == nil || errors.As(err, &mapped) {
+		return err
+	}
+	kinds := errorKinds(err)
+	if kinds == nil {
+		return err
+	}
+	return &storageError{kinds: kinds, err: err}
+}
+
+// annotate maps *err to the storage errors, and prefixes it with the failed operation naming the entity and the key.
+// Every exported method defers it on its named error result.
+func annotate(err *error, format string, args ...any) {
+	if *err == nil {
+		return
+	}
+	*err = fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), mapError(*err))
+}
@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// QueryPlan is the plan of a query run by a Reader method.
+type QueryPlan struct {
+	Query string
+	// SequentialScans lists the tables read by a sequential scan although sequential scans were disabled,
+	// i.e. the tables lacking an index usable by the query.
+	SequentialScans []string
+}
+
+// explainingRunner runs every query after explaining it, recording the sequential scans of its plan.
+type explainingRunner struct {
+	tx    *sql.Tx
+	plans []QueryPlan
+	err   error
+}
+
+func (runner *explainingRunner) explain(query string, args []any) {
+	if runner.err != nil {
+		return
+	}
+	// the planner only falls back to a sequential scan if no index can be used
+	if _, runner.err = runner.tx.Exec("SET LOCAL enable_seqscan = off"); runner.err != nil {
+		return
+	}
+	var planJSON []byte
+	if runner.err = runner.tx.QueryRow("EXPLAIN (FORMAT JSON) "+query, args...).Scan(&planJSON); runner.err != nil {
+		runner.err = fmt.Errorf("failed to explain %q: %w", query, runner.err)
+		return
+	}
+	if _, runner.err = runner.tx.Exec("RESET enable_seqscan"); runner.err != nil {
+		return
+	}
+	var plans []struct {
+		Plan planNode `json:"Plan"`
+	}
+	if runner.err = json.Unmarshal(planJSON, &plans); runner.err != nil {
+		return
+	}
+	plan := QueryPlan{Query: query}
+	for _, explained := range plans {
+		explained.Plan.collectSequentialScans(&plan.SequentialScans)
+	}
+	runner.plans = append(runner.plans, plan)
+}
+func (runner *explainingRunner) Exec(query string, args ...any) (sql.Result, error) {
+	return runner.tx.Exec(query, args...)
+}
+func (runner *explainingRunner) Query(query string, args ...any) (*sql.Rows, error) {
+	runner.explain(query, args)
+	return runner.tx.Query(query, args...)
+}
+func (runner *explainingRunner) QueryRow(query string, args ...any) *sql.Row {
+	runner.explain(query, args)
+	return runner.tx.QueryRow(query, args...)
+}
+
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	Plans        []planNode `json:"Plans"`
+}
+
+func (node *planNode) collectSequentialScans(tables *[]string) {
+	if node.NodeType == "Seq Scan" {
+		*tables = append(*tables, node.RelationName)
+	}
+	for i := range node.Plans {
+		node.Plans[i].collectSequentialScans(tables)
+	}
+}
+
+// queryRow runs the query through the explaining runner while ExplainReaderQueries is in progress.
+// Otherwise the buffered records are flushed first, see writeBuffer.
+func (repo *PostgresRepository) queryRow(query string, args ...any) ScannerWithErrHandling {
+	if repo.explainer != nil {
+		return repo.explainer.QueryRow(query, args...)
+	}
+	if err := repo.flushForRead(); err != nil {
+		return failedRow{err}
+	}
+	return repo.runner.queryRow(query, args...)
+}
+
+// ExplainReaderQueries calls the given function with the repository as Reader,
+// and returns the plans of all queries run meanwhile.
+// It is meant to catch Reader methods lacking a supporting index, see TestReaderQueryPlans.
+func (repo *PostgresRepository) ExplainReaderQueries(call func(storage.Reader) error) ([]QueryPlan, error) {
+	runner := &explainingRunner{tx: repo.runner.tx}
+	statementBuilder := repo.statementBuilder
+	repo.explainer = runner
+	repo.statementBuilder = sq.StatementBuilder.RunWith(runner).PlaceholderFormat(sq.Dollar)
+	defer func() {
+		repo.explainer = nil
+		repo.statementBuilder = statementBuilder
+	}()
+	if err := call(repo); err != nil {
+		return runner.plans, err
+	}
+	return runner.plans, runner.err
+}
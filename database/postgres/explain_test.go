@@ -0,0 +1,181 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/storagetest"
+)
+
+// largeTables are the tables growing with the chain, which no Reader method may read by a sequential scan.
+// The other tables stay small, e.g. "FirstTopics" or "Partitioning".
+var largeTables = map[string]bool{
+	"Addresses":           true,
+	"Blocks":              true,
+	"Uncles":              true,
+	"Transactions":        true,
+	"Receipts":            true,
+	"Logs":                true,
+	"Erc20TokenTransfers": true,
+	"Traces":              true,
+	"EtherBalances":       true,
+	"Erc20TokenBalances":  true,
+	"StateChanges":        true,
+	"StorageChanges":      true,
+	"StorageKeys":         true,
+	"Contracts":           true,
+}
+
+type readerCall struct {
+	name string
+	call func(storage.Reader) error
+}
+
+// readerCalls calls every Reader method once, with arguments taken from the fixture.
+func readerCalls(fixture *storagetest.Fixture) []readerCall {
+	pagination := storage.OffsetPagination{Limit: 10}
+	blockNumber := fixture.Blocks[1].Number
+	blockHash := fixture.Blocks[1].Hash
+	transactionId := fixture.TransactionIds[1]
+	transactionHash := fixture.Transactions[1].Hash
+	logId := fixture.Logs[0].LogId
+	return []readerCall{
+		{"ListBlocks", func(reader storage.Reader) error { _, _, err := reader.ListBlocks(pagination); return err }},
+		{"ListUnclesByBlockNumber", func(reader storage.Reader) error { _, err := reader.ListUnclesByBlockNumber(blockNumber); return err }},
+		{"ListTransactions", func(reader storage.Reader) error { _, _, _, err := reader.ListTransactions(pagination); return err }},
+		{"ListTransactionsByBlockNumber", func(reader storage.Reader) error {
+			_, _, _, err := reader.ListTransactionsByBlockNumber(blockNumber, &pagination)
+			return err
+		}},
+		{"ListTransactionsByAddress", func(reader storage.Reader) error {
+			_, _, _, err := reader.ListTransactionsByAddress(fixture.Sender, pagination)
+			return err
+		}},
+		{"ListTransactionsByFilter", func(reader storage.Reader) error {
+			_, _, _, err := reader.ListTransactionsByFilter(storage.TransactionFilter{Address: &fixture.Sender, MinValue: common.Big1}, pagination)
+			return err
+		}},
+		{"ListStorageKeysByTransactionId", func(reader storage.Reader) error {
+			_, err := reader.ListStorageKeysByTransactionId(transactionId)
+			return err
+		}},
+		{"ListLogsByTransactionId", func(reader storage.Reader) error { _, err := reader.ListLogsByTransactionId(transactionId); return err }},
+		{"ListLogsByFilter", func(reader storage.Reader) error {
+			_, err := reader.ListLogsByFilter(storage.LogFilter{
+				ToBlock:   blockNumber,
+				Addresses: []common.Address{fixture.Token},
+				Topics:    [][]common.Hash{{fixture.EventType.Hash}},
+			}, &pagination)
+			return err
+		}},
+		{"ListErc20TokenTransfers by token", func(reader storage.Reader) error {
+			_, _, err := reader.ListErc20TokenTransfers(&fixture.Token, nil, &pagination)
+			return err
+		}},
+		{"ListErc20TokenTransfers by address", func(reader storage.Reader) error {
+			_, _, err := reader.ListErc20TokenTransfers(nil, &fixture.Sender, &pagination)
+			return err
+		}},
+		{"ListTraces", func(reader storage.Reader) error { _, _, _, _, err := reader.ListTraces(&pagination); return err }},
+		{"ListTracesByTransactionHash", func(reader storage.Reader) error {
+			_, _, _, err := reader.ListTracesByTransactionHash(&transactionHash)
+			return err
+		}},
+		{"ListTracesByBlockNumber", func(reader storage.Reader) error {
+			_, _, _, err := reader.ListTracesByBlockNumber(blockNumber, &pagination)
+			return err
+		}},
+		{"ListTracesByAddress", func(reader storage.Reader) error {
+			_, _, _, _, err := reader.ListTracesByAddress(fixture.Sender, storage.DirectionAny, true, &pagination)
+			return err
+		}},
+		{"ListErc20TokenBalancesAtBlock", func(reader storage.Reader) error {
+			_, err := reader.ListErc20TokenBalancesAtBlock(fixture.SenderId, blockNumber)
+			return err
+		}},
+		{"ListErc20TokenHolders", func(reader storage.Reader) error {
+			_, _, err := reader.ListErc20TokenHolders(fixture.TokenId, blockNumber, pagination)
+			return err
+		}},
+		{"ListStateChangesByTransactionHash", func(reader storage.Reader) error {
+			_, err := reader.ListStateChangesByTransactionHash(&transactionHash)
+			return err
+		}},
+		{"ListMissingBlockRanges", func(reader storage.Reader) error { _, err := reader.ListMissingBlockRanges(0, blockNumber); return err }},
+		{"GetAddressById", func(reader storage.Reader) error { _, err := reader.GetAddressById(fixture.SenderId); return err }},
+		{"GetAddressIdByHash", func(reader storage.Reader) error { _, err := reader.GetAddressIdByHash(fixture.Sender); return err }},
+		{"GetBlockByHash", func(reader storage.Reader) error { _, err := reader.GetBlockByHash(&blockHash); return err }},
+		{"GetBlockByNumber", func(reader storage.Reader) error { _, err := reader.GetBlockByNumber(blockNumber); return err }},
+		{"GetLatestBlockNumber", func(reader storage.Reader) error { _, err := reader.GetLatestBlockNumber(); return err }},
+		{"GetUncleByUncleHash", func(reader storage.Reader) error {
+			_, err := reader.GetUncleByUncleHash(&fixture.Uncles[0].Hash)
+			return err
+		}},
+		{"GetCallTree", func(reader storage.Reader) error { _, err := reader.GetCallTree(&transactionHash); return err }},
+		{"GetTransactionById", func(reader storage.Reader) error { _, err := reader.GetTransactionById(transactionId); return err }},
+		{"GetTransactionByHash", func(reader storage.Reader) error {
+			_, _, err := reader.GetTransactionByHash(&transactionHash)
+			return err
+		}},
+		{"GetReceiptByTransactionId", func(reader storage.Reader) error {
+			_, err := reader.GetReceiptByTransactionId(transactionId)
+			return err
+		}},
+		{"GetByteCode", func(reader storage.Reader) error { _, err := reader.GetByteCode(fixture.BytecodeId); return err }},
+		{"GetLogById", func(reader storage.Reader) error { _, err := reader.GetLogById(logId); return err }},
+		{"GetErc20TokenByAddressId", func(reader storage.Reader) error {
+			_, err := reader.GetErc20TokenByAddressId(fixture.TokenId)
+			return err
+		}},
+		{"GetContractByAddressId", func(reader storage.Reader) error {
+			_, err := reader.GetContractByAddressId(fixture.ContractId)
+			return err
+		}},
+		{"GetEventTypeById", func(reader storage.Reader) error { _, err := reader.GetEventTypeById(fixture.Topic0Id); return err }},
+		{"GetWeiBalanceAtBlock", func(reader storage.Reader) error {
+			_, err := reader.GetWeiBalanceAtBlock(fixture.SenderId, blockNumber)
+			return err
+		}},
+		{"GetLastStoredEtherBalance", func(reader storage.Reader) error {
+			_, err := reader.GetLastStoredEtherBalance(fixture.SenderId)
+			return err
+		}},
+		{"GetLastStoredErc20TokenBalance", func(reader storage.Reader) error {
+			_, err := reader.GetLastStoredErc20TokenBalance(fixture.SenderId, fixture.TokenId)
+			return err
+		}},
+		{"GetFirstTxSent", func(reader storage.Reader) error { _, err := reader.GetFirstTxSent(fixture.SenderId); return err }},
+		{"GetLastTxSent", func(reader storage.Reader) error { _, err := reader.GetLastTxSent(fixture.SenderId); return err }},
+		{"GetErc20TokenHolders", func(reader storage.Reader) error { _, err := reader.GetErc20TokenHolders(fixture.TokenId); return err }},
+	}
+}
+
+// TestReaderQueryPlans fails for Reader methods reading a large table by a sequential scan, i.e. lacking a supporting index.
+func TestReaderQueryPlans(t *testing.T) {
+	repo := newTestRepository(t, newTestDatabase(t))
+	fixture := storagetest.Seed(t, repo)
+	explained := 0
+	for _, readerCall := range readerCalls(fixture) {
+		plans, err := repo.ExplainReaderQueries(readerCall.call)
+		if err != nil {
+			t.Fatalf("%s: %v", readerCall.name, err)
+		}
+		explained += len(plans)
+		for _, plan := range plans {
+			var scannedTables []string
+			for _, table := range plan.SequentialScans {
+				if largeTables[table] {
+					scannedTables = append(scannedTables, table)
+				}
+			}
+			if len(scannedTables) != 0 {
+				t.Errorf("%s reads %s by a sequential scan in:\n%s", readerCall.name, strings.Join(scannedTables, ", "), plan.Query)
+			}
+		}
+	}
+	if explained == 0 {
+		t.Error("no queries were explained")
+	}
+}
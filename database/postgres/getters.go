@@ -10,9 +10,10 @@ import (
 	// _ "github.com/jackc/pgx/v5/stdlib"
 )
 
-func (repo *PostgresRepository) GetEventTypeById(eventTypeId storage.Topic0Id) (*storage.EventType, error) {
+func (repo *PostgresRepository) GetEventTypeById(eventTypeId storage.Topic0Id) (_ *storage.EventType, err error) {
+	defer annotate(&err, "failed to get event type %v", eventTypeId)
 	var eventType storage.EventType
-	err := repo.statementBuilder.
+	err = repo.statementBuilder.
 		Select(tableColumnsEventTypes[1:]...).
 		From(tableNameEventTypes).
 		Where("id = ?", eventTypeId).
@@ -30,68 +31,75 @@ func (repo *PostgresRepository) getBlock(keyColumnName string, key any) (*storag
 		Select(tableColumnsBlocks...).
 		From(tableNameBlocks).
 		Where(fmt.Sprintf(`"%s" = ?`, keyColumnName), key).MustSql()
-	return scanBlock(repo.tx.QueryRow(query, key))
+	return scanBlock(repo.queryRow(query, key))
 }
-func (repo *PostgresRepository) GetBlockByHash(hash *common.Hash) (*storage.Block, error) {
+func (repo *PostgresRepository) GetBlockByHash(hash *common.Hash) (_ *storage.Block, err error) {
+	defer annotate(&err, "failed to get block %v", hash)
 	return repo.getBlock("hash", hash)
 }
-func (repo *PostgresRepository) GetBlockByNumber(number uint64) (*storage.Block, error) {
+func (repo *PostgresRepository) GetBlockByNumber(number uint64) (_ *storage.Block, err error) {
+	defer annotate(&err, "failed to get block %d", number)
 	return repo.getBlock("number", number)
 }
-func (repo *PostgresRepository) GetTransactionById(transactionId storage.TransactionId) (*storage.Transaction, error) {
+func (repo *PostgresRepository) GetTransactionById(transactionId storage.TransactionId) (_ *storage.Transaction, err error) {
+	defer annotate(&err, "failed to get transaction %v", transactionId)
 	query, args := repo.statementBuilder.
 		Select(tableColumnsTransactions...).
 		From(tableNameTransactions).
 		Where("id = ?", transactionId).
 		MustSql()
-	transaction, _, err := scanTransaction(repo.tx.QueryRow(query, args...))
+	transaction, _, err := scanTransaction(repo.queryRow(query, args...))
 	return transaction, err
 }
-func (repo *PostgresRepository) GetTransactionByHash(transactionHash *common.Hash) (*storage.Transaction, storage.TransactionId, error) {
+func (repo *PostgresRepository) GetTransactionByHash(transactionHash *common.Hash) (_ *storage.Transaction, _ storage.TransactionId, err error) {
+	defer annotate(&err, "failed to get transaction %v", transactionHash)
 	query, args := repo.statementBuilder.
 		Select(tableColumnsTransactions...).
 		From(tableNameTransactions).
 		Where("hash = ?", transactionHash).
 		MustSql()
-	return scanTransaction(repo.tx.QueryRow(query, args...))
+	return scanTransaction(repo.queryRow(query, args...))
 }
-func (repo *PostgresRepository) GetAddressById(addressId storage.AddressId) (*common.Address, error) {
+func (repo *PostgresRepository) GetAddressById(addressId storage.AddressId) (_ *common.Address, err error) {
+	defer annotate(&err, "failed to get address %v", addressId)
 	var address common.Address
-	err := repo.statementBuilder.
+	err = repo.statementBuilder.
 		Select("hash").
 		From(tableNameAddresses).
 		Where("id = ?", addressId).
 		Scan(&address)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 	return &address, nil
 }
-func (repo *PostgresRepository) GetAddressIdByHash(addressHash common.Address) (storage.AddressId, error) {
-	var addressId int64
-	err := repo.statementBuilder.
+func (repo *PostgresRepository) GetAddressIdByHash(addressHash common.Address) (_ storage.AddressId, err error) {
+	defer annotate(&err, "failed to get id of address %v", addressHash)
+	var addressId storage.AddressId
+	err = repo.statementBuilder.
 		Select("id").
 		From(tableNameAddresses).
 		Where(`"hash" = ?`, addressHash).
 		Scan(&addressId)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
+	if err != nil && err != sql.ErrNoRows {
+		return storage.AddressId{}, err
 	}
-	return postgresSerialId(addressId), nil
+	return addressId, nil
 }
-func (repo *PostgresRepository) GetReceiptByTransactionId(transactionId storage.TransactionId) (*storage.Receipt, error) {
+func (repo *PostgresRepository) GetReceiptByTransactionId(transactionId storage.TransactionId) (_ *storage.Receipt, err error) {
+	defer annotate(&err, "failed to get receipt of transaction %v", transactionId)
 	var receipt storage.Receipt
-	var gasUsed, cumulativeGasUsed, effectiveGasPrice []byte
-	var contractAddressId *postgresSerialId
-	err := repo.statementBuilder.
+	var effectiveGasPrice numeric
+	var contractAddressId storage.AddressId
+	err = repo.statementBuilder.
 		Select(tableColumnsReceipts[1:]...).
 		From(tableNameReceipts).
 		Where("transaction_id = ?", transactionId).
-		Scan(&cumulativeGasUsed,
-			&gasUsed,
+		Scan(&receipt.CumulativeGasUsed,
+			&receipt.GasUsed,
 			&contractAddressId,
 			&receipt.PostState,
 			&receipt.Status,
@@ -102,28 +110,35 @@ func (repo *PostgresRepository) GetReceiptByTransactionId(transactionId storage.
 		}
 		return nil, err
 	}
-	a := storage.AddressId(contractAddressId)
 	receipt.TransactionId = transactionId
-	receipt.ContractAddressId = &a
-	receipt.GasUsed = bytesToUint64(gasUsed)
-	receipt.CumulativeGasUsed = bytesToUint64(cumulativeGasUsed)
-	receipt.EffectiveGasPrice = new(big.Int).SetBytes(effectiveGasPrice)
+	if !contractAddressId.IsZero() {
+		receipt.ContractAddressId = &contractAddressId
+	}
+	receipt.EffectiveGasPrice = effectiveGasPrice.Value
 	return &receipt, nil
 }
-func (repo *PostgresRepository) GetByteCode(bytecodeId storage.BytecodeId) (bytecode *storage.Bytecode, err error) {
-	err = repo.statementBuilder.Select("bytecode").From(`"Bytecodes"`).Where("id = ?", bytecodeId).Scan(&bytecode)
-	return
+func (repo *PostgresRepository) GetByteCode(bytecodeId storage.BytecodeId) (_ *storage.Bytecode, err error) {
+	defer annotate(&err, "failed to get bytecode %v", bytecodeId)
+	var bytecode storage.Bytecode
+	err = repo.statementBuilder.Select("bytecode").From(tableNameBytecodes).Where("id = ?", bytecodeId).Scan(&bytecode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &bytecode, nil
 }
-func (repo *PostgresRepository) GetLogById(logId storage.LogId) (*storage.Log, error) {
+func (repo *PostgresRepository) GetLogById(logId storage.LogId) (_ *storage.Log, err error) {
+	defer annotate(&err, "failed to get log %d of transaction %v", logId.LogIndex, logId.TransactionId)
 	var log storage.Log
-	var index []byte
-	err := repo.statementBuilder.
+	err = repo.statementBuilder.
 		Select(tableColumnsLogs...).
 		From(tableNameLogs).
 		Where("transaction_id = ?", logId.TransactionId).
-		Where(`"index" = ?`, uint64ToBytes(logId.LogIndex)).
+		Where(`"index" = ?`, logId.LogIndex).
 		Scan(&log.TransactionId,
-			&index,
+			&log.LogIndex,
 			&log.AddressId,
 			&log.Topic0Id,
 			&log.Topic1,
@@ -136,15 +151,15 @@ func (repo *PostgresRepository) GetLogById(logId storage.LogId) (*storage.Log, e
 		}
 		return nil, err
 	}
-	log.LogIndex = bytesToUint64(index)
 	return &log, nil
 }
-func (repo *PostgresRepository) GetErc20TokenByAddressId(addressId storage.AddressId) (*storage.Erc20Token, error) {
+func (repo *PostgresRepository) GetErc20TokenByAddressId(addressId storage.AddressId) (_ *storage.Erc20Token, err error) {
+	defer annotate(&err, "failed to get ERC-20 token %v", addressId)
 	erc20Token := storage.Erc20Token{
 		AddressId: addressId,
 	}
-	var totalSupply []byte
-	err := repo.statementBuilder.
+	var totalSupply numeric
+	err = repo.statementBuilder.
 		Select(tableColumnsErc20Tokens[1:]...).
 		From(tableNameErc20Tokens).
 		Where("address_id = ?", addressId).
@@ -158,18 +173,23 @@ func (repo *PostgresRepository) GetErc20TokenByAddressId(addressId storage.Addre
 		}
 		return nil, err
 	}
-	erc20Token.TotalSupply = new(big.Int).SetBytes(totalSupply)
+	erc20Token.TotalSupply = totalSupply.Value
 	return &erc20Token, err
 }
-func (repo *PostgresRepository) GetContractByAddressId(addressId storage.AddressId) (*storage.Contract, error) {
-	var transactionId, bytecodeId postgresSerialId
-	err := repo.statementBuilder.
+func (repo *PostgresRepository) GetContractByAddressId(addressId storage.AddressId) (_ *storage.Contract, err error) {
+	defer annotate(&err, "failed to get contract %v", addressId)
+	var transactionId storage.TransactionId
+	var bytecodeId storage.BytecodeId
+	err = repo.statementBuilder.
 		Select(tableColumnsContracts[1:]...).
 		From(tableNameContracts).
 		Where("address_id = ?", addressId).
 		Scan(&transactionId, &bytecodeId)
-	if err == sql.ErrNoRows {
-		return nil, nil
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
 	}
 	return &storage.Contract{
 		AddressId:     addressId,
@@ -177,10 +197,11 @@ func (repo *PostgresRepository) GetContractByAddressId(addressId storage.Address
 		BytecodeId:    bytecodeId,
 	}, nil
 }
-func (repo *PostgresRepository) GetLastStoredEtherBalance(addressId storage.AddressId) (*storage.EtherBalance, error) {
+func (repo *PostgresRepository) GetLastStoredEtherBalance(addressId storage.AddressId) (_ *storage.EtherBalance, err error) {
+	defer annotate(&err, "failed to get last ether balance of address %v", addressId)
 	var etherBalance storage.EtherBalance
-	var balance []byte
-	err := repo.statementBuilder.
+	var balance numeric
+	err = repo.statementBuilder.
 		Select("block_id", "balance").
 		From(tableNameEtherBalances).
 		Where("address_id = ?", addressId).
@@ -193,13 +214,14 @@ func (repo *PostgresRepository) GetLastStoredEtherBalance(addressId storage.Addr
 		return nil, err
 	}
 	etherBalance.AddressId = addressId
-	etherBalance.Balance = new(big.Int).SetBytes(balance)
+	etherBalance.Balance = balance.Value
 	return &etherBalance, err
 }
-func (repo *PostgresRepository) GetLastStoredErc20TokenBalance(addressId, tokenAddressId storage.AddressId) (*storage.Erc20TokenBalance, error) {
+func (repo *PostgresRepository) GetLastStoredErc20TokenBalance(addressId, tokenAddressId storage.AddressId) (_ *storage.Erc20TokenBalance, err error) {
+	defer annotate(&err, "failed to get last balance of address %v of token %v", addressId, tokenAddressId)
 	var erc20TokenBalance storage.Erc20TokenBalance
-	var balance []byte
-	err := repo.statementBuilder.
+	var balance numeric
+	err = repo.statementBuilder.
 		Select("block_id", "balance").
 		From(tableNameErc20TokenBalances).
 		Where("address_id = ?", addressId).
@@ -215,20 +237,22 @@ func (repo *PostgresRepository) GetLastStoredErc20TokenBalance(addressId, tokenA
 	}
 	erc20TokenBalance.AddressId = addressId
 	erc20TokenBalance.TokenAddressId = tokenAddressId
-	erc20TokenBalance.Balance = new(big.Int).SetBytes(balance)
+	erc20TokenBalance.Balance = balance.Value
 	return &erc20TokenBalance, err
 }
-func (repo *PostgresRepository) GetLatestBlockNumber() (*storage.BlockNumber, error) {
+func (repo *PostgresRepository) GetLatestBlockNumber() (_ *storage.BlockNumber, err error) {
+	defer annotate(&err, "failed to get latest block number")
 	var blockNumber *storage.BlockNumber
-	err := repo.statementBuilder.Select("MAX(number)").From(tableNameBlocks).Scan(&blockNumber)
+	err = repo.statementBuilder.Select("MAX(number)").From(tableNameBlocks).Scan(&blockNumber)
 	if err != nil {
 		return nil, err
 	}
 	return blockNumber, err
 }
-func (repo *PostgresRepository) GetWeiBalanceAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (*big.Int, error) {
-	var balance []byte
-	err := repo.statementBuilder.
+func (repo *PostgresRepository) GetWeiBalanceAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (_ *big.Int, err error) {
+	defer annotate(&err, "failed to get balance of address %v at block %d", addressId, blockNumber)
+	var balance numeric
+	err = repo.statementBuilder.
 		Select("balance").
 		From(tableNameEtherBalances).
 		Where("address_id = ?", addressId).
@@ -242,51 +266,51 @@ func (repo *PostgresRepository) GetWeiBalanceAtBlock(addressId storage.AddressId
 		}
 		return nil, err
 	}
-	return new(big.Int).SetBytes(balance), nil
+	return balance.Value, nil
 }
-func (repo *PostgresRepository) GetFirstTxSent(addressId storage.AddressId) (*common.Hash, error) {
-	var oldestTxId *uint64
-	err := repo.statementBuilder.
+func (repo *PostgresRepository) GetFirstTxSent(addressId storage.AddressId) (_ *common.Hash, err error) {
+	defer annotate(&err, "failed to get first transaction sent by address %v", addressId)
+	var oldestTxId storage.TransactionId
+	err = repo.statementBuilder.
 		Select("MIN(id)").
 		From(tableNameTransactions).
 		Where("from_address_id = ?", addressId).Scan(&oldestTxId)
-	if err != nil || oldestTxId == nil {
+	if err != nil || oldestTxId.IsZero() {
 		return nil, err
 	}
-	firstTxSent, err := repo.GetTransactionById(postgresSerialId(*oldestTxId))
+	firstTxSent, err := repo.GetTransactionById(oldestTxId)
 	if err != nil {
 		return nil, err
 	}
 	return &firstTxSent.Hash, nil
 }
-func (repo *PostgresRepository) GetLastTxSent(addressId storage.AddressId) (*common.Hash, error) {
-	var latestTxId *uint64
-	err := repo.statementBuilder.
+func (repo *PostgresRepository) GetLastTxSent(addressId storage.AddressId) (_ *common.Hash, err error) {
+	defer annotate(&err, "failed to get last transaction sent by address %v", addressId)
+	var latestTxId storage.TransactionId
+	err = repo.statementBuilder.
 		Select("MAX(id)").
 		From(tableNameTransactions).
 		Where("from_address_id = ?", addressId).
 		Scan(&latestTxId)
-	if err != nil || latestTxId == nil {
+	if err != nil || latestTxId.IsZero() {
 		return nil, err
 	}
-	lastTxSent, err := repo.GetTransactionById(postgresSerialId(*latestTxId))
+	lastTxSent, err := repo.GetTransactionById(latestTxId)
 	if err != nil {
 		return nil, err
 	}
 	return &lastTxSent.Hash, nil
 }
 func (repo *PostgresRepository) GetErc20TokenHolders(erc20TokenId storage.Erc20TokenId) (holders uint64, err error) {
-	err = repo.statementBuilder.
-		Select("COUNT(DISTINCT token_address_id) AS holder_count").
-		From(tableNameErc20TokenBalances).
-		Where(`balance > E'\\x00'`).Scan(&holders)
-	return
+	defer annotate(&err, "failed to count holders of token %v", erc20TokenId)
+	return repo.listers().CountErc20TokenHolders(erc20TokenId, nil)
 }
-func (repo *PostgresRepository) GetUncleByUncleHash(uncleHash *common.Hash) (*storage.Uncle, error) {
+func (repo *PostgresRepository) GetUncleByUncleHash(uncleHash *common.Hash) (_ *storage.Uncle, err error) {
+	defer annotate(&err, "failed to get uncle %v", uncleHash)
 	var uncle storage.Uncle
-	var minerAddressId postgresSerialId
-	var difficulty, reward []byte
-	err := repo.statementBuilder.
+	var minerAddressId storage.AddressId
+	var difficulty, reward numeric
+	err = repo.statementBuilder.
 		Select(tableColumnsUncles...).
 		From(tableNameUncles).
 		Where("hash = ?", uncleHash).
@@ -303,10 +327,21 @@ func (repo *PostgresRepository) GetUncleByUncleHash(uncleHash *common.Hash) (*st
 			&reward,
 			&uncle.Timestamp)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 	uncle.MinerAddressId = minerAddressId
-	uncle.Difficulty = new(big.Int).SetBytes(difficulty)
-	uncle.Reward = new(big.Int).SetBytes(reward)
+	uncle.Difficulty = difficulty.Value
+	uncle.Reward = reward.Value
 	return &uncle, nil
 }
+func (repo *PostgresRepository) GetCallTree(transactionHash *common.Hash) (_ *storage.CallFrame, err error) {
+	defer annotate(&err, "failed to get call tree of transaction %v", transactionHash)
+	traces, _, _, err := repo.ListTracesByTransactionHash(transactionHash)
+	if err != nil {
+		return nil, err
+	}
+	return storage.BuildCallTree(traces)
+}
@@ -1,184 +1,408 @@
 package postgres
 
 import (
-	"crypto/sha256"
 	"database/sql"
-	"math/big"
+	"fmt"
+	"log"
+	"strconv"
 
-	sq "github.com/Masterminds/squirrel"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/lib/pq"
 	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
 )
 
 const on_conflict_do_nothing = "ON CONFLICT DO NOTHING"
 
-func executeBulkInsertIgnore[Record any, Records []Record](tx *sql.Tx, records Records, insertQueryBuilder func(Record) sq.InsertBuilder) (err error) {
+// maxParameters is the number of parameters postgres accepts in a statement.
+const maxParameters = 65535
+
+var (
+	insertTargetAddresses = insertTarget{tableName: tableNameAddresses, columns: []string{"hash"},
+		keyColumns: []string{"hash"}}
+	insertTargetBytecodes = insertTarget{tableName: tableNameBytecodes, columns: tableColumnsBytecodes,
+		keyColumns: []string{"sha256"}}
+	insertTargetBlocks = insertTarget{tableName: tableNameBlocks, columns: tableColumnsBlocks,
+		keyColumns: []string{"number"}}
+	insertTargetUncles = insertTarget{tableName: tableNameUncles, columns: tableColumnsUncles,
+		keyColumns: []string{"hash"}}
+	insertTargetTransactions = insertTarget{tableName: tableNameTransactions, columns: tableColumnsTransactions[1:],
+		keyColumns: []string{"hash"}}
+	// transaction hashes are not unique in a partitioned database, the ids derived from block numbers are
+	insertTargetPartitionedTransactions = insertTarget{tableName: tableNameTransactions, columns: tableColumnsTransactions,
+		keyColumns: []string{"id"}}
+	insertTargetStorageKeys = insertTarget{tableName: tableNameStorageKeys, columns: tableColumnsStorageKeys}
+	insertTargetReceipts    = insertTarget{tableName: tableNameReceipts, columns: tableColumnsReceipts,
+		keyColumns: []string{"transaction_id"}}
+	insertTargetLogs = insertTarget{tableName: tableNameLogs, columns: tableColumnsLogs,
+		keyColumns: []string{"transaction_id", "index"}}
+	insertTargetEventTypes = insertTarget{tableName: tableNameEventTypes, columns: tableColumnsEventTypes[1:],
+		keyColumns: []string{"hash"}, mutableColumns: []string{"signature"}}
+	insertTargetErc20TokenTransfers = insertTarget{tableName: tableNameErc20TokenTransfers, columns: tableColumnsErc20TokenTransfers,
+		keyColumns: []string{"transaction_id", "log_index"}}
+	insertTargetErc20Tokens = insertTarget{tableName: tableNameErc20Tokens, columns: tableColumnsErc20Tokens,
+		keyColumns: []string{"address_id"}, mutableColumns: tableColumnsErc20Tokens[1:]}
+	insertTargetContracts = insertTarget{tableName: tableNameContracts, columns: tableColumnsContracts,
+		keyColumns: []string{"address_id"}}
+	insertTargetTraces = insertTarget{tableName: tableNameTraces, columns: tableColumnsTraces,
+		keyColumns: []string{"transaction_id", "index"}}
+	insertTargetEtherBalances = insertTarget{tableName: tableNameEtherBalances, columns: tableColumnsEtherBalances,
+		keyColumns: []string{"address_id", "block_id"}}
+	insertTargetErc20TokenBalances = insertTarget{tableName: tableNameErc20TokenBalances, columns: tableColumnsErc20TokenBalances,
+		keyColumns: []string{"address_id", "block_id", "token_address_id"}}
+	insertTargetStateChanges = insertTarget{tableName: tableNameStateChanges, columns: tableColumnsStateChanges,
+		keyColumns: []string{"transaction_id", "address_id"}}
+	insertTargetStorageChanges = insertTarget{tableName: tableNammeStorageChanges, columns: tableColumnsStorageChanges,
+		keyColumns: []string{"transaction_id", "address_id", "storage_address"}}
+)
+
+// executeBulkInsert inserts the records, handling the conflicting ones according to the repository's conflictMode.
+// recordValues returns the values of a record in the order of the target's columns.
+// Errors name the failing record, as described by describeRecord.
+func executeBulkInsert[Record any, Records []Record](repo *PostgresRepository, target insertTarget, records Records, describeRecord func(Record) string, recordValues func(Record) []any) (err error) {
+	_, err = executeBulkInsertReturningInserted(repo, target, records, describeRecord, recordValues)
+	return
+}
+
+// executeBulkInsertReturningInserted is the same as executeBulkInsert,
+// but also returns the records which were actually inserted or updated, i.e. did not conflict.
+func executeBulkInsertReturningInserted[Record any, Records []Record](repo *PostgresRepository, target insertTarget, records Records, describeRecord func(Record) string, recordValues func(Record) []any) (inserted Records, err error) {
+	onConflictClause := target.onConflictClause(repo.conflictMode)
+	valuesOfRecords := make([][]any, 0, len(records))
 	for _, record := range records {
-		if _, err = insertQueryBuilder(record).RunWith(tx).Suffix(on_conflict_do_nothing).Exec(); err != nil {
-			break
+		valuesOfRecords = append(valuesOfRecords, recordValues(record))
+	}
+	if len(records) > 1 {
+		var allInserted bool
+		if allInserted, err = repo.insertAll(target, onConflictClause, valuesOfRecords); err != nil {
+			return nil, mapError(err)
+		}
+		if allInserted {
+			return records, nil
+		}
+	}
+	for i, record := range records {
+		values := valuesOfRecords[i]
+		var result sql.Result
+		result, err = repo.statementBuilder.
+			Insert(target.tableName).
+			Columns(target.columns...).
+			Values(values...).
+			Suffix(onConflictClause).
+			Exec()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", describeRecord(record), mapError(err))
+		}
+		var rowsAffected int64
+		if rowsAffected, err = result.RowsAffected(); err != nil {
+			return nil, err
+		}
+		if rowsAffected != 0 {
+			inserted = append(inserted, record)
+		} else if repo.conflictMode != conflictModeIgnore {
+			if err = repo.findConflict(target, describeRecord(record), values); err != nil {
+				return nil, mapError(err)
+			}
 		}
 	}
 	return
 }
-func bigIntMustNotBeNil(number *big.Int) *big.Int {
-	if number == nil {
-		return new(big.Int)
+
+// insertAll inserts the records with as few multi-row statements as possible, and reports whether all of them were inserted.
+// Otherwise nothing is inserted, so the caller can insert the records one by one to tell which ones conflict or fail.
+func (repo *PostgresRepository) insertAll(target insertTarget, onConflictClause string, valuesOfRecords [][]any) (allInserted bool, err error) {
+	if _, err = repo.runner.Exec("SAVEPOINT bulk_insert"); err != nil {
+		return false, err
 	}
-	return number
-}
-func (repo *PostgresRepository) StoreAddress(addresses ...common.Address) (addressIds []storage.AddressId, err error) {
-	for _, address := range addresses {
-		_, err = repo.statementBuilder.
-			Insert(tableNameAddresses).
-			Columns("hash").
-			Values(address).
-			Suffix(on_conflict_do_nothing).
-			Exec()
+	recordsPerStatement := maxParameters / len(target.columns)
+	allInserted = true
+	for from := 0; from < len(valuesOfRecords) && allInserted; from += recordsPerStatement {
+		to := from + recordsPerStatement
+		if to > len(valuesOfRecords) {
+			to = len(valuesOfRecords)
+		}
+		insertBuilder := repo.statementBuilder.
+			Insert(target.tableName).
+			Columns(target.columns...).
+			Suffix(onConflictClause)
+		for _, values := range valuesOfRecords[from:to] {
+			insertBuilder = insertBuilder.Values(values...)
+		}
+		var result sql.Result
+		result, err = insertBuilder.Exec()
 		if err != nil {
-			return nil, err
+			if isRetryable(err) {
+				return false, err
+			}
+			// the failing record is found by inserting the records one by one
+			allInserted = false
+			break
+		}
+		var rowsAffected int64
+		if rowsAffected, err = result.RowsAffected(); err != nil {
+			return false, err
 		}
+		allInserted = rowsAffected == int64(to-from)
+	}
+	if allInserted {
+		_, err = repo.runner.Exec("RELEASE SAVEPOINT bulk_insert")
+	} else {
+		_, err = repo.runner.Exec("ROLLBACK TO SAVEPOINT bulk_insert")
+	}
+	return allInserted && err == nil, err
+}
+func traceAddressToInt64s(traceAddress []uint64) []int64 {
+	converted := make([]int64, 0, len(traceAddress))
+	for _, position := range traceAddress {
+		converted = append(converted, int64(position))
+	}
+	return converted
+}
+
+// The Store methods validate the records, and either buffer them until Commit or the next read, see writeBuffer,
+// or insert them right away through the store functions below them.
+
+func (repo *PostgresRepository) StoreAddress(addresses ...common.Address) (_ []storage.AddressId, err error) {
+	defer annotate(&err, "failed to store addresses")
+	if repo.buffer != nil {
+		return repo.bufferAddresses(addresses)
+	}
+	return repo.storeAddresses(addresses)
+}
+func (repo *PostgresRepository) storeAddresses(addresses []common.Address) (addressIds []storage.AddressId, err error) {
+	err = executeBulkInsert(repo, insertTargetAddresses, addresses,
+		func(address common.Address) string { return fmt.Sprintf("address %v", address) },
+		func(address common.Address) []any { return []any{address} })
+	if err != nil {
+		return nil, err
 	}
 	for _, address := range addresses {
-		var id postgresSerialId
+		var id storage.AddressId
 		err = repo.statementBuilder.
 			Select("id").
 			From(tableNameAddresses).
 			Where("hash = ?", address).
 			Scan(&id)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("id of address %v: %w", address, mapError(err))
 		}
 		addressIds = append(addressIds, id)
 	}
 	return addressIds, nil
 }
 
-func (repo *PostgresRepository) StoreBlock(blocks ...*storage.Block) error {
-	return executeBulkInsertIgnore(repo.tx, blocks,
-		func(block *storage.Block) sq.InsertBuilder {
-			block.Difficulty = bigIntMustNotBeNil(block.Difficulty)
-			block.TotalDifficulty = bigIntMustNotBeNil(block.TotalDifficulty)
-			block.BaseFeePerGas = bigIntMustNotBeNil(block.BaseFeePerGas)
-			block.StaticReward = bigIntMustNotBeNil(block.StaticReward)
-			return repo.statementBuilder.
-				Insert(tableNameBlocks).
-				Columns(tableColumnsBlocks...).
-				Values(block.Hash,
-					block.Number,
-					uint64ToBytes(block.Nonce),
-					block.Sha3Uncles,
-					block.LogsBloom.Bytes(),
-					block.StateRoot,
-					block.MinerAddressId,
-					block.Difficulty.Bytes(),
-					block.TotalDifficulty.Bytes(),
-					uint64ToBytes(block.Size),
-					block.ExtraData,
-					uint64ToBytes(block.GasLimit),
-					uint64ToBytes(block.GasUsed),
-					block.BaseFeePerGas.Bytes(),
-					block.MixHash,
-					block.StaticReward.Bytes(),
-					block.Timestamp)
-		})
-}
-func (repo *PostgresRepository) StoreUncle(uncles ...*storage.Uncle) error {
-	return executeBulkInsertIgnore(repo.tx, uncles,
-		func(uncle *storage.Uncle) sq.InsertBuilder {
-			uncle.Difficulty = bigIntMustNotBeNil(uncle.Difficulty)
-			uncle.Reward = bigIntMustNotBeNil(uncle.Reward)
-			return repo.statementBuilder.
-				Insert(tableNameUncles).
-				Columns(tableColumnsUncles...).
-				Values(
-					uncle.Hash,
-					uncle.Position,
-					uncle.UncleHeight,
-					uncle.BlockHeight,
-					uncle.ParentHash.Bytes(),
-					uncle.MinerAddressId,
-					uncle.Difficulty.Bytes(),
-					uncle.GasLimit,
-					uncle.GasUsed,
-					uncle.Reward.Bytes(),
-					uncle.Timestamp)
-		})
+func (repo *PostgresRepository) StoreBlock(blocks ...*storage.Block) (err error) {
+	defer annotate(&err, "failed to store blocks")
+	if err = sqlcommon.ValidateRecords(blocks, sqlcommon.ValidateBlock); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.blocks = append(repo.buffer.blocks, blocks...)
+		return nil
+	}
+	return repo.storeBlocks(blocks)
 }
-func (repo *PostgresRepository) StoreTransaction(transactions ...*storage.Transaction) ([]storage.TransactionId, error) {
-	err := executeBulkInsertIgnore(repo.tx, transactions, func(transaction *storage.Transaction) sq.InsertBuilder {
-		var nullableGasTipCap, nullableGasFeeCap any
-		if transaction.GasTipCap == nil {
-			nullableGasTipCap = "NULL"
-		} else {
-			nullableGasTipCap = transaction.GasTipCap.Bytes()
-		}
-		if transaction.GasFeeCap == nil {
-			nullableGasFeeCap = "NULL"
-		} else {
-			nullableGasFeeCap = transaction.GasFeeCap.Bytes()
+func (repo *PostgresRepository) storeBlocks(blocks []*storage.Block) (err error) {
+	if repo.partitionSize != 0 {
+		for _, block := range blocks {
+			if err = repo.ensurePartitions(block.Number); err != nil {
+				return fmt.Errorf("partitions of block %d: %w", block.Number, err)
+			}
 		}
-		return repo.statementBuilder.
-			Insert(tableNameTransactions).
-			Columns(tableColumnsTransactions[1:]...).
-			Values(transaction.BlockNumber,
-				transaction.Hash,
-				uint64ToBytes(transaction.Nonce),
-				uint64ToBytes(transaction.Index),
-				transaction.FromAddressId,
-				transaction.ToAddressId,
-				transaction.Value.Bytes(),
-				uint64ToBytes(transaction.Gas),
-				transaction.GasPrice.Bytes(),
-				nullableGasTipCap,
-				nullableGasFeeCap,
-				transaction.Input,
-				transaction.Type)
+	}
+	err = executeBulkInsert(repo, insertTargetBlocks, blocks, sqlcommon.DescribeBlock, func(block *storage.Block) []any {
+		block.Difficulty = sqlcommon.BigIntMustNotBeNil(block.Difficulty)
+		block.TotalDifficulty = sqlcommon.BigIntMustNotBeNil(block.TotalDifficulty)
+		block.BaseFeePerGas = sqlcommon.BigIntMustNotBeNil(block.BaseFeePerGas)
+		block.StaticReward = sqlcommon.BigIntMustNotBeNil(block.StaticReward)
+		return []any{block.Hash,
+			block.Number,
+			strconv.FormatUint(block.Nonce, 10),
+			block.Sha3Uncles,
+			block.LogsBloom.Bytes(),
+			block.StateRoot,
+			block.MinerAddressId,
+			bigIntToNumeric(block.Difficulty),
+			bigIntToNumeric(block.TotalDifficulty),
+			block.Size,
+			block.ExtraData,
+			block.GasLimit,
+			block.GasUsed,
+			bigIntToNumeric(block.BaseFeePerGas),
+			block.MixHash,
+			bigIntToNumeric(block.StaticReward),
+			block.Timestamp,
+			block.ParentHash,
+			block.TransactionsRoot,
+			block.ReceiptsRoot}
+	})
+	if err != nil {
+		return err
+	}
+	return repo.indexBloomBits(blocks)
+}
+func (repo *PostgresRepository) StoreUncle(uncles ...*storage.Uncle) (err error) {
+	defer annotate(&err, "failed to store uncles")
+	if err = sqlcommon.ValidateRecords(uncles, nil); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.uncles = append(repo.buffer.uncles, uncles...)
+		return nil
+	}
+	return repo.storeUncles(uncles)
+}
+func (repo *PostgresRepository) storeUncles(uncles []*storage.Uncle) error {
+	return executeBulkInsert(repo, insertTargetUncles, uncles, sqlcommon.DescribeUncle, func(uncle *storage.Uncle) []any {
+		uncle.Difficulty = sqlcommon.BigIntMustNotBeNil(uncle.Difficulty)
+		uncle.Reward = sqlcommon.BigIntMustNotBeNil(uncle.Reward)
+		return []any{uncle.Hash,
+			uncle.Position,
+			uncle.UncleHeight,
+			uncle.BlockHeight,
+			uncle.ParentHash.Bytes(),
+			uncle.MinerAddressId,
+			bigIntToNumeric(uncle.Difficulty),
+			uncle.GasLimit,
+			uncle.GasUsed,
+			bigIntToNumeric(uncle.Reward),
+			uncle.Timestamp}
+	})
+}
+func (repo *PostgresRepository) StoreTransaction(transactions ...*storage.Transaction) (_ []storage.TransactionId, err error) {
+	defer annotate(&err, "failed to store transactions")
+	if err = sqlcommon.ValidateRecords(transactions, sqlcommon.ValidateTransaction); err != nil {
+		return nil, err
+	}
+	if repo.buffer != nil {
+		return repo.bufferTransactions(transactions)
+	}
+	if repo.partitionSize != 0 {
+		return repo.storePartitionedTransactions(transactions)
+	}
+	err = executeBulkInsert(repo, insertTargetTransactions, transactions, sqlcommon.DescribeTransaction, func(transaction *storage.Transaction) []any {
+		return transactionValues(transaction)[1:]
 	})
 	if err != nil {
 		return nil, err
 	}
 	var transactionIds []storage.TransactionId
 	for _, transaction := range transactions {
-		var id postgresSerialId
+		var id storage.TransactionId
 		err = repo.statementBuilder.
 			Select("id").
 			From(tableNameTransactions).
 			Where("hash = ?", transaction.Hash).
 			Scan(&id)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("id of transaction %v: %w", transaction.Hash, mapError(err))
 		}
 		transactionIds = append(transactionIds, id)
 	}
 	return transactionIds, nil
 }
-func (repo *PostgresRepository) StoreStorageKey(storageKeys ...*storage.StorageKey) error {
-	return executeBulkInsertIgnore(repo.tx, storageKeys, func(storageKey *storage.StorageKey) sq.InsertBuilder {
-		return repo.statementBuilder.
-			Insert(tableNameStorageKeys).
-			Columns(tableColumnsStorageKeys...).
-			Values(storageKey.TransactionId,
-				storageKey.AddressId,
-				storageKey.StorageKey.Bytes())
+
+// transactionValues returns the values of the transaction in the order of tableColumnsTransactions, starting with a nil id.
+func transactionValues(transaction *storage.Transaction) []any {
+	return []any{nil,
+		transaction.BlockNumber,
+		transaction.Hash,
+		transaction.Nonce,
+		transaction.Index,
+		transaction.FromAddressId,
+		transaction.ToAddressId,
+		bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(transaction.Value)),
+		transaction.Gas,
+		bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(transaction.GasPrice)),
+		bigIntToNumeric(transaction.GasTipCap),
+		bigIntToNumeric(transaction.GasFeeCap),
+		transaction.Input,
+		transaction.Type,
+		transaction.Raw}
+}
+
+// storePartitionedTransactions stores transactions with ids derived from their block numbers and indexes.
+func (repo *PostgresRepository) storePartitionedTransactions(transactions []*storage.Transaction) ([]storage.TransactionId, error) {
+	transactionIds, err := partitionedTransactionIds(transactions)
+	if err != nil {
+		return nil, err
+	}
+	i := 0
+	err = executeBulkInsert(repo, insertTargetPartitionedTransactions, transactions, sqlcommon.DescribeTransaction, func(transaction *storage.Transaction) []any {
+		values := transactionValues(transaction)
+		values[0] = transactionIds[i]
+		i++
+		return values
 	})
+	if err != nil {
+		return nil, err
+	}
+	return transactionIds, nil
 }
-func (repo *PostgresRepository) StoreReceipt(receipts ...*storage.Receipt) error {
-	return executeBulkInsertIgnore(repo.tx, receipts,
-		func(receipt *storage.Receipt) sq.InsertBuilder {
-			return repo.statementBuilder.
-				Insert(tableNameReceipts).
-				Columns(tableColumnsReceipts...).
-				Values(receipt.TransactionId,
-					uint64ToBytes(receipt.CumulativeGasUsed),
-					uint64ToBytes(receipt.GasUsed),
-					receipt.ContractAddressId,
-					receipt.PostState.Bytes(),
-					receipt.Status,
-					receipt.EffectiveGasPrice.Bytes())
-		})
+func partitionedTransactionIds(transactions []*storage.Transaction) ([]storage.TransactionId, error) {
+	transactionIds := make([]storage.TransactionId, 0, len(transactions))
+	for _, transaction := range transactions {
+		id, err := partitionedTransactionId(transaction.BlockNumber, transaction.Index)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sqlcommon.DescribeTransaction(transaction), err)
+		}
+		transactionIds = append(transactionIds, id)
+	}
+	return transactionIds, nil
+}
+func (repo *PostgresRepository) StoreStorageKey(storageKeys ...*storage.StorageKey) (err error) {
+	defer annotate(&err, "failed to store storage keys")
+	if err = sqlcommon.ValidateRecords(storageKeys, sqlcommon.ValidateStorageKey); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.storageKeys = append(repo.buffer.storageKeys, storageKeys...)
+		return nil
+	}
+	return repo.storeStorageKeys(storageKeys)
+}
+func (repo *PostgresRepository) storeStorageKeys(storageKeys []*storage.StorageKey) error {
+	return executeBulkInsert(repo, insertTargetStorageKeys, storageKeys, sqlcommon.DescribeStorageKey, func(storageKey *storage.StorageKey) []any {
+		return []any{storageKey.TransactionId,
+			storageKey.AddressId,
+			storageKey.StorageKey.Bytes()}
+	})
+}
+func (repo *PostgresRepository) StoreReceipt(receipts ...*storage.Receipt) (err error) {
+	defer annotate(&err, "failed to store receipts")
+	if err = sqlcommon.ValidateRecords(receipts, sqlcommon.ValidateReceipt); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.receipts = append(repo.buffer.receipts, receipts...)
+		return nil
+	}
+	return repo.storeReceipts(receipts)
 }
-func (repo *PostgresRepository) StoreLog(logs ...*storage.Log) error {
-	return executeBulkInsertIgnore(repo.tx, logs, func(log *storage.Log) sq.InsertBuilder {
+func (repo *PostgresRepository) storeReceipts(receipts []*storage.Receipt) error {
+	return executeBulkInsert(repo, insertTargetReceipts, receipts, sqlcommon.DescribeReceipt, func(receipt *storage.Receipt) []any {
+		return []any{receipt.TransactionId,
+			receipt.CumulativeGasUsed,
+			receipt.GasUsed,
+			receipt.ContractAddressId,
+			receipt.PostState.Bytes(),
+			receipt.Status,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(receipt.EffectiveGasPrice))}
+	})
+}
+func (repo *PostgresRepository) StoreLog(logs ...*storage.Log) (err error) {
+	defer annotate(&err, "failed to store logs")
+	if err = sqlcommon.ValidateRecords(logs, sqlcommon.ValidateLog); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.logs = append(repo.buffer.logs, logs...)
+		return nil
+	}
+	return repo.storeLogs(logs)
+}
+func (repo *PostgresRepository) storeLogs(logs []*storage.Log) error {
+	err := executeBulkInsert(repo, insertTargetLogs, logs, sqlcommon.DescribeLog, func(log *storage.Log) []any {
 		var topic1, topic2, topic3 []byte
 		if log.Topic1 != nil {
 			topic1 = log.Topic1[:]
@@ -189,182 +413,246 @@ func (repo *PostgresRepository) StoreLog(logs ...*storage.Log) error {
 		if log.Topic3 != nil {
 			topic3 = log.Topic3[:]
 		}
-		return repo.statementBuilder.
-			Insert(tableNameLogs).
-			Columns(tableColumnsLogs...).
-			Values(log.TransactionId,
-				uint64ToBytes(log.LogIndex),
-				log.AddressId,
-				log.Topic0Id,
-				topic1,
-				topic2,
-				topic3,
-				log.Data)
+		return []any{log.TransactionId,
+			log.LogIndex,
+			log.AddressId,
+			log.Topic0Id,
+			topic1,
+			topic2,
+			topic3,
+			log.Data}
 	})
+	if err != nil || !repo.derivingErc20TokenTransfers {
+		return err
+	}
+	return repo.deriveErc20TokenTransfers(logs)
 }
-func (repo *PostgresRepository) StoreTopic0(eventTypes ...*storage.EventType) ([]storage.Topic0Id, error) {
-	err := executeBulkInsertIgnore(repo.tx, eventTypes, func(eventType *storage.EventType) sq.InsertBuilder {
-		return repo.statementBuilder.
-			Insert(tableNameEventTypes).
-			Columns(tableColumnsEventTypes[1:]...).
-			Values(eventType.Hash, eventType.Signature)
+func (repo *PostgresRepository) StoreTopic0(eventTypes ...*storage.EventType) (_ []storage.Topic0Id, err error) {
+	defer annotate(&err, "failed to store event types")
+	if err = sqlcommon.ValidateRecords(eventTypes, nil); err != nil {
+		return nil, err
+	}
+	if repo.buffer != nil {
+		return repo.bufferEventTypes(eventTypes)
+	}
+	err = executeBulkInsert(repo, insertTargetEventTypes, eventTypes, sqlcommon.DescribeEventType, func(eventType *storage.EventType) []any {
+		return []any{eventType.Hash, eventType.Signature}
 	})
 	if err != nil {
 		return nil, err
 	}
 	var eventTypeIds []storage.Topic0Id
 	for _, eventType := range eventTypes {
-		var id postgresSerialId
+		var id storage.Topic0Id
 		err = repo.statementBuilder.Select("id").From(tableNameEventTypes).Where("hash = ?", eventType.Hash).Scan(&id)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("id of %s: %w", sqlcommon.DescribeEventType(eventType), mapError(err))
 		}
-		eventTypeIds = append(eventTypeIds, postgresSerialId(id))
+		eventTypeIds = append(eventTypeIds, id)
 	}
 	return eventTypeIds, nil
 }
-func (repo *PostgresRepository) StoreErc20TokenTransfer(erc20TokenTransfers ...*storage.Erc20TokenTransfer) error {
-	return executeBulkInsertIgnore(repo.tx, erc20TokenTransfers,
-		func(erc20TokenTransfer *storage.Erc20TokenTransfer) sq.InsertBuilder {
-
-			return repo.statementBuilder.
-				Insert(tableNameErc20TokenTransfers).
-				Columns(tableColumnsErc20TokenTransfers...).
-				Values(
-					erc20TokenTransfer.LogId.TransactionId,
-					uint64ToBytes(erc20TokenTransfer.LogId.LogIndex),
-					erc20TokenTransfer.TokenAddressId,
-					erc20TokenTransfer.FromAddressId,
-					erc20TokenTransfer.ToAddressId,
-					erc20TokenTransfer.Value.Bytes())
-		})
+func (repo *PostgresRepository) StoreErc20TokenTransfer(erc20TokenTransfers ...*storage.Erc20TokenTransfer) (err error) {
+	defer annotate(&err, "failed to store ERC-20 token transfers")
+	if err = sqlcommon.ValidateRecords(erc20TokenTransfers, sqlcommon.ValidateErc20TokenTransfer); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.erc20TokenTransfers = append(repo.buffer.erc20TokenTransfers, erc20TokenTransfers...)
+		return nil
+	}
+	return repo.storeErc20TokenTransfers(erc20TokenTransfers)
 }
-func (repo *PostgresRepository) StoreErc20Token(erc20Tokens ...*storage.Erc20Token) error {
-	return executeBulkInsertIgnore(repo.tx, erc20Tokens,
-		func(erc20Token *storage.Erc20Token) sq.InsertBuilder {
-			return repo.statementBuilder.
-				Insert(tableNameErc20Tokens).
-				Columns(tableColumnsErc20Tokens...).
-				Values(erc20Token.AddressId,
-					erc20Token.Symbol,
-					erc20Token.Name,
-					erc20Token.Decimals,
-					erc20Token.TotalSupply.Bytes())
+func (repo *PostgresRepository) storeErc20TokenTransfers(erc20TokenTransfers []*storage.Erc20TokenTransfer) error {
+	inserted, err := executeBulkInsertReturningInserted(repo, insertTargetErc20TokenTransfers, erc20TokenTransfers, sqlcommon.DescribeErc20TokenTransfer,
+		func(erc20TokenTransfer *storage.Erc20TokenTransfer) []any {
+			return []any{erc20TokenTransfer.LogId.TransactionId,
+				erc20TokenTransfer.LogId.LogIndex,
+				erc20TokenTransfer.TokenAddressId,
+				erc20TokenTransfer.FromAddressId,
+				erc20TokenTransfer.ToAddressId,
+				bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(erc20TokenTransfer.Value))}
 		})
+	if err != nil || !repo.derivingErc20TokenBalances {
+		return err
+	}
+	return repo.applyErc20TokenTransfersToBalances(inserted)
 }
-func (repo *PostgresRepository) StoreContract(contracts ...*storage.Contract) error {
-	return executeBulkInsertIgnore(repo.tx, contracts,
-		func(contract *storage.Contract) sq.InsertBuilder {
-			return repo.statementBuilder.
-				Insert(tableNameContracts).
-				Columns(tableColumnsContracts...).
-				Values(contract.AddressId, contract.TransactionId, contract.BytecodeId)
-		})
+func (repo *PostgresRepository) StoreErc20Token(erc20Tokens ...*storage.Erc20Token) (err error) {
+	defer annotate(&err, "failed to store ERC-20 tokens")
+	if err = sqlcommon.ValidateRecords(erc20Tokens, sqlcommon.ValidateErc20Token); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.erc20Tokens = append(repo.buffer.erc20Tokens, erc20Tokens...)
+		return nil
+	}
+	return repo.storeErc20Tokens(erc20Tokens)
 }
-func (repo *PostgresRepository) StoreBytecode(bytecodes ...storage.Bytecode) ([]storage.BytecodeId, error) {
-	if len(bytecodes) != 1 {
-		panic("bulk insert not implemented")
-	}
-	bytecode := bytecodes[0]
-	sha256Digest := sha256.Sum256(bytecode)
-	hash := sha256Digest[:]
-	_, err := repo.statementBuilder.
-		Insert(tableNameBytecodes).
-		Columns(tableColumnsBytecodes...).
-		Values(bytecode, hash).
-		Suffix(on_conflict_do_nothing).
-		Exec()
-	if err != nil {
-		return nil, err
+func (repo *PostgresRepository) storeErc20Tokens(erc20Tokens []*storage.Erc20Token) error {
+	return executeBulkInsert(repo, insertTargetErc20Tokens, erc20Tokens, sqlcommon.DescribeErc20Token, func(erc20Token *storage.Erc20Token) []any {
+		return []any{erc20Token.AddressId,
+			erc20Token.Symbol,
+			erc20Token.Name,
+			erc20Token.Decimals,
+			bigIntToNumeric(erc20Token.TotalSupply)}
+	})
+}
+func (repo *PostgresRepository) StoreContract(contracts ...*storage.Contract) (err error) {
+	defer annotate(&err, "failed to store contracts")
+	if err = sqlcommon.ValidateRecords(contracts, sqlcommon.ValidateContract); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.contracts = append(repo.buffer.contracts, contracts...)
+		return nil
 	}
-	var id postgresSerialId
-	err = repo.statementBuilder.
-		Select("id").
-		From(tableNameBytecodes).
-		Where("sha256 = ?", hash).
-		Scan(&id)
+	return repo.storeContracts(contracts)
+}
+func (repo *PostgresRepository) storeContracts(contracts []*storage.Contract) error {
+	return executeBulkInsert(repo, insertTargetContracts, contracts, sqlcommon.DescribeContract, func(contract *storage.Contract) []any {
+		return []any{contract.AddressId, contract.TransactionId, contract.BytecodeId}
+	})
+}
+func (repo *PostgresRepository) StoreBytecode(bytecodes ...storage.Bytecode) (_ []storage.BytecodeId, err error) {
+	defer annotate(&err, "failed to store bytecodes")
+	if repo.buffer != nil {
+		return repo.bufferBytecodes(bytecodes)
+	}
+	err = executeBulkInsert(repo, insertTargetBytecodes, bytecodes, sqlcommon.DescribeBytecode, func(bytecode storage.Bytecode) []any {
+		return []any{bytecode, sqlcommon.BytecodeSha256(bytecode)}
+	})
 	if err != nil {
 		return nil, err
 	}
-	return []storage.BytecodeId{id}, nil
-}
-func (repo *PostgresRepository) StoreTrace(traceActions ...*storage.TraceAction) error {
-	return executeBulkInsertIgnore(repo.tx, traceActions,
-		func(traceAction *storage.TraceAction) sq.InsertBuilder {
-			return repo.statementBuilder.
-				Insert(tableNameTraces).
-				Columns(tableColumnsTraces...).
-				Values(traceAction.TransactionId,
-					traceAction.Index,
-					traceAction.Type,
-					traceAction.Input,
-					traceAction.From,
-					traceAction.To,
-					traceAction.Value.Bytes(),
-					traceAction.Gas,
-					traceAction.Error)
-		})
+	bytecodeIds := make([]storage.BytecodeId, 0, len(bytecodes))
+	for _, bytecode := range bytecodes {
+		var id storage.BytecodeId
+		err = repo.statementBuilder.
+			Select("id").
+			From(tableNameBytecodes).
+			Where("sha256 = ?", sqlcommon.BytecodeSha256(bytecode)).
+			Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("id of %s: %w", sqlcommon.DescribeBytecode(bytecode), mapError(err))
+		}
+		bytecodeIds = append(bytecodeIds, id)
+	}
+	return bytecodeIds, nil
 }
-func (repo *PostgresRepository) StoreEtherBalance(etherBalances ...*storage.EtherBalance) error {
-	return executeBulkInsertIgnore(repo.tx, etherBalances,
-		func(etherBalance *storage.EtherBalance) sq.InsertBuilder {
-			return repo.statementBuilder.
-				Insert(tableNameEtherBalances).
-				Columns(tableColumnsEtherBalances...).
-				Values(etherBalance.AddressId, etherBalance.BlockNumber, etherBalance.Balance.Bytes())
-		})
+func (repo *PostgresRepository) StoreTrace(traceActions ...*storage.TraceAction) (err error) {
+	defer annotate(&err, "failed to store traces")
+	if err = sqlcommon.ValidateRecords(traceActions, sqlcommon.ValidateTrace); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.traces = append(repo.buffer.traces, traceActions...)
+		return nil
+	}
+	return repo.storeTraces(traceActions)
 }
-func (repo *PostgresRepository) StoreErc20TokenBalance(tokenBalances ...*storage.Erc20TokenBalance) error {
-	return executeBulkInsertIgnore(repo.tx, tokenBalances,
-		func(tokenBalance *storage.Erc20TokenBalance) sq.InsertBuilder {
-			return repo.statementBuilder.
-				Insert(tableNameErc20TokenBalances).
-				Columns(tableColumnsErc20TokenBalances...).
-				Values(tokenBalance.AddressId, tokenBalance.BlockNumber, tokenBalance.TokenAddressId, tokenBalance.Balance.Bytes())
-		})
+func (repo *PostgresRepository) storeTraces(traceActions []*storage.TraceAction) error {
+	return executeBulkInsert(repo, insertTargetTraces, traceActions, sqlcommon.DescribeTrace, func(traceAction *storage.TraceAction) []any {
+		return []any{traceAction.TransactionId,
+			traceAction.Index,
+			traceAction.Type,
+			traceAction.Input,
+			traceAction.From,
+			traceAction.To,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(traceAction.Value)),
+			traceAction.Gas,
+			traceAction.Error,
+			pq.Array(traceAddressToInt64s(traceAction.TraceAddress)),
+			traceAction.Depth,
+			traceAction.Output,
+			traceAction.GasUsed,
+			traceAction.CreatedAddressId,
+			traceAction.InitCode,
+			traceAction.RefundAddressId}
+	})
 }
-func (repo *PostgresRepository) StoreStateChange(stateChanges ...*storage.StateChange) error {
-	return executeBulkInsertIgnore(repo.tx, stateChanges, func(stateChange *storage.StateChange) sq.InsertBuilder {
-		var nullableBalanceBefore any
-		if stateChange.BalanceAfter == nil {
-			nullableBalanceBefore = "NULL"
-		} else {
-			nullableBalanceBefore = stateChange.BalanceBefore.Bytes()
-		}
-		var nullableNonceBefore any
-		if stateChange.NonceBefore == nil {
-			nullableNonceBefore = "NULL"
-		} else {
-			nullableNonceBefore = uint64ToBytes(*stateChange.NonceBefore)
-		}
-		var nullableNonceAfter any
-		if stateChange.NonceAfter == nil {
-			nullableNonceAfter = "NULL"
-		} else {
-			nullableNonceAfter = uint64ToBytes(*stateChange.NonceAfter)
+func (repo *PostgresRepository) StoreEtherBalance(etherBalances ...*storage.EtherBalance) (err error) {
+	defer annotate(&err, "failed to store ether balances")
+	if err = sqlcommon.ValidateRecords(etherBalances, sqlcommon.ValidateEtherBalance); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.etherBalances = append(repo.buffer.etherBalances, etherBalances...)
+		return nil
+	}
+	return repo.storeEtherBalances(etherBalances)
+}
+func (repo *PostgresRepository) storeEtherBalances(etherBalances []*storage.EtherBalance) error {
+	return executeBulkInsert(repo, insertTargetEtherBalances, etherBalances, sqlcommon.DescribeEtherBalance, func(etherBalance *storage.EtherBalance) []any {
+		return []any{etherBalance.AddressId, etherBalance.BlockNumber, bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(etherBalance.Balance))}
+	})
+}
+
+// StoreErc20TokenBalance stores nothing while balances are derived from the transfers, see env_POSTGRES_DERIVE_ERC20_BALANCES:
+// the derived balances take precedence, and ReconcileErc20TokenBalances reports the holders whose transfers do not add up.
+// The balances are ignored rather than rejected, so scrapers storing them keep working, but the first ignored ones are logged.
+func (repo *PostgresRepository) StoreErc20TokenBalance(tokenBalances ...*storage.Erc20TokenBalance) (err error) {
+	defer annotate(&err, "failed to store ERC-20 token balances")
+	if err = sqlcommon.ValidateRecords(tokenBalances, sqlcommon.ValidateErc20TokenBalance); err != nil {
+		return err
+	}
+	if repo.derivingErc20TokenBalances {
+		if len(tokenBalances) != 0 && !repo.balancesIgnoredLogged {
+			log.Printf("ignoring the ERC-20 token balances stored, as they are derived from the transfers (%s is set), first ignored: %s",
+				env_POSTGRES_DERIVE_ERC20_BALANCES, sqlcommon.DescribeErc20TokenBalance(tokenBalances[0]))
+			repo.balancesIgnoredLogged = true
 		}
-		return repo.statementBuilder.
-			Insert(tableNameStateChanges).
-			Columns(tableColumnsStateChanges...).
-			Values(
-				stateChange.TransactionId,
-				stateChange.AddressId,
-				nullableBalanceBefore,
-				bigIntMustNotBeNil(stateChange.BalanceAfter).Bytes(),
-				nullableNonceBefore,
-				nullableNonceAfter)
+		return nil
+	}
+	if repo.buffer != nil {
+		repo.buffer.erc20TokenBalances = append(repo.buffer.erc20TokenBalances, tokenBalances...)
+		return nil
+	}
+	return repo.storeErc20TokenBalances(tokenBalances)
+}
+func (repo *PostgresRepository) storeErc20TokenBalances(tokenBalances []*storage.Erc20TokenBalance) error {
+	return executeBulkInsert(repo, insertTargetErc20TokenBalances, tokenBalances, sqlcommon.DescribeErc20TokenBalance, func(tokenBalance *storage.Erc20TokenBalance) []any {
+		return []any{tokenBalance.AddressId, tokenBalance.BlockNumber, tokenBalance.TokenAddressId, bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(tokenBalance.Balance))}
 	})
 }
-func (repo *PostgresRepository) StoreStorageChange(storageChanges ...*storage.StorageChange) error {
-	return executeBulkInsertIgnore(repo.tx, storageChanges, func(storageChange *storage.StorageChange) sq.InsertBuilder {
-		return repo.statementBuilder.
-			Insert(tableNammeStorageChanges).
-			Columns(tableColumnsStorageChanges...).
-			Values(
-				storageChange.TransactionId,
-				storageChange.AddressId,
-				storageChange.StorageAddress.Bytes(),
-				storageChange.ValueBefore.Bytes(),
-				storageChange.ValueAfter.Bytes())
+func (repo *PostgresRepository) StoreStateChange(stateChanges ...*storage.StateChange) (err error) {
+	defer annotate(&err, "failed to store state changes")
+	if err = sqlcommon.ValidateRecords(stateChanges, sqlcommon.ValidateStateChange); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.stateChanges = append(repo.buffer.stateChanges, stateChanges...)
+		return nil
+	}
+	return repo.storeStateChanges(stateChanges)
+}
+func (repo *PostgresRepository) storeStateChanges(stateChanges []*storage.StateChange) error {
+	return executeBulkInsert(repo, insertTargetStateChanges, stateChanges, sqlcommon.DescribeStateChange, func(stateChange *storage.StateChange) []any {
+		return []any{stateChange.TransactionId,
+			stateChange.AddressId,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(stateChange.BalanceBefore)),
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(stateChange.BalanceAfter)),
+			stateChange.NonceBefore,
+			stateChange.NonceAfter}
+	})
+}
+func (repo *PostgresRepository) StoreStorageChange(storageChanges ...*storage.StorageChange) (err error) {
+	defer annotate(&err, "failed to store storage changes")
+	if err = sqlcommon.ValidateRecords(storageChanges, sqlcommon.ValidateStorageChange); err != nil {
+		return err
+	}
+	if repo.buffer != nil {
+		repo.buffer.storageChanges = append(repo.buffer.storageChanges, storageChanges...)
+		return nil
+	}
+	return repo.storeStorageChanges(storageChanges)
+}
+func (repo *PostgresRepository) storeStorageChanges(storageChanges []*storage.StorageChange) error {
+	return executeBulkInsert(repo, insertTargetStorageChanges, storageChanges, sqlcommon.DescribeStorageChange, func(storageChange *storage.StorageChange) []any {
+		return []any{storageChange.TransactionId,
+			storageChange.AddressId,
+			storageChange.StorageAddress.Bytes(),
+			storageChange.ValueBefore.Bytes(),
+			storageChange.ValueAfter.Bytes()}
 	})
 }
@@ -0,0 +1,241 @@
+package postgres
+
+import (
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+)
+
+// Names of the integrity checks, see CheckIntegrity.
+const (
+	IntegrityCheckMissingBlocks           = "missing_blocks"
+	IntegrityCheckParentHash              = "parent_hash"
+	IntegrityCheckTransactionIndexes      = "transaction_indexes"
+	IntegrityCheckBlockGasUsed            = "block_gas_used"
+	IntegrityCheckMissingReceipts         = "missing_receipts"
+	IntegrityCheckCumulativeGasUsed       = "cumulative_gas_used"
+	IntegrityCheckLogsWithoutReceipts     = "logs_without_receipts"
+	IntegrityCheckContractsWithoutReceipt = "contracts_without_receipt"
+	IntegrityCheckEtherBalances           = "ether_balances"
+)
+
+// Inconsistency is a violation of an invariant of the stored chain data, found by CheckIntegrity.
+type Inconsistency struct {
+	Check       string
+	BlockNumber storage.BlockNumber
+	Detail      string
+}
+
+// integrityCheck selects the block number and the details of each inconsistency found between the given blocks.
+type integrityCheck struct {
+	name   string
+	query  func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder
+	detail func(scanner sq.RowScanner) (storage.BlockNumber, string, error)
+}
+
+var integrityChecks = []integrityCheck{
+	{
+		name: IntegrityCheckParentHash,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			return repo.statementBuilder.
+				Select("block.number", "block.parent_hash", "parent.hash").
+				From(tableNameBlocks+" block").
+				Join(tableNameBlocks+" parent ON parent.number = block.number - 1").
+				Where("block.number BETWEEN ? AND ?", from, to).
+				// blocks stored by earlier versions have no parent hash
+				Where("block.parent_hash IS NOT NULL").
+				Where("block.parent_hash <> parent.hash").
+				OrderBy("block.number")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var blockNumber storage.BlockNumber
+			var parentHash, previousHash []byte
+			err := scanner.Scan(&blockNumber, &parentHash, &previousHash)
+			return blockNumber, fmt.Sprintf("parent hash %v differs from hash %v of block %d",
+				common.BytesToHash(parentHash), common.BytesToHash(previousHash), blockNumber-1), err
+		},
+	},
+	{
+		name: IntegrityCheckTransactionIndexes,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			return repo.statementBuilder.
+				Select("block_id", "COUNT(*)", "MIN(index)", "MAX(index)").
+				From(tableNameTransactions).
+				Where("block_id BETWEEN ? AND ?", from, to).
+				GroupBy("block_id").
+				Having("MIN(index) <> 0 OR MAX(index) <> COUNT(*) - 1").
+				OrderBy("block_id")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var blockNumber, count, minIndex, maxIndex uint64
+			err := scanner.Scan(&blockNumber, &count, &minIndex, &maxIndex)
+			return blockNumber, fmt.Sprintf("%d transactions have indexes from %d to %d", count, minIndex, maxIndex), err
+		},
+	},
+	{
+		name: IntegrityCheckBlockGasUsed,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			return repo.statementBuilder.
+				Select("block.number", "block.gas_used", "COALESCE(MAX(receipt.cumulative_gas_used), 0)").
+				From(tableNameBlocks+" block").
+				LeftJoin(tableNameTransactions+" tx ON tx.block_id = block.number").
+				LeftJoin(tableNameReceipts+" receipt ON receipt.transaction_id = tx.id").
+				Where("block.number BETWEEN ? AND ?", from, to).
+				Where("block.gas_used IS NOT NULL").
+				GroupBy("block.number", "block.gas_used").
+				Having("COALESCE(MAX(receipt.cumulative_gas_used), 0) <> block.gas_used").
+				OrderBy("block.number")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var blockNumber, gasUsed, cumulativeGasUsed uint64
+			err := scanner.Scan(&blockNumber, &gasUsed, &cumulativeGasUsed)
+			return blockNumber, fmt.Sprintf("gas used %d differs from cumulative gas used %d of the last receipt", gasUsed, cumulativeGasUsed), err
+		},
+	},
+	{
+		name: IntegrityCheckMissingReceipts,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			return repo.statementBuilder.
+				Select("tx.block_id", "tx.hash").
+				From(tableNameTransactions+" tx").
+				LeftJoin(tableNameReceipts+" receipt ON receipt.transaction_id = tx.id").
+				Where("tx.block_id BETWEEN ? AND ?", from, to).
+				Where("receipt.transaction_id IS NULL").
+				OrderBy("tx.id")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var blockNumber storage.BlockNumber
+			var hash []byte
+			err := scanner.Scan(&blockNumber, &hash)
+			return blockNumber, fmt.Sprintf("transaction %v has no receipt", common.BytesToHash(hash)), err
+		},
+	},
+	{
+		name: IntegrityCheckCumulativeGasUsed,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			return repo.statementBuilder.
+				Select("block_id", "hash", "cumulative_gas_used", "previous_cumulative_gas_used").
+				FromSelect(repo.statementBuilder.
+					Select("tx.id", "tx.block_id", "tx.hash", "receipt.cumulative_gas_used",
+						"LAG(receipt.cumulative_gas_used, 1, 0::bigint) OVER (PARTITION BY tx.block_id ORDER BY tx.index) AS previous_cumulative_gas_used").
+					From(tableNameTransactions+" tx").
+					Join(tableNameReceipts+" receipt ON receipt.transaction_id = tx.id").
+					Where("tx.block_id BETWEEN ? AND ?", from, to), "receipts").
+				Where("cumulative_gas_used < previous_cumulative_gas_used").
+				OrderBy("id")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var blockNumber, cumulativeGasUsed, previousCumulativeGasUsed uint64
+			var hash []byte
+			err := scanner.Scan(&blockNumber, &hash, &cumulativeGasUsed, &previousCumulativeGasUsed)
+			return blockNumber, fmt.Sprintf("cumulative gas used %d of transaction %v is less than %d of the previous transaction",
+				cumulativeGasUsed, common.BytesToHash(hash), previousCumulativeGasUsed), err
+		},
+	},
+	{
+		name: IntegrityCheckLogsWithoutReceipts,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			return repo.statementBuilder.
+				Select("tx.block_id", "log.transaction_id", "log.index").
+				From(tableNameLogs+" log").
+				Join(tableNameTransactions+" tx ON tx.id = log.transaction_id").
+				LeftJoin(tableNameReceipts+" receipt ON receipt.transaction_id = log.transaction_id").
+				Where("tx.block_id BETWEEN ? AND ?", from, to).
+				Where("receipt.transaction_id IS NULL").
+				OrderBy("log.transaction_id", "log.index")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var blockNumber storage.BlockNumber
+			var logId storage.LogId
+			err := scanner.Scan(&blockNumber, &logId.TransactionId, &logId.LogIndex)
+			return blockNumber, sqlcommon.DescribeLogId(logId) + " has no receipt", err
+		},
+	},
+	{
+		name: IntegrityCheckContractsWithoutReceipt,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			return repo.statementBuilder.
+				Select("tx.block_id", "contract.address_id", "contract.transaction_id").
+				From(tableNameContracts+" contract").
+				Join(tableNameTransactions+" tx ON tx.id = contract.transaction_id").
+				LeftJoin(tableNameReceipts+" receipt ON receipt.transaction_id = contract.transaction_id").
+				Where("tx.block_id BETWEEN ? AND ?", from, to).
+				Where("receipt.contract_address_id IS DISTINCT FROM contract.address_id").
+				OrderBy("contract.transaction_id")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var blockNumber storage.BlockNumber
+			var contract storage.Contract
+			err := scanner.Scan(&blockNumber, &contract.AddressId, &contract.TransactionId)
+			return blockNumber, fmt.Sprintf("%s is not the contract address of the receipt of transaction %v",
+				sqlcommon.DescribeContract(&contract), contract.TransactionId), err
+		},
+	},
+	{
+		name: IntegrityCheckEtherBalances,
+		query: func(repo *PostgresRepository, from, to storage.BlockNumber) sq.SelectBuilder {
+			// the balance at the end of a block is the balance after the last transaction changing it in the block
+			return repo.statementBuilder.
+				Select("balance.block_id", "balance.address_id", "balance.balance", "last_change.balance_after").
+				From(tableNameEtherBalances+" balance").
+				Join("LATERAL ("+
+					"SELECT state_change.balance_after FROM "+tableNameStateChanges+" state_change "+
+					"JOIN "+tableNameTransactions+" tx ON tx.id = state_change.transaction_id "+
+					"WHERE tx.block_id = balance.block_id AND state_change.address_id = balance.address_id "+
+					"ORDER BY tx.index DESC LIMIT 1) last_change ON true").
+				Where("balance.block_id BETWEEN ? AND ?", from, to).
+				Where("balance.balance <> last_change.balance_after").
+				OrderBy("balance.block_id", "balance.address_id")
+		},
+		detail: func(scanner sq.RowScanner) (storage.BlockNumber, string, error) {
+			var etherBalance storage.EtherBalance
+			var balance, balanceAfter numeric
+			err := scanner.Scan(&etherBalance.BlockNumber, &etherBalance.AddressId, &balance, &balanceAfter)
+			return etherBalance.BlockNumber, fmt.Sprintf("%s is %v, but its last state change in the block left %v",
+				sqlcommon.DescribeEtherBalance(&etherBalance), balance.Value, balanceAfter.Value), err
+		},
+	},
+}
+
+// CheckIntegrity walks the data stored between the given blocks, and returns the inconsistencies found, ordered by check.
+// The checks only see what was stored: e.g. blocks without transactions can not be told from blocks whose transactions are missing.
+func (repo *PostgresRepository) CheckIntegrity(from, to storage.BlockNumber) (inconsistencies []*Inconsistency, err error) {
+	defer annotate(&err, "failed to check integrity of blocks between %d and %d", from, to)
+	missingRanges, err := repo.ListMissingBlockRanges(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, missingRange := range missingRanges {
+		inconsistencies = append(inconsistencies, &Inconsistency{
+			Check:       IntegrityCheckMissingBlocks,
+			BlockNumber: missingRange.From,
+			Detail:      fmt.Sprintf("blocks %d to %d are missing", missingRange.From, missingRange.To),
+		})
+	}
+	for _, check := range integrityChecks {
+		found, err := repo.runIntegrityCheck(check, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %w", check.name, err)
+		}
+		inconsistencies = append(inconsistencies, found...)
+	}
+	return inconsistencies, nil
+}
+func (repo *PostgresRepository) runIntegrityCheck(check integrityCheck, from, to storage.BlockNumber) (inconsistencies []*Inconsistency, err error) {
+	rows, err := check.query(repo, from, to).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		blockNumber, detail, err := check.detail(rows)
+		if err != nil {
+			return nil, err
+		}
+		inconsistencies = append(inconsistencies, &Inconsistency{Check: check.name, BlockNumber: blockNumber, Detail: detail})
+	}
+	return inconsistencies, rows.Err()
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+func TestCheckIntegrityReportsInconsistencies(t *testing.T) {
+	repo := newTestRepository(t, newTestDatabase(t))
+	chain := newTestChain(t, repo)
+	sender, recipient := chain.address(common.HexToAddress("0xbb")), chain.address(common.HexToAddress("0xcc"))
+	chain.storeBlocks(1)
+	// block 2 does not follow block 1
+	orphan := chain.block(2)
+	orphan.ParentHash = common.HexToHash("0xbad")
+	block3 := chain.block(3)
+	block3.GasUsed = 21000
+	if err := repo.StoreBlock(orphan, block3); err != nil {
+		t.Fatal(err)
+	}
+	// the second transaction of block 3 has no receipt
+	withReceipt := chain.storeTransaction(3, 0, sender, recipient)
+	chain.storeTransaction(3, 1, sender, recipient)
+	if err := repo.StoreReceipt(&storage.Receipt{TransactionId: withReceipt, CumulativeGasUsed: 21000, GasUsed: 21000, Status: storage.ReceiptStatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	chain.commit()
+
+	inconsistencies, err := repo.CheckIntegrity(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]storage.BlockNumber, len(inconsistencies))
+	for _, inconsistency := range inconsistencies {
+		found[inconsistency.Check] = inconsistency.BlockNumber
+	}
+	want := map[string]storage.BlockNumber{IntegrityCheckParentHash: 2, IntegrityCheckMissingReceipts: 3}
+	if len(inconsistencies) != len(want) || !reflect.DeepEqual(found, want) {
+		for _, inconsistency := range inconsistencies {
+			t.Logf("%s at block %d: %s", inconsistency.Check, inconsistency.BlockNumber, inconsistency.Detail)
+		}
+		t.Errorf("inconsistencies by check: got %v, want %v", found, want)
+	}
+
+	// the consistent blocks alone are not reported
+	if inconsistencies, err = repo.CheckIntegrity(1, 1); err != nil || len(inconsistencies) != 0 {
+		t.Errorf("checking block 1: got %d inconsistencies, %v, want none", len(inconsistencies), err)
+	}
+}
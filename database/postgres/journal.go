@@ -0,0 +1,291 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// maxJournalLength bounds the memory used by the journal, longer transactions are not replayed.
+const maxJournalLength = 100000
+
+// errTransactionLost is returned when a transaction failed and could not be replayed,
+// so the writes since the last commit have to be redone by the caller.
+var errTransactionLost = fmt.Errorf("%w: the transaction was lost, writes since the last commit have to be redone", storage.ErrRetryable)
+
+type journalEntry struct {
+	query string
+	args  []any
+	// result is the fingerprint of the row scanned by a read, nil for writes.
+	result *string
+	// destinationTypes are the types the row of a read was scanned into.
+	destinationTypes []reflect.Type
+}
+
+// journalingRunner runs the statements of the repository's transaction, recording the ones since the first write of the transaction.
+// When a statement fails because the transaction was aborted by a serialization failure or lost its connection,
+// it begins a new transaction, replays the recorded statements, and runs the failed statement again.
+// Replaying is abandoned if a recorded read returns a different row, e.g. as a sequence generated new ids,
+// or if rows were read which could not be recorded, then errTransactionLost is returned.
+type journalingRunner struct {
+	conn   *sql.DB
+	tx     *sql.Tx
+	policy storage.RetryPolicy
+
+	journal    []journalEntry
+	writing    bool
+	replayable bool
+	// failed is set while tx is rolled back or committed, and no new transaction could be begun yet.
+	failed bool
+	// lost is called when a transaction was abandoned, so cached state stored by it can be dropped.
+	lost func()
+}
+
+func newJournalingRunner(conn *sql.DB, lost func()) *journalingRunner {
+	runner := &journalingRunner{conn: conn, policy: storage.DefaultRetryPolicy, replayable: true, lost: lost}
+	runner.policy.Retryable = func(err error) bool {
+		return isRetryable(err) && !errors.Is(err, errTransactionLost)
+	}
+	return runner
+}
+
+func isRetryable(err error) bool {
+	return errors.Is(mapError(err), storage.ErrRetryable)
+}
+
+// begin begins the next transaction, the journal is left to the caller.
+func (runner *journalingRunner) begin(ctx context.Context) error {
+	runner.failed = true
+	return runner.policy.Do(ctx, func() error {
+		tx, err := runner.conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		runner.tx = tx
+		runner.failed = false
+		return nil
+	})
+}
+func (runner *journalingRunner) resetJournal() {
+	runner.journal = nil
+	runner.writing = false
+	runner.replayable = true
+}
+
+// commit commits the transaction and begins the next one.
+// If committing fails, the transaction is lost: it can not be replayed, as it might have been committed before losing the connection.
+func (runner *journalingRunner) commit(ctx context.Context) error {
+	if runner.failed {
+		// the transaction was abandoned, and nothing was written since
+		return runner.recover()
+	}
+	err := runner.tx.Commit()
+	runner.resetJournal()
+	if err != nil {
+		runner.lost()
+		if isRetryable(err) {
+			err = fmt.Errorf("%w: %w", errTransactionLost, err)
+		}
+		return errors.Join(err, runner.begin(ctx))
+	}
+	return runner.begin(ctx)
+}
+
+// run runs the statement, recovering the transaction and retrying the statement after transient failures.
+func (runner *journalingRunner) run(statement func() error) error {
+	return runner.policy.Do(context.Background(), func() error {
+		if runner.failed {
+			if err := runner.recover(); err != nil {
+				return err
+			}
+		}
+		err := statement()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if recoverErr := runner.recover(); recoverErr != nil {
+			return recoverErr
+		}
+		return err
+	})
+}
+
+// recover replaces the failed transaction by a new one replaying the journal.
+func (runner *journalingRunner) recover() error {
+	// rolling back fails if the connection is lost or tx is done already, the server has rolled back then
+	_ = runner.tx.Rollback()
+	if err := runner.begin(context.Background()); err != nil {
+		return err
+	}
+	if !runner.replayable {
+		return runner.abandon()
+	}
+	replayed, err := runner.replay()
+	if err != nil {
+		if isRetryable(err) {
+			runner.failed = true
+			return err
+		}
+		return errors.Join(runner.abandon(), err)
+	}
+	if !replayed {
+		return runner.abandon()
+	}
+	return nil
+}
+
+// replay runs the journal in the new transaction, and reports whether all reads returned the recorded rows.
+func (runner *journalingRunner) replay() (bool, error) {
+	for _, entry := range runner.journal {
+		if entry.result == nil {
+			if _, err := runner.tx.Exec(entry.query, entry.args...); err != nil {
+				return false, err
+			}
+			continue
+		}
+		destinations := make([]any, 0, len(entry.destinationTypes))
+		for _, destinationType := range entry.destinationTypes {
+			destinations = append(destinations, reflect.New(destinationType).Interface())
+		}
+		result, err := fingerprintRow(runner.tx.QueryRow(entry.query, entry.args...), destinations)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		if result != *entry.result {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// abandon drops the journal, the next statement begins a new transaction.
+func (runner *journalingRunner) abandon() error {
+	runner.resetJournal()
+	runner.failed = true
+	runner.lost()
+	return errTransactionLost
+}
+
+func (runner *journalingRunner) record(entry journalEntry) {
+	if !runner.replayable {
+		return
+	}
+	if len(runner.journal) == maxJournalLength {
+		runner.journal = nil
+		runner.replayable = false
+		return
+	}
+	runner.journal = append(runner.journal, entry)
+}
+
+func (runner *journalingRunner) Exec(query string, args ...any) (result sql.Result, err error) {
+	err = runner.run(func() (err error) {
+		result, err = runner.tx.Exec(query, args...)
+		return
+	})
+	if err == nil {
+		runner.writing = true
+		runner.record(journalEntry{query: query, args: args})
+	}
+	return
+}
+
+// Query runs a read which can not be recorded, as its rows are scanned by the caller.
+// The transaction is not replayed if anything is written after it.
+func (runner *journalingRunner) Query(query string, args ...any) (rows *sql.Rows, err error) {
+	err = runner.run(func() (err error) {
+		rows, err = runner.tx.Query(query, args...)
+		return
+	})
+	if err == nil && runner.writing {
+		runner.journal = nil
+		runner.replayable = false
+	}
+	return
+}
+func (runner *journalingRunner) QueryRow(query string, args ...any) sq.RowScanner {
+	return runner.queryRow(query, args...)
+}
+func (runner *journalingRunner) queryRow(query string, args ...any) *journaledRow {
+	return &journaledRow{runner: runner, query: query, args: args}
+}
+
+// journaledRow runs its query when scanned, so its result can be recorded.
+type journaledRow struct {
+	runner *journalingRunner
+	query  string
+	args   []any
+}
+
+func (row *journaledRow) Scan(destinations ...any) error {
+	var result string
+	err := row.runner.run(func() (err error) {
+		result, err = fingerprintRow(row.runner.tx.QueryRow(row.query, row.args...), destinations)
+		return
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	// reads before the first write only see committed rows, they do not need to be replayed
+	if row.runner.writing {
+		destinationTypes := make([]reflect.Type, 0, len(destinations))
+		for _, destination := range destinations {
+			destinationTypes = append(destinationTypes, reflect.TypeOf(destination).Elem())
+		}
+		row.runner.record(journalEntry{query: row.query, args: row.args, result: &result, destinationTypes: destinationTypes})
+	}
+	return err
+}
+
+// Err reports no error, as errors are reported by Scan.
+func (row *journaledRow) Err() error {
+	return nil
+}
+
+// fingerprintRow scans the row into destinations, and returns the text form of the scanned values.
+// sql.ErrNoRows is returned along with its fingerprint, as not finding a row is a result too.
+func fingerprintRow(row *sql.Row, destinations []any) (string, error) {
+	if err := row.Scan(destinations...); err != nil {
+		if err == sql.ErrNoRows {
+			return "no rows", err
+		}
+		return "", err
+	}
+	var fingerprint strings.Builder
+	for _, destination := range destinations {
+		appendFingerprint(&fingerprint, reflect.ValueOf(destination))
+	}
+	return fingerprint.String(), nil
+}
+
+// appendFingerprint appends the text form of the value, following pointers unlike fmt.
+func appendFingerprint(fingerprint *strings.Builder, value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			fingerprint.WriteString("nil ")
+			return
+		}
+		appendFingerprint(fingerprint, value.Elem())
+	case reflect.Struct:
+		fingerprint.WriteString("{ ")
+		for i := 0; i < value.NumField(); i++ {
+			appendFingerprint(fingerprint, value.Field(i))
+		}
+		fingerprint.WriteString("} ")
+	case reflect.Slice, reflect.Array:
+		fingerprint.WriteString("[ ")
+		for i := 0; i < value.Len(); i++ {
+			appendFingerprint(fingerprint, value.Index(i))
+		}
+		fingerprint.WriteString("] ")
+	default:
+		fmt.Fprint(fingerprint, value, " ")
+	}
+}
@@ -0,0 +1,306 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// fakeServer is a database/sql connector standing in for postgres in the tests of the journal.
+// Writes are recorded as their query text, and become visible to other transactions once committed.
+// Reads return a single integer:
+//   - "SELECT count" returns the number of writes visible to the transaction,
+//   - "SELECT nextval" returns the next value of a sequence, which is not rolled back, like the sequences of postgres.
+type fakeServer struct {
+	mu        sync.Mutex
+	committed []string
+	sequence  int64
+	// failures is the number of statements still to fail with a serialization failure, aborting their transaction.
+	failures int
+}
+
+func (server *fakeServer) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{server: server}, nil
+}
+func (server *fakeServer) Driver() driver.Driver {
+	return fakeDriver{server}
+}
+func (server *fakeServer) committedWrites() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return append([]string(nil), server.committed...)
+}
+func (server *fakeServer) failNextStatements(count int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.failures = count
+}
+
+type fakeDriver struct {
+	server *fakeServer
+}
+
+func (fakeDriver fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeDriver.server.Connect(context.Background())
+}
+
+type fakeConn struct {
+	server *fakeServer
+	tx     *fakeTx
+}
+
+func (conn *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported: %s", query)
+}
+func (conn *fakeConn) Close() error {
+	return nil
+}
+func (conn *fakeConn) Begin() (driver.Tx, error) {
+	conn.tx = &fakeTx{conn: conn}
+	return conn.tx, nil
+}
+
+// run fails the statement if the transaction is aborted or a failure is pending.
+func (conn *fakeConn) run() error {
+	if conn.tx == nil {
+		return fmt.Errorf("statement outside of a transaction")
+	}
+	if conn.tx.aborted {
+		return &pq.Error{Code: "25P02", Message: "current transaction is aborted"}
+	}
+	if conn.server.failures > 0 {
+		conn.server.failures--
+		conn.tx.aborted = true
+		return &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	}
+	return nil
+}
+func (conn *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	conn.server.mu.Lock()
+	defer conn.server.mu.Unlock()
+	if err := conn.run(); err != nil {
+		return nil, err
+	}
+	conn.tx.pending = append(conn.tx.pending, query)
+	return driver.RowsAffected(1), nil
+}
+func (conn *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	conn.server.mu.Lock()
+	defer conn.server.mu.Unlock()
+	if err := conn.run(); err != nil {
+		return nil, err
+	}
+	var value int64
+	switch query {
+	case "SELECT count":
+		value = int64(len(conn.server.committed) + len(conn.tx.pending))
+	case "SELECT nextval":
+		conn.server.sequence++
+		value = conn.server.sequence
+	default:
+		return nil, fmt.Errorf("unknown query %s", query)
+	}
+	return &fakeRows{values: []int64{value}}, nil
+}
+
+type fakeTx struct {
+	conn    *fakeConn
+	pending []string
+	aborted bool
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.conn.server.mu.Lock()
+	defer tx.conn.server.mu.Unlock()
+	tx.conn.tx = nil
+	if tx.aborted {
+		return pq.ErrInFailedTransaction
+	}
+	tx.conn.server.committed = append(tx.conn.server.committed, tx.pending...)
+	return nil
+}
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+type fakeRows struct {
+	values []int64
+}
+
+func (rows *fakeRows) Columns() []string {
+	return []string{"value"}
+}
+func (rows *fakeRows) Close() error {
+	return nil
+}
+func (rows *fakeRows) Next(destination []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	destination[0] = rows.values[0]
+	rows.values = rows.values[1:]
+	return nil
+}
+
+// newTestRunner returns a runner with a begun transaction on a fake server, and the number of transactions it lost.
+func newTestRunner(t *testing.T) (*fakeServer, *journalingRunner, *int) {
+	t.Helper()
+	server := &fakeServer{}
+	conn := sql.OpenDB(server)
+	t.Cleanup(func() { conn.Close() })
+	lost := new(int)
+	runner := newJournalingRunner(conn, func() { *lost++ })
+	runner.policy.InitialDelay = time.Millisecond
+	if err := runner.begin(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return server, runner, lost
+}
+func mustExec(t *testing.T, runner *journalingRunner, query string) {
+	t.Helper()
+	if _, err := runner.Exec(query); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+func mustQueryRow(t *testing.T, runner *journalingRunner, query string) int64 {
+	t.Helper()
+	var value int64
+	if err := runner.QueryRow(query).Scan(&value); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return value
+}
+
+func TestJournalReplaysAfterSerializationFailure(t *testing.T) {
+	server, runner, lost := newTestRunner(t)
+	// reads before the first write are not recorded
+	if count := mustQueryRow(t, runner, "SELECT count"); count != 0 {
+		t.Fatalf("counted %d writes in an empty database", count)
+	}
+	mustExec(t, runner, "INSERT 1")
+	if count := mustQueryRow(t, runner, "SELECT count"); count != 1 {
+		t.Fatalf("counted %d writes, expected 1", count)
+	}
+	if len(runner.journal) != 2 {
+		t.Fatalf("journal has %d entries, expected the write and the read following it", len(runner.journal))
+	}
+
+	server.failNextStatements(1)
+	mustExec(t, runner, "INSERT 2")
+	if count := mustQueryRow(t, runner, "SELECT count"); count != 2 {
+		t.Errorf("counted %d writes after the replay, expected 2", count)
+	}
+	if err := runner.commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if committed := server.committedWrites(); !reflect.DeepEqual(committed, []string{"INSERT 1", "INSERT 2"}) {
+		t.Errorf("committed %v, expected every write exactly once", committed)
+	}
+	if *lost != 0 {
+		t.Errorf("%d transactions were lost", *lost)
+	}
+	if len(runner.journal) != 0 || runner.writing || !runner.replayable {
+		t.Error("the journal was not reset by the commit")
+	}
+}
+
+func TestJournalReplayFailsOnDifferentRead(t *testing.T) {
+	server, runner, lost := newTestRunner(t)
+	mustExec(t, runner, "INSERT 1")
+	// the sequence returns a new value in the replay, so later writes might depend on a value which changed
+	mustQueryRow(t, runner, "SELECT nextval")
+
+	server.failNextStatements(1)
+	_, err := runner.Exec("INSERT 2")
+	if !errors.Is(err, errTransactionLost) || !errors.Is(err, storage.ErrRetryable) {
+		t.Fatalf("writing after a failed replay returned %v, expected %v", err, errTransactionLost)
+	}
+	if *lost != 1 {
+		t.Errorf("%d transactions were lost, expected 1", *lost)
+	}
+	// the caller redoes the writes since the last commit in a new transaction
+	mustExec(t, runner, "INSERT 1")
+	if err = runner.commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if committed := server.committedWrites(); !reflect.DeepEqual(committed, []string{"INSERT 1"}) {
+		t.Errorf("committed %v, expected the redone write only", committed)
+	}
+}
+
+func TestJournalIsNotReplayedAfterQuery(t *testing.T) {
+	server, runner, lost := newTestRunner(t)
+	rows, err := runner.Query("SELECT count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if !runner.replayable {
+		t.Fatal("a query before the first write made the journal unreplayable")
+	}
+	mustExec(t, runner, "INSERT 1")
+	// the rows of a query are scanned by the caller, so they can not be compared in a replay
+	if rows, err = runner.Query("SELECT count"); err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if runner.replayable {
+		t.Fatal("a query after a write left the journal replayable")
+	}
+
+	server.failNextStatements(1)
+	if _, err = runner.Exec("INSERT 2"); !errors.Is(err, errTransactionLost) {
+		t.Fatalf("writing after a failure returned %v, expected %v", err, errTransactionLost)
+	}
+	if *lost != 1 {
+		t.Errorf("%d transactions were lost, expected 1", *lost)
+	}
+	if count := mustQueryRow(t, runner, "SELECT count"); count != 0 {
+		t.Errorf("counted %d writes in the new transaction, expected 0", count)
+	}
+}
+
+func TestJournalExceedingMaxJournalLength(t *testing.T) {
+	server, runner, lost := newTestRunner(t)
+	for i := 0; i < maxJournalLength; i++ {
+		mustExec(t, runner, "INSERT")
+	}
+	if !runner.replayable || len(runner.journal) != maxJournalLength {
+		t.Fatalf("journal of %d entries is not replayable, expected %d entries to be", len(runner.journal), maxJournalLength)
+	}
+	mustExec(t, runner, "INSERT")
+	if runner.replayable || runner.journal != nil {
+		t.Fatalf("journal exceeding %d entries was kept", maxJournalLength)
+	}
+
+	server.failNextStatements(1)
+	_, err := runner.Exec("INSERT")
+	if !errors.Is(err, errTransactionLost) {
+		t.Fatalf("writing after a failure returned %v, expected %v", err, errTransactionLost)
+	}
+	if *lost != 1 {
+		t.Errorf("%d transactions were lost, expected 1", *lost)
+	}
+	if err = runner.commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if committed := server.committedWrites(); len(committed) != 0 {
+		t.Errorf("committed %d writes of the lost transaction", len(committed))
+	}
+	// the next transaction is journaled again
+	mustExec(t, runner, "INSERT")
+	if !runner.replayable || len(runner.journal) != 1 {
+		t.Error("the journal was not reset after the lost transaction")
+	}
+}
@@ -0,0 +1,672 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// ErrMigrationsPending is returned by Load when the database was created by an earlier version of the repository.
+// Pending migrations are applied by Migrate, see cmd/migrate.
+var ErrMigrationsPending = fmt.Errorf("%w: database schema has pending migrations", storage.ErrSchemaMismatch)
+
+// MigrationProgress is called after each step of a migration on a table, e.g. a converted batch of rows or a built index.
+// done and total are in the units of the migration, e.g. keys of the table or indexes.
+type MigrationProgress func(migrationName, tableName string, done, total int64)
+
+const defaultMigrationBatchSize = 10000
+
+type migration struct {
+	name    string
+	pending func(ctx context.Context, conn *sql.DB) (bool, error)
+	apply   func(ctx context.Context, conn *sql.DB, batchSize int64, progress MigrationProgress) error
+}
+
+var migrations = []migration{
+	{
+		name:    "numeric_columns",
+		pending: numericColumnsPending,
+		apply:   migrateNumericColumns,
+	},
+	{
+		name:    "trace_call_details",
+		pending: traceCallDetailsPending,
+		apply:   addTraceCallDetails,
+	},
+	{
+		name:    "secondary_indexes",
+		pending: secondaryIndexesPending,
+		apply:   createSecondaryIndexes,
+	},
+}
+
+// pendingMigrations returns the names of the migrations still to be applied to the database.
+func pendingMigrations(ctx context.Context, conn *sql.DB) (names []string, err error) {
+	for _, migration := range migrations {
+		pending, err := migration.pending(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check migration %s: %w", migration.name, err)
+		}
+		if pending {
+			names = append(names, migration.name)
+		}
+	}
+	return
+}
+
+// checkMigrations applies the pending migrations to an empty database, and fails for any other database.
+func (repo *PostgresRepository) checkMigrations() error {
+	ctx := context.Background()
+	pending, err := pendingMigrations(ctx, repo.conn)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	empty, err := isEmptyDatabase(ctx, repo.conn)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("%w: %s", ErrMigrationsPending, strings.Join(pending, ", "))
+	}
+	return applyMigrations(ctx, repo.conn, defaultMigrationBatchSize, nil)
+}
+
+// Migrate applies the pending migrations in batches of batchSize keys.
+// Migrations are online: while they run, an earlier version of the repository can keep reading and writing the database,
+// only the final swap of every table takes a short exclusive lock.
+// Migrate can be interrupted at any time, the next run resumes the conversion.
+func (repo *PostgresRepository) Migrate(ctx context.Context, batchSize int64, progress MigrationProgress) (err error) {
+	defer annotate(&err, "failed to migrate the database")
+	if batchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d", batchSize)
+	}
+	if err = repo.openPostgresConnection(false); err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := repo.conn.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return applyMigrations(ctx, repo.conn, batchSize, progress)
+}
+func applyMigrations(ctx context.Context, conn *sql.DB, batchSize int64, progress MigrationProgress) error {
+	for _, migration := range migrations {
+		pending, err := migration.pending(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", migration.name, err)
+		}
+		if !pending {
+			continue
+		}
+		if err = migration.apply(ctx, conn, batchSize, progress); err != nil {
+			return fmt.Errorf("migration %s failed: %w", migration.name, err)
+		}
+	}
+	return nil
+}
+
+// isEmptyDatabase reports whether no blocks were stored yet, in which case migrations are applied by Load right away.
+func isEmptyDatabase(ctx context.Context, conn *sql.DB) (bool, error) {
+	var hasBlocks bool
+	err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT EXISTS (SELECT FROM %s)", tableNameBlocks)).Scan(&hasBlocks)
+	return !hasBlocks, err
+}
+
+// numericColumn is a column formerly stored as big-endian bytea.
+type numericColumn struct {
+	name    string
+	sqlType string
+	notNull bool
+	// conversion overrides the default conversion of the bytea value, %[1]s being the prefix of the row's columns.
+	conversion string
+	// defaultValue is the default of the column, set again after the swap, as the converted column is added without it.
+	defaultValue string
+}
+
+type numericTable struct {
+	name string
+	// batchKey is the leading column of an index of the table, used to convert the rows in ranges.
+	batchKey string
+	// primaryKey is set when the primary key contains converted columns, and has to be rebuilt.
+	primaryKey []string
+	columns    []numericColumn
+	// sourceType is the udt_name of the columns before the conversion, bytea unless set.
+	sourceType string
+}
+
+const (
+	sqlTypeNumeric = "numeric(78,0)"
+	sqlTypeBigint  = "bigint"
+
+	numericColumnSuffix = "__numeric"
+)
+
+// legacyNull is what earlier versions stored instead of NULL in nullable bytea columns.
+const legacyNull = `'NULL'::bytea`
+
+var numericTables = []numericTable{
+	{
+		name:     tableNameBlocks,
+		batchKey: "number",
+		columns: []numericColumn{
+			{name: "nonce", sqlType: "numeric(20,0)", notNull: true},
+			{name: "difficulty", sqlType: sqlTypeNumeric, notNull: true},
+			{name: "total_difficulty", sqlType: sqlTypeNumeric, notNull: true},
+			{name: "size", sqlType: sqlTypeBigint},
+			{name: "gas_limit", sqlType: sqlTypeBigint},
+			{name: "gas_used", sqlType: sqlTypeBigint},
+			{name: "base_fee", sqlType: sqlTypeNumeric},
+			{name: "static_reward", sqlType: sqlTypeNumeric, notNull: true},
+		},
+	},
+	{
+		name:     tableNameUncles,
+		batchKey: "block_height",
+		columns: []numericColumn{
+			{name: "difficulty", sqlType: sqlTypeNumeric, notNull: true},
+			{name: "reward", sqlType: sqlTypeNumeric, notNull: true},
+		},
+	},
+	{
+		name:     tableNameTransactions,
+		batchKey: "id",
+		columns: []numericColumn{
+			{name: "nonce", sqlType: sqlTypeBigint, notNull: true},
+			{name: "index", sqlType: sqlTypeBigint, notNull: true},
+			{name: "value", sqlType: sqlTypeNumeric, notNull: true},
+			{name: "gas", sqlType: sqlTypeBigint, notNull: true},
+			{name: "gas_price", sqlType: sqlTypeNumeric, notNull: true},
+			// legacy transactions have no fee caps, earlier versions stored garbage for them
+			{name: "gas_tip_cap", sqlType: sqlTypeNumeric,
+				conversion: `CASE WHEN %[1]s"transaction_type" < 2 THEN NULL ELSE bytea_to_numeric(%[1]s"gas_tip_cap") END`},
+			{name: "gas_fee_cap", sqlType: sqlTypeNumeric,
+				conversion: `CASE WHEN %[1]s"transaction_type" < 2 THEN NULL ELSE bytea_to_numeric(%[1]s"gas_fee_cap") END`},
+		},
+	},
+	{
+		name:     tableNameReceipts,
+		batchKey: "transaction_id",
+		columns: []numericColumn{
+			{name: "cumulative_gas_used", sqlType: sqlTypeBigint, notNull: true},
+			{name: "gas_used", sqlType: sqlTypeBigint, notNull: true},
+			{name: "effective_gas_price", sqlType: sqlTypeNumeric, notNull: true},
+		},
+	},
+	{
+		name:       tableNameLogs,
+		batchKey:   "transaction_id",
+		primaryKey: []string{"transaction_id", "index"},
+		columns: []numericColumn{
+			{name: "index", sqlType: sqlTypeBigint, notNull: true},
+		},
+	},
+	{
+		name:     tableNameErc20Tokens,
+		batchKey: "address_id",
+		columns: []numericColumn{
+			{name: "total_supply", sqlType: sqlTypeNumeric},
+		},
+	},
+	{
+		name:       tableNameErc20TokenTransfers,
+		batchKey:   "transaction_id",
+		primaryKey: []string{"transaction_id", "log_index"},
+		columns: []numericColumn{
+			{name: "log_index", sqlType: sqlTypeBigint, notNull: true},
+			{name: "value", sqlType: sqlTypeNumeric, notNull: true},
+		},
+	},
+	{
+		name:     tableNameTraces,
+		batchKey: "transaction_id",
+		columns: []numericColumn{
+			{name: "value", sqlType: sqlTypeNumeric, notNull: true},
+		},
+	},
+	{
+		name:     tableNameEtherBalances,
+		batchKey: "address_id",
+		columns: []numericColumn{
+			{name: "balance", sqlType: sqlTypeNumeric, notNull: true},
+		},
+	},
+	{
+		name:     tableNameErc20TokenBalances,
+		batchKey: "address_id",
+		columns: []numericColumn{
+			{name: "balance", sqlType: sqlTypeNumeric, notNull: true},
+		},
+	},
+	{
+		name:     tableNameStateChanges,
+		batchKey: "transaction_id",
+		columns: []numericColumn{
+			{name: "balance_before", sqlType: sqlTypeNumeric, notNull: true,
+				conversion: `CASE WHEN %[1]s"balance_before" = ` + legacyNull + ` THEN 0 ELSE bytea_to_numeric(%[1]s"balance_before") END`},
+			{name: "balance_after", sqlType: sqlTypeNumeric, notNull: true},
+			{name: "nonce_before", sqlType: sqlTypeBigint,
+				conversion: `CASE WHEN %[1]s"nonce_before" = ` + legacyNull + ` THEN NULL ELSE bytea_to_numeric(%[1]s"nonce_before")::bigint END`},
+			{name: "nonce_after", sqlType: sqlTypeBigint,
+				conversion: `CASE WHEN %[1]s"nonce_after" = ` + legacyNull + ` THEN NULL ELSE bytea_to_numeric(%[1]s"nonce_after")::bigint END`},
+		},
+	},
+}
+
+const byteaToNumericFunction = `CREATE OR REPLACE FUNCTION bytea_to_numeric(value bytea) RETURNS numeric AS $$
+DECLARE
+    result numeric := 0;
+BEGIN
+    IF value IS NULL THEN
+        RETURN NULL;
+    END IF;
+    FOR i IN 0 .. length(value) - 1 LOOP
+        result := result * 256 + get_byte(value, i);
+    END LOOP;
+    RETURN result;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE STRICT`
+
+// unquotedTableName strips the quotes of the table name constants.
+func unquotedTableName(tableName string) string {
+	return strings.Trim(tableName, `"`)
+}
+
+// convertedColumn returns the temporary column holding the converted values of the column.
+func (column numericColumn) convertedColumn() string {
+	return pq.QuoteIdentifier(column.name + numericColumnSuffix)
+}
+
+// convertedValue returns the expression converting the bytea value of the column, its columns prefixed with rowPrefix.
+func (column numericColumn) convertedValue(rowPrefix string) string {
+	if column.conversion != "" {
+		return fmt.Sprintf(column.conversion, rowPrefix)
+	}
+	conversion := fmt.Sprintf("bytea_to_numeric(%s%s)", rowPrefix, pq.QuoteIdentifier(column.name))
+	if column.sqlType == sqlTypeBigint {
+		conversion += "::bigint"
+	}
+	return conversion
+}
+
+// notNullConstraint is the name of the check constraint allowing the converted column to become NOT NULL without a full table scan.
+func (table numericTable) notNullConstraint(column numericColumn) string {
+	return pq.QuoteIdentifier(unquotedTableName(table.name) + "_" + column.name + numericColumnSuffix + "_not_null")
+}
+func (table numericTable) syncFunction() string {
+	return pq.QuoteIdentifier(unquotedTableName(table.name) + "_numeric_sync")
+}
+func (table numericTable) primaryKeyIndex() string {
+	return unquotedTableName(table.name) + "_numeric_pkey"
+}
+
+func numericColumnsPending(ctx context.Context, conn *sql.DB) (bool, error) {
+	for _, table := range numericTables {
+		for _, column := range table.columns {
+			var dataType string
+			err := conn.QueryRowContext(ctx,
+				"SELECT data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2",
+				unquotedTableName(table.name), column.name).
+				Scan(&dataType)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					continue
+				}
+				return false, err
+			}
+			if dataType == "bytea" {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func migrateNumericColumns(ctx context.Context, conn *sql.DB, batchSize int64, progress MigrationProgress) error {
+	if _, err := conn.ExecContext(ctx, byteaToNumericFunction); err != nil {
+		return err
+	}
+	if err := convertTables(ctx, conn, "numeric_columns", numericTables, batchSize, progress); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, "DROP FUNCTION IF EXISTS bytea_to_numeric(bytea)")
+	return err
+}
+
+// convertTables converts the columns of every table to their new type in four steps:
+//  1. a column of the new type is added next to each column, kept in sync by a trigger for rows written meanwhile,
+//  2. existing rows are converted in batches of batchKey ranges,
+//  3. NOT NULL checks and the new primary key index are built without blocking writes,
+//  4. the former columns are replaced by the converted ones under a short exclusive lock.
+func convertTables(ctx context.Context, conn *sql.DB, migrationName string, tables []numericTable, batchSize int64, progress MigrationProgress) error {
+	for _, table := range tables {
+		pending, err := table.pending(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if !pending {
+			continue
+		}
+		if err = table.addConvertedColumns(ctx, conn); err != nil {
+			return fmt.Errorf("failed to prepare %s: %w", table.name, err)
+		}
+		if err = table.convertRows(ctx, conn, migrationName, batchSize, progress); err != nil {
+			return fmt.Errorf("failed to convert %s: %w", table.name, err)
+		}
+		if err = table.buildConstraints(ctx, conn); err != nil {
+			return fmt.Errorf("failed to build constraints of %s: %w", table.name, err)
+		}
+		if err = table.swapColumns(ctx, conn); err != nil {
+			return fmt.Errorf("failed to swap columns of %s: %w", table.name, err)
+		}
+	}
+	return nil
+}
+func (table numericTable) pending(ctx context.Context, conn *sql.DB) (bool, error) {
+	sourceType := table.sourceType
+	if sourceType == "" {
+		sourceType = "bytea"
+	}
+	var unconvertedColumns int
+	err := conn.QueryRowContext(ctx,
+		"SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND udt_name = $2 AND column_name = ANY($3)",
+		unquotedTableName(table.name), sourceType, pq.Array(table.columnNames())).
+		Scan(&unconvertedColumns)
+	return unconvertedColumns > 0, err
+}
+func (table numericTable) columnNames() []string {
+	names := make([]string, 0, len(table.columns))
+	for _, column := range table.columns {
+		names = append(names, column.name)
+	}
+	return names
+}
+func (table numericTable) addConvertedColumns(ctx context.Context, conn *sql.DB) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var addColumns, syncColumns, syncedColumns []string
+	for _, column := range table.columns {
+		addColumns = append(addColumns, fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s %s NULL", column.convertedColumn(), column.sqlType))
+		syncColumns = append(syncColumns, fmt.Sprintf("    NEW.%s := %s;", column.convertedColumn(), column.convertedValue("NEW.")))
+		syncedColumns = append(syncedColumns, pq.QuoteIdentifier(column.name))
+	}
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE %s %s", table.name, strings.Join(addColumns, ", ")),
+		fmt.Sprintf("CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$\nBEGIN\n%s\n    RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql",
+			table.syncFunction(), strings.Join(syncColumns, "\n")),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %[1]s ON %[2]s", table.syncFunction(), table.name),
+		fmt.Sprintf("CREATE TRIGGER %[1]s BEFORE INSERT OR UPDATE OF %[3]s ON %[2]s FOR EACH ROW EXECUTE FUNCTION %[1]s()",
+			table.syncFunction(), table.name, strings.Join(syncedColumns, ", ")),
+	}
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+func (table numericTable) convertRows(ctx context.Context, conn *sql.DB, migrationName string, batchSize int64, progress MigrationProgress) error {
+	var firstKey, lastKey sql.NullInt64
+	err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT min(%[1]s), max(%[1]s) FROM %[2]s", pq.QuoteIdentifier(table.batchKey), table.name)).
+		Scan(&firstKey, &lastKey)
+	if err != nil || !firstKey.Valid {
+		return err
+	}
+	var setColumns, unconvertedRows []string
+	for _, column := range table.columns {
+		setColumns = append(setColumns, fmt.Sprintf("%s = %s", column.convertedColumn(), column.convertedValue("")))
+		unconvertedRows = append(unconvertedRows, fmt.Sprintf("(%s IS NOT NULL AND %s IS NULL)", pq.QuoteIdentifier(column.name), column.convertedColumn()))
+	}
+	// rows converted by an interrupted run, or by the trigger, are skipped
+	statement := fmt.Sprintf("UPDATE %s SET %s WHERE %[3]s >= $1 AND %[3]s < $2 AND (%[4]s)",
+		table.name, strings.Join(setColumns, ", "), pq.QuoteIdentifier(table.batchKey), strings.Join(unconvertedRows, " OR "))
+	for from := firstKey.Int64; from <= lastKey.Int64; from += batchSize {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if _, err = conn.ExecContext(ctx, statement, from, from+batchSize); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(migrationName, table.name, from+batchSize-1, lastKey.Int64)
+		}
+	}
+	return nil
+}
+func (table numericTable) buildConstraints(ctx context.Context, conn *sql.DB) error {
+	for _, column := range table.columns {
+		if !column.notNull {
+			continue
+		}
+		// NOT VALID only takes a short lock, the validation scans the table without blocking writes
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s", table.name, table.notNullConstraint(column)),
+			fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s IS NOT NULL) NOT VALID", table.name, table.notNullConstraint(column), column.convertedColumn()),
+			fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", table.name, table.notNullConstraint(column)),
+		}
+		for _, statement := range statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+	}
+	if table.primaryKey == nil {
+		return nil
+	}
+	// an interrupted concurrent build leaves an invalid index behind
+	var valid sql.NullBool
+	err := conn.QueryRowContext(ctx, "SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)", pq.QuoteIdentifier(table.primaryKeyIndex())).
+		Scan(&valid)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if valid.Valid && valid.Bool {
+		return nil
+	}
+	if valid.Valid {
+		if _, err = conn.ExecContext(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+pq.QuoteIdentifier(table.primaryKeyIndex())); err != nil {
+			return err
+		}
+	}
+	var keyColumns []string
+	for _, keyColumn := range table.primaryKey {
+		keyColumn = pq.QuoteIdentifier(keyColumn)
+		for _, column := range table.columns {
+			if pq.QuoteIdentifier(column.name) == keyColumn {
+				keyColumn = column.convertedColumn()
+			}
+		}
+		keyColumns = append(keyColumns, keyColumn)
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("CREATE UNIQUE INDEX CONCURRENTLY %s ON %s (%s)",
+		pq.QuoteIdentifier(table.primaryKeyIndex()), table.name, strings.Join(keyColumns, ", ")))
+	return err
+}
+func (table numericTable) swapColumns(ctx context.Context, conn *sql.DB) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	statements := []string{
+		fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE", table.name),
+		fmt.Sprintf("DROP TRIGGER %[1]s ON %[2]s", table.syncFunction(), table.name),
+		fmt.Sprintf("DROP FUNCTION %s()", table.syncFunction()),
+	}
+	for _, column := range table.columns {
+		// dropping a column also drops the primary key containing it
+		statements = append(statements,
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table.name, pq.QuoteIdentifier(column.name)),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table.name, column.convertedColumn(), pq.QuoteIdentifier(column.name)))
+		if column.notNull {
+			// the validated check constraint spares the table scan of SET NOT NULL
+			statements = append(statements,
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table.name, pq.QuoteIdentifier(column.name)),
+				fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table.name, table.notNullConstraint(column)))
+		}
+		if column.defaultValue != "" {
+			statements = append(statements,
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", table.name, pq.QuoteIdentifier(column.name), column.defaultValue))
+		}
+	}
+	if table.primaryKey != nil {
+		primaryKey := pq.QuoteIdentifier(unquotedTableName(table.name) + "_pkey")
+		statements = append(statements,
+			fmt.Sprintf("ALTER INDEX %s RENAME TO %s", pq.QuoteIdentifier(table.primaryKeyIndex()), primaryKey),
+			fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %[2]s PRIMARY KEY USING INDEX %[2]s", table.name, primaryKey))
+	}
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// traceCallDetailColumns hold the call tree details of the traces, see GetCallTree.
+var traceCallDetailColumns = []struct{ name, definition string }{
+	{name: "trace_address", definition: `bigint[] NOT NULL DEFAULT '{}'`},
+	{name: "depth", definition: `smallint NOT NULL DEFAULT 0`},
+	{name: "output", definition: `bytea NULL`},
+	{name: "gas_used", definition: `bigint NULL`},
+	{name: "created_address_id", definition: `bigint REFERENCES "Addresses" NULL`},
+	{name: "init", definition: `bytea NULL`},
+	{name: "refund_address_id", definition: `bigint REFERENCES "Addresses" NULL`},
+}
+
+// traceAddressTable converts the trace addresses stored as integer[] by earlier versions, which overflow above 2^31-1.
+var traceAddressTable = numericTable{
+	name:       tableNameTraces,
+	batchKey:   "transaction_id",
+	sourceType: "_int4",
+	columns: []numericColumn{
+		{name: "trace_address", sqlType: "bigint[]", notNull: true, defaultValue: `'{}'`, conversion: `%[1]s"trace_address"::bigint[]`},
+	},
+}
+
+func traceCallDetailsPending(ctx context.Context, conn *sql.DB) (bool, error) {
+	names := make([]string, 0, len(traceCallDetailColumns))
+	for _, column := range traceCallDetailColumns {
+		names = append(names, column.name)
+	}
+	var existingColumns int
+	err := conn.QueryRowContext(ctx,
+		"SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = ANY($2)",
+		unquotedTableName(tableNameTraces), pq.Array(names)).
+		Scan(&existingColumns)
+	if err != nil || existingColumns < len(traceCallDetailColumns) {
+		return err == nil, err
+	}
+	return traceAddressTable.pending(ctx, conn)
+}
+
+// addTraceCallDetails adds the missing call tree columns, which only changes the catalog as their defaults are constants,
+// and converts the trace addresses stored as integer[].
+func addTraceCallDetails(ctx context.Context, conn *sql.DB, batchSize int64, progress MigrationProgress) error {
+	addColumns := make([]string, 0, len(traceCallDetailColumns))
+	for _, column := range traceCallDetailColumns {
+		addColumns = append(addColumns, fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s %s", pq.QuoteIdentifier(column.name), column.definition))
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s %s", tableNameTraces, strings.Join(addColumns, ", "))); err != nil {
+		return err
+	}
+	return convertTables(ctx, conn, "trace_call_details", []numericTable{traceAddressTable}, batchSize, progress)
+}
+
+// secondaryIndex is an index on foreign key and lookup columns.
+// Indexes are added here rather than to the init script, so existing databases build them without blocking writes.
+type secondaryIndex struct {
+	name      string
+	tableName string
+	columns   string
+	// where is the predicate of a partial index, empty for a full one.
+	where string
+}
+
+// Lookups by holder in "EtherBalances" are served by its primary key (address_id, block_id), which is also scanned backwards.
+var secondaryIndexes = []secondaryIndex{
+	{name: "Logs_address_id_idx", tableName: tableNameLogs, columns: `"address_id", "transaction_id" DESC`},
+	{name: "Erc20TokenTransfers_token_address_id_idx", tableName: tableNameErc20TokenTransfers, columns: `"token_address_id", "transaction_id" DESC, "log_index" DESC`},
+	{name: "Erc20TokenTransfers_from_address_id_idx", tableName: tableNameErc20TokenTransfers, columns: `"from_address_id", "transaction_id" DESC, "log_index" DESC`},
+	{name: "Erc20TokenTransfers_to_address_id_idx", tableName: tableNameErc20TokenTransfers, columns: `"to_address_id", "transaction_id" DESC, "log_index" DESC`},
+	// foreign keys cascading deletes of reorged blocks and transactions
+	{name: "Uncles_block_height_idx", tableName: tableNameUncles, columns: `"block_height"`},
+	{name: "EtherBalances_block_id_idx", tableName: tableNameEtherBalances, columns: `"block_id"`},
+	{name: "Erc20TokenBalances_block_id_idx", tableName: tableNameErc20TokenBalances, columns: `"block_id"`},
+	{name: "StorageKeys_transaction_id_idx", tableName: tableNameStorageKeys, columns: `"transaction_id"`},
+	{name: "Contracts_transaction_id_idx", tableName: tableNameContracts, columns: `"transaction_id"`},
+	// listings by address, newest first
+	{name: "Transactions_from_address_id_id_idx", tableName: tableNameTransactions, columns: `"from_address_id", "id" DESC`},
+	{name: "Transactions_to_address_id_id_idx", tableName: tableNameTransactions, columns: `"to_address_id", "id" DESC`},
+	{name: "Transactions_block_id_idx", tableName: tableNameTransactions, columns: `"block_id"`},
+	{name: "Transactions_contract_creation_idx", tableName: tableNameTransactions, columns: `"from_address_id", "id" DESC`, where: `"to_address_id" IS NULL`},
+	{name: "Receipts_failed_idx", tableName: tableNameReceipts, columns: `"transaction_id"`, where: `NOT "success"`},
+	{name: "Traces_from_address_id_idx", tableName: tableNameTraces, columns: `"from_address_id", "transaction_id" DESC, "index" DESC`},
+	{name: "Traces_to_address_id_idx", tableName: tableNameTraces, columns: `"to_address_id", "transaction_id" DESC, "index" DESC`},
+	{name: "Logs_topic_0_id_idx", tableName: tableNameLogs, columns: `"topic_0_id", "transaction_id"`},
+	{name: "Erc20TokenBalances_token_address_id_idx", tableName: tableNameErc20TokenBalances, columns: `"token_address_id", "address_id", "block_id" DESC`},
+}
+
+// indexState returns whether the index exists, and whether it is valid, i.e. its concurrent build was not interrupted.
+func indexState(ctx context.Context, conn *sql.DB, name string) (exists, valid bool, err error) {
+	err = conn.QueryRowContext(ctx, "SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)", pq.QuoteIdentifier(name)).
+		Scan(&valid)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	return err == nil, valid, err
+}
+func secondaryIndexesPending(ctx context.Context, conn *sql.DB) (bool, error) {
+	for _, index := range secondaryIndexes {
+		if _, valid, err := indexState(ctx, conn, index.name); err != nil || !valid {
+			return err == nil, err
+		}
+	}
+	return false, nil
+}
+func createSecondaryIndexes(ctx context.Context, conn *sql.DB, _ int64, progress MigrationProgress) error {
+	for i, index := range secondaryIndexes {
+		exists, valid, err := indexState(ctx, conn, index.name)
+		if err != nil {
+			return err
+		}
+		if exists && valid {
+			continue
+		}
+		if exists {
+			if _, err = conn.ExecContext(ctx, "DROP INDEX CONCURRENTLY "+pq.QuoteIdentifier(index.name)); err != nil {
+				return err
+			}
+		}
+		var partitioned bool
+		err = conn.QueryRowContext(ctx, "SELECT EXISTS (SELECT FROM pg_partitioned_table WHERE partrelid = $1::regclass)", index.tableName).
+			Scan(&partitioned)
+		if err != nil {
+			return err
+		}
+		// partitioned tables do not support concurrent builds, they are only created with new databases anyway
+		concurrently := " CONCURRENTLY"
+		if partitioned {
+			concurrently = ""
+		}
+		statement := fmt.Sprintf("CREATE INDEX%s %s ON %s (%s)", concurrently, pq.QuoteIdentifier(index.name), index.tableName, index.columns)
+		if index.where != "" {
+			statement += " WHERE " + index.where
+		}
+		_, err = conn.ExecContext(ctx, statement)
+		if err != nil {
+			return fmt.Errorf("failed to create index %s: %w", index.name, err)
+		}
+		if progress != nil {
+			progress("secondary_indexes", index.tableName, int64(i+1), int64(len(secondaryIndexes)))
+		}
+	}
+	return nil
+}
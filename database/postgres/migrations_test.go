@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/storagetest"
+)
+
+// numericToByteaFunction is the inverse of bytea_to_numeric, encoding numbers the way earlier versions stored them.
+const numericToByteaFunction = `CREATE OR REPLACE FUNCTION numeric_to_bytea(value numeric) RETURNS bytea AS $$
+DECLARE
+    result bytea := '';
+BEGIN
+    WHILE value > 0 LOOP
+        result := set_byte(decode('00', 'hex'), 0, mod(value, 256)::integer) || result;
+        value := div(value, 256);
+    END LOOP;
+    RETURN result;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE STRICT`
+
+// downgradeNumericColumns turns the database into one stored by a version preceding the numeric_columns migration:
+// its numeric columns become big-endian bytea, and the indexes of later migrations are dropped.
+func downgradeNumericColumns(t *testing.T, databaseURL string) {
+	t.Helper()
+	repo, err := NewPostgresRepository(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.openPostgresConnection(false); err != nil {
+		t.Fatal(err)
+	}
+	defer repo.conn.Close()
+	statements := []string{numericToByteaFunction}
+	for _, index := range secondaryIndexes {
+		statements = append(statements, "DROP INDEX IF EXISTS "+pq.QuoteIdentifier(index.name))
+	}
+	for _, table := range numericTables {
+		if table.sourceType != "" {
+			continue
+		}
+		for _, column := range table.columns {
+			legacyValue := fmt.Sprintf("numeric_to_bytea(%s::numeric)", pq.QuoteIdentifier(column.name))
+			if strings.Contains(column.conversion, legacyNull) {
+				// the columns converted from legacyNull were written as such instead of NULL
+				legacyValue = fmt.Sprintf("COALESCE(%s, %s)", legacyValue, legacyNull)
+			}
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE bytea USING %s",
+				table.name, pq.QuoteIdentifier(column.name), legacyValue))
+		}
+	}
+	statements = append(statements, "DROP FUNCTION numeric_to_bytea")
+	for _, statement := range statements {
+		if _, err = repo.conn.Exec(statement); err != nil {
+			t.Fatalf("failed to downgrade the database: %s: %v", statement, err)
+		}
+	}
+}
+
+// readFixture reads back every entity of the fixture, keyed by a description of the read.
+func readFixture(t *testing.T, reader storage.Reader, fixture *storagetest.Fixture) map[string]any {
+	t.Helper()
+	values := map[string]any{}
+	read := func(description string, value any, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", description, err)
+		}
+		values[description] = value
+	}
+	for _, block := range fixture.Blocks {
+		stored, err := reader.GetBlockByNumber(block.Number)
+		read(fmt.Sprintf("block %d", block.Number), stored, err)
+		uncles, err := reader.ListUnclesByBlockNumber(block.Number)
+		read(fmt.Sprintf("uncles of block %d", block.Number), uncles, err)
+	}
+	for i, transactionId := range fixture.TransactionIds {
+		transaction, err := reader.GetTransactionById(transactionId)
+		read(fmt.Sprintf("transaction %d", i), transaction, err)
+		receipt, err := reader.GetReceiptByTransactionId(transactionId)
+		read(fmt.Sprintf("receipt %d", i), receipt, err)
+		logs, err := reader.ListLogsByTransactionId(transactionId)
+		read(fmt.Sprintf("logs of transaction %d", i), logs, err)
+		traces, _, _, err := reader.ListTracesByTransactionHash(&fixture.Transactions[i].Hash)
+		read(fmt.Sprintf("traces of transaction %d", i), traces, err)
+		stateChanges, err := reader.ListStateChangesByTransactionHash(&fixture.Transactions[i].Hash)
+		read(fmt.Sprintf("state changes of transaction %d", i), stateChanges, err)
+	}
+	token, err := reader.GetErc20TokenByAddressId(fixture.TokenId)
+	read("token", token, err)
+	transfers, _, err := reader.ListErc20TokenTransfers(nil, nil, &storage.OffsetPagination{Limit: 10})
+	read("token transfers", transfers, err)
+	for _, etherBalance := range fixture.EtherBalances {
+		balance, err := reader.GetWeiBalanceAtBlock(etherBalance.AddressId, etherBalance.BlockNumber)
+		read(fmt.Sprintf("wei balance of %v at block %d", etherBalance.AddressId, etherBalance.BlockNumber), balance, err)
+	}
+	for _, tokenBalance := range fixture.Erc20TokenBalances {
+		balances, err := reader.ListErc20TokenBalancesAtBlock(tokenBalance.AddressId, tokenBalance.BlockNumber)
+		read(fmt.Sprintf("token balances of %v at block %d", tokenBalance.AddressId, tokenBalance.BlockNumber), balances, err)
+	}
+	return values
+}
+
+func TestNumericColumnsMigration(t *testing.T) {
+	databaseURL := newTestDatabase(t)
+	repo := newTestRepository(t, databaseURL)
+	fixture := storagetest.Seed(t, repo)
+	expected := readFixture(t, repo, fixture)
+	for i, block := range fixture.Blocks {
+		storagetest.CheckEqual(t, fmt.Sprintf("block %d", i), expected[fmt.Sprintf("block %d", block.Number)], block)
+	}
+	for i, transaction := range fixture.Transactions {
+		storagetest.CheckEqual(t, fmt.Sprintf("transaction %d", i), expected[fmt.Sprintf("transaction %d", i)], transaction)
+		storagetest.CheckEqual(t, fmt.Sprintf("receipt %d", i), expected[fmt.Sprintf("receipt %d", i)], fixture.Receipts[i])
+	}
+	closeTestRepository(repo)
+
+	downgradeNumericColumns(t, databaseURL)
+	legacyRepo, err := NewPostgresRepository(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = legacyRepo.Load(); !errors.Is(err, ErrMigrationsPending) {
+		t.Fatalf("loading the legacy database returned %v, expected %v", err, ErrMigrationsPending)
+	}
+
+	migratedTables := map[string]bool{}
+	err = legacyRepo.Migrate(context.Background(), 1, func(migrationName, tableName string, done, total int64) {
+		if migrationName == "numeric_columns" {
+			migratedTables[tableName] = true
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range numericTables {
+		if !migratedTables[table.name] {
+			t.Errorf("no progress reported for table %s", table.name)
+		}
+	}
+
+	migratedRepo := newTestRepository(t, databaseURL)
+	pending, err := pendingMigrations(context.Background(), migratedRepo.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("migrations %v are still pending", pending)
+	}
+	actual := readFixture(t, migratedRepo, fixture)
+	descriptions := make([]string, 0, len(expected))
+	for description := range expected {
+		descriptions = append(descriptions, description)
+	}
+	sort.Strings(descriptions)
+	for _, description := range descriptions {
+		storagetest.CheckEqual(t, description, actual[description], expected[description])
+	}
+}
@@ -0,0 +1,253 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/lib/pq"
+	storage "github.com/librescan-org/backend-db"
+)
+
+// env_POSTGRES_PARTITION_SIZE enables partitioning of the large tables by block range, in ranges of the given number of blocks.
+// Partitioning can only be enabled on a new database, and the size can not be changed afterwards.
+const env_POSTGRES_PARTITION_SIZE = "POSTGRES_PARTITION_SIZE"
+
+// In a partitioned database, transaction ids are derived from the block number and the transaction index,
+// so the tables referencing transactions can be partitioned by transaction id ranges matching the block ranges.
+const (
+	transactionIndexBits = 16
+	maxTransactionIndex  = 1<<transactionIndexBits - 1
+)
+
+// partitionedTables are partitioned by transaction id, in the order their partitions are detached.
+var partitionedTables = []string{
+	tableNameLogs,
+	tableNameTraces,
+	tableNameStorageKeys,
+	tableNameErc20TokenTransfers,
+	tableNameTransactions,
+}
+
+// transactionReferencingTables reference transactions without a foreign key in a partitioned database,
+// their rows are deleted explicitly on reorgs.
+var transactionReferencingTables = []string{
+	tableNameReceipts,
+	tableNameContracts,
+	tableNameStateChanges,
+	tableNammeStorageChanges,
+	tableNameLogs,
+	tableNameTraces,
+	tableNameStorageKeys,
+	tableNameErc20TokenTransfers,
+}
+
+type initParameters struct {
+	Partitioned   bool
+	PartitionSize uint64
+}
+
+var postgresInitTemplate = template.Must(template.New("postgres_init.sql").
+	Funcs(template.FuncMap{
+		"partitionBy":          func(string) string { return "" },
+		"transactionReference": func() string { return "" },
+	}).
+	Parse(postgres_init_sql))
+
+// initSql renders the schema, partitioned if partitionSize is not 0.
+func initSql(partitionSize uint64) (string, error) {
+	parameters := initParameters{Partitioned: partitionSize != 0, PartitionSize: partitionSize}
+	initTemplate, err := postgresInitTemplate.Clone()
+	if err != nil {
+		return "", err
+	}
+	initTemplate.Funcs(template.FuncMap{
+		"partitionBy": func(column string) string {
+			if !parameters.Partitioned {
+				return ""
+			}
+			return fmt.Sprintf(" PARTITION BY RANGE (%s)", pq.QuoteIdentifier(column))
+		},
+		"transactionReference": func() string {
+			// foreign keys would prevent detaching partitions
+			if parameters.Partitioned {
+				return ""
+			}
+			return fmt.Sprintf(" REFERENCES %s ON DELETE CASCADE", tableNameTransactions)
+		},
+	})
+	var rendered bytes.Buffer
+	if err = initTemplate.Execute(&rendered, parameters); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+func getPartitionSizeEnv() (uint64, error) {
+	value := os.Getenv(env_POSTGRES_PARTITION_SIZE)
+	if value == "" {
+		return 0, nil
+	}
+	partitionSize, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value of %s: %w", env_POSTGRES_PARTITION_SIZE, err)
+	}
+	if partitionSize > math.MaxInt64>>transactionIndexBits {
+		return 0, fmt.Errorf("value of %s is too large", env_POSTGRES_PARTITION_SIZE)
+	}
+	return partitionSize, nil
+}
+
+// checkPartitioning verifies that the database was created with the configured partition size.
+func (repo *PostgresRepository) checkPartitioning() error {
+	var storedPartitionSize uint64
+	err := repo.conn.QueryRow(`SELECT partition_size FROM "Partitioning"`).Scan(&storedPartitionSize)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if storedPartitionSize != repo.partitionSize {
+		if storedPartitionSize == 0 {
+			return fmt.Errorf("%w: %s is set, but the database was created without partitioning", storage.ErrSchemaMismatch, env_POSTGRES_PARTITION_SIZE)
+		}
+		return fmt.Errorf("%w: the database is partitioned by %d blocks, but %s is %d", storage.ErrSchemaMismatch, storedPartitionSize, env_POSTGRES_PARTITION_SIZE, repo.partitionSize)
+	}
+	return nil
+}
+
+// partitionedTransactionId returns the id of a transaction in a partitioned database.
+func partitionedTransactionId(blockNumber storage.BlockNumber, transactionIndex uint64) (storage.TransactionId, error) {
+	if transactionIndex > maxTransactionIndex {
+		return storage.TransactionId{}, fmt.Errorf("transaction index %d of block %d exceeds the maximum of %d in a partitioned database", transactionIndex, blockNumber, maxTransactionIndex)
+	}
+	return storage.NewTransactionId(int64(blockNumber<<transactionIndexBits | transactionIndex)), nil
+}
+
+// transactionIdRange returns the range of transaction ids of the given block range, the upper bound excluded.
+func transactionIdRange(fromBlock, toBlock storage.BlockNumber) (from, to int64) {
+	return int64(fromBlock << transactionIndexBits), int64(toBlock << transactionIndexBits)
+}
+
+func partitionName(tableName string, partition uint64) string {
+	return pq.QuoteIdentifier(fmt.Sprintf("%s_p%d", unquotedTableName(tableName), partition))
+}
+
+// ensurePartitions creates the partitions holding the given block, and the ones of the upcoming block range.
+// Blocks are not necessarily stored in order, so every partition is checked once, whether a later one exists or not.
+func (repo *PostgresRepository) ensurePartitions(blockNumber storage.BlockNumber) error {
+	partition := blockNumber / repo.partitionSize
+	for _, upcoming := range []uint64{partition, partition + 1} {
+		if repo.createdPartitions[upcoming] {
+			continue
+		}
+		from, to := transactionIdRange(upcoming*repo.partitionSize, (upcoming+1)*repo.partitionSize)
+		for _, tableName := range partitionedTables {
+			_, err := repo.runner.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (%d) TO (%d)",
+				partitionName(tableName, upcoming), tableName, from, to))
+			if err != nil {
+				return err
+			}
+		}
+		if repo.createdPartitions == nil {
+			repo.createdPartitions = make(map[uint64]bool)
+		}
+		repo.createdPartitions[upcoming] = true
+	}
+	return nil
+}
+
+// deleteTransactionsOfBlocks deletes the transactions of the given blocks and all rows referencing them.
+// Foreign keys do the same in a database without partitioning.
+func (repo *PostgresRepository) deleteTransactionsOfBlocks(blockNumbers []storage.BlockNumber) error {
+	for _, blockNumber := range blockNumbers {
+		from, to := transactionIdRange(blockNumber, blockNumber+1)
+		for _, tableName := range transactionReferencingTables {
+			_, err := repo.statementBuilder.
+				Delete(tableName).
+				Where("transaction_id >= ? AND transaction_id < ?", from, to).
+				Exec()
+			if err != nil {
+				return err
+			}
+		}
+		_, err := repo.statementBuilder.
+			Delete(tableNameTransactions).
+			Where("id >= ? AND id < ?", from, to).
+			Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchivePartitions detaches the partitions holding only blocks before the given block number,
+// and moves them to archiveSchema, from where they can be dumped or dropped.
+// Blocks, balances and the unpartitioned tables referencing transactions (receipts, contracts, state and storage changes) are kept.
+// The names of the archived partitions are returned.
+func (repo *PostgresRepository) ArchivePartitions(ctx context.Context, beforeBlock storage.BlockNumber, archiveSchema string) (archived []string, err error) {
+	defer annotate(&err, "failed to archive partitions before block %d", beforeBlock)
+	if repo.partitionSize == 0 {
+		return nil, fmt.Errorf("the database is not partitioned")
+	}
+	tx, err := repo.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(archiveSchema)); err != nil {
+		return nil, err
+	}
+	lastArchivedPartition := beforeBlock / repo.partitionSize
+	for _, tableName := range partitionedTables {
+		partitions, err := listPartitions(ctx, tx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			if partition >= lastArchivedPartition {
+				continue
+			}
+			name := partitionName(tableName, partition)
+			statements := []string{
+				fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", tableName, name),
+				fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s", name, pq.QuoteIdentifier(archiveSchema)),
+			}
+			for _, statement := range statements {
+				if _, err = tx.ExecContext(ctx, statement); err != nil {
+					return nil, err
+				}
+			}
+			archived = append(archived, name)
+		}
+	}
+	return archived, tx.Commit()
+}
+
+// listPartitions returns the partition numbers of the table, as named by partitionName.
+func listPartitions(ctx context.Context, tx *sql.Tx, tableName string) ([]uint64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT inhrelid::regclass::text FROM pg_inherits WHERE inhparent = $1::regclass", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	prefix := unquotedTableName(tableName) + "_p"
+	var partitions []uint64
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partition, err := strconv.ParseUint(strings.TrimPrefix(strings.Trim(name, `"`), prefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected partition %s of %s", name, tableName)
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
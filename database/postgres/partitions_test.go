@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEnsurePartitionsOfBlocksStoredOutOfOrder(t *testing.T) {
+	t.Setenv(env_POSTGRES_PARTITION_SIZE, "1000")
+	repo := newTestRepository(t, newTestDatabase(t))
+	chain := newTestChain(t, repo)
+	sender, recipient := chain.address(common.HexToAddress("0xbb")), chain.address(common.HexToAddress("0xcc"))
+	// the partition of the lower block is created after the ones of the higher block, in the same transaction and in a later one
+	for _, blockNumbers := range [][]uint64{{5000, 2000}, {8000}, {3500}} {
+		for _, blockNumber := range blockNumbers {
+			chain.storeBlocks(blockNumber)
+			chain.storeTransaction(blockNumber, 0, sender, recipient)
+		}
+		chain.commit()
+	}
+
+	tx, err := repo.conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	want := []uint64{2, 3, 4, 5, 6, 8, 9}
+	for _, tableName := range partitionedTables {
+		partitions, err := listPartitions(context.Background(), tx, tableName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		if !reflect.DeepEqual(partitions, want) {
+			t.Errorf("partitions of %s: got %v, want %v", tableName, partitions, want)
+		}
+	}
+}
@@ -6,23 +6,32 @@ CREATE TABLE IF NOT EXISTS "Addresses"(
 CREATE TABLE IF NOT EXISTS "Blocks" (
     "number" bigint PRIMARY KEY,
     "hash" bytea UNIQUE NOT NULL,
-    "nonce" bytea NOT NULL,
+    "nonce" numeric(20,0) NOT NULL,
     "sha3_uncles" bytea NOT NULL,
     "logs_bloom" bytea NOT NULL,
     "state_root" bytea NOT NULL,
     "miner" bigint REFERENCES "Addresses" NOT NULL,
-    "difficulty" bytea NOT NULL,
-    "total_difficulty" bytea NOT NULL,
-    "size" bytea NULL,
+    "difficulty" numeric(78,0) NOT NULL,
+    "total_difficulty" numeric(78,0) NOT NULL,
+    "size" bigint NULL,
     "extra_data" bytea NULL,
-    "gas_limit" bytea NULL,
-    "gas_used" bytea NULL,
-    "base_fee" bytea NULL,
+    "gas_limit" bigint NULL,
+    "gas_used" bigint NULL,
+    "base_fee" numeric(78,0) NULL,
     "mix_hash" bytea NULL,
-    "static_reward" bytea NOT NULL,
-    "timestamp" bigint NOT NULL
+    "static_reward" numeric(78,0) NOT NULL,
+    "timestamp" bigint NOT NULL,
+    "parent_hash" bytea NULL,
+    "transactions_root" bytea NULL,
+    "receipts_root" bytea NULL
 );
 
+-- blocks stored by earlier versions have no parent hash
+ALTER TABLE "Blocks" ADD COLUMN IF NOT EXISTS "parent_hash" bytea NULL;
+-- nor transaction and receipt roots
+ALTER TABLE "Blocks" ADD COLUMN IF NOT EXISTS "transactions_root" bytea NULL;
+ALTER TABLE "Blocks" ADD COLUMN IF NOT EXISTS "receipts_root" bytea NULL;
+
 CREATE TABLE IF NOT EXISTS "Uncles" (
     "hash" bytea PRIMARY KEY,
     "position" smallint NOT NULL,
@@ -30,10 +39,10 @@ CREATE TABLE IF NOT EXISTS "Uncles" (
     "block_height" bigint REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
     "parent_hash" bytea NOT NULL,
     "miner" bigint REFERENCES "Addresses",
-    "difficulty" bytea NOT NULL,
+    "difficulty" numeric(78,0) NOT NULL,
     "gas_limit" bigint NULL,
     "gas_used" bigint NULL,
-    "reward" bytea NOT NULL,
+    "reward" numeric(78,0) NOT NULL,
     "timestamp" bigint NOT NULL
 );
 
@@ -46,40 +55,44 @@ CREATE TABLE IF NOT EXISTS "Bytecodes" (
 CREATE TABLE IF NOT EXISTS "Transactions" (
     "id" bigserial PRIMARY KEY,
     "block_id" bigint REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
-    "hash" bytea UNIQUE NOT NULL,
-    "nonce" bytea NOT NULL,
-    "index" bytea NOT NULL,
+    "hash" bytea {{if not .Partitioned}}UNIQUE {{end}}NOT NULL,
+    "nonce" bigint NOT NULL,
+    "index" bigint NOT NULL,
     "from_address_id" bigint REFERENCES "Addresses" NOT NULL,
     "to_address_id" bigint REFERENCES "Addresses" NULL,
-    "value" bytea NOT NULL,
-    "gas" bytea NOT NULL,
-    "gas_price" bytea NOT NULL,
-    "gas_tip_cap" bytea NULL,
-    "gas_fee_cap" bytea NULL,
+    "value" numeric(78,0) NOT NULL,
+    "gas" bigint NOT NULL,
+    "gas_price" numeric(78,0) NOT NULL,
+    "gas_tip_cap" numeric(78,0) NULL,
+    "gas_fee_cap" numeric(78,0) NULL,
     "input" bytea NOT NULL,
-    "transaction_type" bigint NOT NULL
-);
+    "transaction_type" bigint NOT NULL,
+    "raw" bytea NULL
+){{partitionBy "id"}};
+
+-- transactions stored by earlier versions have no raw encoding
+ALTER TABLE "Transactions" ADD COLUMN IF NOT EXISTS "raw" bytea NULL;
 
 CREATE TABLE IF NOT EXISTS "StorageKeys" (
-    "transaction_id" bigint REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "transaction_id" bigint{{transactionReference}} NOT NULL,
     "address_id" bigint REFERENCES "Addresses" NOT NULL,
     "storage_key" bytea NOT NULL
-);
+){{partitionBy "transaction_id"}};
 
 CREATE TABLE IF NOT EXISTS "Contracts" (
     "address_id" bigint PRIMARY KEY REFERENCES "Addresses",
-    "transaction_id" bigint REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "transaction_id" bigint{{transactionReference}} NOT NULL,
     "bytecode_id" bigint REFERENCES "Bytecodes" NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS "Receipts" (
-    "transaction_id" bigint PRIMARY KEY REFERENCES "Transactions" ON DELETE CASCADE,
-    "cumulative_gas_used" bytea NOT NULL,
-    "gas_used" bytea NOT NULL,
+    "transaction_id" bigint PRIMARY KEY{{transactionReference}},
+    "cumulative_gas_used" bigint NOT NULL,
+    "gas_used" bigint NOT NULL,
     "contract_address_id" bigint REFERENCES "Addresses" NULL,
     "post_state" bytea NOT NULL,
     "success" bool NOT NULL,
-    "effective_gas_price" bytea NOT NULL
+    "effective_gas_price" numeric(78,0) NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS "FirstTopics" (
@@ -89,8 +102,8 @@ CREATE TABLE IF NOT EXISTS "FirstTopics" (
 );
 
 CREATE TABLE IF NOT EXISTS "Logs" (
-    "transaction_id" bigint REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
-    "index" bytea NOT NULL,
+    "transaction_id" bigint{{transactionReference}} NOT NULL,
+    "index" bigint NOT NULL,
     "address_id" bigint REFERENCES "Addresses" NOT NULL,
     "topic_0_id" bigint REFERENCES "FirstTopics" NULL,
     "topic_1" bytea NOT NULL,
@@ -98,43 +111,50 @@ CREATE TABLE IF NOT EXISTS "Logs" (
     "topic_3" bytea NOT NULL,
     "data" bytea NOT NULL,
     PRIMARY KEY("transaction_id", "index")
-);
+){{partitionBy "transaction_id"}};
 
 CREATE TABLE IF NOT EXISTS "Erc20Tokens" (
     "address_id" bigint PRIMARY KEY REFERENCES "Addresses",
     "symbol" text NULL,
     "name" text NULL,
     "decimals" smallint NULL,
-    "total_supply" bytea NULL
+    "total_supply" numeric(78,0) NULL
 );
 
 CREATE TABLE IF NOT EXISTS "Erc20TokenTransfers" (
-    "transaction_id" bigint REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
-    "log_index" bytea NOT NULL,
+    "transaction_id" bigint{{transactionReference}} NOT NULL,
+    "log_index" bigint NOT NULL,
     "token_address_id" bigint REFERENCES "Addresses" NOT NULL,
     "from_address_id" bigint REFERENCES "Addresses" NOT NULL,
     "to_address_id" bigint REFERENCES "Addresses" NOT NULL,
-    "value" bytea NOT NULL,
+    "value" numeric(78,0) NOT NULL,
     PRIMARY KEY("transaction_id", "log_index")
-);
+){{partitionBy "transaction_id"}};
 
 CREATE TABLE IF NOT EXISTS "Traces" (
-    "transaction_id" bigint REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "transaction_id" bigint{{transactionReference}} NOT NULL,
     "index" integer NOT NULL,
     "type" text NOT NULL,
     "input" bytea NULL,
     "from_address_id" bigint REFERENCES "Addresses" NOT NULL,
     "to_address_id" bigint REFERENCES "Addresses" NOT NULL,
-    "value" bytea NOT NULL,
+    "value" numeric(78,0) NOT NULL,
     "gas" bigint NULL,
     "error" text NULL,
+    "trace_address" bigint[] NOT NULL DEFAULT '{}',
+    "depth" smallint NOT NULL DEFAULT 0,
+    "output" bytea NULL,
+    "gas_used" bigint NULL,
+    "created_address_id" bigint REFERENCES "Addresses" NULL,
+    "init" bytea NULL,
+    "refund_address_id" bigint REFERENCES "Addresses" NULL,
     PRIMARY KEY("transaction_id", "index")
-);
+){{partitionBy "transaction_id"}};
 
 CREATE TABLE IF NOT EXISTS "EtherBalances" (
     "address_id" bigint REFERENCES "Addresses" NOT NULL,
     "block_id" bigint REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
-    "balance" bytea NOT NULL,
+    "balance" numeric(78,0) NOT NULL,
     PRIMARY KEY("address_id", "block_id")
 );
 
@@ -142,25 +162,66 @@ CREATE TABLE IF NOT EXISTS "Erc20TokenBalances" (
     "address_id" bigint REFERENCES "Addresses" NOT NULL,
     "block_id" bigint REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
     "token_address_id" bigint REFERENCES "Addresses" NOT NULL,
-    "balance" bytea NOT NULL,
+    "balance" numeric(78,0) NOT NULL,
     PRIMARY KEY("address_id", "block_id", "token_address_id")
 );
 
 CREATE TABLE IF NOT EXISTS "StateChanges" (
-    "transaction_id" bigint REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "transaction_id" bigint{{transactionReference}} NOT NULL,
     "address_id" bigint REFERENCES "Addresses" NOT NULL,
-    "balance_before" bytea NOT NULL,
-    "balance_after" bytea NOT NULL,
-    "nonce_before" bytea NULL,
-    "nonce_after" bytea NULL,
+    "balance_before" numeric(78,0) NOT NULL,
+    "balance_after" numeric(78,0) NOT NULL,
+    "nonce_before" bigint NULL,
+    "nonce_after" bigint NULL,
     PRIMARY KEY("transaction_id", "address_id")
 );
 
 CREATE TABLE IF NOT EXISTS "StorageChanges" (
-    "transaction_id" bigint REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "transaction_id" bigint{{transactionReference}} NOT NULL,
     "address_id" bigint REFERENCES "Addresses" NOT NULL,
     "storage_address" bytea NOT NULL,
     "value_before" bytea NOT NULL,
     "value_after" bytea NOT NULL,
     PRIMARY KEY("transaction_id", "address_id", "storage_address")
-);
\ No newline at end of file
+);
+
+CREATE TABLE IF NOT EXISTS "Partitioning" (
+    "partition_size" bigint NOT NULL
+);
+
+{{if .Partitioned -}}
+INSERT INTO "Partitioning"
+    SELECT {{.PartitionSize}}
+    WHERE NOT EXISTS (SELECT FROM "Partitioning")
+    AND EXISTS (SELECT FROM pg_partitioned_table WHERE partrelid = '"Transactions"'::regclass);
+
+{{end -}}
+CREATE TABLE IF NOT EXISTS "DerivationCheckpoints" (
+    "name" text PRIMARY KEY,
+    "transaction_id" bigint NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "BackfillRanges" (
+    "from_block" bigint PRIMARY KEY,
+    "to_block" bigint NOT NULL,
+    "state" text NOT NULL DEFAULT 'pending',
+    "claimed_by" text NULL,
+    "claimed_at" timestamptz NULL,
+    "attempts" bigint NOT NULL DEFAULT 0,
+    "error" text NULL
+);
+CREATE INDEX IF NOT EXISTS "BackfillRanges_state_idx" ON "BackfillRanges" ("state", "from_block");
+
+-- each row holds one bit of the logs blooms of the 4096 blocks of a section, the bit of block n being bit n % 4096 of "bits"
+CREATE TABLE IF NOT EXISTS "BloomBits" (
+    "section" bigint NOT NULL,
+    "bit" smallint NOT NULL,
+    "bits" bytea NOT NULL,
+    PRIMARY KEY("section", "bit")
+);
+
+-- the other secondary indexes are built by the migrations, concurrently on existing databases, see secondaryIndexes;
+-- this one is only created along with the partitioned tables of a new database, whose primary key does not cover the hash
+{{if .Partitioned -}}
+CREATE INDEX IF NOT EXISTS "Transactions_hash_idx" ON "Transactions" ("hash");
+{{end -}}
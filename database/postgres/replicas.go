@@ -0,0 +1,543 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+const (
+	// env_POSTGRES_READ_REPLICAS lists the postgres URLs of the read replicas, separated by commas, see WithReadReplicasFromEnv.
+	env_POSTGRES_READ_REPLICAS          = "POSTGRES_READ_REPLICAS"
+	env_POSTGRES_READ_YOUR_WRITES       = "POSTGRES_READ_YOUR_WRITES"
+	env_POSTGRES_REPLICA_MAX_LAG        = "POSTGRES_REPLICA_MAX_LAG"
+	env_POSTGRES_REPLICA_CHECK_INTERVAL = "POSTGRES_REPLICA_CHECK_INTERVAL"
+)
+
+// ReplicaOptions tell how a ReplicatedRepository routes the reads.
+type ReplicaOptions struct {
+	// MaxLag is the number of blocks a replica may lag behind the primary before reads avoid it.
+	MaxLag uint64
+	// CheckInterval is the time between the health checks of the replicas, done by the first read after it.
+	CheckInterval time.Duration
+	// ReadYourWrites routes the reads to the primary while the transaction has uncommitted writes,
+	// and after Commit until a replica stores the latest block committed to the primary.
+	ReadYourWrites bool
+}
+
+// DefaultReplicaOptions are the options used unless the environment overrides them.
+var DefaultReplicaOptions = ReplicaOptions{
+	MaxLag:        2,
+	CheckInterval: 5 * time.Second,
+}
+
+type replica struct {
+	repo    *PostgresRepository
+	loaded  bool
+	healthy bool
+	// storedBlocks is the latest block number of the replica plus one, 0 if it stores no block.
+	storedBlocks uint64
+}
+
+// ReplicatedRepository routes the Reader methods to the read replicas of the primary in turns, while the Inserter, Deleter
+// and Loader methods, as well as the other methods of PostgresRepository, use the primary.
+// The replicas are checked every CheckInterval: failing replicas and replicas lagging more than MaxLag blocks
+// behind the primary are avoided until a later check finds them usable again.
+// Reads go to the primary if no replica is usable, or the replica fails with a transient error.
+// Like PostgresRepository, it is not safe for concurrent use.
+type ReplicatedRepository struct {
+	*PostgresRepository
+	replicas []*replica
+	options  ReplicaOptions
+
+	next      int
+	checkedAt time.Time
+	// committedBlocks is the latest block number of the primary plus one after the last Commit, if ReadYourWrites is set.
+	committedBlocks uint64
+}
+
+// NewReplicatedRepository returns a repository reading from the replicas of the postgres URLs, see NewPostgresRepository,
+// and writing to the primary.
+func NewReplicatedRepository(primary *PostgresRepository, replicaURLs []string, options ReplicaOptions) (*ReplicatedRepository, error) {
+	if len(replicaURLs) == 0 {
+		return nil, errors.New("no read replicas")
+	}
+	repo := &ReplicatedRepository{PostgresRepository: primary, options: options}
+	for _, replicaURL := range replicaURLs {
+		replicaRepo, err := NewPostgresRepository(replicaURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read replica: %w", err)
+		}
+		repo.replicas = append(repo.replicas, &replica{repo: replicaRepo})
+	}
+	return repo, nil
+}
+
+// WithReadReplicasFromEnv returns the primary along with the read replicas of POSTGRES_READ_REPLICAS, or the primary itself if it is not set.
+// POSTGRES_READ_YOUR_WRITES, POSTGRES_REPLICA_MAX_LAG and POSTGRES_REPLICA_CHECK_INTERVAL override DefaultReplicaOptions.
+// Invalid values fail even without replicas, so a misconfiguration is not noticed only once replicas are added.
+func WithReadReplicasFromEnv(primary *PostgresRepository) (_ storage.Storage, err error) {
+	defer annotate(&err, "invalid configuration of the read replicas")
+	options := DefaultReplicaOptions
+	if options.ReadYourWrites, err = getBoolEnv(env_POSTGRES_READ_YOUR_WRITES); err != nil {
+		return nil, err
+	}
+	if value := os.Getenv(env_POSTGRES_REPLICA_MAX_LAG); value != "" {
+		if options.MaxLag, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", env_POSTGRES_REPLICA_MAX_LAG, err)
+		}
+	}
+	if value := os.Getenv(env_POSTGRES_REPLICA_CHECK_INTERVAL); value != "" {
+		if options.CheckInterval, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", env_POSTGRES_REPLICA_CHECK_INTERVAL, err)
+		}
+		if options.CheckInterval <= 0 {
+			return nil, fmt.Errorf("invalid value of %s: %s is not positive", env_POSTGRES_REPLICA_CHECK_INTERVAL, value)
+		}
+	}
+	var replicaURLs []string
+	for _, replicaURL := range strings.Split(os.Getenv(env_POSTGRES_READ_REPLICAS), ",") {
+		if replicaURL = strings.TrimSpace(replicaURL); replicaURL != "" {
+			replicaURLs = append(replicaURLs, replicaURL)
+		}
+	}
+	if len(replicaURLs) == 0 {
+		return primary, nil
+	}
+	repo, err := NewReplicatedRepository(primary, replicaURLs, options)
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// loadReplica loads the repository of a read replica. Unlike Load, it neither creates nor migrates the database, which is read-only.
+// Failing statements are not retried, as the reads fall back to the primary instead.
+func (repo *PostgresRepository) loadReplica() (err error) {
+	defer annotate(&err, "failed to load read replica %s", repo.connectionURL.Redacted())
+	defer func() {
+		// loading is retried by the next health check
+		if err != nil && repo.conn != nil {
+			repo.conn.Close()
+			repo.conn = nil
+		}
+	}()
+	if err = repo.loadSettings(); err != nil {
+		return
+	}
+	if err = repo.openPostgresConnection(false); err != nil {
+		return
+	}
+	if err = repo.checkPartitioning(); err != nil {
+		return
+	}
+	pending, err := pendingMigrations(context.Background(), repo.conn)
+	if err != nil {
+		return
+	}
+	if len(pending) != 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationsPending, strings.Join(pending, ", "))
+	}
+	// the transactions are begun by every read, see replica.read
+	repo.runner = newJournalingRunner(repo.conn, repo.dropTransactionState)
+	repo.runner.policy.MaxAttempts = 1
+	repo.statementBuilder = sq.StatementBuilder.RunWith(repo.runner).PlaceholderFormat(sq.Dollar)
+	return nil
+}
+
+// read calls a Reader method of the replica in a transaction of its own, rolled back once done.
+// No transaction stays open between the reads: on a hot standby, a long-lived transaction is canceled
+// by the recovery conflicts of the replayed writes, and its snapshot would hide the blocks replicated since it began.
+func (replica *replica) read(call func(storage.Reader) error) error {
+	runner := replica.repo.runner
+	if err := runner.begin(context.Background()); err != nil {
+		return fmt.Errorf("failed to begin transaction on read replica %s: %w", replica.repo.connectionURL.Redacted(), mapError(err))
+	}
+	defer func() {
+		// the transaction might have been replaced while recovering from a failure, so the current one is rolled back
+		_ = runner.tx.Rollback()
+	}()
+	return call(replica.repo)
+}
+
+// Load loads the primary, and the replicas which can be loaded, the others are loaded by the following health checks.
+func (repo *ReplicatedRepository) Load() error {
+	if err := repo.PostgresRepository.Load(); err != nil {
+		return err
+	}
+	repo.checkReplicas()
+	return nil
+}
+
+// Commit commits the transaction of the primary.
+// With ReadYourWrites, the reads stay on the primary until a replica stores its latest block.
+func (repo *ReplicatedRepository) Commit(ctx context.Context) error {
+	if err := repo.PostgresRepository.Commit(ctx); err != nil {
+		return err
+	}
+	if repo.options.ReadYourWrites {
+		committedBlocks, err := storedBlocks(repo.PostgresRepository)
+		if err != nil {
+			// the commit succeeded, so the error is not returned, the reads stay on the primary until the next Commit instead
+			log.Printf("failed to get latest block committed to the primary: %v", err)
+			committedBlocks = math.MaxUint64
+		}
+		repo.committedBlocks = committedBlocks
+	}
+	return nil
+}
+
+// storedBlocks returns the latest block number of the reader plus one, 0 if it stores no block.
+func storedBlocks(reader storage.Reader) (uint64, error) {
+	latestBlockNumber, err := reader.GetLatestBlockNumber()
+	if err != nil || latestBlockNumber == nil {
+		return 0, err
+	}
+	return *latestBlockNumber + 1, nil
+}
+
+// checkReplicas loads the replicas not loaded yet, and compares the latest block numbers of the replicas with the primary's.
+func (repo *ReplicatedRepository) checkReplicas() {
+	repo.checkedAt = time.Now()
+	primaryBlocks, err := storedBlocks(repo.PostgresRepository)
+	if err != nil {
+		// the lag of the replicas is unknown, they are left as they were
+		log.Printf("failed to check read replicas: %v", err)
+		return
+	}
+	for _, replica := range repo.replicas {
+		replica.healthy = false
+		if !replica.loaded {
+			if err = replica.repo.loadReplica(); err != nil {
+				log.Printf("%v", err)
+				continue
+			}
+			replica.loaded = true
+		}
+		err = replica.read(func(reader storage.Reader) (err error) {
+			replica.storedBlocks, err = storedBlocks(reader)
+			return
+		})
+		if err != nil {
+			log.Printf("failed to check read replica %s: %v", replica.repo.connectionURL.Redacted(), err)
+			continue
+		}
+		replica.healthy = replica.storedBlocks+repo.options.MaxLag >= primaryBlocks
+	}
+}
+
+// nextReplica returns the next usable replica, or nil if the reads go to the primary.
+func (repo *ReplicatedRepository) nextReplica() *replica {
+	if repo.options.ReadYourWrites && repo.PostgresRepository.hasUncommittedWrites() {
+		return nil
+	}
+	if time.Since(repo.checkedAt) >= repo.options.CheckInterval {
+		repo.checkReplicas()
+	}
+	for range repo.replicas {
+		replica := repo.replicas[repo.next]
+		repo.next = (repo.next + 1) % len(repo.replicas)
+		if replica.healthy && replica.storedBlocks >= repo.committedBlocks {
+			return replica
+		}
+	}
+	return nil
+}
+
+// read calls a Reader method on the next usable replica, or on the primary if there is none, or the replica fails with a transient error.
+func (repo *ReplicatedRepository) read(call func(storage.Reader) error) error {
+	if replica := repo.nextReplica(); replica != nil {
+		err := replica.read(call)
+		if !errors.Is(err, storage.ErrRetryable) {
+			return err
+		}
+		replica.healthy = false
+		log.Printf("failed to read from read replica %s, reading from the primary: %v", replica.repo.connectionURL.Redacted(), err)
+	}
+	return call(repo.PostgresRepository)
+}
+
+func (repo *ReplicatedRepository) ListBlocks(pagination storage.OffsetPagination) (blocks []*storage.Block, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		blocks, totalRecordsFound, err = reader.ListBlocks(pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListUnclesByBlockNumber(blockNumber storage.BlockNumber) (uncles []*storage.Uncle, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		uncles, err = reader.ListUnclesByBlockNumber(blockNumber)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTransactions(pagination storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transactions, transactionIds, totalRecordsFound, err = reader.ListTransactions(pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTransactionsByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transactions, transactionIds, totalRecordsFound, err = reader.ListTransactionsByBlockNumber(blockNumber, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTransactionsByAddress(address common.Address, pagination storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transactions, transactionIds, totalRecordsFound, err = reader.ListTransactionsByAddress(address, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTransactionsByFilter(filter storage.TransactionFilter, pagination storage.OffsetPagination) (transactions []*storage.Transaction, transactionIds []storage.TransactionId, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transactions, transactionIds, totalRecordsFound, err = reader.ListTransactionsByFilter(filter, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListStorageKeysByTransactionId(transactionId storage.TransactionId) (storageKeys []*storage.StorageKey, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		storageKeys, err = reader.ListStorageKeysByTransactionId(transactionId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListLogsByTransactionId(transactionId storage.TransactionId) (logs []*storage.Log, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		logs, err = reader.ListLogsByTransactionId(transactionId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListLogsByFilter(filter storage.LogFilter, pagination *storage.OffsetPagination) (logs []*storage.Log, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		logs, err = reader.ListLogsByFilter(filter, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListErc20TokenTransfers(token, fromOrToFilter *common.Address, pagination *storage.OffsetPagination) (transfers []*storage.Erc20TokenTransfer, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transfers, totalRecordsFound, err = reader.ListErc20TokenTransfers(token, fromOrToFilter, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTraces(pagination *storage.OffsetPagination) (traceActions []*storage.TraceAction, blockNumbers []uint64, timestamps []uint64, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		traceActions, blockNumbers, timestamps, totalRecordsFound, err = reader.ListTraces(pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTracesByTransactionHash(transactionHash *common.Hash) (traceActions []*storage.TraceAction, blockNumber uint64, timestamp uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		traceActions, blockNumber, timestamp, err = reader.ListTracesByTransactionHash(transactionHash)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTracesByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (traceActions []*storage.TraceAction, timestamp uint64, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		traceActions, timestamp, totalRecordsFound, err = reader.ListTracesByBlockNumber(blockNumber, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListTracesByAddress(address common.Address, direction storage.Direction, onlyWithValue bool, pagination *storage.OffsetPagination) (traceActions []*storage.TraceAction, blockNumbers []uint64, timestamps []uint64, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		traceActions, blockNumbers, timestamps, totalRecordsFound, err = reader.ListTracesByAddress(address, direction, onlyWithValue, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListErc20TokenBalancesAtBlock(holder storage.AddressId, blockNumber storage.BlockNumber) (balances []*storage.Erc20TokenBalance, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		balances, err = reader.ListErc20TokenBalancesAtBlock(holder, blockNumber)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListErc20TokenHolders(token storage.Erc20TokenId, atBlock storage.BlockNumber, pagination storage.OffsetPagination) (holders []*storage.Erc20TokenHolder, totalRecordsFound uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		holders, totalRecordsFound, err = reader.ListErc20TokenHolders(token, atBlock, pagination)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListStateChangesByTransactionHash(transactionHash *common.Hash) (stateChanges []*storage.StateChange, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		stateChanges, err = reader.ListStateChangesByTransactionHash(transactionHash)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) ListMissingBlockRanges(from, to storage.BlockNumber) (missingRanges []*storage.BlockRange, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		missingRanges, err = reader.ListMissingBlockRanges(from, to)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetAddressById(addressId storage.AddressId) (address *common.Address, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		address, err = reader.GetAddressById(addressId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetAddressIdByHash(addressHash common.Address) (addressId storage.AddressId, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		addressId, err = reader.GetAddressIdByHash(addressHash)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetBlockByHash(hash *common.Hash) (block *storage.Block, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		block, err = reader.GetBlockByHash(hash)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetBlockByNumber(number uint64) (block *storage.Block, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		block, err = reader.GetBlockByNumber(number)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetLatestBlockNumber() (blockNumber *storage.BlockNumber, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		blockNumber, err = reader.GetLatestBlockNumber()
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetUncleByUncleHash(uncleHash *common.Hash) (uncle *storage.Uncle, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		uncle, err = reader.GetUncleByUncleHash(uncleHash)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetCallTree(transactionHash *common.Hash) (callTree *storage.CallFrame, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		callTree, err = reader.GetCallTree(transactionHash)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetTransactionById(transactionId storage.TransactionId) (transaction *storage.Transaction, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transaction, err = reader.GetTransactionById(transactionId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetTransactionByHash(transactionHash *common.Hash) (transaction *storage.Transaction, transactionId storage.TransactionId, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transaction, transactionId, err = reader.GetTransactionByHash(transactionHash)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetReceiptByTransactionId(transactionId storage.TransactionId) (receipt *storage.Receipt, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		receipt, err = reader.GetReceiptByTransactionId(transactionId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetByteCode(bytecodeId storage.BytecodeId) (bytecode *storage.Bytecode, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		bytecode, err = reader.GetByteCode(bytecodeId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetLogById(logId storage.LogId) (storedLog *storage.Log, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		storedLog, err = reader.GetLogById(logId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetErc20TokenByAddressId(addressId storage.AddressId) (token *storage.Erc20Token, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		token, err = reader.GetErc20TokenByAddressId(addressId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetContractByAddressId(addressId storage.AddressId) (contract *storage.Contract, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		contract, err = reader.GetContractByAddressId(addressId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetEventTypeById(eventTypeId storage.Topic0Id) (eventType *storage.EventType, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		eventType, err = reader.GetEventTypeById(eventTypeId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetWeiBalanceAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (balance *big.Int, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		balance, err = reader.GetWeiBalanceAtBlock(addressId, blockNumber)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetLastStoredEtherBalance(addressId storage.AddressId) (balance *storage.EtherBalance, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		balance, err = reader.GetLastStoredEtherBalance(addressId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetLastStoredErc20TokenBalance(holder, tokenAddressId storage.AddressId) (balance *storage.Erc20TokenBalance, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		balance, err = reader.GetLastStoredErc20TokenBalance(holder, tokenAddressId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetFirstTxSent(addressId storage.AddressId) (transactionHash *common.Hash, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transactionHash, err = reader.GetFirstTxSent(addressId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetLastTxSent(addressId storage.AddressId) (transactionHash *common.Hash, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		transactionHash, err = reader.GetLastTxSent(addressId)
+		return
+	})
+	return
+}
+func (repo *ReplicatedRepository) GetErc20TokenHolders(erc20TokenId storage.Erc20TokenId) (holders uint64, err error) {
+	err = repo.read(func(reader storage.Reader) (err error) {
+		holders, err = reader.GetErc20TokenHolders(erc20TokenId)
+		return
+	})
+	return
+}
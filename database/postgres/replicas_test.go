@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"database/sql"
+	"net/url"
+	"testing"
+
+	storage "github.com/librescan-org/backend-db"
+)
+
+func TestWithReadReplicasFromEnvFailsOnInvalidValues(t *testing.T) {
+	for _, test := range []struct {
+		name, key, value string
+	}{
+		{"read your writes", env_POSTGRES_READ_YOUR_WRITES, "maybe"},
+		{"max lag", env_POSTGRES_REPLICA_MAX_LAG, "-1"},
+		{"check interval", env_POSTGRES_REPLICA_CHECK_INTERVAL, "5"},
+		{"negative check interval", env_POSTGRES_REPLICA_CHECK_INTERVAL, "-5s"},
+		{"replica URL", env_POSTGRES_READ_REPLICAS, "postgres://librescan@replica:5432/"},
+	} {
+		for _, replicas := range []string{"", "postgres://librescan@replica:5432/librescan"} {
+			t.Run(test.name, func(t *testing.T) {
+				t.Setenv(env_POSTGRES_READ_REPLICAS, replicas)
+				t.Setenv(test.key, test.value)
+				repo, err := WithReadReplicasFromEnv(&PostgresRepository{})
+				if err == nil || repo != nil {
+					t.Errorf("%s=%q with replicas %q: got %v, %v, want an error", test.key, test.value, replicas, repo, err)
+				}
+			})
+		}
+	}
+	t.Setenv(env_POSTGRES_READ_REPLICAS, "")
+	primary := &PostgresRepository{}
+	if repo, err := WithReadReplicasFromEnv(primary); err != nil || repo != primary {
+		t.Errorf("without replicas: got %v, %v, want the primary", repo, err)
+	}
+}
+
+// newTestReplica returns a loaded replica on a fake server, see newTestRunner.
+func newTestReplica(t *testing.T) (*fakeServer, *replica) {
+	t.Helper()
+	server := &fakeServer{}
+	conn := sql.OpenDB(server)
+	t.Cleanup(func() { conn.Close() })
+	repo := &PostgresRepository{conn: conn, connectionURL: &url.URL{Scheme: "postgres", Host: "replica", Path: "/librescan"}}
+	repo.runner = newJournalingRunner(conn, repo.dropTransactionState)
+	repo.runner.policy.MaxAttempts = 1
+	return server, &replica{repo: repo, loaded: true, healthy: true}
+}
+
+func TestReplicaReadsRunInTransactionsOfTheirOwn(t *testing.T) {
+	server, replica := newTestReplica(t)
+	var transactions []*sql.Tx
+	read := func(reader storage.Reader) error {
+		transactions = append(transactions, replica.repo.runner.tx)
+		var count int64
+		return replica.repo.runner.QueryRow("SELECT count").Scan(&count)
+	}
+	for i := 0; i < 2; i++ {
+		if err := replica.read(read); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if transactions[0] == transactions[1] {
+		t.Error("reads share their transaction")
+	}
+	for i, tx := range transactions {
+		if err := tx.Rollback(); err != sql.ErrTxDone {
+			t.Errorf("transaction of read %d is still open", i)
+		}
+	}
+
+	// the failing read recovers its transaction, which is rolled back too
+	server.failNextStatements(1)
+	if err := replica.read(read); err == nil {
+		t.Fatal("read succeeded despite the serialization failure")
+	}
+	if err := replica.repo.runner.tx.Rollback(); err != sql.ErrTxDone {
+		t.Error("transaction of the failed read is still open")
+	}
+}
@@ -0,0 +1,19 @@
+package sqlite
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	storage "github.com/librescan-org/backend-db"
+)
+
+func (repo *SqliteRepository) DeleteBlockAndAllReferences(blockNumbers ...storage.BlockNumber) (err error) {
+	defer annotate(&err, "failed to delete blocks %v", blockNumbers)
+	if len(blockNumbers) == 0 {
+		return nil
+	}
+	// the records referencing the blocks are deleted by the cascading foreign keys
+	_, err = repo.statementBuilder.
+		Delete(tableNameBlocks).
+		Where(sq.Eq{"number": blockNumbers}).
+		Exec()
+	return err
+}
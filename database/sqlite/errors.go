@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	storage "github.com/librescan-org/backend-db"
+	"modernc.org/sqlite"
+)
+
+// storageError attaches the storage errors matching a SQLite error, keeping the original error in the chain.
+type storageError struct {
+	kinds []error
+	err   error
+}
+
+func (err *storageError) Error() string {
+	return err.err.Error()
+}
+func (err *storageError) Unwrap() []error {
+	return append(append([]error(nil), err.kinds...), err.err)
+}
+
+// errorKindsByCode maps the extended result codes which are handled individually, see https://www.sqlite.org/rescode.html
+var errorKindsByCode = map[int][]error{
+	1555: {storage.ErrConflict},                        // SQLITE_CONSTRAINT_PRIMARYKEY
+	2067: {storage.ErrConflict},                        // SQLITE_CONSTRAINT_UNIQUE
+	787:  {storage.ErrConstraint, storage.ErrNotFound}, // SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+// errorKindsByPrimaryCode maps the primary result codes of the extended ones not handled individually by errorKindsByCode.
+var errorKindsByPrimaryCode = map[int][]error{
+	5:  {storage.ErrRetryable},                            // SQLITE_BUSY
+	6:  {storage.ErrRetryable},                            // SQLITE_LOCKED
+	9:  {storage.ErrTimeout},                              // SQLITE_INTERRUPT
+	17: {storage.ErrSchemaMismatch, storage.ErrRetryable}, // SQLITE_SCHEMA
+	18: {storage.ErrConstraint},                           // SQLITE_TOOBIG
+	19: {storage.ErrConstraint},                           // SQLITE_CONSTRAINT
+	20: {storage.ErrConstraint},                           // SQLITE_MISMATCH
+	26: {storage.ErrSchemaMismatch},                       // SQLITE_NOTADB
+}
+
+// errorKinds returns the storage errors matching err, or nil if there are none.
+func errorKinds(err error) []error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		if kinds, found := errorKindsByCode[sqliteErr.Code()]; found {
+			return kinds
+		}
+		return errorKindsByPrimaryCode[sqliteErr.Code()&0xff]
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return []error{storage.ErrNotFound}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return []error{storage.ErrTimeout}
+	}
+	return nil
+}
+
+// mapError wraps err into the storage errors matching it, unless that was done already.
+func mapError(err error) error {
+	var mapped *storageError
+	if err == nil || errors.As(err, &mapped) {
+		return err
+	}
+	kinds := errorKinds(err)
+	if kinds == nil {
+		return err
+	}
+	return &storageError{kinds: kinds, err: err}
+}
+
+// annotate maps *err to the storage errors, and prefixes it with the failed operation naming the entity and the key.
+// Every exported method defers it on its named error result.
+func annotate(err *error, format string, args ...any) {
+	if *err == nil {
+		return
+	}
+	*err = fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), mapError(*err))
+}
@@ -0,0 +1,337 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+)
+
+func (repo *SqliteRepository) GetEventTypeById(eventTypeId storage.Topic0Id) (_ *storage.EventType, err error) {
+	defer annotate(&err, "failed to get event type %v", eventTypeId)
+	var eventType storage.EventType
+	err = repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsEventTypes[1:])...).
+		From(tableNameEventTypes).
+		Where("id = ?", eventTypeId).
+		Scan(&eventType.Hash, &eventType.Signature)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &eventType, err
+}
+func (repo *SqliteRepository) getBlock(keyColumnName string, key any) (*storage.Block, error) {
+	return scanBlock(repo.queryRow(repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsBlocks)...).
+		From(tableNameBlocks).
+		Where(fmt.Sprintf(`"%s" = ?`, keyColumnName), key)))
+}
+func (repo *SqliteRepository) GetBlockByHash(hash *common.Hash) (_ *storage.Block, err error) {
+	defer annotate(&err, "failed to get block %v", hash)
+	return repo.getBlock("hash", hash)
+}
+func (repo *SqliteRepository) GetBlockByNumber(number uint64) (_ *storage.Block, err error) {
+	defer annotate(&err, "failed to get block %d", number)
+	return repo.getBlock("number", number)
+}
+func (repo *SqliteRepository) GetTransactionById(transactionId storage.TransactionId) (_ *storage.Transaction, err error) {
+	defer annotate(&err, "failed to get transaction %v", transactionId)
+	selectBuilder := repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsTransactions)...).
+		From(tableNameTransactions).
+		Where("id = ?", transactionId)
+	transaction, _, err := scanTransaction(repo.queryRow(selectBuilder))
+	return transaction, err
+}
+func (repo *SqliteRepository) GetTransactionByHash(transactionHash *common.Hash) (_ *storage.Transaction, _ storage.TransactionId, err error) {
+	defer annotate(&err, "failed to get transaction %v", transactionHash)
+	selectBuilder := repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsTransactions)...).
+		From(tableNameTransactions).
+		Where("hash = ?", transactionHash)
+	return scanTransaction(repo.queryRow(selectBuilder))
+}
+func (repo *SqliteRepository) GetAddressById(addressId storage.AddressId) (_ *common.Address, err error) {
+	defer annotate(&err, "failed to get address %v", addressId)
+	var address common.Address
+	err = repo.statementBuilder.
+		Select("hash").
+		From(tableNameAddresses).
+		Where("id = ?", addressId).
+		Scan(&address)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &address, nil
+}
+func (repo *SqliteRepository) GetAddressIdByHash(addressHash common.Address) (_ storage.AddressId, err error) {
+	defer annotate(&err, "failed to get id of address %v", addressHash)
+	var addressId storage.AddressId
+	err = repo.statementBuilder.
+		Select("id").
+		From(tableNameAddresses).
+		Where(`"hash" = ?`, addressHash).
+		Scan(&addressId)
+	if err != nil && err != sql.ErrNoRows {
+		return storage.AddressId{}, err
+	}
+	return addressId, nil
+}
+func (repo *SqliteRepository) GetReceiptByTransactionId(transactionId storage.TransactionId) (_ *storage.Receipt, err error) {
+	defer annotate(&err, "failed to get receipt of transaction %v", transactionId)
+	var receipt storage.Receipt
+	var effectiveGasPrice numeric
+	var contractAddressId storage.AddressId
+	err = repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsReceipts[1:])...).
+		From(tableNameReceipts).
+		Where("transaction_id = ?", transactionId).
+		Scan(&receipt.CumulativeGasUsed,
+			&receipt.GasUsed,
+			&contractAddressId,
+			&receipt.PostState,
+			&receipt.Status,
+			&effectiveGasPrice)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	receipt.TransactionId = transactionId
+	if !contractAddressId.IsZero() {
+		receipt.ContractAddressId = &contractAddressId
+	}
+	receipt.EffectiveGasPrice = effectiveGasPrice.Value
+	return &receipt, nil
+}
+func (repo *SqliteRepository) GetByteCode(bytecodeId storage.BytecodeId) (_ *storage.Bytecode, err error) {
+	defer annotate(&err, "failed to get bytecode %v", bytecodeId)
+	var bytecode storage.Bytecode
+	err = repo.statementBuilder.Select("bytecode").From(tableNameBytecodes).Where("id = ?", bytecodeId).Scan(&bytecode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &bytecode, nil
+}
+func (repo *SqliteRepository) GetLogById(logId storage.LogId) (_ *storage.Log, err error) {
+	defer annotate(&err, "failed to get log %d of transaction %v", logId.LogIndex, logId.TransactionId)
+	rows, err := repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsLogs)...).
+		From(tableNameLogs).
+		Where("transaction_id = ?", logId.TransactionId).
+		Where(`"index" = ?`, logId.LogIndex).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	logs, err := scanLogs(rows)
+	if err != nil || len(logs) == 0 {
+		return nil, err
+	}
+	return logs[0], nil
+}
+func (repo *SqliteRepository) GetErc20TokenByAddressId(addressId storage.AddressId) (_ *storage.Erc20Token, err error) {
+	defer annotate(&err, "failed to get ERC-20 token %v", addressId)
+	erc20Token := storage.Erc20Token{
+		AddressId: addressId,
+	}
+	var totalSupply numeric
+	err = repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsErc20Tokens[1:])...).
+		From(tableNameErc20Tokens).
+		Where("address_id = ?", addressId).
+		Scan(&erc20Token.Symbol,
+			&erc20Token.Name,
+			&erc20Token.Decimals,
+			&totalSupply)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	erc20Token.TotalSupply = totalSupply.Value
+	return &erc20Token, err
+}
+func (repo *SqliteRepository) GetContractByAddressId(addressId storage.AddressId) (_ *storage.Contract, err error) {
+	defer annotate(&err, "failed to get contract %v", addressId)
+	var transactionId storage.TransactionId
+	var bytecodeId storage.BytecodeId
+	err = repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsContracts[1:])...).
+		From(tableNameContracts).
+		Where("address_id = ?", addressId).
+		Scan(&transactionId, &bytecodeId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &storage.Contract{
+		AddressId:     addressId,
+		TransactionId: transactionId,
+		BytecodeId:    bytecodeId,
+	}, nil
+}
+func (repo *SqliteRepository) GetLastStoredEtherBalance(addressId storage.AddressId) (_ *storage.EtherBalance, err error) {
+	defer annotate(&err, "failed to get last ether balance of address %v", addressId)
+	var etherBalance storage.EtherBalance
+	var balance numeric
+	err = repo.statementBuilder.
+		Select("block_id", "balance").
+		From(tableNameEtherBalances).
+		Where("address_id = ?", addressId).
+		OrderBy("block_id DESC").
+		Limit(1).Scan(&etherBalance.BlockNumber, &balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	etherBalance.AddressId = addressId
+	etherBalance.Balance = balance.Value
+	return &etherBalance, err
+}
+func (repo *SqliteRepository) GetLastStoredErc20TokenBalance(addressId, tokenAddressId storage.AddressId) (_ *storage.Erc20TokenBalance, err error) {
+	defer annotate(&err, "failed to get last balance of address %v of token %v", addressId, tokenAddressId)
+	var erc20TokenBalance storage.Erc20TokenBalance
+	var balance numeric
+	err = repo.statementBuilder.
+		Select("block_id", "balance").
+		From(tableNameErc20TokenBalances).
+		Where("address_id = ?", addressId).
+		Where("token_address_id = ?", tokenAddressId).
+		OrderBy("block_id DESC").
+		Limit(1).
+		Scan(&erc20TokenBalance.BlockNumber, &balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	erc20TokenBalance.AddressId = addressId
+	erc20TokenBalance.TokenAddressId = tokenAddressId
+	erc20TokenBalance.Balance = balance.Value
+	return &erc20TokenBalance, err
+}
+func (repo *SqliteRepository) GetLatestBlockNumber() (_ *storage.BlockNumber, err error) {
+	defer annotate(&err, "failed to get latest block number")
+	var blockNumber *storage.BlockNumber
+	err = repo.statementBuilder.Select("MAX(number)").From(tableNameBlocks).Scan(&blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return blockNumber, err
+}
+func (repo *SqliteRepository) GetWeiBalanceAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (_ *big.Int, err error) {
+	defer annotate(&err, "failed to get balance of address %v at block %d", addressId, blockNumber)
+	var balance numeric
+	err = repo.statementBuilder.
+		Select("balance").
+		From(tableNameEtherBalances).
+		Where("address_id = ?", addressId).
+		Where("block_id <= ?", blockNumber).
+		OrderBy("block_id DESC").
+		Limit(1).
+		Scan(&balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return new(big.Int), nil
+		}
+		return nil, err
+	}
+	return balance.Value, nil
+}
+func (repo *SqliteRepository) GetFirstTxSent(addressId storage.AddressId) (_ *common.Hash, err error) {
+	defer annotate(&err, "failed to get first transaction sent by address %v", addressId)
+	var oldestTxId storage.TransactionId
+	err = repo.statementBuilder.
+		Select("MIN(id)").
+		From(tableNameTransactions).
+		Where("from_address_id = ?", addressId).Scan(&oldestTxId)
+	if err != nil || oldestTxId.IsZero() {
+		return nil, err
+	}
+	firstTxSent, err := repo.GetTransactionById(oldestTxId)
+	if err != nil {
+		return nil, err
+	}
+	return &firstTxSent.Hash, nil
+}
+func (repo *SqliteRepository) GetLastTxSent(addressId storage.AddressId) (_ *common.Hash, err error) {
+	defer annotate(&err, "failed to get last transaction sent by address %v", addressId)
+	var latestTxId storage.TransactionId
+	err = repo.statementBuilder.
+		Select("MAX(id)").
+		From(tableNameTransactions).
+		Where("from_address_id = ?", addressId).
+		Scan(&latestTxId)
+	if err != nil || latestTxId.IsZero() {
+		return nil, err
+	}
+	lastTxSent, err := repo.GetTransactionById(latestTxId)
+	if err != nil {
+		return nil, err
+	}
+	return &lastTxSent.Hash, nil
+}
+func (repo *SqliteRepository) GetErc20TokenHolders(erc20TokenId storage.Erc20TokenId) (holders uint64, err error) {
+	defer annotate(&err, "failed to count holders of token %v", erc20TokenId)
+	return repo.listers().CountErc20TokenHolders(erc20TokenId, nil)
+}
+func (repo *SqliteRepository) GetUncleByUncleHash(uncleHash *common.Hash) (_ *storage.Uncle, err error) {
+	defer annotate(&err, "failed to get uncle %v", uncleHash)
+	var uncle storage.Uncle
+	var minerAddressId storage.AddressId
+	var difficulty, reward numeric
+	err = repo.statementBuilder.
+		Select(quoteIdentifiers(tableColumnsUncles)...).
+		From(tableNameUncles).
+		Where("hash = ?", uncleHash).
+		Scan(
+			&uncle.Hash,
+			&uncle.Position,
+			&uncle.UncleHeight,
+			&uncle.BlockHeight,
+			&uncle.ParentHash,
+			&minerAddressId,
+			&difficulty,
+			&uncle.GasLimit,
+			&uncle.GasUsed,
+			&reward,
+			&uncle.Timestamp)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	uncle.MinerAddressId = minerAddressId
+	uncle.Difficulty = difficulty.Value
+	uncle.Reward = reward.Value
+	return &uncle, nil
+}
+func (repo *SqliteRepository) GetCallTree(transactionHash *common.Hash) (_ *storage.CallFrame, err error) {
+	defer annotate(&err, "failed to get call tree of transaction %v", transactionHash)
+	traces, _, _, err := repo.ListTracesByTransactionHash(transactionHash)
+	if err != nil {
+		return nil, err
+	}
+	return storage.BuildCallTree(traces)
+}
@@ -0,0 +1,353 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+)
+
+const on_conflict_do_nothing = "ON CONFLICT DO NOTHING"
+
+// executeInsert inserts the records one by one, keeping the stored ones on conflicts.
+// SQLite runs in process, so single-row statements cost little, and errors name the failing record, as described by describeRecord.
+// recordValues returns the values of a record in the order of the columns.
+func executeInsert[Record any](repo *SqliteRepository, tableName string, columns []string, records []Record, describeRecord func(Record) string, recordValues func(Record) []any) error {
+	quotedColumns := quoteIdentifiers(columns)
+	for _, record := range records {
+		_, err := repo.statementBuilder.
+			Insert(tableName).
+			Columns(quotedColumns...).
+			Values(recordValues(record)...).
+			Suffix(on_conflict_do_nothing).
+			Exec()
+		if err != nil {
+			return fmt.Errorf("%s: %w", describeRecord(record), mapError(err))
+		}
+	}
+	return nil
+}
+
+// selectId returns the id of the record stored with the key.
+func (repo *SqliteRepository) selectId(tableName, keyColumn string, key any, id any) error {
+	return repo.statementBuilder.
+		Select("id").
+		From(tableName).
+		Where(strconv.Quote(keyColumn)+" = ?", key).
+		Scan(id)
+}
+
+// traceAddressToJson encodes the path of a trace in the call tree, as SQLite has no arrays.
+func traceAddressToJson(traceAddress []uint64) string {
+	if len(traceAddress) == 0 {
+		return "[]"
+	}
+	encoded, _ := json.Marshal(traceAddress)
+	return string(encoded)
+}
+
+func (repo *SqliteRepository) StoreAddress(addresses ...common.Address) (_ []storage.AddressId, err error) {
+	defer annotate(&err, "failed to store addresses")
+	err = executeInsert(repo, tableNameAddresses, []string{"hash"}, addresses,
+		func(address common.Address) string { return fmt.Sprintf("address %v", address) },
+		func(address common.Address) []any { return []any{address} })
+	if err != nil {
+		return nil, err
+	}
+	addressIds := make([]storage.AddressId, 0, len(addresses))
+	for _, address := range addresses {
+		var id storage.AddressId
+		if err = repo.selectId(tableNameAddresses, "hash", address, &id); err != nil {
+			return nil, fmt.Errorf("id of address %v: %w", address, mapError(err))
+		}
+		addressIds = append(addressIds, id)
+	}
+	return addressIds, nil
+}
+func (repo *SqliteRepository) StoreBlock(blocks ...*storage.Block) (err error) {
+	defer annotate(&err, "failed to store blocks")
+	if err = sqlcommon.ValidateRecords(blocks, sqlcommon.ValidateBlock); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameBlocks, tableColumnsBlocks, blocks, sqlcommon.DescribeBlock, func(block *storage.Block) []any {
+		block.Difficulty = sqlcommon.BigIntMustNotBeNil(block.Difficulty)
+		block.TotalDifficulty = sqlcommon.BigIntMustNotBeNil(block.TotalDifficulty)
+		block.BaseFeePerGas = sqlcommon.BigIntMustNotBeNil(block.BaseFeePerGas)
+		block.StaticReward = sqlcommon.BigIntMustNotBeNil(block.StaticReward)
+		return []any{block.Hash,
+			block.Number,
+			strconv.FormatUint(block.Nonce, 10),
+			block.Sha3Uncles,
+			block.LogsBloom.Bytes(),
+			block.StateRoot,
+			block.MinerAddressId,
+			bigIntToNumeric(block.Difficulty),
+			bigIntToNumeric(block.TotalDifficulty),
+			block.Size,
+			block.ExtraData,
+			block.GasLimit,
+			block.GasUsed,
+			bigIntToNumeric(block.BaseFeePerGas),
+			block.MixHash,
+			bigIntToNumeric(block.StaticReward),
+			block.Timestamp,
+			block.ParentHash,
+			block.TransactionsRoot,
+			block.ReceiptsRoot}
+	})
+}
+func (repo *SqliteRepository) StoreUncle(uncles ...*storage.Uncle) (err error) {
+	defer annotate(&err, "failed to store uncles")
+	if err = sqlcommon.ValidateRecords(uncles, nil); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameUncles, tableColumnsUncles, uncles, sqlcommon.DescribeUncle, func(uncle *storage.Uncle) []any {
+		uncle.Difficulty = sqlcommon.BigIntMustNotBeNil(uncle.Difficulty)
+		uncle.Reward = sqlcommon.BigIntMustNotBeNil(uncle.Reward)
+		return []any{uncle.Hash,
+			uncle.Position,
+			uncle.UncleHeight,
+			uncle.BlockHeight,
+			uncle.ParentHash.Bytes(),
+			uncle.MinerAddressId,
+			bigIntToNumeric(uncle.Difficulty),
+			uncle.GasLimit,
+			uncle.GasUsed,
+			bigIntToNumeric(uncle.Reward),
+			uncle.Timestamp}
+	})
+}
+func (repo *SqliteRepository) StoreTransaction(transactions ...*storage.Transaction) (_ []storage.TransactionId, err error) {
+	defer annotate(&err, "failed to store transactions")
+	if err = sqlcommon.ValidateRecords(transactions, sqlcommon.ValidateTransaction); err != nil {
+		return nil, err
+	}
+	err = executeInsert(repo, tableNameTransactions, tableColumnsTransactions[1:], transactions, sqlcommon.DescribeTransaction, func(transaction *storage.Transaction) []any {
+		return []any{transaction.BlockNumber,
+			transaction.Hash,
+			transaction.Nonce,
+			transaction.Index,
+			transaction.FromAddressId,
+			transaction.ToAddressId,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(transaction.Value)),
+			transaction.Gas,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(transaction.GasPrice)),
+			bigIntToNumeric(transaction.GasTipCap),
+			bigIntToNumeric(transaction.GasFeeCap),
+			transaction.Input,
+			transaction.Type,
+			transaction.Raw}
+	})
+	if err != nil {
+		return nil, err
+	}
+	transactionIds := make([]storage.TransactionId, 0, len(transactions))
+	for _, transaction := range transactions {
+		var id storage.TransactionId
+		if err = repo.selectId(tableNameTransactions, "hash", transaction.Hash, &id); err != nil {
+			return nil, fmt.Errorf("id of transaction %v: %w", transaction.Hash, mapError(err))
+		}
+		transactionIds = append(transactionIds, id)
+	}
+	return transactionIds, nil
+}
+func (repo *SqliteRepository) StoreStorageKey(storageKeys ...*storage.StorageKey) (err error) {
+	defer annotate(&err, "failed to store storage keys")
+	if err = sqlcommon.ValidateRecords(storageKeys, sqlcommon.ValidateStorageKey); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameStorageKeys, tableColumnsStorageKeys, storageKeys, sqlcommon.DescribeStorageKey, func(storageKey *storage.StorageKey) []any {
+		return []any{storageKey.TransactionId,
+			storageKey.AddressId,
+			storageKey.StorageKey.Bytes()}
+	})
+}
+func (repo *SqliteRepository) StoreReceipt(receipts ...*storage.Receipt) (err error) {
+	defer annotate(&err, "failed to store receipts")
+	if err = sqlcommon.ValidateRecords(receipts, sqlcommon.ValidateReceipt); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameReceipts, tableColumnsReceipts, receipts, sqlcommon.DescribeReceipt, func(receipt *storage.Receipt) []any {
+		return []any{receipt.TransactionId,
+			receipt.CumulativeGasUsed,
+			receipt.GasUsed,
+			receipt.ContractAddressId,
+			receipt.PostState.Bytes(),
+			receipt.Status,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(receipt.EffectiveGasPrice))}
+	})
+}
+func (repo *SqliteRepository) StoreLog(logs ...*storage.Log) (err error) {
+	defer annotate(&err, "failed to store logs")
+	if err = sqlcommon.ValidateRecords(logs, sqlcommon.ValidateLog); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameLogs, tableColumnsLogs, logs, sqlcommon.DescribeLog, func(log *storage.Log) []any {
+		var topic1, topic2, topic3 []byte
+		if log.Topic1 != nil {
+			topic1 = log.Topic1[:]
+		}
+		if log.Topic2 != nil {
+			topic2 = log.Topic2[:]
+		}
+		if log.Topic3 != nil {
+			topic3 = log.Topic3[:]
+		}
+		return []any{log.TransactionId,
+			log.LogIndex,
+			log.AddressId,
+			log.Topic0Id,
+			topic1,
+			topic2,
+			topic3,
+			log.Data}
+	})
+}
+func (repo *SqliteRepository) StoreTopic0(eventTypes ...*storage.EventType) (_ []storage.Topic0Id, err error) {
+	defer annotate(&err, "failed to store event types")
+	if err = sqlcommon.ValidateRecords(eventTypes, nil); err != nil {
+		return nil, err
+	}
+	err = executeInsert(repo, tableNameEventTypes, tableColumnsEventTypes[1:], eventTypes, sqlcommon.DescribeEventType, func(eventType *storage.EventType) []any {
+		return []any{eventType.Hash, eventType.Signature}
+	})
+	if err != nil {
+		return nil, err
+	}
+	eventTypeIds := make([]storage.Topic0Id, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		var id storage.Topic0Id
+		if err = repo.selectId(tableNameEventTypes, "hash", eventType.Hash, &id); err != nil {
+			return nil, fmt.Errorf("id of %s: %w", sqlcommon.DescribeEventType(eventType), mapError(err))
+		}
+		eventTypeIds = append(eventTypeIds, id)
+	}
+	return eventTypeIds, nil
+}
+func (repo *SqliteRepository) StoreErc20TokenTransfer(erc20TokenTransfers ...*storage.Erc20TokenTransfer) (err error) {
+	defer annotate(&err, "failed to store ERC-20 token transfers")
+	if err = sqlcommon.ValidateRecords(erc20TokenTransfers, sqlcommon.ValidateErc20TokenTransfer); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameErc20TokenTransfers, tableColumnsErc20TokenTransfers, erc20TokenTransfers, sqlcommon.DescribeErc20TokenTransfer,
+		func(erc20TokenTransfer *storage.Erc20TokenTransfer) []any {
+			return []any{erc20TokenTransfer.LogId.TransactionId,
+				erc20TokenTransfer.LogId.LogIndex,
+				erc20TokenTransfer.TokenAddressId,
+				erc20TokenTransfer.FromAddressId,
+				erc20TokenTransfer.ToAddressId,
+				bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(erc20TokenTransfer.Value))}
+		})
+}
+func (repo *SqliteRepository) StoreErc20Token(erc20Tokens ...*storage.Erc20Token) (err error) {
+	defer annotate(&err, "failed to store ERC-20 tokens")
+	if err = sqlcommon.ValidateRecords(erc20Tokens, sqlcommon.ValidateErc20Token); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameErc20Tokens, tableColumnsErc20Tokens, erc20Tokens, sqlcommon.DescribeErc20Token, func(erc20Token *storage.Erc20Token) []any {
+		return []any{erc20Token.AddressId,
+			erc20Token.Symbol,
+			erc20Token.Name,
+			erc20Token.Decimals,
+			bigIntToNumeric(erc20Token.TotalSupply)}
+	})
+}
+func (repo *SqliteRepository) StoreContract(contracts ...*storage.Contract) (err error) {
+	defer annotate(&err, "failed to store contracts")
+	if err = sqlcommon.ValidateRecords(contracts, sqlcommon.ValidateContract); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameContracts, tableColumnsContracts, contracts, sqlcommon.DescribeContract, func(contract *storage.Contract) []any {
+		return []any{contract.AddressId, contract.TransactionId, contract.BytecodeId}
+	})
+}
+func (repo *SqliteRepository) StoreBytecode(bytecodes ...storage.Bytecode) (_ []storage.BytecodeId, err error) {
+	defer annotate(&err, "failed to store bytecodes")
+	err = executeInsert(repo, tableNameBytecodes, tableColumnsBytecodes, bytecodes, sqlcommon.DescribeBytecode, func(bytecode storage.Bytecode) []any {
+		return []any{[]byte(bytecode), sqlcommon.BytecodeSha256(bytecode)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	bytecodeIds := make([]storage.BytecodeId, 0, len(bytecodes))
+	for _, bytecode := range bytecodes {
+		var id storage.BytecodeId
+		if err = repo.selectId(tableNameBytecodes, "sha256", sqlcommon.BytecodeSha256(bytecode), &id); err != nil {
+			return nil, fmt.Errorf("id of %s: %w", sqlcommon.DescribeBytecode(bytecode), mapError(err))
+		}
+		bytecodeIds = append(bytecodeIds, id)
+	}
+	return bytecodeIds, nil
+}
+func (repo *SqliteRepository) StoreTrace(traceActions ...*storage.TraceAction) (err error) {
+	defer annotate(&err, "failed to store traces")
+	if err = sqlcommon.ValidateRecords(traceActions, sqlcommon.ValidateTrace); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameTraces, tableColumnsTraces, traceActions, sqlcommon.DescribeTrace, func(traceAction *storage.TraceAction) []any {
+		return []any{traceAction.TransactionId,
+			traceAction.Index,
+			traceAction.Type,
+			traceAction.Input,
+			traceAction.From,
+			traceAction.To,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(traceAction.Value)),
+			traceAction.Gas,
+			traceAction.Error,
+			traceAddressToJson(traceAction.TraceAddress),
+			traceAction.Depth,
+			traceAction.Output,
+			traceAction.GasUsed,
+			traceAction.CreatedAddressId,
+			traceAction.InitCode,
+			traceAction.RefundAddressId}
+	})
+}
+func (repo *SqliteRepository) StoreEtherBalance(etherBalances ...*storage.EtherBalance) (err error) {
+	defer annotate(&err, "failed to store ether balances")
+	if err = sqlcommon.ValidateRecords(etherBalances, sqlcommon.ValidateEtherBalance); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameEtherBalances, tableColumnsEtherBalances, etherBalances, sqlcommon.DescribeEtherBalance, func(etherBalance *storage.EtherBalance) []any {
+		return []any{etherBalance.AddressId, etherBalance.BlockNumber, bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(etherBalance.Balance))}
+	})
+}
+func (repo *SqliteRepository) StoreErc20TokenBalance(tokenBalances ...*storage.Erc20TokenBalance) (err error) {
+	defer annotate(&err, "failed to store ERC-20 token balances")
+	if err = sqlcommon.ValidateRecords(tokenBalances, sqlcommon.ValidateErc20TokenBalance); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameErc20TokenBalances, tableColumnsErc20TokenBalances, tokenBalances, sqlcommon.DescribeErc20TokenBalance, func(tokenBalance *storage.Erc20TokenBalance) []any {
+		return []any{tokenBalance.AddressId, tokenBalance.BlockNumber, tokenBalance.TokenAddressId, bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(tokenBalance.Balance))}
+	})
+}
+func (repo *SqliteRepository) StoreStateChange(stateChanges ...*storage.StateChange) (err error) {
+	defer annotate(&err, "failed to store state changes")
+	if err = sqlcommon.ValidateRecords(stateChanges, sqlcommon.ValidateStateChange); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameStateChanges, tableColumnsStateChanges, stateChanges, sqlcommon.DescribeStateChange, func(stateChange *storage.StateChange) []any {
+		return []any{stateChange.TransactionId,
+			stateChange.AddressId,
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(stateChange.BalanceBefore)),
+			bigIntToNumeric(sqlcommon.BigIntMustNotBeNil(stateChange.BalanceAfter)),
+			stateChange.NonceBefore,
+			stateChange.NonceAfter}
+	})
+}
+func (repo *SqliteRepository) StoreStorageChange(storageChanges ...*storage.StorageChange) (err error) {
+	defer annotate(&err, "failed to store storage changes")
+	if err = sqlcommon.ValidateRecords(storageChanges, sqlcommon.ValidateStorageChange); err != nil {
+		return err
+	}
+	return executeInsert(repo, tableNameStorageChanges, tableColumnsStorageChanges, storageChanges, sqlcommon.DescribeStorageChange, func(storageChange *storage.StorageChange) []any {
+		return []any{storageChange.TransactionId,
+			storageChange.AddressId,
+			storageChange.StorageAddress.Bytes(),
+			storageChange.ValueBefore.Bytes(),
+			storageChange.ValueAfter.Bytes()}
+	})
+}
@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/ethereum/go-ethereum/common"
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+)
+
+// dialect stores numbers as text padded by bigIntToNumeric, and trace addresses as JSON.
+var dialect = sqlcommon.Dialect{
+	Numeric: bigIntToNumeric,
+	TraceAddress: func(traceAddress *[]uint64) sql.Scanner {
+		return traceAddressScanner{traceAddress}
+	},
+}
+
+// listers returns the listers shared with the other SQL backends, see sqlcommon.Listers.
+func (repo *SqliteRepository) listers() sqlcommon.Listers {
+	return sqlcommon.Listers{Dialect: dialect, StatementBuilder: repo.statementBuilder, Reader: repo}
+}
+
+func (repo *SqliteRepository) ListBlocks(pagination storage.OffsetPagination) (_ []*storage.Block, _ uint64, err error) {
+	defer annotate(&err, "failed to list blocks")
+	return repo.listers().ListBlocks(pagination)
+}
+func (repo *SqliteRepository) ListUnclesByBlockNumber(blockNumber storage.BlockNumber) (_ []*storage.Uncle, err error) {
+	defer annotate(&err, "failed to list uncles of block %d", blockNumber)
+	return repo.listers().ListUnclesByBlockNumber(blockNumber)
+}
+func (repo *SqliteRepository) ListTracesByTransactionHash(transactionHash *common.Hash) (_ []*storage.TraceAction, _, _ uint64, err error) {
+	defer annotate(&err, "failed to list traces of transaction %v", transactionHash)
+	return repo.listers().ListTracesByTransactionHash(transactionHash)
+}
+func (repo *SqliteRepository) ListTransactionsByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (_ []*storage.Transaction, _ []storage.TransactionId, _ uint64, err error) {
+	defer annotate(&err, "failed to list transactions of block %d", blockNumber)
+	return repo.listers().ListTransactionsByBlockNumber(blockNumber, pagination)
+}
+func (repo *SqliteRepository) ListTransactionsByAddress(address common.Address, pagination storage.OffsetPagination) (_ []*storage.Transaction, _ []storage.TransactionId, _ uint64, err error) {
+	defer annotate(&err, "failed to list transactions of address %v", address)
+	return repo.ListTransactionsByFilter(storage.TransactionFilter{Address: &address}, pagination)
+}
+func (repo *SqliteRepository) ListTransactionsByFilter(filter storage.TransactionFilter, pagination storage.OffsetPagination) (_ []*storage.Transaction, _ []storage.TransactionId, _ uint64, err error) {
+	defer annotate(&err, "failed to list transactions by filter")
+	return repo.listers().ListTransactionsByFilter(filter, pagination)
+}
+func (repo *SqliteRepository) ListTransactions(pagination storage.OffsetPagination) (_ []*storage.Transaction, _ []storage.TransactionId, _ uint64, err error) {
+	defer annotate(&err, "failed to list transactions")
+	return repo.listers().ListTransactions(pagination)
+}
+func (repo *SqliteRepository) ListStorageKeysByTransactionId(transactionId storage.TransactionId) (_ []*storage.StorageKey, err error) {
+	defer annotate(&err, "failed to list storage keys of transaction %v", transactionId)
+	return repo.listers().ListStorageKeysByTransactionId(transactionId)
+}
+func (repo *SqliteRepository) ListLogsByTransactionId(transactionId storage.TransactionId) (_ []*storage.Log, err error) {
+	defer annotate(&err, "failed to list logs of transaction %v", transactionId)
+	return repo.listers().ListLogsByTransactionId(transactionId)
+}
+func (repo *SqliteRepository) ListErc20TokenTransfers(token, fromOrToFilter *common.Address, pagination *storage.OffsetPagination) (_ []*storage.Erc20TokenTransfer, _ uint64, err error) {
+	defer annotate(&err, "failed to list ERC-20 token transfers of token %v and address %v", token, fromOrToFilter)
+	return repo.listers().ListErc20TokenTransfers(token, fromOrToFilter, pagination)
+}
+func (repo *SqliteRepository) ListTracesByBlockNumber(blockNumber storage.BlockNumber, pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ uint64, _ uint64, err error) {
+	defer annotate(&err, "failed to list traces of block %d", blockNumber)
+	return repo.listers().ListTracesByBlockNumber(blockNumber, pagination)
+}
+func (repo *SqliteRepository) ListTraces(pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ []uint64, _ []uint64, _ uint64, err error) {
+	defer annotate(&err, "failed to list traces")
+	return repo.listers().ListTraces(pagination)
+}
+func (repo *SqliteRepository) ListTracesByAddress(address common.Address, direction storage.Direction, onlyWithValue bool, pagination *storage.OffsetPagination) (_ []*storage.TraceAction, _ []uint64, _ []uint64, _ uint64, err error) {
+	defer annotate(&err, "failed to list traces of address %v", address)
+	return repo.listers().ListTracesByAddress(address, direction, onlyWithValue, pagination)
+}
+func (repo *SqliteRepository) ListErc20TokenBalancesAtBlock(addressId storage.AddressId, blockNumber storage.BlockNumber) (_ []*storage.Erc20TokenBalance, err error) {
+	defer annotate(&err, "failed to list ERC-20 token balances of address %v at block %d", addressId, blockNumber)
+	return repo.listers().ListErc20TokenBalancesAtBlock(addressId, blockNumber)
+}
+func (repo *SqliteRepository) ListErc20TokenHolders(tokenAddressId storage.Erc20TokenId, atBlock storage.BlockNumber, pagination storage.OffsetPagination) (_ []*storage.Erc20TokenHolder, _ uint64, err error) {
+	defer annotate(&err, "failed to list holders of token %v at block %d", tokenAddressId, atBlock)
+	return repo.listers().ListErc20TokenHolders(tokenAddressId, atBlock, pagination)
+}
+func (repo *SqliteRepository) ListStateChangesByTransactionHash(transactionHash *common.Hash) (_ []*storage.StateChange, err error) {
+	defer annotate(&err, "failed to list state changes of transaction %v", transactionHash)
+	return repo.listers().ListStateChangesByTransactionHash(transactionHash)
+}
+func (repo *SqliteRepository) ListMissingBlockRanges(from, to storage.BlockNumber) (_ []*storage.BlockRange, err error) {
+	defer annotate(&err, "failed to list missing blocks between %d and %d", from, to)
+	return repo.listers().ListMissingBlockRanges(from, to)
+}
+func (repo *SqliteRepository) ListLogsByFilter(filter storage.LogFilter, pagination *storage.OffsetPagination) (_ []*storage.Log, err error) {
+	defer annotate(&err, "failed to list logs of blocks between %d and %d by filter", filter.FromBlock, filter.ToBlock)
+	if filter.FromBlock > filter.ToBlock {
+		return nil, fmt.Errorf("%w: from block is after to block", storage.ErrConstraint)
+	}
+	if len(filter.Topics) > 4 {
+		return nil, fmt.Errorf("%w: logs have at most 4 topics", storage.ErrConstraint)
+	}
+	selectBuilder := repo.statementBuilder.
+		Select(qualifiedColumns(tableNameLogs, tableColumnsLogs)...).
+		From(tableNameLogs).
+		Join(fmt.Sprintf("%[1]s ON %[1]s.id = %[2]s.transaction_id", tableNameTransactions, tableNameLogs)).
+		Where(tableNameTransactions+".block_id BETWEEN ? AND ?", filter.FromBlock, filter.ToBlock).
+		OrderBy(tableNameLogs+".transaction_id", tableNameLogs+`."index"`)
+	// unlike postgres, there is no bloom bits index, the indexes on addresses and first topics narrow down the logs
+	if len(filter.Addresses) != 0 {
+		addresses := make([][]byte, 0, len(filter.Addresses))
+		for _, address := range filter.Addresses {
+			addresses = append(addresses, address.Bytes())
+		}
+		selectBuilder = selectBuilder.Where(sq.Expr(tableNameLogs+".address_id IN (?)",
+			repo.statementBuilder.Select("id").From(tableNameAddresses).Where(sq.Eq{"hash": addresses})))
+	}
+	for position, topics := range filter.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		hashes := make([][]byte, 0, len(topics))
+		for _, topic := range topics {
+			hashes = append(hashes, topic.Bytes())
+		}
+		if position == 0 {
+			selectBuilder = selectBuilder.Where(sq.Expr(tableNameLogs+".topic_0_id IN (?)",
+				repo.statementBuilder.Select("id").From(tableNameEventTypes).Where(sq.Eq{"hash": hashes})))
+		} else {
+			selectBuilder = selectBuilder.Where(sq.Eq{fmt.Sprintf("%s.topic_%d", tableNameLogs, position): hashes})
+		}
+	}
+	if pagination != nil {
+		selectBuilder = selectBuilder.
+			Limit(uint64(pagination.Limit)).
+			Offset(pagination.Offset)
+	}
+	rows, err := selectBuilder.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanLogs(rows)
+}
@@ -0,0 +1,43 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+)
+
+type ScannerWithErrHandling = sqlcommon.ScannerWithErrHandling
+
+var (
+	scanBlock       = sqlcommon.ScanBlock
+	scanTransaction = sqlcommon.ScanTransaction
+	scanLogs        = sqlcommon.ScanLogs
+)
+
+// scanTraceAddress decodes the path of a trace in the call tree, see traceAddressToJson.
+func scanTraceAddress(encoded string) ([]uint64, error) {
+	var traceAddress []uint64
+	if err := json.Unmarshal([]byte(encoded), &traceAddress); err != nil {
+		return nil, fmt.Errorf("invalid trace address %q: %w", encoded, err)
+	}
+	if len(traceAddress) == 0 {
+		return nil, nil
+	}
+	return traceAddress, nil
+}
+
+// traceAddressScanner scans a trace_address column encoded by traceAddressToJson.
+type traceAddressScanner struct {
+	traceAddress *[]uint64
+}
+
+func (scanner traceAddressScanner) Scan(src any) (err error) {
+	var encoded sql.NullString
+	if err = encoded.Scan(src); err != nil {
+		return err
+	}
+	*scanner.traceAddress, err = scanTraceAddress(encoded.String)
+	return err
+}
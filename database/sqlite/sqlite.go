@@ -0,0 +1,132 @@
+// Package sqlite implements storage.Storage on an embedded SQLite database, for single-process deployments,
+// e.g. small chains and local devnets, and for tests. The database is a single file, or lives in memory.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/librescan-org/backend-db/database/internal/sqlcommon"
+	_ "modernc.org/sqlite"
+)
+
+// env_SQLITE_PATH is the path of the database file, ":memory:" for a database living in memory.
+const env_SQLITE_PATH = "SQLITE_PATH"
+
+//go:embed sqlite_init.sql
+var sqlite_init_sql string
+
+// numericDigits is the number of digits of the largest 256 bits integer.
+const numericDigits = 78
+
+// bigIntToNumeric converts a non-negative big.Int to a parameter of a TEXT column, nil becoming NULL.
+// SQLite integers have 64 bits, so larger integers are stored as decimal text padded with zeros to numericDigits,
+// which compares and sorts like the numbers.
+func bigIntToNumeric(number *big.Int) any {
+	if number == nil {
+		return nil
+	}
+	return fmt.Sprintf("%0*s", numericDigits, number.String())
+}
+
+// numeric scans a column stored by bigIntToNumeric into a big.Int, NULL becoming nil.
+type numeric = sqlcommon.Numeric
+
+// txRunner runs the statements of the repository's transaction, which is replaced by the next one on every commit.
+type txRunner struct {
+	tx *sql.Tx
+}
+
+func (runner *txRunner) Exec(query string, args ...any) (sql.Result, error) {
+	return runner.tx.Exec(query, args...)
+}
+func (runner *txRunner) Query(query string, args ...any) (*sql.Rows, error) {
+	return runner.tx.Query(query, args...)
+}
+func (runner *txRunner) QueryRow(query string, args ...any) sq.RowScanner {
+	return runner.tx.QueryRow(query, args...)
+}
+
+type SqliteRepository struct {
+	conn             *sql.DB
+	runner           *txRunner
+	statementBuilder sq.StatementBuilderType
+
+	// path is empty if the database is configured by the environment, see NewSqliteRepository.
+	path string
+}
+
+// NewSqliteRepository returns a repository of the database at the path, ":memory:" for a database living in memory,
+// instead of the one of SQLITE_PATH.
+func NewSqliteRepository(path string) *SqliteRepository {
+	return &SqliteRepository{path: path}
+}
+
+// dataSourceName enables foreign keys, so deleting blocks cascades to the records referencing them.
+func dataSourceName(path string) string {
+	if path == ":memory:" {
+		return "file::memory:?_pragma=foreign_keys(1)"
+	}
+	return "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+func (repo *SqliteRepository) Load() (err error) {
+	path := repo.path
+	if path == "" {
+		path = os.Getenv(env_SQLITE_PATH)
+	}
+	defer annotate(&err, "failed to load database %s", path)
+	if strings.TrimSpace(path) == "" {
+		return fmt.Errorf("%s is not set", env_SQLITE_PATH)
+	}
+	defer func() {
+		// Load can be retried, see database.LoadRepository
+		if err != nil && repo.conn != nil {
+			repo.conn.Close()
+			repo.conn = nil
+		}
+	}()
+	if repo.conn, err = sql.Open("sqlite", dataSourceName(path)); err != nil {
+		return err
+	}
+	// the repository uses a single transaction, and an in-memory database lives as long as its connection
+	repo.conn.SetMaxOpenConns(1)
+	repo.conn.SetConnMaxIdleTime(0)
+	repo.conn.SetConnMaxLifetime(0)
+	if _, err = repo.conn.Exec(sqlite_init_sql); err != nil {
+		return err
+	}
+	repo.runner = &txRunner{}
+	if repo.runner.tx, err = repo.conn.Begin(); err != nil {
+		return err
+	}
+	repo.statementBuilder = sq.StatementBuilder.RunWith(repo.runner)
+	return nil
+}
+func (repo *SqliteRepository) Commit(ctx context.Context) (err error) {
+	defer annotate(&err, "failed to commit")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	commitErr := repo.runner.tx.Commit()
+	// the next transaction is begun even if committing failed, so the repository stays usable,
+	// and without the context, which would roll it back once done
+	tx, err := repo.conn.Begin()
+	if err != nil {
+		return errors.Join(commitErr, err)
+	}
+	repo.runner.tx = tx
+	return commitErr
+}
+
+// queryRow runs the query of the builder, returning a row which can be scanned by the scanners.
+func (repo *SqliteRepository) queryRow(selectBuilder sq.SelectBuilder) *sql.Row {
+	query, args := selectBuilder.MustSql()
+	return repo.runner.tx.QueryRow(query, args...)
+}
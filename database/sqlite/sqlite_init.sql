@@ -0,0 +1,197 @@
+-- The schema mirrors the postgres one, see database/postgres/postgres_init.sql.
+-- Integers not fitting into 64 bits are stored as decimal text padded with zeros to 78 digits, see numericText,
+-- so they compare and sort like numbers.
+
+CREATE TABLE IF NOT EXISTS "Addresses"(
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "hash" BLOB NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS "Blocks" (
+    "number" INTEGER PRIMARY KEY,
+    "hash" BLOB UNIQUE NOT NULL,
+    "nonce" TEXT NOT NULL,
+    "sha3_uncles" BLOB NOT NULL,
+    "logs_bloom" BLOB NOT NULL,
+    "state_root" BLOB NOT NULL,
+    "miner" INTEGER REFERENCES "Addresses" NOT NULL,
+    "difficulty" TEXT NOT NULL,
+    "total_difficulty" TEXT NOT NULL,
+    "size" INTEGER NULL,
+    "extra_data" BLOB NULL,
+    "gas_limit" INTEGER NULL,
+    "gas_used" INTEGER NULL,
+    "base_fee" TEXT NULL,
+    "mix_hash" BLOB NULL,
+    "static_reward" TEXT NOT NULL,
+    "timestamp" INTEGER NOT NULL,
+    "parent_hash" BLOB NULL,
+    "transactions_root" BLOB NULL,
+    "receipts_root" BLOB NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Uncles" (
+    "hash" BLOB PRIMARY KEY,
+    "position" INTEGER NOT NULL,
+    "uncle_height" INTEGER NOT NULL,
+    "block_height" INTEGER REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
+    "parent_hash" BLOB NOT NULL,
+    "miner" INTEGER REFERENCES "Addresses",
+    "difficulty" TEXT NOT NULL,
+    "gas_limit" INTEGER NULL,
+    "gas_used" INTEGER NULL,
+    "reward" TEXT NOT NULL,
+    "timestamp" INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Bytecodes" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "bytecode" BLOB NOT NULL,
+    "sha256" BLOB UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Transactions" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "block_id" INTEGER REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
+    "hash" BLOB UNIQUE NOT NULL,
+    "nonce" INTEGER NOT NULL,
+    "index" INTEGER NOT NULL,
+    "from_address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "to_address_id" INTEGER REFERENCES "Addresses" NULL,
+    "value" TEXT NOT NULL,
+    "gas" INTEGER NOT NULL,
+    "gas_price" TEXT NOT NULL,
+    "gas_tip_cap" TEXT NULL,
+    "gas_fee_cap" TEXT NULL,
+    "input" BLOB NOT NULL,
+    "transaction_type" INTEGER NOT NULL,
+    "raw" BLOB NULL
+);
+
+CREATE TABLE IF NOT EXISTS "StorageKeys" (
+    "transaction_id" INTEGER REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "storage_key" BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Contracts" (
+    "address_id" INTEGER PRIMARY KEY REFERENCES "Addresses",
+    "transaction_id" INTEGER REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "bytecode_id" INTEGER REFERENCES "Bytecodes" NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Receipts" (
+    "transaction_id" INTEGER PRIMARY KEY REFERENCES "Transactions" ON DELETE CASCADE,
+    "cumulative_gas_used" INTEGER NOT NULL,
+    "gas_used" INTEGER NOT NULL,
+    "contract_address_id" INTEGER REFERENCES "Addresses" NULL,
+    "post_state" BLOB NOT NULL,
+    "success" INTEGER NOT NULL,
+    "effective_gas_price" TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "FirstTopics" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "hash" BLOB UNIQUE NOT NULL,
+    "signature" TEXT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Logs" (
+    "transaction_id" INTEGER REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "index" INTEGER NOT NULL,
+    "address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "topic_0_id" INTEGER REFERENCES "FirstTopics" NULL,
+    "topic_1" BLOB NULL,
+    "topic_2" BLOB NULL,
+    "topic_3" BLOB NULL,
+    "data" BLOB NOT NULL,
+    PRIMARY KEY("transaction_id", "index")
+);
+
+CREATE TABLE IF NOT EXISTS "Erc20Tokens" (
+    "address_id" INTEGER PRIMARY KEY REFERENCES "Addresses",
+    "symbol" TEXT NULL,
+    "name" TEXT NULL,
+    "decimals" INTEGER NULL,
+    "total_supply" TEXT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Erc20TokenTransfers" (
+    "transaction_id" INTEGER REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "log_index" INTEGER NOT NULL,
+    "token_address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "from_address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "to_address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "value" TEXT NOT NULL,
+    PRIMARY KEY("transaction_id", "log_index")
+);
+
+CREATE TABLE IF NOT EXISTS "Traces" (
+    "transaction_id" INTEGER REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "index" INTEGER NOT NULL,
+    "type" TEXT NOT NULL,
+    "input" BLOB NULL,
+    "from_address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "to_address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "value" TEXT NOT NULL,
+    "gas" INTEGER NULL,
+    "error" TEXT NULL,
+    -- JSON array of the positions
+    "trace_address" TEXT NOT NULL DEFAULT '[]',
+    "depth" INTEGER NOT NULL DEFAULT 0,
+    "output" BLOB NULL,
+    "gas_used" INTEGER NULL,
+    "created_address_id" INTEGER REFERENCES "Addresses" NULL,
+    "init" BLOB NULL,
+    "refund_address_id" INTEGER REFERENCES "Addresses" NULL,
+    PRIMARY KEY("transaction_id", "index")
+);
+
+CREATE TABLE IF NOT EXISTS "EtherBalances" (
+    "address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "block_id" INTEGER REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
+    "balance" TEXT NOT NULL,
+    PRIMARY KEY("address_id", "block_id")
+);
+
+CREATE TABLE IF NOT EXISTS "Erc20TokenBalances" (
+    "address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "block_id" INTEGER REFERENCES "Blocks" ON DELETE CASCADE NOT NULL,
+    "token_address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "balance" TEXT NOT NULL,
+    PRIMARY KEY("address_id", "block_id", "token_address_id")
+);
+
+CREATE TABLE IF NOT EXISTS "StateChanges" (
+    "transaction_id" INTEGER REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "balance_before" TEXT NOT NULL,
+    "balance_after" TEXT NOT NULL,
+    "nonce_before" INTEGER NULL,
+    "nonce_after" INTEGER NULL,
+    PRIMARY KEY("transaction_id", "address_id")
+);
+
+CREATE TABLE IF NOT EXISTS "StorageChanges" (
+    "transaction_id" INTEGER REFERENCES "Transactions" ON DELETE CASCADE NOT NULL,
+    "address_id" INTEGER REFERENCES "Addresses" NOT NULL,
+    "storage_address" BLOB NOT NULL,
+    "value_before" BLOB NOT NULL,
+    "value_after" BLOB NOT NULL,
+    PRIMARY KEY("transaction_id", "address_id", "storage_address")
+);
+
+CREATE INDEX IF NOT EXISTS "Uncles_block_height_idx" ON "Uncles" ("block_height");
+CREATE INDEX IF NOT EXISTS "Transactions_from_address_id_id_idx" ON "Transactions" ("from_address_id", "id" DESC);
+CREATE INDEX IF NOT EXISTS "Transactions_to_address_id_id_idx" ON "Transactions" ("to_address_id", "id" DESC);
+CREATE INDEX IF NOT EXISTS "Transactions_block_id_idx" ON "Transactions" ("block_id");
+CREATE INDEX IF NOT EXISTS "StorageKeys_transaction_id_idx" ON "StorageKeys" ("transaction_id");
+CREATE INDEX IF NOT EXISTS "Contracts_transaction_id_idx" ON "Contracts" ("transaction_id");
+CREATE INDEX IF NOT EXISTS "Traces_from_address_id_idx" ON "Traces" ("from_address_id", "transaction_id" DESC, "index" DESC);
+CREATE INDEX IF NOT EXISTS "Traces_to_address_id_idx" ON "Traces" ("to_address_id", "transaction_id" DESC, "index" DESC);
+CREATE INDEX IF NOT EXISTS "Logs_address_id_idx" ON "Logs" ("address_id", "transaction_id");
+CREATE INDEX IF NOT EXISTS "Logs_topic_0_id_idx" ON "Logs" ("topic_0_id", "transaction_id");
+CREATE INDEX IF NOT EXISTS "EtherBalances_block_id_idx" ON "EtherBalances" ("block_id");
+CREATE INDEX IF NOT EXISTS "Erc20TokenBalances_block_id_idx" ON "Erc20TokenBalances" ("block_id");
+CREATE INDEX IF NOT EXISTS "Erc20TokenBalances_token_address_id_idx" ON "Erc20TokenBalances" ("token_address_id", "address_id", "block_id" DESC);
+CREATE INDEX IF NOT EXISTS "StateChanges_transaction_id_idx" ON "StateChanges" ("transaction_id");
@@ -0,0 +1,26 @@
+package sqlite
+
+import (
+	"testing"
+
+	storage "github.com/librescan-org/backend-db"
+	"github.com/librescan-org/backend-db/database/internal/storagetest"
+)
+
+// newTestRepository returns a loaded repository of an in-memory database, closed at the end of the test.
+func newTestRepository(t *testing.T) storage.Storage {
+	t.Helper()
+	repo := NewSqliteRepository(":memory:")
+	if err := repo.Load(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = repo.runner.tx.Rollback()
+		_ = repo.conn.Close()
+	})
+	return repo
+}
+
+func TestSqliteRepository(t *testing.T) {
+	storagetest.TestStorage(t, newTestRepository)
+}
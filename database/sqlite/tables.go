@@ -0,0 +1,46 @@
+package sqlite
+
+import "github.com/librescan-org/backend-db/database/internal/sqlcommon"
+
+// The tables are shared with the other SQL backends, see sqlcommon.
+const (
+	tableNameAddresses           = sqlcommon.TableNameAddresses
+	tableNameBlocks              = sqlcommon.TableNameBlocks
+	tableNameUncles              = sqlcommon.TableNameUncles
+	tableNameBytecodes           = sqlcommon.TableNameBytecodes
+	tableNameTransactions        = sqlcommon.TableNameTransactions
+	tableNameStorageKeys         = sqlcommon.TableNameStorageKeys
+	tableNameContracts           = sqlcommon.TableNameContracts
+	tableNameReceipts            = sqlcommon.TableNameReceipts
+	tableNameEventTypes          = sqlcommon.TableNameEventTypes
+	tableNameLogs                = sqlcommon.TableNameLogs
+	tableNameErc20Tokens         = sqlcommon.TableNameErc20Tokens
+	tableNameErc20TokenTransfers = sqlcommon.TableNameErc20TokenTransfers
+	tableNameTraces              = sqlcommon.TableNameTraces
+	tableNameEtherBalances       = sqlcommon.TableNameEtherBalances
+	tableNameErc20TokenBalances  = sqlcommon.TableNameErc20TokenBalances
+	tableNameStateChanges        = sqlcommon.TableNameStateChanges
+	tableNameStorageChanges      = sqlcommon.TableNameStorageChanges
+)
+
+var (
+	tableColumnsBlocks              = sqlcommon.TableColumnsBlocks
+	tableColumnsUncles              = sqlcommon.TableColumnsUncles
+	tableColumnsContracts           = sqlcommon.TableColumnsContracts
+	tableColumnsTransactions        = sqlcommon.TableColumnsTransactions
+	tableColumnsStorageKeys         = sqlcommon.TableColumnsStorageKeys
+	tableColumnsErc20Tokens         = sqlcommon.TableColumnsErc20Tokens
+	tableColumnsBytecodes           = sqlcommon.TableColumnsBytecodes
+	tableColumnsLogs                = sqlcommon.TableColumnsLogs
+	tableColumnsReceipts            = sqlcommon.TableColumnsReceipts
+	tableColumnsEventTypes          = sqlcommon.TableColumnsEventTypes
+	tableColumnsErc20TokenTransfers = sqlcommon.TableColumnsErc20TokenTransfers
+	tableColumnsTraces              = sqlcommon.TableColumnsTraces
+	tableColumnsEtherBalances       = sqlcommon.TableColumnsEtherBalances
+	tableColumnsErc20TokenBalances  = sqlcommon.TableColumnsErc20TokenBalances
+	tableColumnsStateChanges        = sqlcommon.TableColumnsStateChanges
+	tableColumnsStorageChanges      = sqlcommon.TableColumnsStorageChanges
+
+	qualifiedColumns = sqlcommon.QualifiedColumns
+	quoteIdentifiers = sqlcommon.QuoteIdentifiers
+)
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by backends wrap one or more of the following errors, to be checked with errors.Is.
+// The wrapping error names the entity and the key involved, and also wraps the backend's own error.
+var (
+	// ErrNotFound is returned when an entity referenced by the operation does not exist.
+	// Getters still return nil without error for the entity they are asked for, see Reader.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when an entity conflicts with an already stored one, e.g. by having the same key.
+	ErrConflict = errors.New("conflict")
+	// ErrConstraint is returned when an entity violates a constraint of the backend, e.g. a missing mandatory field.
+	ErrConstraint = errors.New("constraint violation")
+	// ErrRetryable is returned when the operation failed for transient reasons, like a serialization failure,
+	// a deadlock or a lost connection. The whole transaction since the last Commit has to be retried.
+	ErrRetryable = errors.New("retryable")
+	// ErrTimeout is returned when the operation was canceled or timed out.
+	ErrTimeout = errors.New("timeout")
+	// ErrSchemaMismatch is returned when the backend's schema does not match the one expected by the code,
+	// e.g. migrations are pending or the backend was created with different settings.
+	ErrSchemaMismatch = errors.New("schema mismatch")
+)
+
+// ConflictError is returned by inserters when an entity being stored differs from the stored entity having the same key,
+// in backends configured to detect conflicts. It wraps ErrConflict.
+type ConflictError struct {
+	// Entity names the entity and its key, e.g. "block 42".
+	Entity string
+	// Fields lists the differing fields. It is empty if the entity conflicts with a stored one on another unique field.
+	Fields []ConflictingField
+}
+
+// ConflictingField is a field of a ConflictError, its values are in the backend's text form.
+type ConflictingField struct {
+	Name     string
+	Stored   string
+	Incoming string
+}
+
+func (err *ConflictError) Error() string {
+	if len(err.Fields) == 0 {
+		return fmt.Sprintf("%s conflicts with a stored entity on another unique field", err.Entity)
+	}
+	differences := make([]string, 0, len(err.Fields))
+	for _, field := range err.Fields {
+		differences = append(differences, fmt.Sprintf("%s is %s instead of %s", field.Name, field.Incoming, field.Stored))
+	}
+	return fmt.Sprintf("%s differs from the stored one: %s", err.Entity, strings.Join(differences, ", "))
+}
+func (err *ConflictError) Unwrap() error {
+	return ErrConflict
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+)
+
+// Kinds of entities, separating their ids at compile time.
+type (
+	addressKind       struct{}
+	transactionKind   struct{}
+	receiptKind       struct{}
+	tokenTransferKind struct{}
+	bytecodeKind      struct{}
+	topic0Kind        struct{}
+)
+
+// Id identifies an entity of the given kind in a backend.
+// Its encoding is up to the backend, users of the storage only compare, print and parse ids.
+// The zero value is not a valid id, getters return it for entities not found.
+// Ids implement sql.Scanner and driver.Valuer, the zero id being NULL.
+type Id[Kind any] struct {
+	value int64
+	valid bool
+}
+
+type (
+	AddressId       = Id[addressKind]
+	TransactionId   = Id[transactionKind]
+	ReceiptId       = Id[receiptKind]
+	TokenTransferId = Id[tokenTransferKind]
+	BytecodeId      = Id[bytecodeKind]
+	Topic0Id        = Id[topic0Kind]
+	// Erc20TokenId is the id of the token contract's address.
+	Erc20TokenId = AddressId
+)
+
+// NewAddressId is meant to be used by backends only, see Id.
+func NewAddressId(value int64) AddressId { return AddressId{value: value, valid: true} }
+
+// NewTransactionId is meant to be used by backends only, see Id.
+func NewTransactionId(value int64) TransactionId { return TransactionId{value: value, valid: true} }
+
+// NewReceiptId is meant to be used by backends only, see Id.
+func NewReceiptId(value int64) ReceiptId { return ReceiptId{value: value, valid: true} }
+
+// NewTokenTransferId is meant to be used by backends only, see Id.
+func NewTokenTransferId(value int64) TokenTransferId {
+	return TokenTransferId{value: value, valid: true}
+}
+
+// NewBytecodeId is meant to be used by backends only, see Id.
+func NewBytecodeId(value int64) BytecodeId { return BytecodeId{value: value, valid: true} }
+
+// NewTopic0Id is meant to be used by backends only, see Id.
+func NewTopic0Id(value int64) Topic0Id { return Topic0Id{value: value, valid: true} }
+
+// Int64 returns the backend's encoding of the id, it is meant to be used by backends only.
+func (id Id[Kind]) Int64() int64 {
+	return id.value
+}
+
+// IsZero reports whether the id is the zero value, i.e. it does not identify any entity.
+func (id Id[Kind]) IsZero() bool {
+	return !id.valid
+}
+
+// String returns the text form of the id, the empty string for the zero id.
+func (id Id[Kind]) String() string {
+	if !id.valid {
+		return ""
+	}
+	return strconv.FormatInt(id.value, 10)
+}
+
+// MarshalText implements encoding.TextMarshaler, e.g. for using ids in URLs and JSON.
+func (id Id[Kind]) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the output of MarshalText.
+func (id *Id[Kind]) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = Id[Kind]{}
+		return nil
+	}
+	value, err := strconv.ParseInt(string(text), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %q", text)
+	}
+	*id = Id[Kind]{value: value, valid: true}
+	return nil
+}
+
+// Scan implements sql.Scanner, NULL becoming the zero id.
+func (id *Id[Kind]) Scan(src any) error {
+	switch typedSrc := src.(type) {
+	case nil:
+		*id = Id[Kind]{}
+		return nil
+	case int64:
+		*id = Id[Kind]{value: typedSrc, valid: true}
+		return nil
+	case []byte:
+		return id.UnmarshalText(typedSrc)
+	case string:
+		return id.UnmarshalText([]byte(typedSrc))
+	default:
+		return fmt.Errorf("cannot scan %T into id", src)
+	}
+}
+
+// Value implements driver.Valuer, the zero id becoming NULL.
+func (id Id[Kind]) Value() (driver.Value, error) {
+	if !id.valid {
+		return nil, nil
+	}
+	return id.value, nil
+}
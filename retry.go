@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy retries failing operations, waiting exponentially longer between attempts.
+type RetryPolicy struct {
+	// MaxAttempts limits the number of attempts, 0 means no limit.
+	MaxAttempts int
+	// InitialDelay is the wait before the second attempt, it doubles after every further attempt.
+	InitialDelay time.Duration
+	// MaxDelay caps the wait between attempts, 0 means no cap.
+	MaxDelay time.Duration
+	// Retryable tells which errors are retried, by default the ones wrapping ErrRetryable.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy retries transient errors for about 6 seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  8,
+	InitialDelay: 50 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+}
+
+func (policy RetryPolicy) isRetryable(err error) bool {
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return errors.Is(err, ErrRetryable)
+}
+
+// Do calls operation until it succeeds, fails with an error which is not retryable, or runs out of attempts.
+// The last error of operation is returned, joined with the context's error if the context is done while waiting.
+func (policy RetryPolicy) Do(ctx context.Context, operation func() error) error {
+	delay := policy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || !policy.isRetryable(err) || attempt == policy.MaxAttempts {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(ctx.Err(), err)
+		case <-timer.C:
+		}
+		delay *= 2
+		if policy.MaxDelay != 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
@@ -9,19 +9,10 @@ import (
 )
 
 type LogIndex = uint64
-type (
-	AddressId       = any
-	TransactionId   = any
-	ReceiptId       = any
-	TokenTransferId = any
-	Erc20TokenId    = any
-	BytecodeId      = any
-	Topic0Id        = any
-	LogId           struct {
-		TransactionId
-		LogIndex
-	}
-)
+type LogId struct {
+	TransactionId TransactionId
+	LogIndex      LogIndex
+}
 
 // OffsetPagination represents an offset based pagination.
 //   - Limit tells the number of records to return.
@@ -40,6 +31,50 @@ func NewOffsetPagination(limit uint8, page uint64) OffsetPagination {
 	}
 }
 
+// Direction restricts address based listers to one side of a transfer.
+type Direction uint8
+
+const (
+	// DirectionAny matches records where the address is either the sender or the recipient.
+	DirectionAny Direction = iota
+	// DirectionOutgoing matches records sent by the address.
+	DirectionOutgoing
+	// DirectionIncoming matches records received by the address.
+	DirectionIncoming
+)
+
+// TransactionFilter narrows down the transactions returned by ListTransactionsByFilter.
+// Nil fields and zero values do not filter.
+//   - Address and Direction filter on the sender and/or the recipient.
+//   - OnlyContractCreations only matches transactions without a recipient.
+//   - FromBlock, ToBlock, FromTimestamp and ToTimestamp are inclusive bounds.
+//   - Status filters on the receipt's status, transactions without receipts never match.
+//   - MethodSelector matches the first 4 bytes of the transaction input.
+//   - MinValue is an inclusive lower bound on the transferred wei.
+type TransactionFilter struct {
+	Address               *common.Address
+	Direction             Direction
+	OnlyContractCreations bool
+	FromBlock             *BlockNumber
+	ToBlock               *BlockNumber
+	FromTimestamp         *uint64
+	ToTimestamp           *uint64
+	Status                *ReceiptStatus
+	MethodSelector        *[4]byte
+	MinValue              *big.Int
+}
+
+// LogFilter narrows down the logs returned by ListLogsByFilter, like the filters of eth_getLogs.
+//   - FromBlock and ToBlock are inclusive bounds.
+//   - Addresses matches the logs emitted by any of the addresses, all logs if it is empty.
+//   - Topics matches the topics of the logs by position, a position matching any of its topics, or any topic if it is empty.
+type LogFilter struct {
+	FromBlock BlockNumber
+	ToBlock   BlockNumber
+	Addresses []common.Address
+	Topics    [][]common.Hash
+}
+
 type Storage interface {
 	Loader
 	DataStore
@@ -65,6 +100,8 @@ type Loader interface {
 // Bulk insertion MUST be supported for all methods.
 // All methods MUST execute into a single, common transaction for DataStore, and never commit!
 // See Loader's Commit method for explanation.
+// Storing an already stored entity is not an error by default. Backends configured to detect conflicts
+// return a ConflictError instead if the entity differs from the stored one.
 type Inserter interface {
 	StoreAddress(...common.Address) ([]AddressId, error)
 	StoreBlock(...*Block) error
@@ -102,6 +139,10 @@ type Deleter interface {
 // Getter methods:
 //   - Names start with the word "Get", and MUST return a single entity, OR nil when not found.
 //   - If the entity is not found, nil MUST be returned without error, as that is a valid query result.
+//   - Getters returning ids MUST return the zero id instead of nil, see Id.
+//
+// Errors of all methods MUST wrap the matching storage errors, e.g. ErrRetryable for serialization failures,
+// and name the entity and the key involved.
 //
 // Most listers accept an OffsetPagination or *OffsetPagination:
 //   - OffsetPagination type is mandatory to provide. Even if its Limit parameter is 0, non-list return values MUST still be returned.
@@ -112,20 +153,28 @@ type Reader interface {
 	ListTransactions(OffsetPagination) (_ []*Transaction, _ []TransactionId, totalRecordsFound uint64, _ error)
 	ListTransactionsByBlockNumber(BlockNumber, *OffsetPagination) (_ []*Transaction, _ []TransactionId, totalRecordsFound uint64, _ error)
 	ListTransactionsByAddress(common.Address, OffsetPagination) (_ []*Transaction, _ []TransactionId, totalRecordsFound uint64, _ error)
+	ListTransactionsByFilter(TransactionFilter, OffsetPagination) (_ []*Transaction, _ []TransactionId, totalRecordsFound uint64, _ error)
 	ListStorageKeysByTransactionId(TransactionId) ([]*StorageKey, error)
 	ListLogsByTransactionId(TransactionId) ([]*Log, error)
+	// ListLogsByFilter lists the logs matching the filter, in the order they were emitted.
+	ListLogsByFilter(LogFilter, *OffsetPagination) ([]*Log, error)
 	ListErc20TokenTransfers(token, fromOrToFilter *common.Address, _ *OffsetPagination) (_ []*Erc20TokenTransfer, totalRecordsFound uint64, _ error)
 	ListTraces(*OffsetPagination) (_ []*TraceAction, blockNumbers []uint64, timestamps []uint64, totalRecordsFound uint64, _ error)
 	ListTracesByTransactionHash(transactionHash *common.Hash) (_ []*TraceAction, blockNumber uint64, timestamp uint64, _ error)
 	ListTracesByBlockNumber(BlockNumber, *OffsetPagination) (_ []*TraceAction, timestamp uint64, totalRecordsFound uint64, _ error)
+	ListTracesByAddress(_ common.Address, _ Direction, onlyWithValue bool, _ *OffsetPagination) (_ []*TraceAction, blockNumbers []uint64, timestamps []uint64, totalRecordsFound uint64, _ error)
 	ListErc20TokenBalancesAtBlock(holder AddressId, _ BlockNumber) ([]*Erc20TokenBalance, error)
+	ListErc20TokenHolders(token Erc20TokenId, atBlock BlockNumber, _ OffsetPagination) (_ []*Erc20TokenHolder, totalRecordsFound uint64, _ error)
 	ListStateChangesByTransactionHash(*common.Hash) ([]*StateChange, error)
+	// ListMissingBlockRanges MUST return the ranges of blocks between from and to which are not stored, in block order.
+	ListMissingBlockRanges(from, to BlockNumber) ([]*BlockRange, error)
 	GetAddressById(AddressId) (*common.Address, error)
 	GetAddressIdByHash(common.Address) (AddressId, error)
 	GetBlockByHash(*common.Hash) (*Block, error)
 	GetBlockByNumber(uint64) (*Block, error)
 	GetLatestBlockNumber() (*BlockNumber, error)
 	GetUncleByUncleHash(*common.Hash) (*Uncle, error)
+	GetCallTree(transactionHash *common.Hash) (*CallFrame, error)
 	GetTransactionById(TransactionId) (*Transaction, error)
 	GetTransactionByHash(*common.Hash) (*Transaction, TransactionId, error)
 	GetReceiptByTransactionId(TransactionId) (*Receipt, error)
@@ -142,8 +191,8 @@ type Reader interface {
 	GetErc20TokenHolders(Erc20TokenId) (uint64, error)
 }
 type StateChange struct {
-	TransactionId
-	AddressId
+	TransactionId  TransactionId
+	AddressId      AddressId
 	BalanceBefore  *big.Int
 	BalanceAfter   *big.Int
 	NonceBefore    *uint64
@@ -151,8 +200,8 @@ type StateChange struct {
 	StorageChanges []*StorageChange
 }
 type StorageChange struct {
-	TransactionId
-	AddressId
+	TransactionId  TransactionId
+	AddressId      AddressId
 	StorageAddress *big.Int
 	ValueBefore    *big.Int
 	ValueAfter     *big.Int
@@ -162,8 +211,8 @@ type BlockNumber = uint64
 type TokenSymbol string
 type EtherBalance struct {
 	BlockNumber
-	AddressId
-	Balance *big.Int
+	AddressId AddressId
+	Balance   *big.Int
 }
 type Erc20TokenBalance struct {
 	BlockNumber
@@ -171,6 +220,13 @@ type Erc20TokenBalance struct {
 	TokenAddressId AddressId
 	Balance        *big.Int
 }
+
+// Erc20TokenHolder is a holder's balance of a token, along with its share of the token's total supply.
+// PercentageOfSupply is nil if the token's total supply is unknown or zero.
+type Erc20TokenHolder struct {
+	Erc20TokenBalance
+	PercentageOfSupply *float64
+}
 type Uncle struct {
 	Position    uint8
 	Hash        common.Hash
@@ -186,28 +242,32 @@ type Uncle struct {
 	Reward         *big.Int
 }
 type Block struct {
-	Hash            common.Hash
-	Number          BlockNumber
-	Nonce           uint64
-	Sha3Uncles      common.Hash
-	LogsBloom       types.Bloom
-	StateRoot       common.Hash
-	MinerAddressId  AddressId
-	Difficulty      *big.Int
-	TotalDifficulty *big.Int
-	Size            uint64
-	ExtraData       []byte
-	GasLimit        uint64
-	GasUsed         uint64
-	Timestamp       uint64
-	BaseFeePerGas   *big.Int
-	MixHash         common.Hash
-	StaticReward    *big.Int
+	Hash       common.Hash
+	ParentHash common.Hash
+	Number     BlockNumber
+	Nonce      uint64
+	Sha3Uncles common.Hash
+	LogsBloom  types.Bloom
+	StateRoot  common.Hash
+	// TransactionsRoot and ReceiptsRoot are empty for blocks stored by earlier versions.
+	TransactionsRoot common.Hash
+	ReceiptsRoot     common.Hash
+	MinerAddressId   AddressId
+	Difficulty       *big.Int
+	TotalDifficulty  *big.Int
+	Size             uint64
+	ExtraData        []byte
+	GasLimit         uint64
+	GasUsed          uint64
+	Timestamp        uint64
+	BaseFeePerGas    *big.Int
+	MixHash          common.Hash
+	StaticReward     *big.Int
 }
 type StorageKey struct {
-	TransactionId
-	AddressId
-	StorageKey *big.Int
+	TransactionId TransactionId
+	AddressId     AddressId
+	StorageKey    *big.Int
 }
 type Transaction struct {
 	BlockNumber
@@ -223,6 +283,9 @@ type Transaction struct {
 	GasFeeCap     *big.Int
 	Input         []byte
 	Type          uint8
+	// Raw is the consensus encoding of the transaction, including its signature.
+	// It is nil for transactions stored by earlier versions.
+	Raw []byte
 }
 type ReceiptStatus = bool
 
@@ -232,7 +295,7 @@ const (
 )
 
 type Receipt struct {
-	TransactionId
+	TransactionId     TransactionId
 	CumulativeGasUsed uint64
 	GasUsed           uint64
 	ContractAddressId *AddressId
@@ -247,12 +310,12 @@ func (receipt *Receipt) TransactionFee() *big.Int {
 
 type Log struct {
 	LogId
-	AddressId
-	Topic0Id *Topic0Id
-	Topic1   *[32]byte
-	Topic2   *[32]byte
-	Topic3   *[32]byte
-	Data     []byte
+	AddressId AddressId
+	Topic0Id  *Topic0Id
+	Topic1    *[32]byte
+	Topic2    *[32]byte
+	Topic3    *[32]byte
+	Data      []byte
 }
 
 type Erc20TokenTransfer struct {
@@ -264,12 +327,12 @@ type Erc20TokenTransfer struct {
 }
 
 type Contract struct {
-	AddressId
-	TransactionId
-	BytecodeId
+	AddressId     AddressId
+	TransactionId TransactionId
+	BytecodeId    BytecodeId
 }
 type Erc20Token struct {
-	AddressId
+	AddressId   AddressId
 	Symbol      string
 	Name        string
 	Decimals    uint8
@@ -281,13 +344,20 @@ type EventType struct {
 	Signature *string
 }
 type TraceAction struct {
-	TransactionId
-	Index uint16 // position in the returned traces list
-	Type  string
-	Input []byte
-	From  AddressId
-	To    AddressId
-	Value *big.Int
-	Gas   uint64
-	Error *string
+	TransactionId    TransactionId
+	Index            uint16   // position in the returned traces list
+	TraceAddress     []uint64 // path in the call tree, empty for the top level call
+	Depth            uint16   // equals to len(TraceAddress)
+	Type             string
+	Input            []byte
+	Output           []byte
+	From             AddressId
+	To               AddressId
+	Value            *big.Int
+	Gas              uint64
+	GasUsed          uint64
+	Error            *string
+	CreatedAddressId *AddressId // only set for CREATE and CREATE2
+	InitCode         []byte     // only set for CREATE and CREATE2
+	RefundAddressId  *AddressId // only set for SELFDESTRUCT
 }